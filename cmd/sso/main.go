@@ -17,9 +17,9 @@ func main() {
 
 	log.Info("Starting application", slog.String("config", cfg.Env))
 
-	application := app.New(log, cfg.GRPC.Port, cfg.StoragePath, cfg.TokenTTL)
+	application := app.New(log, cfg)
 
-	go application.GrpcApp.MustRun()
+	application.MustRun()
 
 	// Graceful shutdown
 
@@ -30,7 +30,7 @@ func main() {
 
 	log.Info("Stopping application", slog.String("signal", sign.String()))
 
-	application.GrpcApp.Stop()
+	application.Stop()
 
 	log.Info("Application stopped")
 }
@@ -1,34 +1,449 @@
 package app
 
 import (
+	"context"
+	"fmt"
+	goredis "github.com/redis/go-redis/v9"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+	gatewayapp "grpc-sso/internal/app/gateway"
 	grpcapp "grpc-sso/internal/app/grpc"
+	metricsapp "grpc-sso/internal/app/metrics"
+	retentionapp "grpc-sso/internal/app/retention"
+	webhookapp "grpc-sso/internal/app/webhook"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/federation"
+	"grpc-sso/internal/lib/ldap"
+	"grpc-sso/internal/lib/ratelimit"
+	"grpc-sso/internal/lib/ratelimit/memory"
+	"grpc-sso/internal/lib/ratelimit/redis"
+	"grpc-sso/internal/lib/tlsconfig"
+	"grpc-sso/internal/lib/tracing"
 	"grpc-sso/internal/services/auth"
-	"grpc-sso/internal/storage/sqlite"
+	"grpc-sso/internal/services/webhook"
+	"grpc-sso/internal/storage/cached"
+	"io"
 	"log/slog"
+	"net/http"
 	"time"
 )
 
+const shutdownTimeout = 10 * time.Second
+
+// minTicketKeyLen is the shortest federation ticket key, the size of the HS256 hash
+const minTicketKeyLen = 32
+
 type App struct {
-	GrpcApp *grpcapp.App
+	log            *slog.Logger
+	GrpcApp        *grpcapp.App
+	GatewayApp     *gatewayapp.App
+	MetricsApp     *metricsapp.App
+	RetentionApp   *retentionapp.App
+	WebhookApp     *webhookapp.App
+	tracerProvider *sdktrace.TracerProvider
+	certReloaders  []*tlsconfig.Reloader
+	storage        io.Closer
 }
 
 // New creates new gRPC server app
 func New(
 	log *slog.Logger,
-	grpcPort int,
-	storagePath string,
-	tokenTTL time.Duration,
+	cfg *config.Config,
 ) *App {
-	storage, err := sqlite.New(storagePath)
+	if err := checkGatewayTLS(cfg); err != nil {
+		panic(err)
+	}
+
+	tracerProvider, err := newTracerProvider(cfg.Tracing)
+	if err != nil {
+		panic(err)
+	}
+	tracing.Install(tracerProvider)
+
+	storage, err := newStorage(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	var (
+		userProvider auth.UserProvider        = storage
+		appProvider  auth.AppProvider         = storage
+		permissions  auth.PermissionsProvider = storage
+		authOpts                              = []auth.Option{
+			auth.WithAuditLog(storage),
+			auth.WithOutbox(storage),
+			auth.WithEventLog(storage, cfg.UserEvents.PollInterval),
+			auth.WithSessions(storage),
+			auth.WithConsents(storage),
+			auth.WithAccessTokens(storage),
+			auth.WithImpersonationTTL(cfg.ImpersonationTTL),
+		}
+	)
+	if cfg.Storage.Cache.Enabled {
+		c := cached.New(storage, storage, storage, cached.Options{
+			AppTTL:             cfg.Storage.Cache.AppTTL,
+			AppNegativeTTL:     cfg.Storage.Cache.AppNegativeTTL,
+			AppMaxSize:         cfg.Storage.Cache.AppMaxSize,
+			IsAdminTTL:         cfg.Storage.Cache.IsAdminTTL,
+			IsAdminMaxSize:     cfg.Storage.Cache.IsAdminMaxSize,
+			PermissionsTTL:     cfg.Storage.Cache.PermissionsTTL,
+			PermissionsMaxSize: cfg.Storage.Cache.PermissionsMaxSize,
+		})
+
+		userProvider, appProvider, permissions = c, c, c
+		authOpts = append(authOpts, auth.WithInvalidator(c))
+	}
+	authOpts = append(authOpts, auth.WithGroups(storage, permissions))
+
+	providers, err := newFederationProviders(cfg.Federation)
 	if err != nil {
 		panic(err)
 	}
+	if len(providers) > 0 {
+		authOpts = append(authOpts, auth.WithFederation(storage, cfg.Federation.TicketKey, providers...))
+	}
 
-	authService := auth.New(log, storage, storage, storage, tokenTTL)
+	directories, err := newLDAPBackends(cfg.LDAP)
+	if err != nil {
+		panic(err)
+	}
+	if len(directories) > 0 {
+		authOpts = append(authOpts, auth.WithLDAP(storage, directories...))
+	}
 
-	grpcApp := grpcapp.New(log, authService, grpcPort)
+	authService := auth.New(log, storage, userProvider, appProvider, cfg.TokenTTL, authOpts...)
+
+	healthChecks, err := newHealthChecks(cfg, storage)
+	if err != nil {
+		panic(err)
+	}
+
+	grpcOpts := []grpcapp.Option{grpcapp.WithHealthChecks(healthChecks...)}
+
+	limiter, err := newLimiter(cfg.GRPC.RateLimit)
+	if err != nil {
+		panic(err)
+	}
+	if limiter != nil {
+		grpcOpts = append(grpcOpts, grpcapp.WithRateLimiter(limiter))
+	}
+
+	var certReloaders []*tlsconfig.Reloader
+	if cfg.GRPC.TLS.Enabled {
+		certReloader, err := newCertReloader(log, cfg.GRPC.TLS)
+		if err != nil {
+			panic(err)
+		}
+		certReloaders = append(certReloaders, certReloader)
+
+		grpcOpts = append(grpcOpts,
+			grpcapp.WithTransportCredentials(credentials.NewTLS(certReloader.Config())))
+	}
+
+	grpcApp := grpcapp.New(log, authService, cfg.GRPC, grpcOpts...)
+
+	var gatewayApp *gatewayapp.App
+	if cfg.Gateway.Enabled {
+		var gatewayOpts []gatewayapp.Option
+		if cfg.Gateway.TLS.Enabled {
+			certReloader, err := newCertReloader(log, cfg.Gateway.TLS)
+			if err != nil {
+				panic(err)
+			}
+			certReloaders = append(certReloaders, certReloader)
+
+			gatewayOpts = append(gatewayOpts, gatewayapp.WithTLSConfig(certReloader.Config()))
+		}
+
+		conn, err := grpcApp.DialInProcess()
+		if err != nil {
+			panic(err)
+		}
+
+		gatewayApp, err = gatewayapp.New(log, conn, cfg.Gateway.Port, gatewayOpts...)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var metricsApp *metricsapp.App
+	if cfg.Metrics.Enabled {
+		metricsApp = metricsapp.New(log, cfg.Metrics.Port)
+	}
+
+	var webhookApp *webhookapp.App
+	if cfg.Webhooks.Enabled {
+		dispatcher := webhook.New(log, storage, appProvider, &http.Client{}, webhook.Options{
+			BatchSize:   cfg.Webhooks.BatchSize,
+			MaxAttempts: cfg.Webhooks.MaxAttempts,
+			MinBackoff:  cfg.Webhooks.MinBackoff,
+			MaxBackoff:  cfg.Webhooks.MaxBackoff,
+			Timeout:     cfg.Webhooks.Timeout,
+		})
+
+		webhookApp = webhookapp.New(log, dispatcher, cfg.Webhooks.PollInterval)
+	}
+
+	retentionApp := retentionapp.New(log, cfg.Audit.PruneInterval,
+		retentionapp.Job{
+			Name:      "audit_events",
+			Retention: cfg.Audit.Retention,
+			Prune:     storage.PruneAuditEvents,
+		},
+		retentionapp.Job{
+			Name:      "outbox_events",
+			Retention: cfg.Webhooks.Retention,
+			Prune:     storage.PruneDeliveredEvents,
+		},
+		retentionapp.Job{
+			Name:      "user_events",
+			Retention: cfg.UserEvents.Retention,
+			Prune:     storage.PruneUserEvents,
+		},
+		retentionapp.Job{
+			Name:      "sessions",
+			Retention: cfg.Sessions.Retention,
+			Prune:     storage.PruneSessions,
+		},
+	)
 
 	return &App{
-		GrpcApp: grpcApp,
+		log:            log,
+		GrpcApp:        grpcApp,
+		GatewayApp:     gatewayApp,
+		MetricsApp:     metricsApp,
+		RetentionApp:   retentionApp,
+		WebhookApp:     webhookApp,
+		tracerProvider: tracerProvider,
+		certReloaders:  certReloaders,
+		storage:        storage,
+	}
+}
+
+// MustRun runs all servers of the app in background and panics if any of them fails
+func (a *App) MustRun() {
+	go a.GrpcApp.MustRun()
+
+	if a.GatewayApp != nil {
+		go a.GatewayApp.MustRun()
+	}
+
+	if a.MetricsApp != nil {
+		go a.MetricsApp.MustRun()
+	}
+
+	if a.WebhookApp != nil {
+		go a.WebhookApp.Run()
+	}
+
+	go a.RetentionApp.Run()
+}
+
+// Stop stops all servers of the app and flushes pending spans
+func (a *App) Stop() {
+	const op = "app.Stop"
+
+	log := a.log.With(slog.String("op", op))
+
+	if a.GatewayApp != nil {
+		if err := a.GatewayApp.Stop(); err != nil {
+			log.Error("failed to stop REST gateway", slog.String("error", err.Error()))
+		}
+	}
+
+	if err := a.GrpcApp.Stop(); err != nil {
+		log.Error("failed to stop gRPC server", slog.String("error", err.Error()))
+	}
+
+	if a.MetricsApp != nil {
+		if err := a.MetricsApp.Stop(); err != nil {
+			log.Error("failed to stop metrics server", slog.String("error", err.Error()))
+		}
+	}
+
+	// Stopped after the servers, the events left pending are delivered after the restart
+	if a.WebhookApp != nil {
+		a.WebhookApp.Stop()
+	}
+
+	a.RetentionApp.Stop()
+
+	// The storage is closed after the servers, in-flight calls may still use it
+	if err := a.storage.Close(); err != nil {
+		log.Error("failed to close storage", slog.String("error", err.Error()))
+	}
+
+	for _, certReloader := range a.certReloaders {
+		if err := certReloader.Close(); err != nil {
+			log.Error("failed to stop TLS certificates watcher", slog.String("error", err.Error()))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := a.tracerProvider.Shutdown(ctx); err != nil {
+		log.Error("failed to shutdown tracer provider", slog.String("error", err.Error()))
+	}
+}
+
+// checkGatewayTLS refuses a gateway that serves the API with weaker transport security
+// than the gRPC listener. The gateway calls the server in process, so the client
+// certificates required by grpc.tls are only checked by the gateway listener
+func checkGatewayTLS(cfg *config.Config) error {
+	const op = "app.checkGatewayTLS"
+
+	if !cfg.Gateway.Enabled || !cfg.GRPC.TLS.Enabled {
+		return nil
+	}
+
+	if !cfg.Gateway.TLS.Enabled {
+		return fmt.Errorf("%s: gateway.tls is required when grpc.tls is enabled", op)
+	}
+
+	if cfg.GRPC.TLS.ClientAuth == tlsconfig.ClientAuthRequire && cfg.Gateway.TLS.ClientAuth != tlsconfig.ClientAuthRequire {
+		return fmt.Errorf("%s: gateway.tls.client_auth must be %q when grpc.tls.client_auth is",
+			op, tlsconfig.ClientAuthRequire)
+	}
+
+	return nil
+}
+
+// newCertReloader loads the certificate files of the listener and watches them for changes
+func newCertReloader(log *slog.Logger, cfg config.TLSConfig) (*tlsconfig.Reloader, error) {
+	return tlsconfig.New(log, tlsconfig.Options{
+		CertFile:     cfg.CertFile,
+		KeyFile:      cfg.KeyFile,
+		MinVersion:   cfg.MinVersion,
+		CipherSuites: cfg.CipherSuites,
+		ClientCAFile: cfg.ClientCAFile,
+		ClientAuth:   cfg.ClientAuth,
+	})
+}
+
+// newTracerProvider creates the tracer provider with the configured exporter
+func newTracerProvider(cfg config.TracingConfig) (*sdktrace.TracerProvider, error) {
+	const op = "app.newTracerProvider"
+
+	exporter, err := tracing.NewExporter(context.Background(), cfg.Exporter, cfg.Endpoint, cfg.Insecure)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tracing.NewProvider(exporter, cfg.ServiceName, cfg.SampleRatio), nil
+}
+
+// newFederationProviders creates the upstream identity providers of the federation config
+func newFederationProviders(cfg config.FederationConfig) ([]auth.FederationProvider, error) {
+	const op = "app.newFederationProviders"
+
+	if len(cfg.Providers) > 0 && len(cfg.TicketKey) < minTicketKeyLen {
+		return nil, fmt.Errorf("%s: ticket_key of at least %d bytes is required", op, minTicketKeyLen)
+	}
+
+	client := &http.Client{Timeout: cfg.Timeout}
+	providers := make([]auth.FederationProvider, 0, len(cfg.Providers))
+
+	for _, p := range cfg.Providers {
+		if p.Name == "" {
+			return nil, fmt.Errorf("%s: provider name is required", op)
+		}
+
+		upstream, err := federation.New(federation.Options{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  p.RedirectURL,
+			Scopes:       p.Scopes,
+			AuthURL:      p.AuthURL,
+			TokenURL:     p.TokenURL,
+			UserInfoURL:  p.UserInfoURL,
+			EmailsURL:    p.EmailsURL,
+		}, client)
+		if err != nil {
+			return nil, fmt.Errorf("%s: provider %s: %w", op, p.Name, err)
+		}
+
+		providers = append(providers, auth.FederationProvider{
+			Name:     p.Name,
+			Upstream: upstream,
+			TenantID: p.TenantID,
+			Signup:   p.Signup,
+		})
+	}
+
+	return providers, nil
+}
+
+// newLDAPBackends creates the directories of the LDAP config, in the default tenant unless configured
+func newLDAPBackends(cfg []config.LDAPConfig) ([]auth.LDAPBackend, error) {
+	const op = "app.newLDAPBackends"
+
+	backends := make([]auth.LDAPBackend, 0, len(cfg))
+
+	for _, c := range cfg {
+		if c.Name == "" {
+			return nil, fmt.Errorf("%s: directory name is required", op)
+		}
+
+		directory, err := ldap.New(ldap.Options{
+			URL:            c.URL,
+			StartTLS:       c.StartTLS,
+			CAFile:         c.CAFile,
+			Timeout:        c.Timeout,
+			BindDN:         c.BindDN,
+			BindPassword:   c.BindPassword,
+			BaseDN:         c.BaseDN,
+			UserFilter:     c.UserFilter,
+			IDAttribute:    c.IDAttribute,
+			EmailAttribute: c.EmailAttribute,
+			GroupAttribute: c.GroupAttribute,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: directory %s: %w", op, c.Name, err)
+		}
+
+		tenantID := c.TenantID
+		if tenantID == 0 {
+			tenantID = models.DefaultTenantID
+		}
+
+		backends = append(backends, auth.LDAPBackend{
+			Name:      c.Name,
+			Directory: directory,
+			TenantID:  tenantID,
+			Domains:   c.Domains,
+			Provision: c.Provision,
+			SyncEmail: c.SyncEmail,
+			Groups:    c.Groups,
+		})
+	}
+
+	return backends, nil
+}
+
+// newLimiter creates the rate limiter backend.
+// Returns nil limiter if rate limiting is disabled.
+func newLimiter(cfg config.RateLimitConfig) (ratelimit.Limiter, error) {
+	const op = "app.newLimiter"
+
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	switch cfg.Backend {
+	case "memory":
+		return memory.New(), nil
+	case "redis":
+		client := goredis.NewClient(&goredis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+
+		return redis.New(client, cfg.Redis.KeyPrefix), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown rate limit backend %q", op, cfg.Backend)
 	}
 }
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"grpc-sso/internal/config"
+	"testing"
+)
+
+func TestCheckGatewayTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		grpc    config.TLSConfig
+		gateway config.GatewayConfig
+		wantErr bool
+	}{
+		{
+			name:    "Plaintext",
+			gateway: config.GatewayConfig{Enabled: true},
+		},
+		{
+			name: "Gateway disabled",
+			grpc: config.TLSConfig{Enabled: true, ClientAuth: "require"},
+		},
+		{
+			name:    "Plaintext gateway with TLS",
+			grpc:    config.TLSConfig{Enabled: true, ClientAuth: "none"},
+			gateway: config.GatewayConfig{Enabled: true},
+			wantErr: true,
+		},
+		{
+			name:    "TLS gateway without client certificates with mTLS",
+			grpc:    config.TLSConfig{Enabled: true, ClientAuth: "require"},
+			gateway: config.GatewayConfig{Enabled: true, TLS: config.TLSConfig{Enabled: true, ClientAuth: "request"}},
+			wantErr: true,
+		},
+		{
+			name:    "TLS gateway with mTLS",
+			grpc:    config.TLSConfig{Enabled: true, ClientAuth: "require"},
+			gateway: config.GatewayConfig{Enabled: true, TLS: config.TLSConfig{Enabled: true, ClientAuth: "require"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.Config{Gateway: test.gateway}
+			cfg.GRPC.TLS = test.grpc
+
+			err := checkGatewayTLS(cfg)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
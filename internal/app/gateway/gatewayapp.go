@@ -0,0 +1,200 @@
+package gatewayapp
+
+import (
+	"context"
+	"crypto/tls"
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/lib/requestid"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/textproto"
+	"time"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+// OpenAPIPath is the path the OpenAPI spec of the REST API is served on
+const OpenAPIPath = "/openapi.json"
+
+//go:embed openapi/sso.swagger.json
+var openAPISpec []byte
+
+// forwardedHeaders are HTTP headers passed to the gRPC metadata as is
+var forwardedHeaders = map[string]bool{
+	textproto.CanonicalMIMEHeaderKey(requestid.MetadataKey):  true,
+	textproto.CanonicalMIMEHeaderKey(clientinfo.TenantIDKey): true,
+	"Traceparent": true,
+	"Tracestate":  true,
+}
+
+// spoofedHeaders are the metadata the gateway sets itself, clients may not send them
+// with the Grpc-Metadata- prefix
+var spoofedHeaders = map[string]bool{
+	runtime.MetadataHeaderPrefix + "X-Forwarded-For":        true,
+	runtime.MetadataHeaderPrefix + "Grpcgateway-User-Agent": true,
+}
+
+type App struct {
+	log        *slog.Logger
+	httpServer *http.Server
+	conn       *grpc.ClientConn
+	port       int
+	tlsConfig  *tls.Config
+}
+
+type options struct {
+	tlsConfig *tls.Config
+}
+
+// Option configures optional parts of the gateway app
+type Option func(*options)
+
+// WithTLSConfig makes the gateway serve HTTPS with the config, e.g. one requiring
+// client certificates, instead of plaintext
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = cfg
+	}
+}
+
+// New creates new REST/JSON gateway app.
+// Requests are translated to gRPC calls over conn.
+func New(
+	log *slog.Logger,
+	conn *grpc.ClientConn,
+	port int,
+	opts ...Option,
+) (*App, error) {
+	const op = "gatewayapp.New"
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	gwMux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher),
+	)
+
+	if err := sso.RegisterAuthHandler(context.Background(), gwMux, conn); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", gwMux)
+	mux.HandleFunc(OpenAPIPath, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(openAPISpec)
+	})
+
+	return &App{
+		log: log,
+		httpServer: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		conn:      conn,
+		port:      port,
+		tlsConfig: o.tlsConfig,
+	}, nil
+}
+
+// Handler returns the HTTP handler of the gateway
+func (app *App) Handler() http.Handler {
+	return app.httpServer.Handler
+}
+
+// MustRun runs gateway server and panics if any error occurs
+func (app *App) MustRun() {
+	err := app.Run()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Run runs gateway server
+func (app *App) Run() error {
+	const op = "gatewayapp.Run"
+
+	log := app.log.With(slog.String("op", op))
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", app.port))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("REST gateway is running",
+		slog.String("address", listener.Addr().String()),
+		slog.Int("port", app.port),
+		slog.Bool("tls", app.tlsConfig != nil),
+	)
+
+	if err := app.serve(listener); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// serve serves the gateway on the listener, over TLS if it is configured
+func (app *App) serve(listener net.Listener) error {
+	if app.tlsConfig != nil {
+		listener = tls.NewListener(listener, app.tlsConfig)
+	}
+
+	if err := app.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Stop stops gateway server and closes the gRPC connection
+func (app *App) Stop() error {
+	const op = "gatewayapp.Stop"
+
+	app.log.With(slog.String("op", op)).
+		Info("stopping REST gateway", slog.Int("port", app.port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := app.httpServer.Shutdown(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := app.conn.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func incomingHeaderMatcher(key string) (string, bool) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	if forwardedHeaders[key] {
+		return key, true
+	}
+
+	if spoofedHeaders[key] {
+		return "", false
+	}
+
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+func outgoingHeaderMatcher(key string) (string, bool) {
+	if key == requestid.MetadataKey {
+		return textproto.CanonicalMIMEHeaderKey(key), true
+	}
+
+	return runtime.MetadataHeaderPrefix + key, true
+}
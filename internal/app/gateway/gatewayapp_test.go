@@ -0,0 +1,1174 @@
+package gatewayapp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	grpcapp "grpc-sso/internal/app/grpc"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/services/auth"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeAuth struct{}
+
+func (fakeAuth) Login(ctx context.Context, email string, _ string, appID int, _ ...auth.LoginOption) (string, error) {
+	switch {
+	case email == "client@example.com":
+		return clientinfo.IP(ctx) + " " + clientinfo.UserAgent(ctx), nil
+	case appID == 2:
+		return "", auth.ErrInvalidCredentials
+	case email == "panic@example.com":
+		panic("boom")
+	case email == "staff@corp.example.com":
+		return "", auth.ErrDirectoryUnavailable
+	}
+
+	return "token-" + email, nil
+}
+
+func (fakeAuth) RegisterNewUser(_ context.Context, email string, _ string, _ int) (int64, error) {
+	switch email {
+	case "exists@example.com":
+		return 0, auth.ErrUserExists
+	case "broken@example.com":
+		return 0, fmt.Errorf("disk is full")
+	case "staff@corp.example.com":
+		return 0, auth.ErrDirectoryUser
+	}
+
+	return 42, nil
+}
+
+func (fakeAuth) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	if userID == 404 {
+		return false, auth.ErrUserNotFound
+	}
+
+	return userID == 1, nil
+}
+
+func (fakeAuth) VerifyToken(_ context.Context, token string) (models.Caller, error) {
+	if token != "admin-token" {
+		return models.Caller{}, jwt.ErrInvalidToken
+	}
+
+	return models.Caller{UserID: 1, Email: "admin@example.com", AppID: 1}, nil
+}
+
+func (fakeAuth) ListAuditEvents(
+	ctx context.Context,
+	filter models.AuditFilter,
+	_ int,
+	_ string,
+) ([]models.AuditEvent, string, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return nil, "", auth.ErrUnauthenticated
+	}
+
+	return []models.AuditEvent{{
+		ID:        7,
+		Type:      models.AuditLogin,
+		UserID:    filter.UserID,
+		CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}}, "next", nil
+}
+
+func (fakeAuth) ChangeEmail(ctx context.Context, newEmail string, _ string) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if newEmail == "exists@example.com" {
+		return auth.ErrUserExists
+	}
+
+	return nil
+}
+
+func (fakeAuth) DisableUser(ctx context.Context, userID int64) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if userID == 404 {
+		return auth.ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (fakeAuth) ListWebhookEvents(
+	ctx context.Context,
+	filter models.OutboxFilter,
+	_ int,
+	_ string,
+) ([]models.OutboxEvent, string, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return nil, "", auth.ErrUnauthenticated
+	}
+
+	return []models.OutboxEvent{{
+		ID:      3,
+		EventID: "ev-3",
+		Type:    models.EventUserRegistered,
+		AppID:   filter.AppID,
+		Status:  models.OutboxDead,
+		Payload: []byte(`{}`),
+	}}, "", nil
+}
+
+func (fakeAuth) ReplayWebhookEvents(ctx context.Context, filter models.ReplayFilter) (int64, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return 0, auth.ErrUnauthenticated
+	}
+
+	return int64(len(filter.IDs)), nil
+}
+
+func (fakeAuth) ChangePassword(ctx context.Context, oldPassword string, _ string) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if oldPassword != "secret" {
+		return auth.ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+func (fakeAuth) WatchUserEvents(
+	ctx context.Context,
+	filter models.UserEventFilter,
+	_ string,
+	send func(event models.UserEvent, cursor string) error,
+) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	for id := int64(1); id <= 2; id++ {
+		event := models.UserEvent{ID: id, Type: models.EventUserRegistered, UserID: id, AppID: filter.AppID}
+		if err := send(event, fmt.Sprintf("cursor-%d", id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fakeAuth) ListSessions(ctx context.Context) ([]models.Session, error) {
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	return []models.Session{{ID: "session-1", UserID: c.UserID, AppID: c.AppID}}, nil
+}
+
+func (fakeAuth) RevokeSession(ctx context.Context, sessionID string) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if sessionID == "missing" {
+		return auth.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (fakeAuth) RevokeAllSessions(ctx context.Context, keepCurrent bool) (int64, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return 0, auth.ErrUnauthenticated
+	}
+
+	if keepCurrent {
+		return 1, nil
+	}
+
+	return 2, nil
+}
+
+func (fakeAuth) ListUserSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	return []models.Session{{ID: fmt.Sprintf("session-of-%d", userID), UserID: userID}}, nil
+}
+
+func (fakeAuth) RevokeUserSession(ctx context.Context, _ int64, sessionID string) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if sessionID == "missing" {
+		return auth.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (fakeAuth) RevokeAllUserSessions(ctx context.Context, userID int64) (int64, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return 0, auth.ErrUnauthenticated
+	}
+
+	if userID == 404 {
+		return 0, auth.ErrUserNotFound
+	}
+
+	return 3, nil
+}
+
+func (fakeAuth) CreateGroup(ctx context.Context, name string) (int64, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return 0, auth.ErrUnauthenticated
+	}
+
+	if name == "taken" {
+		return 0, auth.ErrGroupExists
+	}
+
+	return 7, nil
+}
+
+func (fakeAuth) ListGroups(ctx context.Context) ([]models.Group, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	return []models.Group{{ID: 7, Name: "engineering"}}, nil
+}
+
+func (fakeAuth) GetGroup(ctx context.Context, groupID int64) (models.Group, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return models.Group{}, auth.ErrUnauthenticated
+	}
+
+	if groupID == 404 {
+		return models.Group{}, auth.ErrGroupNotFound
+	}
+
+	return models.Group{ID: groupID, Name: "engineering", UserIDs: []int64{5},
+		Roles: []models.GroupRole{{AppID: 1, Role: "editor"}}}, nil
+}
+
+func (fakeAuth) DeleteGroup(ctx context.Context, _ int64) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	return nil
+}
+
+func (fakeAuth) AddGroupMember(ctx context.Context, groupID int64, member models.GroupMember) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if member.SubgroupID == groupID {
+		return auth.ErrGroupCycle
+	}
+
+	return nil
+}
+
+func (fakeAuth) RemoveGroupMember(ctx context.Context, _ int64, _ models.GroupMember) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	return nil
+}
+
+func (fakeAuth) GrantGroupRole(ctx context.Context, _ int64, _ models.GroupRole) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	return nil
+}
+
+func (fakeAuth) RevokeGroupRole(ctx context.Context, _ int64, role models.GroupRole) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if role.AppID == 2 {
+		return auth.ErrInvalidAppID
+	}
+
+	return nil
+}
+
+func (fakeAuth) GetUserPermissions(ctx context.Context, userID int64, appID int) (models.Permissions, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return models.Permissions{}, auth.ErrUnauthenticated
+	}
+
+	return models.Permissions{Groups: []string{fmt.Sprintf("group-of-%d", userID)},
+		Roles: []string{fmt.Sprintf("role-in-%d", appID)}}, nil
+}
+
+func (fakeAuth) ListConsents(ctx context.Context) ([]models.Consent, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	return []models.Consent{{AppID: 3, Scopes: []string{"email", "profile"}}}, nil
+}
+
+func (fakeAuth) RevokeConsent(ctx context.Context, appID int) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if appID == 404 {
+		return auth.ErrConsentNotFound
+	}
+
+	return nil
+}
+
+func (fakeAuth) CreateAccessToken(
+	ctx context.Context,
+	name string,
+	appID int,
+	scopes []string,
+	expiresAt time.Time,
+) (string, models.AccessToken, error) {
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return "", models.AccessToken{}, auth.ErrUnauthenticated
+	}
+
+	if appID == 404 {
+		return "", models.AccessToken{}, auth.ErrInvalidAppID
+	}
+
+	return "sso_pat_secret", models.AccessToken{ID: 7, UserID: c.UserID, AppID: appID, Name: name,
+		Hint: "sso_pat_secr", Scopes: scopes, ExpiresAt: expiresAt}, nil
+}
+
+func (fakeAuth) ListAccessTokens(ctx context.Context) ([]models.AccessToken, error) {
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	return []models.AccessToken{{ID: 7, UserID: c.UserID, AppID: c.AppID, Name: "ci", Hint: "sso_pat_secr"}}, nil
+}
+
+func (fakeAuth) RevokeAccessToken(ctx context.Context, tokenID int64) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if tokenID == 404 {
+		return auth.ErrAccessTokenNotFound
+	}
+
+	return nil
+}
+
+func (fakeAuth) Impersonate(ctx context.Context, userID int64, _ int, _ string) (string, time.Time, error) {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return "", time.Time{}, auth.ErrUnauthenticated
+	}
+
+	if userID == 404 {
+		return "", time.Time{}, auth.ErrUserNotFound
+	}
+
+	return "impersonation-token", time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+func (fakeAuth) ExchangeToken(
+	_ context.Context,
+	_ string,
+	_ int,
+	appSecret string,
+	_ int,
+	scopes []string,
+) (string, models.Caller, time.Time, error) {
+	if appSecret != "app-secret" {
+		return "", models.Caller{}, time.Time{}, auth.ErrInvalidClient
+	}
+
+	return "exchanged-token", models.Caller{Scopes: scopes}, time.Now().Add(time.Hour), nil
+}
+
+func (fakeAuth) StartFederatedLogin(_ context.Context, provider string, _ int, _ bool) (string, string, error) {
+	if provider != "google" {
+		return "", "", auth.ErrUnknownProvider
+	}
+
+	return "https://accounts.example.com/authorize?state=state", "login-ticket", nil
+}
+
+func (fakeAuth) CompleteFederatedLogin(
+	_ context.Context,
+	_ int,
+	code string,
+	_ string,
+	_ string,
+) (auth.FederatedLogin, error) {
+	if code != "code" {
+		return auth.FederatedLogin{}, auth.ErrFederatedLoginFailed
+	}
+
+	return auth.FederatedLogin{Token: "federated-token", UserID: 5, Created: true}, nil
+}
+
+func (fakeAuth) ListIdentities(ctx context.Context) ([]models.Identity, error) {
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return nil, auth.ErrUnauthenticated
+	}
+
+	return []models.Identity{{UserID: c.UserID, Provider: "google", Subject: "g-1", Email: c.Email}}, nil
+}
+
+func (fakeAuth) UnlinkIdentity(ctx context.Context, provider string) error {
+	if _, ok := caller.FromContext(ctx); !ok {
+		return auth.ErrUnauthenticated
+	}
+
+	if provider != "google" {
+		return auth.ErrIdentityNotFound
+	}
+
+	return auth.ErrLastIdentity
+}
+
+func newTestGateway(t *testing.T) http.Handler {
+	t.Helper()
+
+	return newTestApp(t).Handler()
+}
+
+func newTestApp(t *testing.T, opts ...Option) *App {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	grpcApp := grpcapp.New(log, fakeAuth{}, config.GRPCConfig{Port: 0})
+	go grpcApp.MustRun()
+	t.Cleanup(func() { _ = grpcApp.Stop() })
+
+	conn, err := grpcApp.DialInProcess()
+	require.NoError(t, err)
+
+	app, err := New(log, conn, 0, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return app
+}
+
+// newTestCert returns a self-signed certificate for localhost
+func newTestCert(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestGateway_StatusMapping(t *testing.T) {
+	handler := newTestGateway(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Register",
+			method:     http.MethodPost,
+			path:       "/v1/register",
+			body:       `{"email":"new@example.com","password":"secret"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"userId":"42"`,
+		},
+		{
+			name:       "Register existing user",
+			method:     http.MethodPost,
+			path:       "/v1/register",
+			body:       `{"email":"exists@example.com","password":"secret"}`,
+			wantStatus: http.StatusConflict,
+			wantBody:   "user already exists",
+		},
+		{
+			name:       "Register without email",
+			method:     http.MethodPost,
+			path:       "/v1/register",
+			body:       `{"password":"secret"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "email is required",
+		},
+		{
+			name:       "Register directory user",
+			method:     http.MethodPost,
+			path:       "/v1/register",
+			body:       `{"email":"staff@corp.example.com","password":"secret"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Register internal error",
+			method:     http.MethodPost,
+			path:       "/v1/register",
+			body:       `{"email":"broken@example.com","password":"secret"}`,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "Login",
+			method:     http.MethodPost,
+			path:       "/v1/login",
+			body:       `{"email":"user@example.com","password":"secret","app_id":1}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"token":"token-user@example.com"`,
+		},
+		{
+			name:       "Login with invalid credentials",
+			method:     http.MethodPost,
+			path:       "/v1/login",
+			body:       `{"email":"user@example.com","password":"secret","app_id":2}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Login with directory unavailable",
+			method:     http.MethodPost,
+			path:       "/v1/login",
+			body:       `{"email":"staff@corp.example.com","password":"secret","app_id":1}`,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "Login panics",
+			method:     http.MethodPost,
+			path:       "/v1/login",
+			body:       `{"email":"panic@example.com","password":"secret","app_id":1}`,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "IsAdmin",
+			method:     http.MethodGet,
+			path:       "/v1/users/1/is_admin",
+			wantStatus: http.StatusOK,
+			wantBody:   `"isAdmin":true`,
+		},
+		{
+			name:       "IsAdmin unknown user",
+			method:     http.MethodGet,
+			path:       "/v1/users/404/is_admin",
+			wantStatus: http.StatusNotFound,
+			wantBody:   "user not found",
+		},
+		{
+			name:       "ListAuditEvents",
+			method:     http.MethodGet,
+			path:       "/v1/admin/audit_events?user_id=5&types=login",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"userId":"5"`,
+		},
+		{
+			name:       "ListAuditEvents without token",
+			method:     http.MethodGet,
+			path:       "/v1/admin/audit_events",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "ListAuditEvents with invalid token",
+			method:     http.MethodGet,
+			path:       "/v1/admin/audit_events",
+			token:      "forged",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "ChangeEmail",
+			method:     http.MethodPost,
+			path:       "/v1/users/me/email",
+			body:       `{"email":"new@example.com","password":"secret"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ChangeEmail to a taken email",
+			method:     http.MethodPost,
+			path:       "/v1/users/me/email",
+			body:       `{"email":"exists@example.com","password":"secret"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "ChangeEmail without token",
+			method:     http.MethodPost,
+			path:       "/v1/users/me/email",
+			body:       `{"email":"new@example.com","password":"secret"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "DisableUser",
+			method:     http.MethodPost,
+			path:       "/v1/admin/users/5/disable",
+			body:       `{}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "DisableUser unknown user",
+			method:     http.MethodPost,
+			path:       "/v1/admin/users/404/disable",
+			body:       `{}`,
+			token:      "admin-token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "ListWebhookEvents",
+			method:     http.MethodGet,
+			path:       "/v1/admin/webhook_events?app_id=2&status=dead",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"eventId":"ev-3"`,
+		},
+		{
+			name:       "ReplayWebhookEvents",
+			method:     http.MethodPost,
+			path:       "/v1/admin/webhook_events/replay",
+			body:       `{"ids":["3","4"]}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"replayed":"2"`,
+		},
+		{
+			name:       "ReplayWebhookEvents without token",
+			method:     http.MethodPost,
+			path:       "/v1/admin/webhook_events/replay",
+			body:       `{}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "ChangePassword",
+			method:     http.MethodPost,
+			path:       "/v1/users/me/password",
+			body:       `{"oldPassword":"secret","newPassword":"new-secret"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "ChangePassword with wrong password",
+			method:     http.MethodPost,
+			path:       "/v1/users/me/password",
+			body:       `{"oldPassword":"wrong","newPassword":"new-secret"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "ListSessions",
+			method:     http.MethodGet,
+			path:       "/v1/users/me/sessions",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"session-1"`,
+		},
+		{
+			name:       "ListSessions without token",
+			method:     http.MethodGet,
+			path:       "/v1/users/me/sessions",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "RevokeSession",
+			method:     http.MethodDelete,
+			path:       "/v1/users/me/sessions/session-1",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "RevokeSession unknown session",
+			method:     http.MethodDelete,
+			path:       "/v1/users/me/sessions/missing",
+			token:      "admin-token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "RevokeAllSessions",
+			method:     http.MethodPost,
+			path:       "/v1/users/me/sessions/revoke_all",
+			body:       `{"keepCurrent":true}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"revoked":"1"`,
+		},
+		{
+			name:       "ListUserSessions",
+			method:     http.MethodGet,
+			path:       "/v1/admin/users/5/sessions",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"session-of-5"`,
+		},
+		{
+			name:       "RevokeUserSession",
+			method:     http.MethodDelete,
+			path:       "/v1/admin/users/5/sessions/session-1",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "RevokeAllUserSessions unknown user",
+			method:     http.MethodPost,
+			path:       "/v1/admin/users/404/sessions/revoke_all",
+			body:       `{}`,
+			token:      "admin-token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "CreateGroup",
+			method:     http.MethodPost,
+			path:       "/v1/admin/groups",
+			body:       `{"name":"engineering"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"groupId":"7"`,
+		},
+		{
+			name:       "CreateGroup taken name",
+			method:     http.MethodPost,
+			path:       "/v1/admin/groups",
+			body:       `{"name":"taken"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "ListGroups",
+			method:     http.MethodGet,
+			path:       "/v1/admin/groups",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"name":"engineering"`,
+		},
+		{
+			name:       "GetGroup",
+			method:     http.MethodGet,
+			path:       "/v1/admin/groups/7",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"role":"editor"`,
+		},
+		{
+			name:       "GetGroup unknown",
+			method:     http.MethodGet,
+			path:       "/v1/admin/groups/404",
+			token:      "admin-token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "DeleteGroup",
+			method:     http.MethodDelete,
+			path:       "/v1/admin/groups/7",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "AddGroupMember",
+			method:     http.MethodPost,
+			path:       "/v1/admin/groups/7/members",
+			body:       `{"userId":"5"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "AddGroupMember cycle",
+			method:     http.MethodPost,
+			path:       "/v1/admin/groups/7/members",
+			body:       `{"subgroupId":"7"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "RemoveGroupMember",
+			method:     http.MethodPost,
+			path:       "/v1/admin/groups/7/members/remove",
+			body:       `{"subgroupId":"8"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "GrantGroupRole",
+			method:     http.MethodPost,
+			path:       "/v1/admin/groups/7/roles",
+			body:       `{"appId":1,"role":"editor"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "RevokeGroupRole app of another tenant",
+			method:     http.MethodDelete,
+			path:       "/v1/admin/groups/7/roles/2/editor",
+			token:      "admin-token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "GetUserPermissions",
+			method:     http.MethodGet,
+			path:       "/v1/users/5/permissions?app_id=3",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"roles":["role-in-3"]`,
+		},
+		{
+			name:       "ListConsents",
+			method:     http.MethodGet,
+			path:       "/v1/users/me/consents",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"scopes":["email",`,
+		},
+		{
+			name:       "RevokeConsent",
+			method:     http.MethodDelete,
+			path:       "/v1/users/me/consents/3",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "RevokeConsent unknown consent",
+			method:     http.MethodDelete,
+			path:       "/v1/users/me/consents/404",
+			token:      "admin-token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "CreateAccessToken",
+			method:     http.MethodPost,
+			path:       "/v1/users/me/access_tokens",
+			body:       `{"name":"ci","app_id":3,"scopes":["email"],"expires_at":"2030-01-01T00:00:00Z"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"token":"sso_pat_secret"`,
+		},
+		{
+			name:       "CreateAccessToken unknown app",
+			method:     http.MethodPost,
+			path:       "/v1/users/me/access_tokens",
+			body:       `{"name":"ci","app_id":404}`,
+			token:      "admin-token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "ListAccessTokens",
+			method:     http.MethodGet,
+			path:       "/v1/users/me/access_tokens",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"hint":"sso_pat_secr"`,
+		},
+		{
+			name:       "RevokeAccessToken unknown token",
+			method:     http.MethodDelete,
+			path:       "/v1/users/me/access_tokens/404",
+			token:      "admin-token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Impersonate",
+			method:     http.MethodPost,
+			path:       "/v1/admin/users/5/impersonate",
+			body:       `{"reason":"ticket 42"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"token":"impersonation-token"`,
+		},
+		{
+			name:       "Impersonate without reason",
+			method:     http.MethodPost,
+			path:       "/v1/admin/users/5/impersonate",
+			body:       `{}`,
+			token:      "admin-token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Impersonate unknown user",
+			method:     http.MethodPost,
+			path:       "/v1/admin/users/404/impersonate",
+			body:       `{"reason":"ticket 42"}`,
+			token:      "admin-token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "ExchangeToken",
+			method:     http.MethodPost,
+			path:       "/v1/token/exchange",
+			body:       `{"subject_token":"user-token","app_id":1,"app_secret":"app-secret","audience":2,"scopes":["email"]}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"accessToken":"exchanged-token"`,
+		},
+		{
+			name:       "ExchangeToken wrong secret",
+			method:     http.MethodPost,
+			path:       "/v1/token/exchange",
+			body:       `{"subject_token":"user-token","app_id":1,"app_secret":"wrong","audience":2}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "StartFederatedLogin",
+			method:     http.MethodPost,
+			path:       "/v1/federation/google/start",
+			body:       `{"app_id":1}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"ticket":"login-ticket"`,
+		},
+		{
+			name:       "StartFederatedLogin unknown provider",
+			method:     http.MethodPost,
+			path:       "/v1/federation/facebook/start",
+			body:       `{"app_id":1}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "CompleteFederatedLogin",
+			method:     http.MethodPost,
+			path:       "/v1/federation/complete",
+			body:       `{"app_id":1,"code":"code","state":"state","ticket":"login-ticket"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"token":"federated-token"`,
+		},
+		{
+			name:       "CompleteFederatedLogin invalid code",
+			method:     http.MethodPost,
+			path:       "/v1/federation/complete",
+			body:       `{"app_id":1,"code":"forged","state":"state","ticket":"login-ticket"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "ListIdentities",
+			method:     http.MethodGet,
+			path:       "/v1/users/me/identities",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"subject":"g-1"`,
+		},
+		{
+			name:       "UnlinkIdentity last identity",
+			method:     http.MethodDelete,
+			path:       "/v1/users/me/identities/google",
+			token:      "admin-token",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "UnlinkIdentity unknown identity",
+			method:     http.MethodDelete,
+			path:       "/v1/users/me/identities/github",
+			token:      "admin-token",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "WatchUserEvents",
+			method:     http.MethodGet,
+			path:       "/v1/admin/user_events/watch?app_id=2",
+			token:      "admin-token",
+			wantStatus: http.StatusOK,
+			wantBody:   `"cursor":"cursor-2"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			req.Header.Set("X-Request-Id", "req-"+test.name)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.wantStatus, rec.Code, rec.Body.String())
+			assert.Contains(t, rec.Body.String(), test.wantBody)
+			if test.wantStatus == http.StatusOK {
+				assert.Equal(t, "req-"+test.name, rec.Header().Get("X-Request-Id"))
+			}
+		})
+	}
+}
+
+func TestGateway_ClientInfo(t *testing.T) {
+	handler := newTestGateway(t)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name: "Remote address",
+			want: "192.0.2.1 agent/1.0",
+		},
+		{
+			name:    "Forwarded address",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			want:    "192.0.2.1 agent/1.0",
+		},
+		{
+			name: "Metadata headers",
+			headers: map[string]string{
+				"Grpc-Metadata-X-Forwarded-For":        "203.0.113.7",
+				"Grpc-Metadata-Grpcgateway-User-Agent": "spoofed/1.0",
+			},
+			want: "192.0.2.1 agent/1.0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/login",
+				strings.NewReader(`{"email":"client@example.com","password":"secret","app_id":1}`))
+			req.Header.Set("User-Agent", "agent/1.0")
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+			var resp struct {
+				Token string `json:"token"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, test.want, resp.Token)
+		})
+	}
+}
+
+func TestGateway_TLS(t *testing.T) {
+	serverCert, clientCert := newTestCert(t), newTestCert(t)
+
+	clientCAs := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	require.NoError(t, err)
+	clientCAs.AddCert(leaf)
+
+	app := newTestApp(t, WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = app.serve(listener) }()
+	t.Cleanup(func() { _ = app.httpServer.Close() })
+
+	rootCAs := x509.NewCertPool()
+	serverLeaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	require.NoError(t, err)
+	rootCAs.AddCert(serverLeaf)
+
+	get := func(scheme string, certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: rootCAs, Certificates: certs},
+		}}
+
+		return client.Get(scheme + "://" + listener.Addr().String() + OpenAPIPath)
+	}
+
+	t.Run("Plaintext", func(t *testing.T) {
+		resp, err := get("http")
+		if err == nil {
+			_ = resp.Body.Close()
+			assert.NotEqual(t, http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("Without client certificate", func(t *testing.T) {
+		resp, err := get("https")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		require.Error(t, err)
+	})
+
+	t.Run("With client certificate", func(t *testing.T) {
+		resp, err := get("https", clientCert)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestGateway_OpenAPI(t *testing.T) {
+	handler := newTestGateway(t)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, OpenAPIPath, nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var spec struct {
+		Paths map[string]any `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &spec))
+
+	for _, path := range []string{"/v1/register", "/v1/login", "/v1/users/{userId}/is_admin", "/v1/admin/audit_events",
+		"/v1/users/me/email", "/v1/admin/users/{userId}/disable", "/v1/admin/webhook_events",
+		"/v1/admin/webhook_events/replay", "/v1/users/me/password", "/v1/admin/user_events/watch",
+		"/v1/users/me/sessions", "/v1/users/me/sessions/{sessionId}", "/v1/users/me/sessions/revoke_all",
+		"/v1/admin/users/{userId}/sessions", "/v1/admin/users/{userId}/sessions/{sessionId}",
+		"/v1/admin/users/{userId}/sessions/revoke_all",
+		"/v1/admin/groups", "/v1/admin/groups/{groupId}", "/v1/admin/groups/{groupId}/members",
+		"/v1/admin/groups/{groupId}/members/remove", "/v1/admin/groups/{groupId}/roles",
+		"/v1/admin/groups/{groupId}/roles/{appId}/{role}", "/v1/users/{userId}/permissions",
+		"/v1/users/me/consents", "/v1/users/me/consents/{appId}",
+		"/v1/users/me/access_tokens", "/v1/users/me/access_tokens/{id}",
+		"/v1/admin/users/{userId}/impersonate", "/v1/token/exchange",
+		"/v1/federation/{provider}/start", "/v1/federation/complete",
+		"/v1/users/me/identities", "/v1/users/me/identities/{provider}",
+	} {
+		assert.Contains(t, spec.Paths, path)
+	}
+}
@@ -1,32 +1,194 @@
 package grpcapp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/test/bufconn"
+	"grpc-sso/internal/config"
 	grpcauth "grpc-sso/internal/grpc/auth"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/grpc/health"
+	"grpc-sso/internal/grpc/interceptors"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/lib/ratelimit"
 	"log/slog"
 	"net"
+	"sync"
+	"time"
 )
 
+const stopTimeout = 10 * time.Second
+
+// inProcessBufferSize is the buffer size of in-process connections
+const inProcessBufferSize = 1024 * 1024
+
 type App struct {
-	log        *slog.Logger
-	gRPCServer *grpc.Server
-	port       int
+	log               *slog.Logger
+	gRPCServer        *grpc.Server
+	inProcessServer   *grpc.Server
+	inProcessListener *bufconn.Listener
+	healthMonitor     *health.Monitor
+	port              int
+	// shutdown is closed by Stop to end the streams
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+}
+
+type options struct {
+	limiter      ratelimit.Limiter
+	creds        credentials.TransportCredentials
+	healthChecks []health.Check
+}
+
+// Option configures optional parts of the gRPC server app
+type Option func(*options)
+
+// WithRateLimiter enables rate limiting of calls with the given limiter
+func WithRateLimiter(limiter ratelimit.Limiter) Option {
+	return func(o *options) {
+		o.limiter = limiter
+	}
 }
 
-// New creates new gRPC server app
+// WithTransportCredentials makes the server use the credentials, e.g. TLS,
+// instead of plaintext
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) {
+		o.creds = creds
+	}
+}
+
+// WithHealthChecks makes the health service report SERVING only when all checks pass
+func WithHealthChecks(checks ...health.Check) Option {
+	return func(o *options) {
+		o.healthChecks = append(o.healthChecks, checks...)
+	}
+}
+
+// New creates new gRPC server app.
+// Every call gets a request ID, metrics, an access log record, panic recovery
+// and the caller of its bearer token, if any. Streams also end when the server stops.
+//
+// Besides the network listener the services are served in process without
+// transport credentials, for the REST gateway. See DialInProcess.
 func New(
 	log *slog.Logger,
 	authService grpcauth.Auth,
-	port int,
+	cfg config.GRPCConfig,
+	opts ...Option,
 ) *App {
-	gRPCServer := grpc.NewServer()
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		interceptors.RequestID(log),
+		interceptors.Metrics(),
+		interceptors.Logging(log),
+		interceptors.Recovery(log),
+	}
+
+	if o.limiter != nil {
+		unaryInterceptors = append(unaryInterceptors,
+			interceptors.RateLimit(log, o.limiter, rateLimitRules(cfg.RateLimit)))
+	}
+
+	unaryInterceptors = append(unaryInterceptors, interceptors.Authenticate(log, authService.VerifyToken))
+
+	shutdown := make(chan struct{})
+
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		interceptors.StreamRequestID(log),
+		interceptors.StreamMetrics(),
+		interceptors.StreamLogging(log),
+		interceptors.StreamRecovery(log),
+	}
+
+	if o.limiter != nil {
+		streamInterceptors = append(streamInterceptors,
+			interceptors.StreamRateLimit(log, o.limiter, rateLimitRules(cfg.RateLimit)))
+	}
+
+	streamInterceptors = append(streamInterceptors,
+		interceptors.StreamAuthenticate(log, authService.VerifyToken),
+		interceptors.StreamShutdown(shutdown),
+	)
+
+	serverOpts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+
+	inProcessServer := grpc.NewServer(serverOpts...)
+	grpcauth.Register(inProcessServer, authService)
+
+	if o.creds != nil {
+		serverOpts = append(serverOpts, grpc.Creds(o.creds))
+	}
+
+	gRPCServer := grpc.NewServer(serverOpts...)
 	grpcauth.Register(gRPCServer, authService)
 
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(gRPCServer, healthServer)
+
+	healthMonitor := health.NewMonitor(log, healthServer, cfg.HealthCheckInterval,
+		[]string{sso.Auth_ServiceDesc.ServiceName}, o.healthChecks...)
+
+	if cfg.Reflection {
+		reflection.Register(gRPCServer)
+	}
+
 	return &App{
-		log:        log,
-		gRPCServer: gRPCServer,
-		port:       port,
+		log:               log,
+		gRPCServer:        gRPCServer,
+		inProcessServer:   inProcessServer,
+		inProcessListener: bufconn.Listen(inProcessBufferSize),
+		healthMonitor:     healthMonitor,
+		port:              cfg.Port,
+		shutdown:          shutdown,
+	}
+}
+
+// DialInProcess returns a client connection to the in-process server.
+// The connection skips the network and transport credentials,
+// but goes through the same interceptors as network calls.
+func (app *App) DialInProcess() (*grpc.ClientConn, error) {
+	const op = "grpcapp.DialInProcess"
+
+	conn, err := grpc.NewClient("passthrough:///"+clientinfo.InProcessNetwork,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return app.inProcessListener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return conn, nil
+}
+
+func rateLimitRules(cfg config.RateLimitConfig) interceptors.RateLimitRules {
+	methods := make(map[string]ratelimit.Limit, len(cfg.Methods))
+	for method, limit := range cfg.Methods {
+		methods[method] = ratelimit.Limit(limit)
+	}
+
+	return interceptors.RateLimitRules{
+		Methods: methods,
+		Peer:    ratelimit.Limit(cfg.Peer),
+		App:     ratelimit.Limit(cfg.App),
 	}
 }
 
@@ -54,21 +216,52 @@ func (app *App) Run() error {
 		slog.Int("port", app.port),
 	)
 
-	if err := app.gRPCServer.Serve(listener); err != nil {
+	go app.healthMonitor.Run()
+
+	go func() {
+		if err := app.inProcessServer.Serve(app.inProcessListener); err != nil {
+			log.Error("in-process gRPC server failed", slog.String("error", err.Error()))
+		}
+	}()
+
+	// ErrServerStopped means Stop was called before the server started serving
+	if err := app.gRPCServer.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
 
-// Stop stops gRPC server
+// Stop stops gRPC server.
+// The health status is switched to NOT_SERVING first, so no new calls are routed
+// to the server while in-flight calls finish. Streams are ended right away
+// with UNAVAILABLE, they don't finish by themselves. Calls still running after
+// the timeout are cancelled.
 func (app *App) Stop() error {
 	const op = "grpcapp.Stop"
 
-	app.log.With(slog.String("op", op)).
-		Info("stopping gRPC server", slog.Int("port", app.port))
+	log := app.log.With(slog.String("op", op))
+
+	log.Info("stopping gRPC server", slog.Int("port", app.port))
+
+	app.healthMonitor.Shutdown()
 
-	app.gRPCServer.GracefulStop()
+	app.shutdownOnce.Do(func() { close(app.shutdown) })
+
+	stopped := make(chan struct{})
+	go func() {
+		app.gRPCServer.GracefulStop()
+		app.inProcessServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(stopTimeout):
+		log.Warn("graceful stop timed out, cancelling active calls")
+		app.gRPCServer.Stop()
+		app.inProcessServer.Stop()
+	}
 
 	return nil
 }
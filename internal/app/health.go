@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/grpc/health"
+	"grpc-sso/internal/lib/migrations"
+)
+
+var (
+	ErrMigrationsDirty    = errors.New("migrations are dirty")
+	ErrMigrationsOutdated = errors.New("migrations are not at the expected version")
+	ErrNoSigningKeys      = errors.New("no signing keys loaded")
+)
+
+type readinessProvider interface {
+	Ping(ctx context.Context) error
+	SigningKeys(ctx context.Context) (count int, err error)
+}
+
+// migrationsProvider is implemented by the storages that are migrated with the migrator
+type migrationsProvider interface {
+	MigrationVersion(ctx context.Context, table string) (version uint, dirty bool, err error)
+}
+
+// newHealthChecks returns readiness checks of the storage.
+// The migrations check is skipped when the migrations path is not configured
+// or the storage has no migrations.
+func newHealthChecks(cfg *config.Config, storage readinessProvider) ([]health.Check, error) {
+	const op = "app.newHealthChecks"
+
+	checks := []health.Check{
+		{Name: "storage", Func: storage.Ping},
+		{Name: "signing_keys", Func: func(ctx context.Context) error {
+			count, err := storage.SigningKeys(ctx)
+			if err != nil {
+				return err
+			}
+
+			if count == 0 {
+				return ErrNoSigningKeys
+			}
+
+			return nil
+		}},
+	}
+
+	migrated, ok := storage.(migrationsProvider)
+	if !ok || cfg.MigrationsPath == "" {
+		return checks, nil
+	}
+
+	expected, err := migrations.LatestVersion(cfg.MigrationsPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	checks = append(checks, health.Check{Name: "migrations", Func: func(ctx context.Context) error {
+		version, dirty, err := migrated.MigrationVersion(ctx, cfg.MigrationsTable)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return ErrMigrationsDirty
+		}
+
+		if version != expected {
+			return fmt.Errorf("%w: %d, expected %d", ErrMigrationsOutdated, version, expected)
+		}
+
+		return nil
+	}})
+
+	return checks, nil
+}
@@ -0,0 +1,86 @@
+package metricsapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/lib/metrics"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+type App struct {
+	log        *slog.Logger
+	httpServer *http.Server
+	port       int
+}
+
+// New creates new HTTP server app exposing /metrics
+func New(
+	log *slog.Logger,
+	port int,
+) *App {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	return &App{
+		log: log,
+		httpServer: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+		port: port,
+	}
+}
+
+// MustRun runs metrics server and panics if any error occurs
+func (app *App) MustRun() {
+	err := app.Run()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Run runs metrics server
+func (app *App) Run() error {
+	const op = "metricsapp.Run"
+
+	log := app.log.With(slog.String("op", op))
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", app.port))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("metrics server is running",
+		slog.String("address", listener.Addr().String()),
+		slog.Int("port", app.port),
+	)
+
+	if err := app.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Stop stops metrics server
+func (app *App) Stop() error {
+	const op = "metricsapp.Stop"
+
+	app.log.With(slog.String("op", op)).
+		Info("stopping metrics server", slog.Int("port", app.port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := app.httpServer.Shutdown(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
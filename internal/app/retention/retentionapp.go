@@ -0,0 +1,116 @@
+package retentionapp
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Job deletes the records that are older than its retention
+type Job struct {
+	// Name is used in logs, e.g. audit_events
+	Name      string
+	Retention time.Duration
+	// Prune deletes the records created before the time and returns their number
+	Prune func(ctx context.Context, before time.Time) (int64, error)
+}
+
+type App struct {
+	log      *slog.Logger
+	interval time.Duration
+	jobs     []Job
+	now      func() time.Time
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// New creates the app that runs the jobs every interval.
+// Jobs with zero retention keep the records forever and are not run.
+func New(log *slog.Logger, interval time.Duration, jobs ...Job) *App {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	enabled := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if job.Retention > 0 {
+			enabled = append(enabled, job)
+		}
+	}
+
+	return &App{
+		log:      log,
+		interval: interval,
+		jobs:     enabled,
+		now:      time.Now,
+		ctx:      ctx,
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Run runs the jobs right away and then every interval until Stop is called
+func (app *App) Run() {
+	const op = "retentionapp.Run"
+
+	defer close(app.stopped)
+
+	if len(app.jobs) == 0 || app.interval <= 0 {
+		return
+	}
+
+	app.log.With(slog.String("op", op)).
+		Info("retention jobs are running", slog.Duration("interval", app.interval))
+
+	ticker := time.NewTicker(app.interval)
+	defer ticker.Stop()
+
+	for {
+		app.RunOnce(app.ctx)
+
+		select {
+		case <-app.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs every job once. Failures are logged, the other jobs still run
+func (app *App) RunOnce(ctx context.Context) {
+	const op = "retentionapp.RunOnce"
+
+	log := app.log.With(slog.String("op", op))
+
+	for _, job := range app.jobs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		deleted, err := job.Prune(ctx, app.now().Add(-job.Retention))
+		if err != nil {
+			log.Error("retention job failed",
+				slog.String("job", job.Name),
+				slog.String("error", err.Error()))
+
+			continue
+		}
+
+		if deleted > 0 {
+			log.Info("expired records deleted",
+				slog.String("job", job.Name),
+				slog.Int64("deleted", deleted))
+		}
+	}
+}
+
+// Stop cancels the running jobs and waits for Run to return.
+// Run must have been called
+func (app *App) Stop() {
+	const op = "retentionapp.Stop"
+
+	app.log.With(slog.String("op", op)).Info("stopping retention jobs")
+
+	app.cancel()
+	<-app.stopped
+}
@@ -0,0 +1,73 @@
+package retentionapp
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestRunOnce(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var got []time.Time
+	prune := func(_ context.Context, before time.Time) (int64, error) {
+		got = append(got, before)
+
+		return 1, nil
+	}
+
+	app := New(discard, time.Hour,
+		Job{Name: "audit_events", Retention: 24 * time.Hour, Prune: prune},
+		Job{Name: "failing", Retention: time.Hour, Prune: func(context.Context, time.Time) (int64, error) {
+			return 0, errors.New("storage is down")
+		}},
+		Job{Name: "keep_forever", Retention: 0, Prune: prune},
+		Job{Name: "sessions", Retention: time.Hour, Prune: prune},
+	)
+	app.now = func() time.Time { return now }
+
+	app.RunOnce(context.Background())
+
+	// The failing job doesn't stop the next ones, the disabled one isn't run
+	assert.Equal(t, []time.Time{now.Add(-24 * time.Hour), now.Add(-time.Hour)}, got)
+}
+
+func TestRunStop(t *testing.T) {
+	var runs atomic.Int64
+
+	app := New(discard, 10*time.Millisecond, Job{
+		Name:      "audit_events",
+		Retention: time.Hour,
+		Prune: func(context.Context, time.Time) (int64, error) {
+			runs.Add(1)
+
+			return 0, nil
+		},
+	})
+
+	go app.Run()
+
+	require.Eventually(t, func() bool { return runs.Load() >= 2 }, time.Second, 5*time.Millisecond)
+
+	app.Stop()
+
+	n := runs.Load()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, runs.Load(), "jobs run after Stop")
+}
+
+func TestRunStop_NoJobs(t *testing.T) {
+	app := New(discard, time.Hour)
+
+	go app.Run()
+
+	app.Stop()
+}
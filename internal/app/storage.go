@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/services/auth"
+	"grpc-sso/internal/services/webhook"
+	"grpc-sso/internal/storage/memory"
+	"grpc-sso/internal/storage/postgres"
+	"grpc-sso/internal/storage/sqlite"
+	"io"
+	"time"
+)
+
+// storage is what the app needs from a storage backend
+type storage interface {
+	auth.UserSaver
+	auth.UserProvider
+	auth.AppProvider
+	auth.AuditLog
+	PruneAuditEvents(ctx context.Context, before time.Time) (int64, error)
+	auth.Outbox
+	webhook.Outbox
+	PruneDeliveredEvents(ctx context.Context, before time.Time) (int64, error)
+	auth.EventLog
+	PruneUserEvents(ctx context.Context, before time.Time) (int64, error)
+	auth.Sessions
+	PruneSessions(ctx context.Context, before time.Time) (int64, error)
+	auth.Groups
+	auth.PermissionsProvider
+	auth.Consents
+	auth.AccessTokens
+	auth.Identities
+	readinessProvider
+	io.Closer
+}
+
+// newStorage opens the configured storage backend
+func newStorage(cfg *config.Config) (storage, error) {
+	const op = "app.newStorage"
+
+	switch cfg.Storage.Driver {
+	case config.StorageDriverSQLite:
+		s, err := sqlite.New(cfg.StoragePath, sqlite.Options{
+			JournalMode:     cfg.Storage.SQLite.JournalMode,
+			BusyTimeout:     cfg.Storage.SQLite.BusyTimeout,
+			ForeignKeys:     cfg.Storage.SQLite.ForeignKeys,
+			Synchronous:     cfg.Storage.SQLite.Synchronous,
+			MaxOpenConns:    cfg.Storage.SQLite.MaxOpenConns,
+			MaxIdleConns:    cfg.Storage.SQLite.MaxIdleConns,
+			ConnMaxLifetime: cfg.Storage.SQLite.ConnMaxLifetime,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return s, nil
+	case config.StorageDriverPostgres:
+		s, err := postgres.New(cfg.Storage.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return s, nil
+	case config.StorageDriverMemory:
+		s := memory.New()
+
+		for _, tenant := range cfg.Storage.Tenants {
+			s.AddTenant(models.Tenant{ID: tenant.ID, Name: tenant.Name})
+
+			for _, email := range tenant.Admins {
+				s.AddAdminEmail(tenant.ID, email)
+			}
+		}
+
+		for _, app := range cfg.Storage.Apps {
+			s.AddApp(models.App{
+				ID:            app.ID,
+				TenantID:      app.TenantID,
+				Name:          app.Name,
+				Secret:        app.Secret,
+				WebhookURL:    app.WebhookURL,
+				WebhookSecret: app.WebhookSecret,
+				Scopes:        app.Scopes,
+
+				ExchangeAudiences: app.ExchangeAudiences,
+			})
+		}
+
+		for _, email := range cfg.Storage.Admins {
+			s.AddAdminEmail(models.DefaultTenantID, email)
+		}
+
+		return s, nil
+	default:
+		return nil, fmt.Errorf("%s: unknown storage driver %q", op, cfg.Storage.Driver)
+	}
+}
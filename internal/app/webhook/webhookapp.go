@@ -0,0 +1,85 @@
+package webhookapp
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Dispatcher delivers a batch of the due outbox events
+type Dispatcher interface {
+	DispatchOnce(ctx context.Context) (int, error)
+}
+
+type App struct {
+	log          *slog.Logger
+	dispatcher   Dispatcher
+	pollInterval time.Duration
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// New creates the app that polls the outbox every pollInterval.
+// While there are due events the batches are dispatched back to back
+func New(log *slog.Logger, dispatcher Dispatcher, pollInterval time.Duration) *App {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &App{
+		log:          log,
+		dispatcher:   dispatcher,
+		pollInterval: pollInterval,
+		ctx:          ctx,
+		cancel:       cancel,
+		stopped:      make(chan struct{}),
+	}
+}
+
+// Run dispatches the events until Stop is called
+func (app *App) Run() {
+	const op = "webhookapp.Run"
+
+	defer close(app.stopped)
+
+	log := app.log.With(slog.String("op", op))
+
+	if app.pollInterval <= 0 {
+		return
+	}
+
+	log.Info("webhook dispatcher is running", slog.Duration("poll_interval", app.pollInterval))
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-app.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		n, err := app.dispatcher.DispatchOnce(app.ctx)
+		if err != nil && app.ctx.Err() == nil {
+			log.Error("failed to dispatch webhook events", slog.String("error", err.Error()))
+		}
+
+		if n > 0 && err == nil {
+			timer.Reset(0)
+		} else {
+			timer.Reset(app.pollInterval)
+		}
+	}
+}
+
+// Stop stops polling and waits for the dispatched batch to be delivered.
+// Run must have been called
+func (app *App) Stop() {
+	const op = "webhookapp.Stop"
+
+	app.log.With(slog.String("op", op)).Info("stopping webhook dispatcher")
+
+	app.cancel()
+	<-app.stopped
+}
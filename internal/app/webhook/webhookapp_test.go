@@ -0,0 +1,81 @@
+package webhookapp
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeDispatcher has batches due events, then nothing is due
+type fakeDispatcher struct {
+	batches atomic.Int64
+	calls   atomic.Int64
+	err     error
+}
+
+func (d *fakeDispatcher) DispatchOnce(context.Context) (int, error) {
+	d.calls.Add(1)
+
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	if d.batches.Add(-1) < 0 {
+		return 0, nil
+	}
+
+	return 10, nil
+}
+
+func TestRun_DrainsBacklog(t *testing.T) {
+	d := &fakeDispatcher{}
+	d.batches.Store(3)
+
+	// The backlog is drained without waiting for the poll interval
+	app := New(discard, d, time.Hour)
+
+	go app.Run()
+
+	require.Eventually(t, func() bool { return d.calls.Load() == 4 }, time.Second, time.Millisecond)
+
+	app.Stop()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int64(4), d.calls.Load(), "polled before the interval passed")
+}
+
+func TestRun_PollsAfterError(t *testing.T) {
+	d := &fakeDispatcher{err: errors.New("storage is down")}
+
+	app := New(discard, d, 5*time.Millisecond)
+
+	go app.Run()
+
+	require.Eventually(t, func() bool { return d.calls.Load() >= 3 }, time.Second, time.Millisecond)
+
+	app.Stop()
+
+	n := d.calls.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, n, d.calls.Load(), "polled after Stop")
+}
+
+func TestRunStop_Disabled(t *testing.T) {
+	d := &fakeDispatcher{}
+
+	app := New(discard, d, 0)
+
+	go app.Run()
+
+	app.Stop()
+
+	assert.Zero(t, d.calls.Load())
+}
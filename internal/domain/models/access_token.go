@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// AccessToken is a personal access token of the user, a long-lived credential for scripts.
+// Only its hash is stored, the token itself is shown once when created
+type AccessToken struct {
+	ID     int64
+	UserID int64
+	// AppID is the app the token authenticates to, like the app of a login
+	AppID int
+	Name  string
+	// Hint is the start of the token, to tell the tokens apart
+	Hint   string
+	Hash   string
+	Scopes []string
+	// ExpiresAt is zero for tokens that never expire
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	// LastUsedAt is zero until the token is used
+	LastUsedAt time.Time
+	// RevokedAt is zero until the token is revoked
+	RevokedAt time.Time
+}
+
+// Active tells if the token is neither revoked nor expired at now
+func (t AccessToken) Active(now time.Time) bool {
+	return t.RevokedAt.IsZero() && (t.ExpiresAt.IsZero() || now.Before(t.ExpiresAt))
+}
@@ -1,9 +1,19 @@
 package models
 
 type App struct {
-	ID     int
-	Name   string
-	Secret string
+	ID int
+	// TenantID is the tenant owning the app, its users log in to it
+	TenantID int64
+	Name     string
+	Secret   string
+	// WebhookURL receives the domain events of the app, empty if it has no webhook
+	WebhookURL string
+	// WebhookSecret signs the webhook requests
+	WebhookSecret string
+	// Scopes are the scopes the app may request at the login
+	Scopes []string
+	// ExchangeAudiences are the apps the app may exchange the tokens of its users for, see ExchangeToken
+	ExchangeAudiences []int
 }
 
 const EmptyAppID = 0
@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+// Audit event types
+const (
+	AuditRegister       = "register"
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login_failed"
+	AuditAdminCheck     = "admin_check"
+	AuditPasswordChange = "password_change"
+	AuditEmailChange    = "email_change"
+	AuditTokenRevoke    = "token_revoke"
+	AuditAdminAction    = "admin_action"
+	AuditConsentGrant   = "consent_grant"
+	AuditConsentRevoke  = "consent_revoke"
+	// AuditAccessTokenCreate has the hint of the token as the reason
+	AuditAccessTokenCreate = "access_token_create"
+	AuditAccessTokenRevoke = "access_token_revoke"
+	// AuditImpersonate is the admin as the actor and the impersonated user, the reason given by the admin
+	AuditImpersonate = "impersonate"
+	// AuditTokenExchange has the audience as the app and the app that exchanged the token as the reason
+	AuditTokenExchange = "token_exchange"
+	// AuditIdentityLink and AuditIdentityUnlink have the upstream identity provider as the reason,
+	// so do AuditLogin and AuditRegister of the users signing in at one
+	AuditIdentityLink   = "identity_link"
+	AuditIdentityUnlink = "identity_unlink"
+)
+
+// Reasons of AuditLoginFailed events. Unlike the login response,
+// the audit log tells an unknown user from a wrong password
+const (
+	AuditReasonUserNotFound  = "user_not_found"
+	AuditReasonWrongPassword = "wrong_password"
+	AuditReasonInvalidAppID  = "invalid_app_id"
+	AuditReasonUserDisabled  = "user_disabled"
+	AuditReasonInvalidScope  = "invalid_scope"
+	AuditReasonNoConsent     = "no_consent"
+	AuditReasonInternal      = "internal"
+)
+
+// AuditEvent is a record of the audit log. Zero IDs mean unknown
+type AuditEvent struct {
+	ID       int64
+	TenantID int64
+	Type     string
+	// ActorID is the user who did it, e.g. the admin for admin actions
+	ActorID int64
+	// UserID and Email are of the user it was done to
+	UserID int64
+	Email  string
+	AppID  int
+	// Reason is why a login failed, which admin action was done or which scopes were granted
+	Reason    string
+	IP        string
+	UserAgent string
+	RequestID string
+	CreatedAt time.Time
+}
+
+// AuditFilter selects audit events, the newest first. Zero fields don't filter
+type AuditFilter struct {
+	TenantID int64
+	ActorID  int64
+	UserID   int64
+	AppID    int
+	Types    []string
+	Since    time.Time
+	Until    time.Time
+	// BeforeID continues the listing after the event with this ID
+	BeforeID int64
+	Limit    int
+}
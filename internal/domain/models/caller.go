@@ -0,0 +1,33 @@
+package models
+
+// Caller is the authenticated user making the call, taken from the token
+type Caller struct {
+	UserID   int64
+	TenantID int64
+	Email    string
+	AppID    int
+	// SessionID is the session the token was issued for, empty if it has none
+	SessionID string
+	// Roles are granted to the user in the app through the groups, Groups are the names of
+	// these groups and are set only if the token was requested with them
+	Roles  []string
+	Groups []string
+	// Scopes are the scopes the user consented to for the app, empty for tokens
+	// issued without requesting any
+	Scopes []string
+	// AccessTokenID is the personal access token of the call, zero for the tokens issued by Login
+	AccessTokenID int64
+	// Actor acts on behalf of the user, nil unless the token was issued by Impersonate or ExchangeToken
+	Actor *Actor
+}
+
+// Actor is the one acting on behalf of the user of the token, the act claim of RFC 8693
+type Actor struct {
+	// UserID and Email are the admin impersonating the user, zero for an app
+	UserID int64
+	Email  string
+	// AppID is the app the token was exchanged by, zero for an admin
+	AppID int
+	// Actor is the prior actor of a token exchanged again, nil if there is none
+	Actor *Actor
+}
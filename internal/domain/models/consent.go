@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Consent is what the user allowed the app to do on their behalf
+type Consent struct {
+	UserID int64
+	AppID  int
+	// Scopes are the granted scopes, sorted
+	Scopes []string
+	// GrantedAt is when the last of the scopes was granted
+	GrantedAt time.Time
+}
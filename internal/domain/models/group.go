@@ -0,0 +1,34 @@
+package models
+
+// Group is a set of users and nested groups of the tenant, the roles granted to it
+// are granted to its members and to the members of its nested groups
+type Group struct {
+	ID       int64
+	TenantID int64
+	Name     string
+	// UserIDs, SubgroupIDs and Roles are the direct members and grants, only a single group lookup fills them
+	UserIDs     []int64
+	SubgroupIDs []int64
+	Roles       []GroupRole
+}
+
+// GroupRole is a role granted to a group in an app
+type GroupRole struct {
+	AppID int
+	Role  string
+}
+
+// GroupMember is a user or a nested group, exactly one of the IDs is set
+type GroupMember struct {
+	UserID     int64
+	SubgroupID int64
+}
+
+// Permissions are the effective groups and roles of a user in an app,
+// with the membership of the nested groups expanded
+type Permissions struct {
+	// Groups are the names of the groups the user is a member of, directly or through nested groups
+	Groups []string
+	// Roles are granted to these groups in the app
+	Roles []string
+}
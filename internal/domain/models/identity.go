@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Identity links the account of the user at an upstream identity provider, such as Google or GitHub,
+// to the user. The accounts are linked per tenant, like the emails are registered,
+// and a user has at most one account per provider
+type Identity struct {
+	ID       int64
+	TenantID int64
+	UserID   int64
+	// Provider is the name of the provider in the config
+	Provider string
+	// Subject is the ID of the account at the provider
+	Subject string
+	// Email is the email of the account at the provider when it was linked
+	Email     string
+	CreatedAt time.Time
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Domain event types, delivered to the app webhooks and streamed to the watchers
+const (
+	EventUserRegistered      = "user.registered"
+	EventUserEmailChanged    = "user.email_changed"
+	EventUserDisabled        = "user.disabled"
+	EventUserPasswordChanged = "user.password_changed"
+	EventTokenRevoked        = "user.token_revoked"
+)
+
+// Delivery states of outbox events
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	// OutboxDead is the dead-letter state of events that failed every attempt
+	OutboxDead = "dead"
+)
+
+// OutboxEvent is a domain event to deliver to the webhook of an app.
+// An event is enqueued once per app of the tenant with a webhook, the copies share EventID
+type OutboxEvent struct {
+	ID       int64
+	EventID  string
+	Type     string
+	TenantID int64
+	AppID    int
+	UserID   int64
+	// Payload is the JSON body of the webhook request
+	Payload       []byte
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	// DeliveredAt is zero until the event is delivered
+	DeliveredAt time.Time
+}
+
+// OutboxFilter selects outbox events, the newest first. Zero fields don't filter
+type OutboxFilter struct {
+	Status   string
+	TenantID int64
+	AppID    int
+	// BeforeID continues the listing after the event with this ID
+	BeforeID int64
+	Limit    int
+}
+
+// ReplayFilter selects the events to deliver again. Without IDs
+// all dead-lettered events of the app, or of every app, are selected.
+// Zero TenantID doesn't filter
+type ReplayFilter struct {
+	IDs      []int64
+	TenantID int64
+	AppID    int
+}
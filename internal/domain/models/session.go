@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Session is a login of the user to an app, the token issued at the login carries its ID
+type Session struct {
+	ID         string
+	UserID     int64
+	AppID      int
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	// ExpiresAt is when the token of the session expires
+	ExpiresAt time.Time
+	// RevokedAt is zero until the session is revoked
+	RevokedAt time.Time
+}
+
+// Active tells if the session is neither revoked nor expired at now
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
@@ -0,0 +1,11 @@
+package models
+
+// Tenant is an organization owning its users and apps, isolated from the other tenants
+type Tenant struct {
+	ID   int64
+	Name string
+}
+
+// DefaultTenantID is the tenant of the requests that don't name one,
+// the users and apps created before the tenants were introduced belong to it
+const DefaultTenantID = int64(1)
@@ -1,9 +1,13 @@
 package models
 
 type User struct {
-	ID       int64
+	ID int64
+	// TenantID is the tenant the user belongs to, the email is unique within it
+	TenantID int64
 	Email    string
 	PassHash []byte
+	// Disabled users can't log in
+	Disabled bool
 }
 
 const EmptyUserID = int64(0)
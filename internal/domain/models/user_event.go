@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// UserEvent is a record of the user events log streamed to the watchers.
+// AppID is zero for the events that concern every app, e.g. EventUserDisabled
+type UserEvent struct {
+	ID        int64
+	TenantID  int64
+	Type      string
+	UserID    int64
+	Email     string
+	AppID     int
+	CreatedAt time.Time
+}
+
+// UserEventFilter selects user events, the oldest first. Zero fields don't filter
+type UserEventFilter struct {
+	// AfterID continues the log after the event with this ID
+	AfterID  int64
+	TenantID int64
+	// AppID selects the events of the app and the events of every app
+	AppID int
+	Types []string
+	Limit int
+}
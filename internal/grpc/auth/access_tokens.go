@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/services/auth"
+	"time"
+)
+
+func (s *serverAPI) CreateAccessToken(
+	ctx context.Context,
+	req *sso.CreateAccessTokenRequest,
+) (*sso.CreateAccessTokenResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	var expiresAt time.Time
+	if req.GetExpiresAt() != nil {
+		expiresAt = req.GetExpiresAt().AsTime()
+	}
+
+	token, created, err := s.auth.CreateAccessToken(ctx,
+		req.GetName(), int(req.GetAppId()), req.GetScopes(), expiresAt)
+	if err != nil {
+		return nil, accessTokenError(err)
+	}
+
+	return &sso.CreateAccessTokenResponse{Token: token, AccessToken: toAccessToken(created)}, nil
+}
+
+func (s *serverAPI) ListAccessTokens(
+	ctx context.Context,
+	_ *sso.ListAccessTokensRequest,
+) (*sso.ListAccessTokensResponse, error) {
+	tokens, err := s.auth.ListAccessTokens(ctx)
+	if err != nil {
+		return nil, accessTokenError(err)
+	}
+
+	resp := &sso.ListAccessTokensResponse{AccessTokens: make([]*sso.AccessToken, 0, len(tokens))}
+
+	for _, token := range tokens {
+		resp.AccessTokens = append(resp.AccessTokens, toAccessToken(token))
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) RevokeAccessToken(
+	ctx context.Context,
+	req *sso.RevokeAccessTokenRequest,
+) (*sso.RevokeAccessTokenResponse, error) {
+	if req.GetId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	if err := s.auth.RevokeAccessToken(ctx, req.GetId()); err != nil {
+		return nil, accessTokenError(err)
+	}
+
+	return &sso.RevokeAccessTokenResponse{}, nil
+}
+
+func toAccessToken(token models.AccessToken) *sso.AccessToken {
+	resp := &sso.AccessToken{
+		Id:        token.ID,
+		Name:      token.Name,
+		AppId:     int32(token.AppID),
+		Hint:      token.Hint,
+		Scopes:    token.Scopes,
+		CreatedAt: timestamppb.New(token.CreatedAt),
+	}
+
+	if !token.ExpiresAt.IsZero() {
+		resp.ExpiresAt = timestamppb.New(token.ExpiresAt)
+	}
+
+	if !token.LastUsedAt.IsZero() {
+		resp.LastUsedAt = timestamppb.New(token.LastUsedAt)
+	}
+
+	return resp
+}
+
+// accessTokenError maps the errors of the access token methods
+func accessTokenError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrAccessTokenNotFound):
+		return status.Error(codes.NotFound, "access token not found")
+	case errors.Is(err, auth.ErrInvalidAppID):
+		return status.Error(codes.InvalidArgument, "invalid app id")
+	case errors.Is(err, auth.ErrInvalidScope):
+		return status.Error(codes.InvalidArgument, "invalid scope")
+	case errors.Is(err, auth.ErrInvalidExpiry):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, auth.ErrConsentRequired):
+		return status.Error(codes.FailedPrecondition, "consent required")
+	case errors.Is(err, auth.ErrConsentsDisabled):
+		return status.Error(codes.Unimplemented, "scopes are not enabled")
+	case errors.Is(err, auth.ErrAccessTokensDisabled):
+		return status.Error(codes.Unimplemented, "access tokens are not enabled")
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "access tokens can't create access tokens")
+	default:
+		return adminError(err)
+	}
+}
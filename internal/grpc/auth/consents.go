@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/services/auth"
+)
+
+func (s *serverAPI) ListConsents(
+	ctx context.Context,
+	_ *sso.ListConsentsRequest,
+) (*sso.ListConsentsResponse, error) {
+	consents, err := s.auth.ListConsents(ctx)
+	if err != nil {
+		return nil, consentError(err)
+	}
+
+	resp := &sso.ListConsentsResponse{Consents: make([]*sso.Consent, 0, len(consents))}
+
+	for _, consent := range consents {
+		resp.Consents = append(resp.Consents, &sso.Consent{
+			AppId:     int32(consent.AppID),
+			Scopes:    consent.Scopes,
+			GrantedAt: timestamppb.New(consent.GrantedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) RevokeConsent(
+	ctx context.Context,
+	req *sso.RevokeConsentRequest,
+) (*sso.RevokeConsentResponse, error) {
+	if req.GetAppId() == models.EmptyAppID {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	if err := s.auth.RevokeConsent(ctx, int(req.GetAppId())); err != nil {
+		return nil, consentError(err)
+	}
+
+	return &sso.RevokeConsentResponse{}, nil
+}
+
+// consentError maps the errors of the consent methods
+func consentError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrConsentNotFound):
+		return status.Error(codes.NotFound, "consent not found")
+	case errors.Is(err, auth.ErrConsentsDisabled):
+		return status.Error(codes.Unimplemented, "scopes are not enabled")
+	default:
+		return adminError(err)
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/services/auth"
+	"time"
+)
+
+func (s *serverAPI) ExchangeToken(
+	ctx context.Context,
+	req *sso.ExchangeTokenRequest,
+) (*sso.ExchangeTokenResponse, error) {
+	if err := validateExchangeToken(req); err != nil {
+		return nil, err
+	}
+
+	token, issued, expiresAt, err := s.auth.ExchangeToken(ctx,
+		req.GetSubjectToken(), int(req.GetAppId()), req.GetAppSecret(), int(req.GetAudience()), req.GetScopes())
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidClient):
+			return nil, status.Error(codes.Unauthenticated, "invalid client")
+		case errors.Is(err, auth.ErrInvalidGrant):
+			return nil, status.Error(codes.InvalidArgument, "invalid subject token")
+		case errors.Is(err, auth.ErrAudienceNotAllowed):
+			return nil, status.Error(codes.PermissionDenied, "audience not allowed")
+		case errors.Is(err, auth.ErrInvalidAppID):
+			return nil, status.Error(codes.InvalidArgument, "invalid audience")
+		case errors.Is(err, auth.ErrInvalidScope):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		default:
+			return nil, status.Error(codes.Internal, "iternal error")
+		}
+	}
+
+	return &sso.ExchangeTokenResponse{
+		AccessToken:     token,
+		IssuedTokenType: auth.TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int64(time.Until(expiresAt).Round(time.Second).Seconds()),
+		Scopes:          issued.Scopes,
+	}, nil
+}
+
+func validateExchangeToken(req *sso.ExchangeTokenRequest) error {
+	if req.GetSubjectToken() == "" {
+		return status.Error(codes.InvalidArgument, "subject_token is required")
+	}
+
+	if req.GetSubjectTokenType() != "" && req.GetSubjectTokenType() != auth.TokenTypeAccessToken {
+		return status.Error(codes.InvalidArgument, "unsupported subject_token_type")
+	}
+
+	if req.GetAppId() == models.EmptyAppID {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	if req.GetAudience() == models.EmptyAppID {
+		return status.Error(codes.InvalidArgument, "audience is required")
+	}
+
+	return nil
+}
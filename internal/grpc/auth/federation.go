@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/services/auth"
+)
+
+func (s *serverAPI) StartFederatedLogin(
+	ctx context.Context,
+	req *sso.StartFederatedLoginRequest,
+) (*sso.StartFederatedLoginResponse, error) {
+	if req.GetProvider() == "" {
+		return nil, status.Error(codes.InvalidArgument, "provider is required")
+	}
+
+	if req.GetAppId() == models.EmptyAppID {
+		return nil, status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	authURL, ticket, err := s.auth.StartFederatedLogin(ctx, req.GetProvider(), int(req.GetAppId()), req.GetLink())
+	if err != nil {
+		return nil, federationError(err)
+	}
+
+	return &sso.StartFederatedLoginResponse{AuthUrl: authURL, Ticket: ticket}, nil
+}
+
+func (s *serverAPI) CompleteFederatedLogin(
+	ctx context.Context,
+	req *sso.CompleteFederatedLoginRequest,
+) (*sso.CompleteFederatedLoginResponse, error) {
+	if err := validateCompleteFederatedLogin(req); err != nil {
+		return nil, err
+	}
+
+	login, err := s.auth.CompleteFederatedLogin(ctx,
+		int(req.GetAppId()), req.GetCode(), req.GetState(), req.GetTicket())
+	if err != nil {
+		return nil, federationError(err)
+	}
+
+	return &sso.CompleteFederatedLoginResponse{
+		Token:   login.Token,
+		UserId:  login.UserID,
+		Created: login.Created,
+		Linked:  login.Linked,
+	}, nil
+}
+
+func (s *serverAPI) ListIdentities(
+	ctx context.Context,
+	_ *sso.ListIdentitiesRequest,
+) (*sso.ListIdentitiesResponse, error) {
+	identities, err := s.auth.ListIdentities(ctx)
+	if err != nil {
+		return nil, federationError(err)
+	}
+
+	resp := &sso.ListIdentitiesResponse{Identities: make([]*sso.Identity, 0, len(identities))}
+
+	for _, identity := range identities {
+		resp.Identities = append(resp.Identities, &sso.Identity{
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			Email:     identity.Email,
+			CreatedAt: timestamppb.New(identity.CreatedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) UnlinkIdentity(
+	ctx context.Context,
+	req *sso.UnlinkIdentityRequest,
+) (*sso.UnlinkIdentityResponse, error) {
+	if req.GetProvider() == "" {
+		return nil, status.Error(codes.InvalidArgument, "provider is required")
+	}
+
+	if err := s.auth.UnlinkIdentity(ctx, req.GetProvider()); err != nil {
+		return nil, federationError(err)
+	}
+
+	return &sso.UnlinkIdentityResponse{}, nil
+}
+
+func validateCompleteFederatedLogin(req *sso.CompleteFederatedLoginRequest) error {
+	if req.GetAppId() == models.EmptyAppID {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	if req.GetCode() == "" {
+		return status.Error(codes.InvalidArgument, "code is required")
+	}
+
+	if req.GetState() == "" {
+		return status.Error(codes.InvalidArgument, "state is required")
+	}
+
+	if req.GetTicket() == "" {
+		return status.Error(codes.InvalidArgument, "ticket is required")
+	}
+
+	return nil
+}
+
+// federationError maps the errors of the federation methods
+func federationError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrFederationDisabled):
+		return status.Error(codes.Unimplemented, "federation is not enabled")
+	case errors.Is(err, auth.ErrUnknownProvider):
+		return status.Error(codes.InvalidArgument, "unknown provider")
+	case errors.Is(err, auth.ErrInvalidAppID):
+		return status.Error(codes.InvalidArgument, "invalid app id")
+	case errors.Is(err, auth.ErrInvalidLoginTicket):
+		return status.Error(codes.InvalidArgument, "invalid login ticket")
+	case errors.Is(err, auth.ErrFederatedLoginFailed):
+		return status.Error(codes.Unauthenticated, "login at the provider failed")
+	case errors.Is(err, auth.ErrEmailNotVerified):
+		return status.Error(codes.FailedPrecondition, "email is not verified by the provider")
+	case errors.Is(err, auth.ErrLastIdentity):
+		return status.Error(codes.FailedPrecondition, "the last sign-in method of the user")
+	case errors.Is(err, auth.ErrSignupDisabled):
+		return status.Error(codes.PermissionDenied, "signup is not enabled for the provider")
+	case errors.Is(err, auth.ErrUserDisabled):
+		return status.Error(codes.PermissionDenied, "user is disabled")
+	case errors.Is(err, auth.ErrIdentityLinked):
+		return status.Error(codes.AlreadyExists, "identity is linked to another user")
+	case errors.Is(err, auth.ErrIdentityNotFound):
+		return status.Error(codes.NotFound, "identity not found")
+	default:
+		return adminError(err)
+	}
+}
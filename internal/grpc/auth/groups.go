@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/services/auth"
+)
+
+func (s *serverAPI) CreateGroup(
+	ctx context.Context,
+	req *sso.CreateGroupRequest,
+) (*sso.CreateGroupResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	groupID, err := s.auth.CreateGroup(ctx, req.GetName())
+	if err != nil {
+		return nil, groupError(err)
+	}
+
+	return &sso.CreateGroupResponse{GroupId: groupID}, nil
+}
+
+func (s *serverAPI) ListGroups(
+	ctx context.Context,
+	_ *sso.ListGroupsRequest,
+) (*sso.ListGroupsResponse, error) {
+	groups, err := s.auth.ListGroups(ctx)
+	if err != nil {
+		return nil, groupError(err)
+	}
+
+	resp := &sso.ListGroupsResponse{Groups: make([]*sso.Group, 0, len(groups))}
+
+	for _, group := range groups {
+		resp.Groups = append(resp.Groups, toGroup(group))
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) GetGroup(
+	ctx context.Context,
+	req *sso.GetGroupRequest,
+) (*sso.Group, error) {
+	if req.GetGroupId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "group_id is required")
+	}
+
+	group, err := s.auth.GetGroup(ctx, req.GetGroupId())
+	if err != nil {
+		return nil, groupError(err)
+	}
+
+	return toGroup(group), nil
+}
+
+func (s *serverAPI) DeleteGroup(
+	ctx context.Context,
+	req *sso.DeleteGroupRequest,
+) (*sso.DeleteGroupResponse, error) {
+	if req.GetGroupId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "group_id is required")
+	}
+
+	if err := s.auth.DeleteGroup(ctx, req.GetGroupId()); err != nil {
+		return nil, groupError(err)
+	}
+
+	return &sso.DeleteGroupResponse{}, nil
+}
+
+func (s *serverAPI) AddGroupMember(
+	ctx context.Context,
+	req *sso.GroupMemberRequest,
+) (*sso.GroupMemberResponse, error) {
+	if err := validateGroupMember(req); err != nil {
+		return nil, err
+	}
+
+	member := models.GroupMember{UserID: req.GetUserId(), SubgroupID: req.GetSubgroupId()}
+
+	if err := s.auth.AddGroupMember(ctx, req.GetGroupId(), member); err != nil {
+		return nil, groupError(err)
+	}
+
+	return &sso.GroupMemberResponse{}, nil
+}
+
+func (s *serverAPI) RemoveGroupMember(
+	ctx context.Context,
+	req *sso.GroupMemberRequest,
+) (*sso.GroupMemberResponse, error) {
+	if err := validateGroupMember(req); err != nil {
+		return nil, err
+	}
+
+	member := models.GroupMember{UserID: req.GetUserId(), SubgroupID: req.GetSubgroupId()}
+
+	if err := s.auth.RemoveGroupMember(ctx, req.GetGroupId(), member); err != nil {
+		return nil, groupError(err)
+	}
+
+	return &sso.GroupMemberResponse{}, nil
+}
+
+func (s *serverAPI) GrantGroupRole(
+	ctx context.Context,
+	req *sso.GroupRoleRequest,
+) (*sso.GroupRoleResponse, error) {
+	if err := validateGroupRole(req); err != nil {
+		return nil, err
+	}
+
+	role := models.GroupRole{AppID: int(req.GetAppId()), Role: req.GetRole()}
+
+	if err := s.auth.GrantGroupRole(ctx, req.GetGroupId(), role); err != nil {
+		return nil, groupError(err)
+	}
+
+	return &sso.GroupRoleResponse{}, nil
+}
+
+func (s *serverAPI) RevokeGroupRole(
+	ctx context.Context,
+	req *sso.GroupRoleRequest,
+) (*sso.GroupRoleResponse, error) {
+	if err := validateGroupRole(req); err != nil {
+		return nil, err
+	}
+
+	role := models.GroupRole{AppID: int(req.GetAppId()), Role: req.GetRole()}
+
+	if err := s.auth.RevokeGroupRole(ctx, req.GetGroupId(), role); err != nil {
+		return nil, groupError(err)
+	}
+
+	return &sso.GroupRoleResponse{}, nil
+}
+
+func (s *serverAPI) GetUserPermissions(
+	ctx context.Context,
+	req *sso.GetUserPermissionsRequest,
+) (*sso.UserPermissions, error) {
+	if req.GetUserId() == models.EmptyUserID {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	perms, err := s.auth.GetUserPermissions(ctx, req.GetUserId(), int(req.GetAppId()))
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		return nil, groupError(err)
+	}
+
+	return &sso.UserPermissions{Groups: perms.Groups, Roles: perms.Roles}, nil
+}
+
+func toGroup(group models.Group) *sso.Group {
+	resp := &sso.Group{
+		Id:          group.ID,
+		Name:        group.Name,
+		UserIds:     group.UserIDs,
+		SubgroupIds: group.SubgroupIDs,
+	}
+
+	for _, role := range group.Roles {
+		resp.Roles = append(resp.Roles, &sso.GroupRole{AppId: int32(role.AppID), Role: role.Role})
+	}
+
+	return resp
+}
+
+// groupError maps the errors of the group methods
+func groupError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrGroupNotFound):
+		return status.Error(codes.NotFound, "group not found")
+	case errors.Is(err, auth.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, auth.ErrGroupExists):
+		return status.Error(codes.AlreadyExists, "group already exists")
+	case errors.Is(err, auth.ErrGroupCycle):
+		return status.Error(codes.InvalidArgument, "group can't be nested in itself")
+	case errors.Is(err, auth.ErrInvalidAppID):
+		return status.Error(codes.InvalidArgument, "invalid app id")
+	case errors.Is(err, auth.ErrInvalidMember), errors.Is(err, auth.ErrInvalidRole):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, auth.ErrGroupsDisabled):
+		return status.Error(codes.Unimplemented, "groups are not enabled")
+	default:
+		return adminError(err)
+	}
+}
+
+func validateGroupMember(req *sso.GroupMemberRequest) error {
+	if req.GetGroupId() == 0 {
+		return status.Error(codes.InvalidArgument, "group_id is required")
+	}
+
+	if req.GetUserId() == 0 && req.GetSubgroupId() == 0 {
+		return status.Error(codes.InvalidArgument, "user_id or subgroup_id is required")
+	}
+
+	return nil
+}
+
+func validateGroupRole(req *sso.GroupRoleRequest) error {
+	if req.GetGroupId() == 0 {
+		return status.Error(codes.InvalidArgument, "group_id is required")
+	}
+
+	if req.GetAppId() == models.EmptyAppID {
+		return status.Error(codes.InvalidArgument, "app_id is required")
+	}
+
+	if req.GetRole() == "" {
+		return status.Error(codes.InvalidArgument, "role is required")
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/services/auth"
+)
+
+func (s *serverAPI) Impersonate(
+	ctx context.Context,
+	req *sso.ImpersonateRequest,
+) (*sso.ImpersonateResponse, error) {
+	if req.GetUserId() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	if req.GetReason() == "" {
+		return nil, status.Error(codes.InvalidArgument, "reason is required")
+	}
+
+	token, expiresAt, err := s.auth.Impersonate(ctx, req.GetUserId(), int(req.GetAppId()), req.GetReason())
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			return nil, status.Error(codes.NotFound, "user not found")
+		case errors.Is(err, auth.ErrUserDisabled):
+			return nil, status.Error(codes.FailedPrecondition, "user is disabled")
+		case errors.Is(err, auth.ErrInvalidAppID):
+			return nil, status.Error(codes.InvalidArgument, "invalid app id")
+		default:
+			return nil, adminError(err)
+		}
+	}
+
+	return &sso.ImpersonateResponse{Token: token, ExpiresAt: timestamppb.New(expiresAt)}, nil
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
 	"grpc-sso/internal/services/auth"
+	"time"
 
 	"google.golang.org/grpc"
 
@@ -23,14 +26,120 @@ type Auth interface {
 		email string,
 		password string,
 		appID int,
+		opts ...auth.LoginOption,
 	) (token string, err error)
 
 	RegisterNewUser(ctx context.Context,
 		email string,
 		password string,
+		appID int,
 	) (userID int64, err error)
 
 	IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err error)
+
+	VerifyToken(ctx context.Context, token string) (caller models.Caller, err error)
+
+	ListAuditEvents(ctx context.Context,
+		filter models.AuditFilter,
+		pageSize int,
+		pageToken string,
+	) (events []models.AuditEvent, nextPageToken string, err error)
+
+	ChangeEmail(ctx context.Context, newEmail string, password string) error
+
+	DisableUser(ctx context.Context, userID int64) error
+
+	ListWebhookEvents(ctx context.Context,
+		filter models.OutboxFilter,
+		pageSize int,
+		pageToken string,
+	) (events []models.OutboxEvent, nextPageToken string, err error)
+
+	ReplayWebhookEvents(ctx context.Context, filter models.ReplayFilter) (replayed int64, err error)
+
+	ChangePassword(ctx context.Context, oldPassword string, newPassword string) error
+
+	WatchUserEvents(ctx context.Context,
+		filter models.UserEventFilter,
+		cursor string,
+		send func(event models.UserEvent, cursor string) error,
+	) error
+
+	ListSessions(ctx context.Context) (sessions []models.Session, err error)
+
+	RevokeSession(ctx context.Context, sessionID string) error
+
+	RevokeAllSessions(ctx context.Context, keepCurrent bool) (revoked int64, err error)
+
+	ListUserSessions(ctx context.Context, userID int64) (sessions []models.Session, err error)
+
+	RevokeUserSession(ctx context.Context, userID int64, sessionID string) error
+
+	RevokeAllUserSessions(ctx context.Context, userID int64) (revoked int64, err error)
+
+	CreateGroup(ctx context.Context, name string) (groupID int64, err error)
+
+	ListGroups(ctx context.Context) (groups []models.Group, err error)
+
+	GetGroup(ctx context.Context, groupID int64) (group models.Group, err error)
+
+	DeleteGroup(ctx context.Context, groupID int64) error
+
+	AddGroupMember(ctx context.Context, groupID int64, member models.GroupMember) error
+
+	RemoveGroupMember(ctx context.Context, groupID int64, member models.GroupMember) error
+
+	GrantGroupRole(ctx context.Context, groupID int64, role models.GroupRole) error
+
+	RevokeGroupRole(ctx context.Context, groupID int64, role models.GroupRole) error
+
+	GetUserPermissions(ctx context.Context, userID int64, appID int) (perms models.Permissions, err error)
+
+	ListConsents(ctx context.Context) (consents []models.Consent, err error)
+
+	RevokeConsent(ctx context.Context, appID int) error
+
+	CreateAccessToken(ctx context.Context,
+		name string,
+		appID int,
+		scopes []string,
+		expiresAt time.Time,
+	) (token string, created models.AccessToken, err error)
+
+	ListAccessTokens(ctx context.Context) (tokens []models.AccessToken, err error)
+
+	RevokeAccessToken(ctx context.Context, tokenID int64) error
+
+	Impersonate(ctx context.Context,
+		userID int64,
+		appID int,
+		reason string,
+	) (token string, expiresAt time.Time, err error)
+
+	ExchangeToken(ctx context.Context,
+		subjectToken string,
+		appID int,
+		appSecret string,
+		audience int,
+		scopes []string,
+	) (token string, issued models.Caller, expiresAt time.Time, err error)
+
+	StartFederatedLogin(ctx context.Context,
+		provider string,
+		appID int,
+		link bool,
+	) (authURL string, ticket string, err error)
+
+	CompleteFederatedLogin(ctx context.Context,
+		appID int,
+		code string,
+		state string,
+		ticket string,
+	) (login auth.FederatedLogin, err error)
+
+	ListIdentities(ctx context.Context) (identities []models.Identity, err error)
+
+	UnlinkIdentity(ctx context.Context, provider string) error
 }
 
 func Register(gRPCServer *grpc.Server, auth Auth) {
@@ -45,16 +154,52 @@ func (s *serverAPI) Login(
 		return nil, err
 	}
 
+	var opts []auth.LoginOption
+	if req.GetIncludeGroups() {
+		opts = append(opts, auth.WithGroupsClaim())
+	}
+	if len(req.GetScopes()) > 0 {
+		opts = append(opts, auth.WithScopes(req.GetScopes()...))
+	}
+	if req.GetGrantConsent() {
+		opts = append(opts, auth.WithConsentGrant())
+	}
+
 	token, err := s.auth.Login(ctx,
 		req.GetEmail(),
 		req.GetPassword(),
-		int(req.GetAppId()))
+		int(req.GetAppId()),
+		opts...)
 
 	if err != nil {
 		if errors.Is(err, auth.ErrInvalidCredentials) {
 			return nil, status.Error(codes.InvalidArgument, "invalid argument")
 		}
 
+		if errors.Is(err, auth.ErrUserDisabled) {
+			return nil, status.Error(codes.PermissionDenied, "user is disabled")
+		}
+
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app id")
+		}
+
+		if errors.Is(err, auth.ErrInvalidScope) {
+			return nil, status.Error(codes.InvalidArgument, "invalid scope")
+		}
+
+		if errors.Is(err, auth.ErrConsentRequired) {
+			return nil, status.Error(codes.FailedPrecondition, "consent required")
+		}
+
+		if errors.Is(err, auth.ErrConsentsDisabled) {
+			return nil, status.Error(codes.Unimplemented, "scopes are not enabled")
+		}
+
+		if errors.Is(err, auth.ErrDirectoryUnavailable) {
+			return nil, status.Error(codes.Unavailable, "directory is unavailable")
+		}
+
 		return nil, status.Error(codes.Internal, "iternal error")
 	}
 
@@ -71,12 +216,24 @@ func (s *serverAPI) Register(
 		return nil, err
 	}
 
-	userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), req.GetPassword())
+	userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()))
 	if err != nil {
 		if errors.Is(err, auth.ErrUserExists) {
 			return nil, status.Error(codes.AlreadyExists, "user already exists")
 		}
 
+		if errors.Is(err, auth.ErrInvalidAppID) {
+			return nil, status.Error(codes.InvalidArgument, "invalid app id")
+		}
+
+		if errors.Is(err, auth.ErrInvalidTenant) {
+			return nil, status.Error(codes.InvalidArgument, "invalid tenant")
+		}
+
+		if errors.Is(err, auth.ErrDirectoryUser) {
+			return nil, status.Error(codes.PermissionDenied, "users of the directory register by logging in")
+		}
+
 		return nil, status.Error(codes.Internal, "iternal error")
 	}
 
@@ -107,6 +264,389 @@ func (s *serverAPI) IsAdmin(
 	}, nil
 }
 
+func (s *serverAPI) ListAuditEvents(
+	ctx context.Context,
+	req *sso.ListAuditEventsRequest,
+) (*sso.ListAuditEventsResponse, error) {
+	filter := models.AuditFilter{
+		ActorID: req.GetActorId(),
+		UserID:  req.GetUserId(),
+		AppID:   int(req.GetAppId()),
+		Types:   req.GetTypes(),
+	}
+	if req.GetSince() != nil {
+		filter.Since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		filter.Until = req.GetUntil().AsTime()
+	}
+
+	events, nextPageToken, err := s.auth.ListAuditEvents(ctx, filter, int(req.GetPageSize()), req.GetPageToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+
+		return nil, adminError(err)
+	}
+
+	resp := &sso.ListAuditEventsResponse{
+		Events:        make([]*sso.AuditEvent, 0, len(events)),
+		NextPageToken: nextPageToken,
+	}
+
+	for _, e := range events {
+		resp.Events = append(resp.Events, &sso.AuditEvent{
+			Id:        e.ID,
+			Type:      e.Type,
+			ActorId:   e.ActorID,
+			UserId:    e.UserID,
+			Email:     e.Email,
+			AppId:     int32(e.AppID),
+			Reason:    e.Reason,
+			Ip:        e.IP,
+			UserAgent: e.UserAgent,
+			RequestId: e.RequestID,
+			CreatedAt: timestamppb.New(e.CreatedAt),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) ChangeEmail(
+	ctx context.Context,
+	req *sso.ChangeEmailRequest,
+) (*sso.ChangeEmailResponse, error) {
+	if err := validateChangeEmail(req); err != nil {
+		return nil, err
+	}
+
+	err := s.auth.ChangeEmail(ctx, req.GetEmail(), req.GetPassword())
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUnauthenticated):
+			return nil, status.Error(codes.Unauthenticated, "authentication required")
+		case errors.Is(err, auth.ErrImpersonated):
+			return nil, status.Error(codes.PermissionDenied, "not allowed on behalf of the user")
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			return nil, status.Error(codes.InvalidArgument, "invalid password")
+		case errors.Is(err, auth.ErrUserExists):
+			return nil, status.Error(codes.AlreadyExists, "user already exists")
+		case errors.Is(err, auth.ErrDirectoryUser):
+			return nil, status.Error(codes.PermissionDenied, "email belongs to a directory")
+		case errors.Is(err, auth.ErrUserNotFound):
+			return nil, status.Error(codes.NotFound, "user not found")
+		default:
+			return nil, status.Error(codes.Internal, "iternal error")
+		}
+	}
+
+	return &sso.ChangeEmailResponse{}, nil
+}
+
+func (s *serverAPI) DisableUser(
+	ctx context.Context,
+	req *sso.DisableUserRequest,
+) (*sso.DisableUserResponse, error) {
+	if req.GetUserId() == models.EmptyUserID {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	err := s.auth.DisableUser(ctx, req.GetUserId())
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		return nil, adminError(err)
+	}
+
+	return &sso.DisableUserResponse{}, nil
+}
+
+func (s *serverAPI) ListWebhookEvents(
+	ctx context.Context,
+	req *sso.ListWebhookEventsRequest,
+) (*sso.ListWebhookEventsResponse, error) {
+	filter := models.OutboxFilter{
+		Status: req.GetStatus(),
+		AppID:  int(req.GetAppId()),
+	}
+
+	events, nextPageToken, err := s.auth.ListWebhookEvents(ctx, filter, int(req.GetPageSize()), req.GetPageToken())
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidPageToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+
+		return nil, adminError(err)
+	}
+
+	resp := &sso.ListWebhookEventsResponse{
+		Events:        make([]*sso.WebhookEvent, 0, len(events)),
+		NextPageToken: nextPageToken,
+	}
+
+	for _, e := range events {
+		event := &sso.WebhookEvent{
+			Id:            e.ID,
+			EventId:       e.EventID,
+			Type:          e.Type,
+			AppId:         int32(e.AppID),
+			UserId:        e.UserID,
+			Status:        e.Status,
+			Attempts:      int32(e.Attempts),
+			LastError:     e.LastError,
+			Payload:       string(e.Payload),
+			NextAttemptAt: timestamppb.New(e.NextAttemptAt),
+			CreatedAt:     timestamppb.New(e.CreatedAt),
+		}
+		if !e.DeliveredAt.IsZero() {
+			event.DeliveredAt = timestamppb.New(e.DeliveredAt)
+		}
+
+		resp.Events = append(resp.Events, event)
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) ReplayWebhookEvents(
+	ctx context.Context,
+	req *sso.ReplayWebhookEventsRequest,
+) (*sso.ReplayWebhookEventsResponse, error) {
+	replayed, err := s.auth.ReplayWebhookEvents(ctx, models.ReplayFilter{
+		IDs:   req.GetIds(),
+		AppID: int(req.GetAppId()),
+	})
+	if err != nil {
+		return nil, adminError(err)
+	}
+
+	return &sso.ReplayWebhookEventsResponse{
+		Replayed: replayed,
+	}, nil
+}
+
+func (s *serverAPI) ChangePassword(
+	ctx context.Context,
+	req *sso.ChangePasswordRequest,
+) (*sso.ChangePasswordResponse, error) {
+	if err := validateChangePassword(req); err != nil {
+		return nil, err
+	}
+
+	err := s.auth.ChangePassword(ctx, req.GetOldPassword(), req.GetNewPassword())
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUnauthenticated):
+			return nil, status.Error(codes.Unauthenticated, "authentication required")
+		case errors.Is(err, auth.ErrImpersonated):
+			return nil, status.Error(codes.PermissionDenied, "not allowed on behalf of the user")
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			return nil, status.Error(codes.InvalidArgument, "invalid password")
+		case errors.Is(err, auth.ErrDirectoryUser):
+			return nil, status.Error(codes.PermissionDenied, "the password is kept by the directory")
+		case errors.Is(err, auth.ErrUserNotFound):
+			return nil, status.Error(codes.NotFound, "user not found")
+		default:
+			return nil, status.Error(codes.Internal, "iternal error")
+		}
+	}
+
+	return &sso.ChangePasswordResponse{}, nil
+}
+
+func (s *serverAPI) WatchUserEvents(
+	req *sso.WatchUserEventsRequest,
+	stream grpc.ServerStreamingServer[sso.UserEvent],
+) error {
+	ctx := stream.Context()
+
+	filter := models.UserEventFilter{
+		AppID: int(req.GetAppId()),
+		Types: req.GetTypes(),
+	}
+
+	err := s.auth.WatchUserEvents(ctx, filter, req.GetCursor(), func(e models.UserEvent, cursor string) error {
+		return stream.Send(&sso.UserEvent{
+			Cursor:    cursor,
+			Type:      e.Type,
+			UserId:    e.UserID,
+			Email:     e.Email,
+			AppId:     int32(e.AppID),
+			CreatedAt: timestamppb.New(e.CreatedAt),
+		})
+	})
+
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return status.FromContextError(ctx.Err()).Err()
+	case errors.Is(err, auth.ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, "invalid cursor")
+	default:
+		return adminError(err)
+	}
+}
+
+func (s *serverAPI) ListSessions(
+	ctx context.Context,
+	_ *sso.ListSessionsRequest,
+) (*sso.ListSessionsResponse, error) {
+	sessions, err := s.auth.ListSessions(ctx)
+	if err != nil {
+		return nil, sessionError(err)
+	}
+
+	return toSessionsResponse(ctx, sessions), nil
+}
+
+func (s *serverAPI) RevokeSession(
+	ctx context.Context,
+	req *sso.RevokeSessionRequest,
+) (*sso.RevokeSessionResponse, error) {
+	if req.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	if err := s.auth.RevokeSession(ctx, req.GetSessionId()); err != nil {
+		return nil, sessionError(err)
+	}
+
+	return &sso.RevokeSessionResponse{}, nil
+}
+
+func (s *serverAPI) RevokeAllSessions(
+	ctx context.Context,
+	req *sso.RevokeAllSessionsRequest,
+) (*sso.RevokeAllSessionsResponse, error) {
+	revoked, err := s.auth.RevokeAllSessions(ctx, req.GetKeepCurrent())
+	if err != nil {
+		return nil, sessionError(err)
+	}
+
+	return &sso.RevokeAllSessionsResponse{Revoked: revoked}, nil
+}
+
+func (s *serverAPI) ListUserSessions(
+	ctx context.Context,
+	req *sso.ListUserSessionsRequest,
+) (*sso.ListSessionsResponse, error) {
+	if req.GetUserId() == models.EmptyUserID {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	sessions, err := s.auth.ListUserSessions(ctx, req.GetUserId())
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, sessionError(err)
+		}
+
+		return nil, adminError(err)
+	}
+
+	return toSessionsResponse(ctx, sessions), nil
+}
+
+func (s *serverAPI) RevokeUserSession(
+	ctx context.Context,
+	req *sso.RevokeUserSessionRequest,
+) (*sso.RevokeSessionResponse, error) {
+	if req.GetUserId() == models.EmptyUserID {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	if req.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	err := s.auth.RevokeUserSession(ctx, req.GetUserId(), req.GetSessionId())
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) || errors.Is(err, auth.ErrUserNotFound) {
+			return nil, sessionError(err)
+		}
+
+		return nil, adminError(err)
+	}
+
+	return &sso.RevokeSessionResponse{}, nil
+}
+
+func (s *serverAPI) RevokeAllUserSessions(
+	ctx context.Context,
+	req *sso.RevokeAllUserSessionsRequest,
+) (*sso.RevokeAllSessionsResponse, error) {
+	if req.GetUserId() == models.EmptyUserID {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	revoked, err := s.auth.RevokeAllUserSessions(ctx, req.GetUserId())
+	if err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+
+		return nil, adminError(err)
+	}
+
+	return &sso.RevokeAllSessionsResponse{Revoked: revoked}, nil
+}
+
+// toSessionsResponse marks the session of the caller as the current one
+func toSessionsResponse(ctx context.Context, sessions []models.Session) *sso.ListSessionsResponse {
+	c, _ := caller.FromContext(ctx)
+
+	resp := &sso.ListSessionsResponse{Sessions: make([]*sso.Session, 0, len(sessions))}
+
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &sso.Session{
+			Id:         session.ID,
+			AppId:      int32(session.AppID),
+			Ip:         session.IP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  timestamppb.New(session.CreatedAt),
+			LastUsedAt: timestamppb.New(session.LastUsedAt),
+			ExpiresAt:  timestamppb.New(session.ExpiresAt),
+			Current:    c.SessionID != "" && c.SessionID == session.ID,
+		})
+	}
+
+	return resp
+}
+
+// sessionError maps the errors of the session methods of the authenticated user
+func sessionError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, "authentication required")
+	case errors.Is(err, auth.ErrSessionNotFound):
+		return status.Error(codes.NotFound, "session not found")
+	case errors.Is(err, auth.ErrUserNotFound):
+		return status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, auth.ErrImpersonated):
+		return status.Error(codes.PermissionDenied, "not allowed on behalf of the user")
+	default:
+		return status.Error(codes.Internal, "iternal error")
+	}
+}
+
+// adminError maps the errors of the admin only methods
+func adminError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, "authentication required")
+	case errors.Is(err, auth.ErrImpersonated):
+		return status.Error(codes.PermissionDenied, "not allowed on behalf of the user")
+	case errors.Is(err, auth.ErrPermissionDenied):
+		return status.Error(codes.PermissionDenied, "admin only")
+	default:
+		return status.Error(codes.Internal, "iternal error")
+	}
+}
+
 func validateLogin(req *sso.LoginRequest) error {
 	if req.GetEmail() == "" {
 		return status.Error(codes.InvalidArgument, "email is required")
@@ -135,6 +675,30 @@ func validateRegister(req *sso.RegisterRequest) error {
 	return nil
 }
 
+func validateChangeEmail(req *sso.ChangeEmailRequest) error {
+	if req.GetEmail() == "" {
+		return status.Error(codes.InvalidArgument, "email is required")
+	}
+
+	if req.GetPassword() == "" {
+		return status.Error(codes.InvalidArgument, "password is required")
+	}
+
+	return nil
+}
+
+func validateChangePassword(req *sso.ChangePasswordRequest) error {
+	if req.GetOldPassword() == "" {
+		return status.Error(codes.InvalidArgument, "old_password is required")
+	}
+
+	if req.GetNewPassword() == "" {
+		return status.Error(codes.InvalidArgument, "new_password is required")
+	}
+
+	return nil
+}
+
 func validateIsAdmin(req *sso.IsAdminRequest) error {
 	if req.GetUserId() == models.EmptyUserID {
 		return status.Error(codes.InvalidArgument, "email is required")
@@ -0,0 +1,102 @@
+package clientinfo
+
+import (
+	"context"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// InProcessNetwork is the network name of in-process connections, e.g. from the REST gateway.
+// Only these peers are trusted to forward the original client address and user agent.
+const InProcessNetwork = "bufconn"
+
+const (
+	forwardedForKey     = "x-forwarded-for"
+	userAgentKey        = "user-agent"
+	gatewayUserAgentKey = "grpcgateway-user-agent"
+	// TenantIDKey is the metadata key of the tenant the request is made to
+	TenantIDKey = "x-tenant-id"
+)
+
+// IP returns the IP address of the caller or empty string if it is unknown
+func IP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	if p.Addr.Network() == InProcessNetwork {
+		if forwarded := lastMetadata(ctx, forwardedForKey); forwarded != "" {
+			// The gateway appends the address it sees to whatever the client sent
+			// and adds its value after the ones of the headers, so only the last entry can be trusted
+			hops := strings.Split(forwarded, ",")
+
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+
+	return host
+}
+
+// UserAgent returns the user agent of the caller or empty string if it is unknown
+func UserAgent(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil && p.Addr.Network() == InProcessNetwork {
+		if userAgent := lastMetadata(ctx, gatewayUserAgentKey); userAgent != "" {
+			return userAgent
+		}
+	}
+
+	return firstMetadata(ctx, userAgentKey)
+}
+
+// TenantID returns the tenant the request names in the metadata, ok is false if it names none.
+// A malformed ID is returned as zero, which is not a tenant
+func TenantID(ctx context.Context) (tenantID int64, ok bool) {
+	value := firstMetadata(ctx, TenantIDKey)
+	if value == "" {
+		return 0, false
+	}
+
+	tenantID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, true
+	}
+
+	return tenantID, true
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func lastMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[len(values)-1]
+}
@@ -0,0 +1,127 @@
+package health
+
+import (
+	"context"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	checkTimeout    = 5 * time.Second
+	defaultInterval = 10 * time.Second
+)
+
+// Check is a single readiness condition
+type Check struct {
+	Name string
+	Func func(ctx context.Context) error
+}
+
+// Monitor runs the checks periodically and reports the result
+// through the standard grpc.health.v1 service.
+// The status is SERVING only when every check passes.
+type Monitor struct {
+	log      *slog.Logger
+	server   *health.Server
+	services []string
+	checks   []Check
+	interval time.Duration
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewMonitor creates a monitor that sets the status of the overall server ("")
+// and of every given service. The status is NOT_SERVING until the first run.
+func NewMonitor(
+	log *slog.Logger,
+	server *health.Server,
+	interval time.Duration,
+	services []string,
+	checks ...Check,
+) *Monitor {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	services = append([]string{""}, services...)
+	for _, service := range services {
+		server.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	return &Monitor{
+		log:      log,
+		server:   server,
+		services: services,
+		checks:   checks,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run checks readiness until Shutdown is called
+func (m *Monitor) Run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.update()
+
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops the checks and sets every status to NOT_SERVING for good
+func (m *Monitor) Shutdown() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+
+	m.server.Shutdown()
+}
+
+// Wait blocks until Run returns
+func (m *Monitor) Wait() {
+	<-m.done
+}
+
+func (m *Monitor) update() {
+	status := healthpb.HealthCheckResponse_SERVING
+	if err := m.CheckAll(context.Background()); err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	for _, service := range m.services {
+		m.server.SetServingStatus(service, status)
+	}
+}
+
+// CheckAll runs every check and returns the first failure
+func (m *Monitor) CheckAll(ctx context.Context) error {
+	for _, check := range m.checks {
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		err := check.Func(ctx)
+		cancel()
+
+		if err != nil {
+			m.log.Warn("readiness check failed",
+				slog.String("check", check.Name),
+				slog.String("error", err.Error()))
+
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const service = "Auth.Auth"
+
+func TestMonitor(t *testing.T) {
+	server := health.NewServer()
+
+	var storageDown atomic.Bool
+	storageDown.Store(true)
+
+	monitor := NewMonitor(slog.New(slog.NewTextHandler(io.Discard, nil)), server, 10*time.Millisecond,
+		[]string{service},
+		Check{Name: "storage", Func: func(ctx context.Context) error {
+			if storageDown.Load() {
+				return errors.New("database is locked")
+			}
+
+			return nil
+		}},
+	)
+
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, ""))
+
+	go monitor.Run()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, service))
+
+	storageDown.Store(false)
+
+	for _, name := range []string{"", service} {
+		assert.Eventually(t, func() bool {
+			return status(t, server, name) == healthpb.HealthCheckResponse_SERVING
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	monitor.Shutdown()
+	monitor.Wait()
+
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(t, server, service))
+}
+
+func status(t *testing.T, server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+
+	return resp.GetStatus()
+}
@@ -0,0 +1,100 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/logger/slogger"
+	"log/slog"
+	"strings"
+)
+
+// TokenVerifier returns the caller the token was issued to
+type TokenVerifier func(ctx context.Context, token string) (models.Caller, error)
+
+const authorizationKey = "authorization"
+
+const bearerPrefix = "bearer "
+
+// Authenticate returns an interceptor that verifies the bearer token of the
+// authorization metadata and puts the caller into the context, see caller.FromContext.
+// Calls without the token pass through anonymous, the methods that need
+// a caller check it themselves.
+func Authenticate(log *slog.Logger, verify TokenVerifier) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		ctx, err := authenticate(ctx, log, verify)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthenticate is Authenticate for streaming calls
+func StreamAuthenticate(log *slog.Logger, verify TokenVerifier) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := authenticate(ss.Context(), log, verify)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, withContext(ss, ctx))
+	}
+}
+
+// authenticate puts the caller of the bearer token, if any, into the context
+func authenticate(ctx context.Context, log *slog.Logger, verify TokenVerifier) (context.Context, error) {
+	token, ok := bearerToken(ctx)
+	if !ok {
+		return ctx, nil
+	}
+
+	c, err := verify(ctx, token)
+	if err != nil {
+		if errors.Is(err, jwt.ErrInvalidToken) || errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+
+		slogger.FromContext(ctx, log).Error("failed to authenticate",
+			slog.String("error", err.Error()))
+
+		return nil, status.Error(codes.Internal, "iternal error")
+	}
+
+	return caller.NewContext(ctx, c), nil
+}
+
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	values := md.Get(authorizationKey)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	if len(values[0]) < len(bearerPrefix) || !strings.EqualFold(values[0][:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(values[0][len(bearerPrefix):]), true
+}
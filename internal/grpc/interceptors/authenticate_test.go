@@ -0,0 +1,115 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	verify := func(_ context.Context, token string) (models.Caller, error) {
+		switch token {
+		case "good":
+			return models.Caller{UserID: 7, Email: "admin@example.com", AppID: 1}, nil
+		case "expired":
+			return models.Caller{}, fmt.Errorf("verify: %w", jwt.ErrTokenExpired)
+		case "broken":
+			return models.Caller{}, errors.New("storage is down")
+		default:
+			return models.Caller{}, fmt.Errorf("verify: %w", jwt.ErrInvalidToken)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      codes.Code
+		wantCaller    bool
+	}{
+		{name: "no token", wantCode: codes.OK},
+		{name: "not a bearer token", authorization: "Basic dXNlcjpwYXNz", wantCode: codes.OK},
+		{name: "valid token", authorization: "Bearer good", wantCode: codes.OK, wantCaller: true},
+		{name: "lower case scheme", authorization: "bearer good", wantCode: codes.OK, wantCaller: true},
+		{name: "invalid token", authorization: "Bearer forged", wantCode: codes.Unauthenticated},
+		{name: "expired token", authorization: "Bearer expired", wantCode: codes.Unauthenticated},
+		{name: "verifier failure", authorization: "Bearer broken", wantCode: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+
+			interceptor := Authenticate(slog.New(slog.NewTextHandler(io.Discard, nil)), verify)
+
+			var (
+				called bool
+				got    models.Caller
+				ok     bool
+			)
+
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/auth.Auth/ListAuditEvents"},
+				func(ctx context.Context, req any) (any, error) {
+					called = true
+					got, ok = caller.FromContext(ctx)
+
+					return nil, nil
+				})
+
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantCode == codes.OK, called)
+			assert.Equal(t, tt.wantCaller, ok)
+			if tt.wantCaller {
+				assert.Equal(t, int64(7), got.UserID)
+			}
+		})
+	}
+}
+
+func TestStreamAuthenticate(t *testing.T) {
+	verify := func(_ context.Context, token string) (models.Caller, error) {
+		if token != "good" {
+			return models.Caller{}, jwt.ErrInvalidToken
+		}
+
+		return models.Caller{UserID: 7, AppID: 1}, nil
+	}
+
+	interceptor := StreamAuthenticate(slog.New(slog.NewTextHandler(io.Discard, nil)), verify)
+
+	stream := func(authorization string) grpc.ServerStream {
+		md := metadata.Pairs("authorization", authorization)
+
+		return &fakeStream{ctx: metadata.NewIncomingContext(context.Background(), md)}
+	}
+
+	var got models.Caller
+
+	err := interceptor(nil, stream("Bearer good"), watchInfo, func(_ any, ss grpc.ServerStream) error {
+		got, _ = caller.FromContext(ss.Context())
+
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), got.UserID)
+
+	err = interceptor(nil, stream("Bearer forged"), watchInfo, func(any, grpc.ServerStream) error {
+		t.Error("handler called with an invalid token")
+
+		return nil
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
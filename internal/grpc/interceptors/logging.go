@@ -0,0 +1,89 @@
+package interceptors
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/logger/slogger"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// Logging returns an interceptor that writes an access log record for every call
+func Logging(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		logCall(ctx, log, info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+// StreamLogging is Logging for streaming calls, the record is written when the stream ends
+func StreamLogging(log *slog.Logger) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+
+		err := handler(srv, ss)
+
+		logCall(ss.Context(), log, info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+// logCall writes the access log record of the call
+func logCall(ctx context.Context, log *slog.Logger, method string, start time.Time, err error) {
+	code := status.Code(err)
+
+	attrs := []any{
+		slog.String("method", method),
+		slog.String("peer", clientinfo.IP(ctx)),
+		slog.String("code", code.String()),
+		slog.Duration("latency", time.Since(start)),
+	}
+
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", spanCtx.TraceID().String()))
+	}
+
+	level := logLevel(code)
+	if strings.HasPrefix(method, healthMethodPrefix) && code == codes.OK {
+		// Probes are too frequent for the info level
+		level = slog.LevelDebug
+	}
+
+	slogger.FromContext(ctx, log).Log(ctx, level, "gRPC call finished", attrs...)
+}
+
+// logLevel returns Error level for server side failures
+// and Warn level for the client ones
+func logLevel(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable, codes.Unimplemented:
+		return slog.LevelError
+	default:
+		return slog.LevelWarn
+	}
+}
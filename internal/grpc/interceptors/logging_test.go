@@ -0,0 +1,109 @@
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/lib/requestid"
+	"grpc-sso/internal/logger/slogger"
+	"log/slog"
+	"testing"
+)
+
+func TestChain_RequestIDLoggingRecovery(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	chain := chainUnary(RequestID(log), Logging(log), Recovery(log))
+
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(requestid.MetadataKey, "req-1"))
+
+	_, err := chain(ctx, nil,
+		&grpc.UnaryServerInfo{FullMethod: "/Auth.Auth/Login"},
+		func(ctx context.Context, req any) (any, error) {
+			assert.Equal(t, "req-1", requestid.FromContext(ctx))
+			slogger.FromContext(ctx, nil).Info("from handler")
+
+			panic("boom")
+		})
+
+	require.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	records := decodeRecords(t, &buf)
+	require.Len(t, records, 3)
+
+	assert.Equal(t, "from handler", records[0]["msg"])
+	assert.Equal(t, "panic recovered", records[1]["msg"])
+	assert.Equal(t, "boom", records[1]["panic"])
+
+	access := records[2]
+	assert.Equal(t, "gRPC call finished", access["msg"])
+	assert.Equal(t, "ERROR", access["level"])
+	assert.Equal(t, "/Auth.Auth/Login", access["method"])
+	assert.Equal(t, codes.Internal.String(), access["code"])
+	assert.Contains(t, access, "latency")
+
+	for _, record := range records {
+		assert.Equal(t, "req-1", record["request_id"])
+	}
+}
+
+func TestRequestID_Generated(t *testing.T) {
+	log := slog.New(slog.NewJSONHandler(&bytes.Buffer{}, nil))
+
+	var id string
+	_, err := RequestID(log)(context.Background(), nil,
+		&grpc.UnaryServerInfo{FullMethod: "/Auth.Auth/Register"},
+		func(ctx context.Context, req any) (any, error) {
+			id = requestid.FromContext(ctx)
+
+			return nil, nil
+		})
+
+	require.NoError(t, err)
+	assert.NotEmpty(t, id)
+}
+
+func TestLogLevel(t *testing.T) {
+	assert.Equal(t, slog.LevelInfo, logLevel(codes.OK))
+	assert.Equal(t, slog.LevelWarn, logLevel(codes.InvalidArgument))
+	assert.Equal(t, slog.LevelError, logLevel(codes.Internal))
+}
+
+// chainUnary composes interceptors the same way grpc.ChainUnaryInterceptor does
+func chainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+
+		return next(ctx, req)
+	}
+}
+
+func decodeRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		var record map[string]any
+		require.NoError(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+
+	return records
+}
@@ -0,0 +1,51 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/lib/metrics"
+	"time"
+)
+
+// Metrics returns an interceptor that counts calls by method and status code
+// and observes their latency
+func Metrics() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		metrics.GRPCLatency.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+		metrics.GRPCRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+
+		return resp, err
+	}
+}
+
+// StreamMetrics returns an interceptor that counts streaming calls by method
+// and status code, and the streams being served. The stream lifetime is not
+// observed as latency, it says nothing about the server
+func StreamMetrics() grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		active := metrics.GRPCActiveStreams.WithLabelValues(info.FullMethod)
+		active.Inc()
+		defer active.Dec()
+
+		err := handler(srv, ss)
+
+		metrics.GRPCRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+
+		return err
+	}
+}
@@ -0,0 +1,37 @@
+package interceptors
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/lib/metrics"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	const method = "/Auth.Auth/MetricsTest"
+
+	interceptor := Metrics()
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+
+	_, _ = interceptor(context.Background(), nil, info,
+		func(ctx context.Context, req any) (any, error) { return nil, nil })
+	_, _ = interceptor(context.Background(), nil, info,
+		func(ctx context.Context, req any) (any, error) {
+			return nil, status.Error(codes.InvalidArgument, "invalid argument")
+		})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.GRPCRequests.WithLabelValues(method, codes.OK.String())))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.GRPCRequests.WithLabelValues(method, codes.InvalidArgument.String())))
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `grpc_server_handling_seconds_count{method="/Auth.Auth/MetricsTest"} 2`)
+	assert.Contains(t, body, "go_goroutines")
+}
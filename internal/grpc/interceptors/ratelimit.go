@@ -0,0 +1,125 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/lib/ratelimit"
+	"grpc-sso/internal/logger/slogger"
+	"log/slog"
+	"strconv"
+)
+
+// RateLimitRules are the token bucket limits applied to every call.
+// A disabled limit is not checked.
+type RateLimitRules struct {
+	// Methods maps a full method name to its limit.
+	// The DefaultMethod key applies to methods without their own limit.
+	Methods map[string]ratelimit.Limit
+	// Peer limits calls per peer IP
+	Peer ratelimit.Limit
+	// App limits calls per app ID for requests that carry one
+	App ratelimit.Limit
+}
+
+// DefaultMethod is the RateLimitRules.Methods key used for methods without their own limit
+const DefaultMethod = "*"
+
+type appIDGetter interface {
+	GetAppId() int32
+}
+
+// RateLimit returns an interceptor that rejects calls exceeding the rules
+// with codes.ResourceExhausted.
+// If the limiter itself fails the call is let through.
+func RateLimit(log *slog.Logger, limiter ratelimit.Limiter, rules RateLimitRules) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		if err := allow(ctx, log, limiter, rules.checks(ctx, req, info.FullMethod)); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamRateLimit is RateLimit for streaming calls, opening a stream counts as a call.
+// The request is not read yet, so there is no app limit
+func StreamRateLimit(log *slog.Logger, limiter ratelimit.Limiter, rules RateLimitRules) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx := ss.Context()
+
+		if err := allow(ctx, log, limiter, rules.checks(ctx, nil, info.FullMethod)); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// allow returns codes.ResourceExhausted if any of the checks fails
+func allow(ctx context.Context, log *slog.Logger, limiter ratelimit.Limiter, checks []rateLimitCheck) error {
+	log = slogger.FromContext(ctx, log)
+
+	for _, check := range checks {
+		allowed, err := limiter.Allow(ctx, check.key, check.limit)
+		if err != nil {
+			log.Error("rate limiter failed",
+				slog.String("key", check.key),
+				slog.String("error", err.Error()))
+
+			continue
+		}
+
+		if !allowed {
+			log.Warn("rate limit exceeded", slog.String("key", check.key))
+
+			return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+	}
+
+	return nil
+}
+
+type rateLimitCheck struct {
+	key   string
+	limit ratelimit.Limit
+}
+
+func (r RateLimitRules) checks(ctx context.Context, req any, method string) []rateLimitCheck {
+	var checks []rateLimitCheck
+
+	limit, ok := r.Methods[method]
+	if !ok {
+		limit = r.Methods[DefaultMethod]
+	}
+	if limit.Enabled() {
+		checks = append(checks, rateLimitCheck{key: "method:" + method, limit: limit})
+	}
+
+	if r.Peer.Enabled() {
+		if ip := clientinfo.IP(ctx); ip != "" {
+			checks = append(checks, rateLimitCheck{key: "ip:" + ip, limit: r.Peer})
+		}
+	}
+
+	if r.App.Enabled() {
+		if getter, ok := req.(appIDGetter); ok && getter.GetAppId() != 0 {
+			appID := strconv.Itoa(int(getter.GetAppId()))
+			checks = append(checks, rateLimitCheck{key: "app:" + appID, limit: r.App})
+		}
+	}
+
+	return checks
+}
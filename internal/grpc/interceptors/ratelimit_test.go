@@ -0,0 +1,118 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/lib/ratelimit"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+)
+
+type recordingLimiter struct {
+	keys []string
+	deny map[string]bool
+	err  error
+}
+
+func (l *recordingLimiter) Allow(_ context.Context, key string, _ ratelimit.Limit) (bool, error) {
+	l.keys = append(l.keys, key)
+
+	return !l.deny[key], l.err
+}
+
+func TestRateLimit(t *testing.T) {
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+	rules := RateLimitRules{
+		Methods: map[string]ratelimit.Limit{
+			DefaultMethod:      limit,
+			"/Auth.Auth/Login": {Rate: 2, Burst: 2},
+		},
+		Peer: limit,
+		App:  limit,
+	}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000},
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		req      any
+		deny     string
+		wantKeys []string
+		wantCode codes.Code
+	}{
+		{
+			name:     "Login within limits",
+			method:   "/Auth.Auth/Login",
+			req:      &sso.LoginRequest{AppId: 3},
+			wantKeys: []string{"method:/Auth.Auth/Login", "ip:10.0.0.1", "app:3"},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "Request without app ID",
+			method:   "/Auth.Auth/Register",
+			req:      &sso.RegisterRequest{},
+			wantKeys: []string{"method:/Auth.Auth/Register", "ip:10.0.0.1"},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "Peer limit exceeded",
+			method:   "/Auth.Auth/Login",
+			req:      &sso.LoginRequest{AppId: 3},
+			deny:     "ip:10.0.0.1",
+			wantKeys: []string{"method:/Auth.Auth/Login", "ip:10.0.0.1"},
+			wantCode: codes.ResourceExhausted,
+		},
+		{
+			name:     "App limit exceeded",
+			method:   "/Auth.Auth/Login",
+			req:      &sso.LoginRequest{AppId: 3},
+			deny:     "app:3",
+			wantKeys: []string{"method:/Auth.Auth/Login", "ip:10.0.0.1", "app:3"},
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := &recordingLimiter{deny: map[string]bool{test.deny: true}}
+			interceptor := RateLimit(discardLogger(), limiter, rules)
+
+			_, err := interceptor(ctx, test.req,
+				&grpc.UnaryServerInfo{FullMethod: test.method},
+				func(ctx context.Context, req any) (any, error) { return "ok", nil })
+
+			assert.Equal(t, test.wantCode, status.Code(err))
+			assert.Equal(t, test.wantKeys, limiter.keys)
+		})
+	}
+}
+
+func TestRateLimit_LimiterFailure(t *testing.T) {
+	limiter := &recordingLimiter{err: errors.New("backend is down")}
+	interceptor := RateLimit(discardLogger(), limiter, RateLimitRules{
+		Methods: map[string]ratelimit.Limit{DefaultMethod: {Rate: 1, Burst: 1}},
+	})
+
+	resp, err := interceptor(context.Background(), nil,
+		&grpc.UnaryServerInfo{FullMethod: "/Auth.Auth/Login"},
+		func(ctx context.Context, req any) (any, error) { return "ok", nil })
+
+	require.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
@@ -0,0 +1,71 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"grpc-sso/internal/lib/requestid"
+	"grpc-sso/internal/logger/slogger"
+	"log/slog"
+)
+
+// RequestID returns an interceptor that takes the request ID from the
+// incoming metadata or generates a new one. The ID is put into the context
+// together with a logger that carries it, and is sent back in the response header.
+func RequestID(log *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req any,
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (any, error) {
+		ctx, id := withRequestID(ctx, log)
+
+		_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataKey, id))
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamRequestID is RequestID for streaming calls
+func StreamRequestID(log *slog.Logger) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, id := withRequestID(ss.Context(), log)
+
+		_ = ss.SetHeader(metadata.Pairs(requestid.MetadataKey, id))
+
+		return handler(srv, withContext(ss, ctx))
+	}
+}
+
+// withRequestID puts the request ID of the call and the logger carrying it into the context
+func withRequestID(ctx context.Context, log *slog.Logger) (context.Context, string) {
+	id := incomingRequestID(ctx)
+	if id == "" {
+		id = requestid.New()
+	}
+
+	ctx = requestid.NewContext(ctx, id)
+	ctx = slogger.NewContext(ctx, log.With(slog.String("request_id", id)))
+
+	return ctx, id
+}
+
+func incomingRequestID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(requestid.MetadataKey)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
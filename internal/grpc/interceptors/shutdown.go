@@ -0,0 +1,43 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StreamShutdown returns an interceptor that cancels the streams when shutdown is closed,
+// so GracefulStop doesn't wait for the streams that never end by themselves.
+// Such streams fail with codes.Unavailable, the clients reconnect to another server
+func StreamShutdown(shutdown <-chan struct{}) grpc.StreamServerInterceptor {
+	return func(
+		srv any,
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+
+		go func() {
+			select {
+			case <-shutdown:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err := handler(srv, withContext(ss, ctx))
+		if err == nil || ss.Context().Err() != nil {
+			return err
+		}
+
+		select {
+		case <-shutdown:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		default:
+			return err
+		}
+	}
+}
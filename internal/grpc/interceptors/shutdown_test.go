@@ -0,0 +1,84 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// fakeStream is a server stream with only the context
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+var watchInfo = &grpc.StreamServerInfo{FullMethod: "/Auth.Auth/WatchUserEvents", IsServerStream: true}
+
+// watch is a stream handler that runs until its context is done
+func watch(_ any, ss grpc.ServerStream) error {
+	<-ss.Context().Done()
+
+	return status.FromContextError(ss.Context().Err()).Err()
+}
+
+func TestStreamShutdown(t *testing.T) {
+	shutdown := make(chan struct{})
+	interceptor := StreamShutdown(shutdown)
+
+	done := make(chan error)
+	go func() {
+		done <- interceptor(nil, &fakeStream{ctx: context.Background()}, watchInfo, watch)
+	}()
+
+	close(shutdown)
+
+	select {
+	case err := <-done:
+		assert.Equal(t, codes.Unavailable, status.Code(err), err)
+	case <-time.After(time.Second):
+		t.Fatal("the stream is not cancelled on shutdown")
+	}
+}
+
+func TestStreamShutdown_ClientCancel(t *testing.T) {
+	interceptor := StreamShutdown(make(chan struct{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := interceptor(nil, &fakeStream{ctx: ctx}, watchInfo, watch)
+	assert.Equal(t, codes.Canceled, status.Code(err), err)
+}
+
+func TestStreamShutdown_HandlerError(t *testing.T) {
+	shutdown := make(chan struct{})
+	interceptor := StreamShutdown(shutdown)
+
+	errDenied := status.Error(codes.PermissionDenied, "admin only")
+
+	err := interceptor(nil, &fakeStream{ctx: context.Background()}, watchInfo, func(any, grpc.ServerStream) error {
+		return errDenied
+	})
+	require.ErrorIs(t, err, errDenied)
+
+	// Streams that end by themselves keep their result
+	close(shutdown)
+
+	err = interceptor(nil, &fakeStream{ctx: context.Background()}, watchInfo, func(any, grpc.ServerStream) error {
+		return nil
+	})
+	assert.NoError(t, err)
+
+	err = interceptor(nil, &fakeStream{ctx: context.Background()}, watchInfo, func(any, grpc.ServerStream) error {
+		return errors.New("boom")
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err), "failed because of the shutdown")
+}
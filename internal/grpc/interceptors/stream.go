@@ -0,0 +1,22 @@
+package interceptors
+
+import (
+	"context"
+	"google.golang.org/grpc"
+)
+
+// serverStream is the stream with the context replaced,
+// the stream interceptors pass the values they add this way
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// withContext returns the stream with ctx as its context
+func withContext(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	return &serverStream{ServerStream: ss, ctx: ctx}
+}
@@ -0,0 +1,56 @@
+package accesstoken
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	token, err := Generate()
+	require.NoError(t, err)
+
+	// The pattern secret scanners are given
+	assert.Regexp(t, regexp.MustCompile(`^sso_pat_[0-9a-f]{48}$`), token)
+	assert.True(t, Is(token))
+	assert.True(t, Valid(token))
+	assert.Equal(t, token[:12], Hint(token))
+
+	other, err := Generate()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, Hash(token), Hash(other))
+	assert.Len(t, Hash(token), 64)
+}
+
+func TestValid(t *testing.T) {
+	token, err := Generate()
+	require.NoError(t, err)
+
+	flipped := []byte(token)
+	if flipped[10] == '0' {
+		flipped[10] = '1'
+	} else {
+		flipped[10] = '0'
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "valid", token: token, want: true},
+		{name: "wrong checksum", token: string(flipped)},
+		{name: "truncated", token: token[:len(token)-1]},
+		{name: "no prefix", token: token[len(Prefix):]},
+		{name: "not hex", token: Prefix + "zz" + token[len(Prefix)+2:]},
+		{name: "jwt", token: "eyJhbGciOiJIUzI1NiJ9.e30.sig"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Valid(tt.token))
+		})
+	}
+}
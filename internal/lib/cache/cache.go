@@ -0,0 +1,113 @@
+// Package cache is a size bounded LRU cache with per entry TTL
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// Cache is safe for concurrent use
+type Cache[K comparable, V any] struct {
+	mu      sync.Mutex
+	maxSize int
+	// lru has the most recently used entries in front
+	lru   *list.List
+	items map[K]*list.Element
+	now   func() time.Time
+}
+
+// New creates a cache holding up to maxSize entries, the least recently used are evicted first.
+// maxSize <= 0 means unbounded
+func New[K comparable, V any](maxSize int) *Cache[K, V] {
+	return &Cache[K, V]{
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   make(map[K]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// Get returns the value if it's cached and not expired
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	e := el.Value.(*entry[K, V])
+	if !c.now().Before(e.expiresAt) {
+		c.remove(el)
+
+		return zero, false
+	}
+
+	c.lru.MoveToFront(el)
+
+	return e.value, true
+}
+
+// Set caches the value for ttl
+func (c *Cache[K, V]) Set(key K, value V, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(ttl)
+
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expiresAt = value, expiresAt
+		c.lru.MoveToFront(el)
+
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&entry[K, V]{key: key, value: value, expiresAt: expiresAt})
+
+	if c.maxSize > 0 && c.lru.Len() > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Delete removes the value
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// Purge removes all values
+func (c *Cache[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	clear(c.items)
+}
+
+// Len returns the number of entries including the expired ones not evicted yet
+func (c *Cache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *Cache[K, V]) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
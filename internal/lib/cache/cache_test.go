@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCache_TTL(t *testing.T) {
+	now := time.Now()
+
+	c := New[string, int](0)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1, time.Minute)
+
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	now = now.Add(time.Minute)
+
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Zero(t, c.Len(), "expired entry is evicted on read")
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string, int](2)
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+
+	// "a" becomes the most recently used, so "b" is evicted
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	c.Set("c", 3, time.Minute)
+
+	assert.Equal(t, 2, c.Len())
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+}
+
+func TestCache_SetReplaces(t *testing.T) {
+	c := New[string, int](2)
+
+	c.Set("a", 1, time.Minute)
+	c.Set("a", 2, time.Minute)
+
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestCache_DeleteAndPurge(t *testing.T) {
+	c := New[string, int](0)
+
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+
+	c.Delete("a")
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, c.Len())
+
+	c.Purge()
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+	assert.Zero(t, c.Len())
+}
@@ -0,0 +1,21 @@
+// Package caller carries the authenticated caller in the context
+package caller
+
+import (
+	"context"
+	"grpc-sso/internal/domain/models"
+)
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the caller
+func NewContext(ctx context.Context, c models.Caller) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the caller carried by ctx, false for anonymous calls
+func FromContext(ctx context.Context) (models.Caller, bool) {
+	c, ok := ctx.Value(ctxKey{}).(models.Caller)
+
+	return c, ok
+}
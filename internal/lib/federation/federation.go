@@ -0,0 +1,307 @@
+// Package federation signs the users in at upstream identity providers: OpenID Connect providers
+// such as Google or a corporate IdP, and OAuth 2.0 providers with a user info endpoint such as GitHub.
+// The SSO is the client of the provider, the authorization code flow with PKCE is used with both
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrInvalidOptions = errors.New("invalid provider options")
+	// ErrInvalidIdentity is returned by Exchange if the provider returns no subject,
+	// no ID token or an ID token of another login
+	ErrInvalidIdentity = errors.New("invalid identity")
+)
+
+// maxResponseSize limits the responses of the user info endpoints read
+const maxResponseSize = 1 << 20
+
+// Options describe the provider and the SSO registered at it as a client
+type Options struct {
+	// Issuer enables OpenID Connect: the endpoints are discovered from it and the ID tokens are verified
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback of the app the provider sends the users back to
+	RedirectURL string
+	// Scopes are requested at the login, openid, email and profile with OpenID Connect if not set
+	Scopes []string
+	// AuthURL, TokenURL and UserInfoURL are the endpoints of an OAuth 2.0 provider without OpenID Connect
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	// EmailsURL lists the emails of the user with their verification like the GitHub /user/emails,
+	// it is used if the user info has no verified email
+	EmailsURL string
+}
+
+// Identity is the account of the user at the provider
+type Identity struct {
+	// Subject is the ID of the account at the provider, stable unlike the email
+	Subject string
+	Email   string
+	// EmailVerified tells if the provider verified the user owns the email
+	EmailVerified bool
+}
+
+// Login is the secrets of a login at the provider: the state and the nonce tie the response
+// of the provider to the request and the PKCE verifier ties the code to the client
+type Login struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewLogin returns a login with random secrets
+func NewLogin() (Login, error) {
+	var login Login
+
+	for _, secret := range []*string{&login.State, &login.Nonce} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Login{}, err
+		}
+
+		*secret = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	login.Verifier = oauth2.GenerateVerifier()
+
+	return login, nil
+}
+
+// Provider is an upstream identity provider
+type Provider struct {
+	opts   Options
+	client *http.Client
+
+	// mu guards the endpoints discovered from the issuer, discovered at the first login
+	// so the SSO starts while the provider is down
+	mu       sync.Mutex
+	config   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// New returns the provider, requests to it are made with the client
+func New(opts Options, client *http.Client) (*Provider, error) {
+	const op = "federation.New"
+
+	if opts.ClientID == "" || opts.RedirectURL == "" {
+		return nil, fmt.Errorf("%s: %w: client_id and redirect_url are required", op, ErrInvalidOptions)
+	}
+
+	if opts.Issuer == "" && (opts.AuthURL == "" || opts.TokenURL == "" || opts.UserInfoURL == "") {
+		return nil, fmt.Errorf("%s: %w: either issuer or auth_url, token_url and user_info_url are required",
+			op, ErrInvalidOptions)
+	}
+
+	p := &Provider{opts: opts, client: client}
+
+	if opts.Issuer == "" {
+		p.config = &oauth2.Config{
+			ClientID:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			RedirectURL:  opts.RedirectURL,
+			Endpoint:     oauth2.Endpoint{AuthURL: opts.AuthURL, TokenURL: opts.TokenURL},
+			Scopes:       opts.Scopes,
+		}
+	}
+
+	return p, nil
+}
+
+// AuthCodeURL returns the URL of the provider the user signs in at
+func (p *Provider) AuthCodeURL(ctx context.Context, login Login) (string, error) {
+	const op = "federation.AuthCodeURL"
+
+	config, verifier, err := p.endpoints(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	opts := []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(login.Verifier)}
+	if verifier != nil {
+		opts = append(opts, oidc.Nonce(login.Nonce))
+	}
+
+	return config.AuthCodeURL(login.State, opts...), nil
+}
+
+// Exchange exchanges the code the provider sent the user back with for the identity of the user.
+// The state of the login is checked by the caller
+func (p *Provider) Exchange(ctx context.Context, code string, login Login) (Identity, error) {
+	const op = "federation.Exchange"
+
+	ctx = oidc.ClientContext(ctx, p.client)
+
+	config, verifier, err := p.endpoints(ctx)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var identity Identity
+
+	if verifier != nil {
+		identity, err = idTokenIdentity(ctx, verifier, token, login.Nonce)
+	} else {
+		identity, err = p.userInfo(ctx, config, token)
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if identity.Subject == "" {
+		return Identity{}, fmt.Errorf("%s: %w: no subject", op, ErrInvalidIdentity)
+	}
+
+	return identity, nil
+}
+
+// endpoints returns the client config and, with OpenID Connect, the ID token verifier
+func (p *Provider) endpoints(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config != nil {
+		return p.config, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, p.client), p.opts.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discover %s: %w", p.opts.Issuer, err)
+	}
+
+	scopes := p.opts.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	p.config = &oauth2.Config{
+		ClientID:     p.opts.ClientID,
+		ClientSecret: p.opts.ClientSecret,
+		RedirectURL:  p.opts.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.opts.ClientID})
+
+	return p.config, p.verifier, nil
+}
+
+// idTokenIdentity verifies the ID token of the login and returns the identity it names
+func idTokenIdentity(
+	ctx context.Context,
+	verifier *oidc.IDTokenVerifier,
+	token *oauth2.Token,
+	nonce string,
+) (Identity, error) {
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, fmt.Errorf("%w: no ID token", ErrInvalidIdentity)
+	}
+
+	idToken, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidIdentity, err)
+	}
+
+	// The nonce ties the ID token to the login, so a token of another one can't be replayed
+	if idToken.Nonce != nonce {
+		return Identity{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIdentity)
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrInvalidIdentity, err)
+	}
+
+	return Identity{Subject: idToken.Subject, Email: claims.Email, EmailVerified: claims.EmailVerified}, nil
+}
+
+// userInfo returns the identity from the user info endpoint of an OAuth 2.0 provider.
+// The subject is the sub or, like at GitHub, the id of the user
+func (p *Provider) userInfo(ctx context.Context, config *oauth2.Config, token *oauth2.Token) (Identity, error) {
+	client := config.Client(ctx, token)
+
+	var info struct {
+		Sub           string      `json:"sub"`
+		ID            json.Number `json:"id"`
+		Email         string      `json:"email"`
+		EmailVerified bool        `json:"email_verified"`
+	}
+	if err := getJSON(ctx, client, p.opts.UserInfoURL, &info); err != nil {
+		return Identity{}, fmt.Errorf("user info: %w", err)
+	}
+
+	identity := Identity{Subject: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified}
+	if identity.Subject == "" {
+		identity.Subject = info.ID.String()
+	}
+
+	if identity.EmailVerified || p.opts.EmailsURL == "" {
+		return identity, nil
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, p.opts.EmailsURL, &emails); err != nil {
+		return Identity{}, fmt.Errorf("emails: %w", err)
+	}
+
+	// The primary email if it is verified, any verified one otherwise
+	for _, email := range emails {
+		if !email.Verified {
+			continue
+		}
+
+		if email.Primary || !identity.EmailVerified {
+			identity.Email, identity.EmailVerified = email.Email, true
+		}
+	}
+
+	return identity, nil
+}
+
+// getJSON decodes the JSON response of the GET request to the url into v
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
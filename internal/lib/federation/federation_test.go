@@ -0,0 +1,239 @@
+package federation
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/lib/federation/federationtest"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const redirectURL = "https://app.example.com/callback"
+
+func newOIDCProvider(t *testing.T, idp *federationtest.IdP) *Provider {
+	t.Helper()
+
+	p, err := New(Options{
+		Issuer:       idp.URL,
+		ClientID:     federationtest.ClientID,
+		ClientSecret: federationtest.ClientSecret,
+		RedirectURL:  redirectURL,
+	}, idp.Client())
+	require.NoError(t, err)
+
+	return p
+}
+
+// authorize starts the login at the provider and returns the code the IdP sent the user back with
+func authorize(t *testing.T, p *Provider, idp *federationtest.IdP, login Login) string {
+	t.Helper()
+
+	authURL, err := p.AuthCodeURL(context.Background(), login)
+	require.NoError(t, err)
+
+	callback := idp.Authorize(t, authURL)
+	assert.Equal(t, login.State, callback.Get("state"))
+
+	return callback.Get("code")
+}
+
+func TestProvider_OIDC(t *testing.T) {
+	idp := federationtest.New(t)
+	idp.SignIn(federationtest.Account{Subject: "g-1", Email: "user@example.com", EmailVerified: true})
+
+	p := newOIDCProvider(t, idp)
+
+	login, err := NewLogin()
+	require.NoError(t, err)
+
+	authURL, err := p.AuthCodeURL(context.Background(), login)
+	require.NoError(t, err)
+
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, login.Nonce, u.Query().Get("nonce"))
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, "openid email profile", u.Query().Get("scope"))
+	// The verifier never leaves the client
+	assert.NotContains(t, authURL, login.Verifier)
+
+	code := idp.Authorize(t, authURL).Get("code")
+
+	identity, err := p.Exchange(context.Background(), code, login)
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Subject: "g-1", Email: "user@example.com", EmailVerified: true}, identity)
+}
+
+func TestProvider_OIDCFailCases(t *testing.T) {
+	idp := federationtest.New(t)
+	idp.SignIn(federationtest.Account{Subject: "g-1", Email: "user@example.com"})
+
+	p := newOIDCProvider(t, idp)
+	ctx := context.Background()
+
+	t.Run("nonce of another login", func(t *testing.T) {
+		login, err := NewLogin()
+		require.NoError(t, err)
+
+		code := authorize(t, p, idp, login)
+
+		login.Nonce = "other"
+
+		_, err = p.Exchange(ctx, code, login)
+		assert.ErrorIs(t, err, ErrInvalidIdentity)
+	})
+
+	t.Run("verifier of another login", func(t *testing.T) {
+		login, err := NewLogin()
+		require.NoError(t, err)
+
+		code := authorize(t, p, idp, login)
+
+		other, err := NewLogin()
+		require.NoError(t, err)
+		login.Verifier = other.Verifier
+
+		_, err = p.Exchange(ctx, code, login)
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("code used twice", func(t *testing.T) {
+		login, err := NewLogin()
+		require.NoError(t, err)
+
+		code := authorize(t, p, idp, login)
+
+		_, err = p.Exchange(ctx, code, login)
+		require.NoError(t, err)
+
+		_, err = p.Exchange(ctx, code, login)
+		assert.ErrorContains(t, err, "invalid_grant")
+	})
+
+	t.Run("other issuer", func(t *testing.T) {
+		other := federationtest.New(t)
+
+		p, err := New(Options{
+			Issuer:       other.URL + "/",
+			ClientID:     federationtest.ClientID,
+			ClientSecret: federationtest.ClientSecret,
+			RedirectURL:  redirectURL,
+		}, other.Client())
+		require.NoError(t, err)
+
+		// The issuer of the discovery document has no trailing slash
+		_, err = p.AuthCodeURL(ctx, Login{})
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		p, err := New(Options{
+			Issuer:       idp.URL,
+			ClientID:     federationtest.ClientID,
+			ClientSecret: "wrong",
+			RedirectURL:  redirectURL,
+		}, idp.Client())
+		require.NoError(t, err)
+
+		login, err := NewLogin()
+		require.NoError(t, err)
+
+		_, err = p.Exchange(ctx, authorize(t, p, idp, login), login)
+		assert.ErrorContains(t, err, "invalid_client")
+	})
+}
+
+func TestProvider_OAuth2(t *testing.T) {
+	idp := federationtest.New(t)
+
+	// GitHub has no OpenID Connect, the verified email comes from the emails of the user
+	p, err := New(Options{
+		ClientID:     federationtest.ClientID,
+		ClientSecret: federationtest.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"read:user", "user:email"},
+		AuthURL:      idp.URL + "/authorize",
+		TokenURL:     idp.URL + "/token",
+		UserInfoURL:  idp.URL + "/user",
+		EmailsURL:    idp.URL + "/user/emails",
+	}, idp.Client())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		account federationtest.Account
+		want    Identity
+	}{
+		{
+			name:    "verified primary email",
+			account: federationtest.Account{Subject: "583231", Email: "octocat@example.com", EmailVerified: true},
+			want:    Identity{Subject: "583231", Email: "octocat@example.com", EmailVerified: true},
+		},
+		{
+			name:    "unverified primary email",
+			account: federationtest.Account{Subject: "583231", Email: "octocat@example.com"},
+			want:    Identity{Subject: "583231", Email: "noreply@users.github.example.com", EmailVerified: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp.SignIn(tt.account)
+
+			login, err := NewLogin()
+			require.NoError(t, err)
+
+			identity, err := p.Exchange(context.Background(), authorize(t, p, idp, login), login)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, identity)
+		})
+	}
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "no client", opts: Options{Issuer: "https://idp.example.com", RedirectURL: redirectURL}},
+		{name: "no redirect", opts: Options{Issuer: "https://idp.example.com", ClientID: "sso"}},
+		{name: "no endpoints", opts: Options{ClientID: "sso", RedirectURL: redirectURL,
+			AuthURL: "https://idp.example.com/authorize"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.opts, http.DefaultClient)
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
+
+func TestTicket(t *testing.T) {
+	login, err := NewLogin()
+	require.NoError(t, err)
+
+	ticket := Ticket{Login: login, Provider: "google", AppID: 1, UserID: 42}
+
+	signed, err := SignTicket(ticket, "secret", time.Minute)
+	require.NoError(t, err)
+
+	got, err := ParseTicket(signed, "secret")
+	require.NoError(t, err)
+	assert.Equal(t, ticket, got)
+
+	_, err = ParseTicket(signed, "other")
+	assert.ErrorIs(t, err, ErrInvalidTicket)
+
+	expired, err := SignTicket(ticket, "secret", -time.Minute)
+	require.NoError(t, err)
+
+	_, err = ParseTicket(expired, "secret")
+	assert.ErrorIs(t, err, ErrInvalidTicket)
+
+	_, err = ParseTicket("not.a.ticket", "secret")
+	assert.ErrorIs(t, err, ErrInvalidTicket)
+}
@@ -0,0 +1,326 @@
+// Package federationtest is an in-process identity provider for the tests of the federation.
+// It speaks OpenID Connect, with discovery, signed ID tokens and PKCE, and serves the user info
+// and the emails of the user like GitHub does for the OAuth 2.0 providers
+package federationtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The client the SSO is registered as at the IdP
+const (
+	ClientID     = "sso"
+	ClientSecret = "idp-secret"
+)
+
+const keyID = "test-key"
+
+// Account is the user signed in at the IdP
+type Account struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// IdP is the identity provider, its URL is the issuer
+type IdP struct {
+	*httptest.Server
+
+	key *rsa.PrivateKey
+
+	mu      sync.Mutex
+	account Account
+	// grants are the logins by code, tokens the accounts by access token
+	grants map[string]grant
+	tokens map[string]Account
+}
+
+type grant struct {
+	account     Account
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// New starts the IdP, it is closed with the test
+func New(t testing.TB) *IdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &IdP{
+		key:    key,
+		grants: make(map[string]grant),
+		tokens: make(map[string]Account),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /userinfo", p.userInfo)
+	mux.HandleFunc("GET /user", p.githubUser)
+	mux.HandleFunc("GET /user/emails", p.githubEmails)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+// SignIn makes the account the one signed in at the IdP by the next logins
+func (p *IdP) SignIn(account Account) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.account = account
+}
+
+// Authorize follows the auth URL as the browser of the user signed in and returns the query
+// the IdP sends the user back to the redirect URL with: the code and the state
+func (p *IdP) Authorize(t testing.TB, authURL string) url.Values {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := client.Get(authURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	return location.Query()
+}
+
+func (p *IdP) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"userinfo_endpoint":                     p.URL + "/userinfo",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *IdP) jwks(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": keyID,
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+func (p *IdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("client_id") != ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Host == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+
+		return
+	}
+
+	code := randomString()
+
+	p.mu.Lock()
+	p.grants[code] = grant{
+		account:     p.account,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectURI: redirectURI.String(),
+	}
+	p.mu.Unlock()
+
+	callback := redirectURI.Query()
+	callback.Set("code", code)
+	callback.Set("state", q.Get("state"))
+	redirectURI.RawQuery = callback.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *IdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID != ClientID || clientSecret != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+
+		return
+	}
+
+	p.mu.Lock()
+	g, ok := p.grants[r.PostForm.Get("code")]
+	// The codes are single use
+	delete(p.grants, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != g.redirectURI {
+		tokenError(w, "invalid_grant")
+
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != g.challenge {
+		tokenError(w, "invalid_grant")
+
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.URL,
+		"sub":            g.account.Subject,
+		"aud":            ClientID,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.account.Email,
+		"email_verified": g.account.EmailVerified,
+	})
+	idToken.Header["kid"] = keyID
+
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	accessToken := randomString()
+
+	p.mu.Lock()
+	p.tokens[accessToken] = g.account
+	p.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func (p *IdP) userInfo(w http.ResponseWriter, r *http.Request) {
+	account, ok := p.bearer(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            account.Subject,
+		"email":          account.Email,
+		"email_verified": account.EmailVerified,
+	})
+}
+
+// githubUser is the GitHub /user: the numeric id and the public email, without the verification
+func (p *IdP) githubUser(w http.ResponseWriter, r *http.Request) {
+	account, ok := p.bearer(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	id, ok := new(big.Int).SetString(account.Subject, 10)
+	if !ok {
+		http.Error(w, "GitHub subjects are numeric", http.StatusInternalServerError)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "login": "octocat", "email": account.Email})
+}
+
+// githubEmails is the GitHub /user/emails
+func (p *IdP) githubEmails(w http.ResponseWriter, r *http.Request) {
+	account, ok := p.bearer(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, []map[string]any{
+		{"email": "noreply@users.github.example.com", "primary": false, "verified": true},
+		{"email": account.Email, "primary": true, "verified": account.EmailVerified},
+	})
+}
+
+// bearer returns the account of the access token of the request
+func (p *IdP) bearer(r *http.Request) (Account, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return Account{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	account, ok := p.tokens[token]
+
+	return account, ok
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// randomString returns a random URL-safe string for the codes and the access tokens
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
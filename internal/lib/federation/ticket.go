@@ -0,0 +1,71 @@
+package federation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"time"
+)
+
+// ticketType tells the tickets from other tokens signed with the same secret
+const ticketType = "federation_ticket"
+
+// ErrInvalidTicket is returned by ParseTicket if the ticket is malformed, expired or signed with another secret
+var ErrInvalidTicket = errors.New("invalid login ticket")
+
+// Ticket is the login in progress, kept by the app between the redirect to the provider and the callback.
+// It is signed with a key only the SSO holds, the SSO keeps no state of the logins
+type Ticket struct {
+	Login
+	Provider string
+	AppID    int
+	// UserID is the user linking the account, zero if the user signs in
+	UserID int64
+}
+
+// SignTicket returns the ticket signed with the secret, valid for the ttl
+func SignTicket(ticket Ticket, secret string, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"typ":      ticketType,
+		"provider": ticket.Provider,
+		"app":      ticket.AppID,
+		"user":     ticket.UserID,
+		"state":    ticket.State,
+		"nonce":    ticket.Nonce,
+		"verifier": ticket.Verifier,
+		"exp":      time.Now().Add(ttl).Unix(),
+	})
+
+	return token.SignedString([]byte(secret))
+}
+
+// ParseTicket verifies the ticket signed by SignTicket and returns it back
+func ParseTicket(ticket string, secret string) (Ticket, error) {
+	token, err := jwt.Parse(ticket, func(*jwt.Token) (any, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return Ticket{}, fmt.Errorf("%w: %w", ErrInvalidTicket, err)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	typ, _ := claims["typ"].(string)
+	provider, okProvider := claims["provider"].(string)
+	appID, okApp := claims["app"].(float64)
+	userID, okUser := claims["user"].(float64)
+	state, okState := claims["state"].(string)
+	nonce, okNonce := claims["nonce"].(string)
+	verifier, okVerifier := claims["verifier"].(string)
+
+	if typ != ticketType || !okProvider || !okApp || !okUser || !okState || !okNonce || !okVerifier {
+		return Ticket{}, fmt.Errorf("%w: missing claims", ErrInvalidTicket)
+	}
+
+	return Ticket{
+		Login:    Login{State: state, Nonce: nonce, Verifier: verifier},
+		Provider: provider,
+		AppID:    int(appID),
+		UserID:   int64(userID),
+	}, nil
+}
@@ -3,22 +3,59 @@ package jwt
 import (
 	"github.com/golang-jwt/jwt/v5"
 	"grpc-sso/internal/domain/models"
+	"strings"
 	"time"
 )
 
-func NewToken(user models.User, app models.App, duration time.Duration) (string, error) {
+// NewToken returns the token of the caller signed with the secret of its app.
+// ParseToken returns the caller back
+func NewToken(c models.Caller, secret string, duration time.Duration) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 
 	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = user.ID
-	claims["email"] = user.Email
+	claims["user_id"] = c.UserID
+	claims["email"] = c.Email
 	claims["expires"] = time.Now().Add(duration).Unix()
-	claims["app_id"] = app.ID
+	claims["app_id"] = c.AppID
+	claims["tenant"] = c.TenantID
+	if c.SessionID != "" {
+		claims["sid"] = c.SessionID
+	}
+	if len(c.Roles) > 0 {
+		claims["roles"] = c.Roles
+	}
+	if len(c.Groups) > 0 {
+		claims["groups"] = c.Groups
+	}
+	// The scopes are space-delimited like in OAuth 2.0
+	if len(c.Scopes) > 0 {
+		claims["scope"] = strings.Join(c.Scopes, " ")
+	}
+	if c.Actor != nil {
+		claims["act"] = actClaims(c.Actor)
+	}
 
-	tokenString, err := token.SignedString([]byte(app.Secret))
+	tokenString, err := token.SignedString([]byte(secret))
 	if err != nil {
 		return "", err
 	}
 
 	return tokenString, nil
 }
+
+// actClaims returns the act claim of the actor, the prior actors are nested in it
+func actClaims(actor *models.Actor) map[string]any {
+	claims := map[string]any{}
+	if actor.UserID != 0 {
+		claims["user_id"] = actor.UserID
+		claims["email"] = actor.Email
+	}
+	if actor.AppID != 0 {
+		claims["app_id"] = actor.AppID
+	}
+	if actor.Actor != nil {
+		claims["act"] = actClaims(actor.Actor)
+	}
+
+	return claims
+}
@@ -0,0 +1,201 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"grpc-sso/internal/domain/models"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// SecretFunc returns the secret of the app the token was issued for
+type SecretFunc func(appID int) (string, error)
+
+// ParseToken verifies the token issued by NewToken and returns its caller.
+// Errors of the secret func other than ErrInvalidToken are returned as is
+func ParseToken(tokenString string, secret SecretFunc) (models.Caller, error) {
+	var (
+		caller    models.Caller
+		secretErr error
+	)
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+
+		appID, ok := claims["app_id"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w: no app_id", ErrInvalidToken)
+		}
+
+		appSecret, err := secret(int(appID))
+		if err != nil {
+			secretErr = err
+
+			return nil, err
+		}
+
+		return []byte(appSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		if secretErr != nil && !errors.Is(secretErr, ErrInvalidToken) {
+			return caller, secretErr
+		}
+
+		if errors.Is(err, ErrInvalidToken) {
+			return caller, err
+		}
+
+		return caller, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	userID, okUser := claims["user_id"].(float64)
+	email, okEmail := claims["email"].(string)
+	expires, okExpires := claims["expires"].(float64)
+	appID, _ := claims["app_id"].(float64)
+	// Tokens issued without a session have no sid
+	sessionID, _ := claims["sid"].(string)
+	// Tokens issued before the tenants were introduced belong to the default one
+	tenantID, ok := claims["tenant"].(float64)
+	if !ok || tenantID == 0 {
+		tenantID = float64(models.DefaultTenantID)
+	}
+
+	roles, okRoles := stringsClaim(claims, "roles")
+	groups, okGroups := stringsClaim(claims, "groups")
+	scopes, okScope := scopeClaim(claims)
+	actor, okActor := actClaim(claims)
+
+	if !okUser || !okEmail || !okExpires || !okRoles || !okGroups || !okScope || !okActor {
+		return caller, fmt.Errorf("%w: missing claims", ErrInvalidToken)
+	}
+
+	if time.Now().After(time.Unix(int64(expires), 0)) {
+		return caller, ErrTokenExpired
+	}
+
+	return models.Caller{
+		UserID:    int64(userID),
+		TenantID:  int64(tenantID),
+		Email:     email,
+		AppID:     int(appID),
+		SessionID: sessionID,
+		Roles:     roles,
+		Groups:    groups,
+		Scopes:    scopes,
+		Actor:     actor,
+	}, nil
+}
+
+// stringsClaim returns the list of strings of the claim, a missing claim is an empty list
+func stringsClaim(claims jwt.MapClaims, name string) ([]string, bool) {
+	raw, ok := claims[name]
+	if !ok {
+		return nil, true
+	}
+
+	list, ok := raw.([]any)
+	if !ok {
+		return nil, false
+	}
+
+	res := make([]string, 0, len(list))
+
+	for _, v := range list {
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+
+		res = append(res, s)
+	}
+
+	return res, true
+}
+
+// scopeClaim returns the space-delimited scopes of the scope claim, tokens issued without scopes have none
+func scopeClaim(claims jwt.MapClaims) ([]string, bool) {
+	raw, ok := claims["scope"]
+	if !ok {
+		return nil, true
+	}
+
+	scope, ok := raw.(string)
+	if !ok {
+		return nil, false
+	}
+
+	return strings.Fields(scope), true
+}
+
+// actClaim returns the actor of the act claim, tokens not issued to an actor have none.
+// An actor is either an admin or an app, the prior actors are nested
+func actClaim(claims map[string]any) (*models.Actor, bool) {
+	raw, ok := claims["act"]
+	if !ok {
+		return nil, true
+	}
+
+	act, ok := raw.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+
+	var actor models.Actor
+
+	if rawUserID, ok := act["user_id"]; ok {
+		userID, okUser := rawUserID.(float64)
+		email, okEmail := act["email"].(string)
+		if !okUser || !okEmail {
+			return nil, false
+		}
+
+		actor.UserID, actor.Email = int64(userID), email
+	}
+
+	if rawAppID, ok := act["app_id"]; ok {
+		appID, ok := rawAppID.(float64)
+		if !ok {
+			return nil, false
+		}
+
+		actor.AppID = int(appID)
+	}
+
+	if actor.UserID == 0 && actor.AppID == 0 {
+		return nil, false
+	}
+
+	actor.Actor, ok = actClaim(act)
+	if !ok {
+		return nil, false
+	}
+
+	return &actor, true
+}
+
+// ExpiresAt returns the expiry of the token verified by ParseToken, without verifying it again
+func ExpiresAt(tokenString string) (time.Time, error) {
+	claims := jwt.MapClaims{}
+
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	expires, ok := claims["expires"].(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: missing claims", ErrInvalidToken)
+	}
+
+	return time.Unix(int64(expires), 0), nil
+}
@@ -0,0 +1,224 @@
+package jwt
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"testing"
+	"time"
+)
+
+var (
+	testCaller = models.Caller{UserID: 42, TenantID: 2, Email: "user@example.com", AppID: 3, SessionID: "session-1"}
+	testApp    = models.App{ID: 3, Name: "test", Secret: "test-secret"}
+)
+
+func secretOf(apps ...models.App) SecretFunc {
+	return func(appID int) (string, error) {
+		for _, app := range apps {
+			if app.ID == appID {
+				return app.Secret, nil
+			}
+		}
+
+		return "", ErrInvalidToken
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	token, err := NewToken(testCaller, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	caller, err := ParseToken(token, secretOf(testApp))
+	require.NoError(t, err)
+	assert.Equal(t, testCaller, caller)
+
+	// Tokens issued without a session have no sid claim
+	noSession := testCaller
+	noSession.SessionID = ""
+
+	token, err = NewToken(noSession, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	caller, err = ParseToken(token, secretOf(testApp))
+	require.NoError(t, err)
+	assert.Equal(t, noSession, caller)
+}
+
+func TestParseToken_RolesAndGroups(t *testing.T) {
+	withGroups := testCaller
+	withGroups.Roles = []string{"editor", "viewer"}
+	withGroups.Groups = []string{"backend", "engineering"}
+
+	token, err := NewToken(withGroups, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	caller, err := ParseToken(token, secretOf(testApp))
+	require.NoError(t, err)
+	assert.Equal(t, withGroups, caller)
+
+	// The groups are in the token on request only
+	withRoles := withGroups
+	withRoles.Groups = nil
+
+	token, err = NewToken(withRoles, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	caller, err = ParseToken(token, secretOf(testApp))
+	require.NoError(t, err)
+	assert.Equal(t, withRoles, caller)
+}
+
+func TestParseToken_Scopes(t *testing.T) {
+	withScopes := testCaller
+	withScopes.Scopes = []string{"email", "profile"}
+
+	token, err := NewToken(withScopes, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, "email profile", claims["scope"])
+
+	caller, err := ParseToken(token, secretOf(testApp))
+	require.NoError(t, err)
+	assert.Equal(t, withScopes, caller)
+}
+
+func TestParseToken_Actor(t *testing.T) {
+	impersonated := testCaller
+	impersonated.SessionID = ""
+	impersonated.Actor = &models.Actor{UserID: 1, Email: "admin@example.com"}
+
+	token, err := NewToken(impersonated, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"user_id": float64(1), "email": "admin@example.com"}, claims["act"])
+
+	caller, err := ParseToken(token, secretOf(testApp))
+	require.NoError(t, err)
+	assert.Equal(t, impersonated, caller)
+}
+
+func TestParseToken_NestedActors(t *testing.T) {
+	exchanged := testCaller
+	exchanged.Actor = &models.Actor{AppID: 5, Actor: &models.Actor{AppID: 4,
+		Actor: &models.Actor{UserID: 1, Email: "admin@example.com"}}}
+
+	token, err := NewToken(exchanged, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"app_id": float64(5), "act": map[string]any{"app_id": float64(4),
+		"act": map[string]any{"user_id": float64(1), "email": "admin@example.com"}}}, claims["act"])
+
+	caller, err := ParseToken(token, secretOf(testApp))
+	require.NoError(t, err)
+	assert.Equal(t, exchanged, caller)
+}
+
+func TestExpiresAt(t *testing.T) {
+	token, err := NewToken(testCaller, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	expiresAt, err := ExpiresAt(token)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Second)
+
+	_, err = ExpiresAt("not.a.token")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestParseToken_DefaultTenant(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 42, "email": "user@example.com", "app_id": 3, "expires": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testApp.Secret))
+	require.NoError(t, err)
+
+	caller, err := ParseToken(token, secretOf(testApp))
+	require.NoError(t, err)
+	assert.Equal(t, models.DefaultTenantID, caller.TenantID)
+}
+
+func TestParseToken_Invalid(t *testing.T) {
+	valid, err := NewToken(testCaller, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	expired, err := NewToken(testCaller, testApp.Secret, -time.Minute)
+	require.NoError(t, err)
+
+	otherSecret, err := NewToken(testCaller, "other", time.Hour)
+	require.NoError(t, err)
+
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"user_id": 42, "email": "user@example.com", "app_id": 3, "expires": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	badRoles, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 42, "email": "user@example.com", "app_id": 3, "expires": time.Now().Add(time.Hour).Unix(),
+		"roles": []any{"editor", 1},
+	}).SignedString([]byte(testApp.Secret))
+	require.NoError(t, err)
+
+	badScope, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 42, "email": "user@example.com", "app_id": 3, "expires": time.Now().Add(time.Hour).Unix(),
+		"scope": []any{"email"},
+	}).SignedString([]byte(testApp.Secret))
+	require.NoError(t, err)
+
+	badAct, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 42, "email": "user@example.com", "app_id": 3, "expires": time.Now().Add(time.Hour).Unix(),
+		"act": map[string]any{"user_id": "1"},
+	}).SignedString([]byte(testApp.Secret))
+	require.NoError(t, err)
+
+	emptyAct, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 42, "email": "user@example.com", "app_id": 3, "expires": time.Now().Add(time.Hour).Unix(),
+		"act": map[string]any{"act": map[string]any{"app_id": 4}},
+	}).SignedString([]byte(testApp.Secret))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		token   string
+		secret  SecretFunc
+		wantErr error
+	}{
+		{name: "malformed roles", token: badRoles, secret: secretOf(testApp), wantErr: ErrInvalidToken},
+		{name: "malformed scope", token: badScope, secret: secretOf(testApp), wantErr: ErrInvalidToken},
+		{name: "malformed act", token: badAct, secret: secretOf(testApp), wantErr: ErrInvalidToken},
+		{name: "act without actor", token: emptyAct, secret: secretOf(testApp), wantErr: ErrInvalidToken},
+		{name: "expired", token: expired, secret: secretOf(testApp), wantErr: ErrTokenExpired},
+		{name: "wrong secret", token: otherSecret, secret: secretOf(testApp), wantErr: ErrInvalidToken},
+		{name: "unknown app", token: valid, secret: secretOf(), wantErr: ErrInvalidToken},
+		{name: "unsigned", token: none, secret: secretOf(testApp), wantErr: ErrInvalidToken},
+		{name: "garbage", token: "not.a.token", secret: secretOf(testApp), wantErr: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseToken(tt.token, tt.secret)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseToken_SecretError(t *testing.T) {
+	token, err := NewToken(testCaller, testApp.Secret, time.Hour)
+	require.NoError(t, err)
+
+	storageErr := errors.New("connection refused")
+
+	_, err = ParseToken(token, func(int) (string, error) { return "", storageErr })
+	assert.ErrorIs(t, err, storageErr)
+	assert.NotErrorIs(t, err, ErrInvalidToken)
+}
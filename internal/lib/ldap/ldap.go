@@ -0,0 +1,236 @@
+// Package ldap authenticates the users with their passwords in an LDAP directory, Active Directory included.
+// The entry of the user is searched by the email, then its DN is bound with the password
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	goldap "github.com/go-ldap/ldap/v3"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultTimeout        = 10 * time.Second
+	defaultUserFilter     = "(mail=%s)"
+	defaultEmailAttribute = "mail"
+	defaultGroupAttribute = "memberOf"
+)
+
+var (
+	ErrInvalidOptions = errors.New("invalid LDAP options")
+	// ErrInvalidCredentials is returned by Authenticate if the password is wrong or empty,
+	// or no single entry matches the email
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Options are the connection to the directory and the layout of its user entries
+type Options struct {
+	// URL is ldap://host:389 or ldaps://host:636
+	URL string
+	// StartTLS upgrades the ldap:// connections to TLS
+	StartTLS bool
+	// CAFile verifies the certificate of the directory instead of the system roots
+	CAFile  string
+	Timeout time.Duration
+	// BindDN and BindPassword are the account searching the users, the search is anonymous if not set
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the entry of the user, %s is the escaped email. (mail=%s) by default,
+	// e.g. (userPrincipalName=%s) for Active Directory
+	UserFilter string
+	// IDAttribute is the immutable ID of the entry, e.g. entryUUID or objectGUID. The DN if not set
+	IDAttribute string
+	// EmailAttribute is mail by default
+	EmailAttribute string
+	// GroupAttribute lists the DNs of the groups of the user, memberOf by default
+	GroupAttribute string
+}
+
+// Entry is the user found in the directory
+type Entry struct {
+	DN string
+	// ID is the value of the ID attribute or the DN
+	ID string
+	// Email is the value of the email attribute, empty if the entry has none
+	Email  string
+	Groups []string
+}
+
+// Directory is an LDAP directory, every Authenticate opens its own connection
+type Directory struct {
+	opts Options
+	tls  *tls.Config
+}
+
+// New returns the directory of the options, it doesn't connect until Authenticate
+func New(opts Options) (*Directory, error) {
+	const op = "ldap.New"
+
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Hostname() == "" {
+		return nil, fmt.Errorf("%s: %w: url must be ldap:// or ldaps://", op, ErrInvalidOptions)
+	}
+
+	if opts.BaseDN == "" {
+		return nil, fmt.Errorf("%s: %w: base DN is required", op, ErrInvalidOptions)
+	}
+
+	if opts.UserFilter == "" {
+		opts.UserFilter = defaultUserFilter
+	}
+
+	if !strings.Contains(opts.UserFilter, "%s") {
+		return nil, fmt.Errorf("%s: %w: user filter must contain %%s", op, ErrInvalidOptions)
+	}
+
+	if opts.EmailAttribute == "" {
+		opts.EmailAttribute = defaultEmailAttribute
+	}
+
+	if opts.GroupAttribute == "" {
+		opts.GroupAttribute = defaultGroupAttribute
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: %w: no certificates in %s", op, ErrInvalidOptions, opts.CAFile)
+		}
+	}
+
+	return &Directory{opts: opts, tls: tlsConfig}, nil
+}
+
+// Authenticate finds the entry of the email and binds it with the password
+func (d *Directory) Authenticate(ctx context.Context, email string, password string) (Entry, error) {
+	const op = "ldap.Directory.Authenticate"
+
+	// An empty password is an unauthenticated bind, which most servers accept for any DN
+	if password == "" || email == "" {
+		return Entry{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer conn.Close()
+
+	// The calls of the connection don't take the context, closing it interrupts them
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	if d.opts.BindDN != "" {
+		if err := conn.Bind(d.opts.BindDN, d.opts.BindPassword); err != nil {
+			return Entry{}, fmt.Errorf("%s: bind search account: %w", op, err)
+		}
+	}
+
+	entry, err := d.search(conn, email)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return Entry{}, fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		}
+
+		return Entry{}, fmt.Errorf("%s: bind user: %w", op, err)
+	}
+
+	return entry, nil
+}
+
+func (d *Directory) dial(ctx context.Context) (*goldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.opts.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := goldap.DialURL(d.opts.URL, goldap.DialWithDialer(dialer), goldap.DialWithTLSConfig(d.tls))
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(d.opts.Timeout)
+
+	if d.opts.StartTLS {
+		if err := conn.StartTLS(d.tls); err != nil {
+			_ = conn.Close()
+
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// search returns the single entry matching the email
+func (d *Directory) search(conn *goldap.Conn, email string) (Entry, error) {
+	attributes := []string{d.opts.EmailAttribute, d.opts.GroupAttribute}
+	if d.opts.IDAttribute != "" {
+		attributes = append(attributes, d.opts.IDAttribute)
+	}
+
+	// Two entries are enough to tell the email is ambiguous
+	result, err := conn.Search(goldap.NewSearchRequest(
+		d.opts.BaseDN,
+		goldap.ScopeWholeSubtree, goldap.NeverDerefAliases, 2, int(d.opts.Timeout.Seconds()), false,
+		strings.ReplaceAll(d.opts.UserFilter, "%s", goldap.EscapeFilter(email)),
+		attributes,
+		nil,
+	))
+	if err != nil && !goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return Entry{}, fmt.Errorf("search user: %w", err)
+	}
+
+	if len(result.Entries) != 1 {
+		return Entry{}, fmt.Errorf("%w: %d entries match the email", ErrInvalidCredentials, len(result.Entries))
+	}
+
+	e := result.Entries[0]
+
+	entry := Entry{
+		DN:     e.DN,
+		ID:     e.DN,
+		Email:  e.GetEqualFoldAttributeValue(d.opts.EmailAttribute),
+		Groups: e.GetEqualFoldAttributeValues(d.opts.GroupAttribute),
+	}
+
+	if d.opts.IDAttribute != "" {
+		id := e.GetEqualFoldRawAttributeValue(d.opts.IDAttribute)
+		if len(id) == 0 {
+			return Entry{}, fmt.Errorf("entry %s has no %s", e.DN, d.opts.IDAttribute)
+		}
+
+		// objectGUID is binary
+		entry.ID = string(id)
+		if !utf8.Valid(id) {
+			entry.ID = hex.EncodeToString(id)
+		}
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,176 @@
+package ldap
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/lib/ldap/ldaptest"
+	"testing"
+	"time"
+)
+
+const (
+	aliceDN = "uid=alice,ou=people,dc=example,dc=com"
+	adminDN = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+func newServer(t *testing.T) *ldaptest.Server {
+	t.Helper()
+
+	return ldaptest.New(t,
+		ldaptest.Entry{DN: aliceDN, Password: "alice-secret", Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"mail":        {"Alice@example.com"},
+			"entryUUID":   {"5f3a2b1c-0000-4000-8000-000000000001"},
+			"memberOf":    {adminDN, "cn=staff,ou=groups,dc=example,dc=com"},
+		}},
+		ldaptest.Entry{DN: "uid=bob,ou=people,dc=example,dc=com", Password: "bob-secret", Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"mail":        {"bob@example.com"},
+			"objectGUID":  {"\x01\x02\xff"},
+		}},
+		// Two entries with the same email
+		ldaptest.Entry{DN: "uid=carol,ou=people,dc=example,dc=com", Password: "carol-secret", Attributes: map[string][]string{
+			"mail": {"shared@example.com"},
+		}},
+		ldaptest.Entry{DN: "uid=dave,ou=people,dc=example,dc=com", Password: "dave-secret", Attributes: map[string][]string{
+			"mail": {"shared@example.com"},
+		}},
+	)
+}
+
+func newDirectory(t *testing.T, srv *ldaptest.Server, opts Options) *Directory {
+	t.Helper()
+
+	opts.URL = srv.URL
+	opts.BaseDN = ldaptest.BaseDN
+	opts.Timeout = 5 * time.Second
+
+	d, err := New(opts)
+	require.NoError(t, err)
+
+	return d
+}
+
+func TestDirectory_Authenticate(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+
+	d := newDirectory(t, srv, Options{
+		BindDN:       ldaptest.BindDN,
+		BindPassword: ldaptest.BindPassword,
+		UserFilter:   "(&(objectClass=person)(mail=%s))",
+		IDAttribute:  "entryUUID",
+	})
+
+	entry, err := d.Authenticate(ctx, "alice@example.com", "alice-secret")
+	require.NoError(t, err)
+	assert.Equal(t, Entry{
+		DN:     aliceDN,
+		ID:     "5f3a2b1c-0000-4000-8000-000000000001",
+		Email:  "Alice@example.com",
+		Groups: []string{adminDN, "cn=staff,ou=groups,dc=example,dc=com"},
+	}, entry)
+	assert.Equal(t, 1, srv.Binds())
+
+	// Bob has no entryUUID
+	_, err = d.Authenticate(ctx, "bob@example.com", "bob-secret")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestDirectory_AuthenticateDefaults(t *testing.T) {
+	srv := newServer(t)
+
+	// Anonymous search, the DN is the ID
+	d := newDirectory(t, srv, Options{})
+
+	entry, err := d.Authenticate(context.Background(), "alice@example.com", "alice-secret")
+	require.NoError(t, err)
+	assert.Equal(t, aliceDN, entry.ID)
+	assert.Len(t, entry.Groups, 2)
+
+	// A binary ID is hex encoded
+	d = newDirectory(t, srv, Options{IDAttribute: "objectGUID"})
+
+	entry, err = d.Authenticate(context.Background(), "bob@example.com", "bob-secret")
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString([]byte{1, 2, 0xff}), entry.ID)
+	assert.Empty(t, entry.Groups)
+}
+
+func TestDirectory_AuthenticateFailCases(t *testing.T) {
+	srv := newServer(t)
+	ctx := context.Background()
+
+	d := newDirectory(t, srv, Options{BindDN: ldaptest.BindDN, BindPassword: ldaptest.BindPassword})
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+	}{
+		{name: "wrong password", email: "alice@example.com", password: "wrong"},
+		// The server accepts it as an unauthenticated bind
+		{name: "empty password", email: "alice@example.com", password: ""},
+		{name: "unknown email", email: "nobody@example.com", password: "alice-secret"},
+		{name: "ambiguous email", email: "shared@example.com", password: "carol-secret"},
+		{name: "filter injection", email: "*", password: "alice-secret"},
+		{name: "empty email", email: "", password: "alice-secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := d.Authenticate(ctx, tt.email, tt.password)
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+		})
+	}
+
+	assert.Zero(t, srv.Binds())
+
+	t.Run("wrong search account", func(t *testing.T) {
+		d := newDirectory(t, srv, Options{BindDN: ldaptest.BindDN, BindPassword: "wrong"})
+
+		_, err := d.Authenticate(ctx, "alice@example.com", "alice-secret")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		d, err := New(Options{URL: "ldap://127.0.0.1:1", BaseDN: ldaptest.BaseDN, Timeout: time.Second})
+		require.NoError(t, err)
+
+		_, err = d.Authenticate(ctx, "alice@example.com", "alice-secret")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := d.Authenticate(canceled, "alice@example.com", "alice-secret")
+		assert.Error(t, err)
+	})
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "no url", opts: Options{BaseDN: ldaptest.BaseDN}},
+		{name: "http url", opts: Options{URL: "https://ldap.example.com", BaseDN: ldaptest.BaseDN}},
+		{name: "no base DN", opts: Options{URL: "ldap://ldap.example.com"}},
+		{name: "filter without email", opts: Options{URL: "ldap://ldap.example.com", BaseDN: ldaptest.BaseDN,
+			UserFilter: "(objectClass=person)"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.opts)
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
@@ -0,0 +1,364 @@
+// Package ldaptest is an in-process LDAP server for the tests of the LDAP authentication.
+// It speaks just enough of the protocol for ldap.Directory: simple binds, anonymous or bound searches
+// with the and, or, not, equality and presence filters, and unbinds
+package ldaptest
+
+import (
+	"bufio"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The account searching the users
+const (
+	BindDN       = "cn=sso,ou=services,dc=example,dc=com"
+	BindPassword = "service-secret"
+	BaseDN       = "ou=people,dc=example,dc=com"
+)
+
+// The protocol operations, RFC 4511
+const (
+	opBindRequest       = 0
+	opBindResponse      = 1
+	opUnbindRequest     = 2
+	opSearchRequest     = 3
+	opSearchResultEntry = 4
+	opSearchResultDone  = 5
+	opExtendedRequest   = 23
+	opExtendedResponse  = 24
+)
+
+// The result codes, RFC 4511
+const (
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultSizeLimitExceeded  = 4
+	resultInvalidCredentials = 49
+)
+
+// The filters, RFC 4511
+const (
+	filterAnd           = 0
+	filterOr            = 1
+	filterNot           = 2
+	filterEqualityMatch = 3
+	filterPresent       = 7
+)
+
+// Entry is an entry of the directory, the users bind with its password
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is the directory, its URL is ldap://127.0.0.1:port
+type Server struct {
+	URL string
+
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries []Entry
+	binds   int
+	conns   map[net.Conn]struct{}
+}
+
+// New starts the server with the entries and the search account, it is closed with the test
+func New(t testing.TB, entries ...Entry) *Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &Server{
+		URL:      "ldap://" + listener.Addr().String(),
+		listener: listener,
+		entries:  append([]Entry{{DN: BindDN, Password: BindPassword}}, entries...),
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns[conn] = struct{}{}
+			s.mu.Unlock()
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+
+		// The clients still connected would keep their connections open
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+
+		wg.Wait()
+	})
+
+	return s
+}
+
+// Put adds the entry or replaces the one with its DN
+func (s *Server) Put(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.entries {
+		if strings.EqualFold(e.DN, entry.DN) {
+			s.entries[i] = entry
+
+			return
+		}
+	}
+
+	s.entries = append(s.entries, entry)
+}
+
+// Binds returns the number of the successful binds of the users, the search account excluded
+func (s *Server) Binds() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.binds
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+
+	for {
+		packet, err := ber.ReadPacket(r)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		messageID := packet.Children[0].Value
+		req := packet.Children[1]
+
+		var resp []*ber.Packet
+
+		switch req.Tag {
+		case opBindRequest:
+			resp = []*ber.Packet{result(opBindResponse, s.bind(req))}
+		case opSearchRequest:
+			// Anonymous searches are allowed, like on OpenLDAP by default
+			resp = s.search(req)
+		case opUnbindRequest:
+			return
+		case opExtendedRequest:
+			// StartTLS and the others aren't supported
+			resp = []*ber.Packet{result(opExtendedResponse, resultProtocolError)}
+		default:
+			return
+		}
+
+		for _, op := range resp {
+			envelope := ber.NewSequence("LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+			envelope.AppendChild(op)
+
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bind returns the result of the simple bind. An empty password is an unauthenticated bind,
+// which succeeds for any DN like on most servers
+func (s *Server) bind(req *ber.Packet) int {
+	if len(req.Children) < 3 {
+		return resultProtocolError
+	}
+
+	dn := str(req.Children[1])
+	password := str(req.Children[2])
+
+	if password == "" {
+		return resultSuccess
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) && e.Password != "" && e.Password == password {
+			if !strings.EqualFold(dn, BindDN) {
+				s.binds++
+			}
+
+			return resultSuccess
+		}
+	}
+
+	return resultInvalidCredentials
+}
+
+func (s *Server) search(req *ber.Packet) []*ber.Packet {
+	if len(req.Children) < 8 {
+		return []*ber.Packet{result(opSearchResultDone, resultProtocolError)}
+	}
+
+	baseDN := strings.ToLower(str(req.Children[0]))
+	sizeLimit, _ := req.Children[3].Value.(int64)
+	filter := req.Children[6]
+
+	var requested []string
+	for _, a := range req.Children[7].Children {
+		requested = append(requested, str(a))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var resp []*ber.Packet
+
+	for _, e := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(e.DN), baseDN) || !match(filter, e) {
+			continue
+		}
+
+		if sizeLimit > 0 && int64(len(resp)) == sizeLimit {
+			return append(resp, result(opSearchResultDone, resultSizeLimitExceeded))
+		}
+
+		resp = append(resp, searchEntry(e, requested))
+	}
+
+	return append(resp, result(opSearchResultDone, resultSuccess))
+}
+
+func match(filter *ber.Packet, e Entry) bool {
+	switch filter.Tag {
+	case filterAnd:
+		for _, f := range filter.Children {
+			if !match(f, e) {
+				return false
+			}
+		}
+
+		return true
+	case filterOr:
+		for _, f := range filter.Children {
+			if match(f, e) {
+				return true
+			}
+		}
+
+		return false
+	case filterNot:
+		return len(filter.Children) == 1 && !match(filter.Children[0], e)
+	case filterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+
+		for _, v := range values(e, str(filter.Children[0])) {
+			if strings.EqualFold(v, str(filter.Children[1])) {
+				return true
+			}
+		}
+
+		return false
+	case filterPresent:
+		return len(values(e, str(filter))) > 0
+	default:
+		return false
+	}
+}
+
+func values(e Entry, attribute string) []string {
+	for name, v := range e.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func searchEntry(e Entry, requested []string) *ber.Packet {
+	attributes := ber.NewSequence("Attributes")
+
+	for name, v := range e.Attributes {
+		if len(requested) > 0 && !containsFold(requested, name) {
+			continue
+		}
+
+		attribute := ber.NewSequence("Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range v {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+
+		attribute.AppendChild(vals)
+		attributes.AppendChild(attribute)
+	}
+
+	entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchResultEntry, nil, "Search Result Entry")
+	entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "Object Name"))
+	entry.AppendChild(attributes)
+
+	return entry
+}
+
+func result(op ber.Tag, code int) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "Result")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+
+	return p
+}
+
+// str returns the content of the primitive packet, the strings of any class
+func str(p *ber.Packet) string {
+	if p.Data == nil {
+		return ""
+	}
+
+	return p.Data.String()
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "sso"
+
+// Login failure reasons
+const (
+	ReasonInvalidCredentials = "invalid_credentials"
+	ReasonInvalidAppID       = "invalid_app_id"
+	ReasonUserDisabled       = "user_disabled"
+	ReasonInvalidScope       = "invalid_scope"
+	ReasonNoConsent          = "no_consent"
+	ReasonInternal           = "internal"
+)
+
+// Password hashing operations
+const (
+	HashGenerate = "generate"
+	HashCompare  = "compare"
+)
+
+// Cache lookup results
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+// Webhook delivery results
+const (
+	WebhookDelivered = "delivered"
+	WebhookRetry     = "retry"
+	WebhookDead      = "dead"
+)
+
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	GRPCRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grpc",
+		Subsystem: "server",
+		Name:      "handled_total",
+		Help:      "Total number of gRPC calls completed on the server by method and status code.",
+	}, []string{"method", "code"})
+
+	GRPCLatency = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "grpc",
+		Subsystem: "server",
+		Name:      "handling_seconds",
+		Help:      "Latency of gRPC calls handled by the server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	GRPCActiveStreams = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grpc",
+		Subsystem: "server",
+		Name:      "active_streams",
+		Help:      "Number of streaming gRPC calls being served by method.",
+	}, []string{"method"})
+
+	Registrations = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Total number of registered users.",
+	})
+
+	Logins = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Total number of successful logins.",
+	})
+
+	LoginFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Total number of failed logins by reason.",
+	}, []string{"reason"})
+
+	TokensIssued = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_issued_total",
+		Help:      "Total number of issued tokens by app.",
+	}, []string{"app_id"})
+
+	PasswordHashDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "password_hash_duration_seconds",
+		Help:      "Time spent in bcrypt by operation.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"op"})
+
+	StorageQueryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_query_duration_seconds",
+		Help:      "Latency of storage queries by backend and query.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"backend", "query"})
+
+	CacheRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Total number of storage cache lookups by cache and result.",
+	}, []string{"cache", "result"})
+
+	WebhookDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Total number of webhook delivery attempts by app and result.",
+	}, []string{"app_id", "result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler returns the HTTP handler exposing all metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// ObserveStorageQuery starts a timer for the query.
+// Call the returned function when the query is done.
+func ObserveStorageQuery(backend, query string) func() {
+	timer := prometheus.NewTimer(StorageQueryDuration.WithLabelValues(backend, query))
+
+	return func() { timer.ObserveDuration() }
+}
+
+// ObservePasswordHash starts a timer for the bcrypt operation.
+// Call the returned function when the operation is done.
+func ObservePasswordHash(op string) func() {
+	timer := prometheus.NewTimer(PasswordHashDuration.WithLabelValues(op))
+
+	return func() { timer.ObserveDuration() }
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LatestVersion returns the highest version of the "<version>_<name>.up.sql"
+// migrations in dir
+func LatestVersion(dir string) (uint, error) {
+	const op = "migrations.LatestVersion"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	var latest uint
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		latest = max(latest, uint(version))
+	}
+
+	if latest == 0 {
+		return 0, fmt.Errorf("%s: no migrations in %s", op, dir)
+	}
+
+	return latest, nil
+}
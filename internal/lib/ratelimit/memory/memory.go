@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"grpc-sso/internal/lib/ratelimit"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+// Limiter keeps token buckets in the process memory.
+// It is suitable for a single node only.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*entry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type entry struct {
+	bucket ratelimit.Bucket
+	idle   time.Duration
+}
+
+// New creates a new in-memory limiter
+func New() *Limiter {
+	return &Limiter{
+		buckets: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket identified by key
+func (l *Limiter) Allow(_ context.Context, key string, limit ratelimit.Limit) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	e, ok := l.buckets[key]
+	if !ok {
+		e = &entry{}
+		l.buckets[key] = e
+	}
+	e.idle = limit.RefillTime()
+
+	return e.bucket.Take(now, limit), nil
+}
+
+// sweep drops buckets that have been idle long enough to be full again
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, e := range l.buckets {
+		if now.Sub(e.bucket.Last) > e.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
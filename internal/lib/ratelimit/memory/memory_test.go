@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/lib/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	limiter := New()
+	limiter.now = func() time.Time { return now }
+
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+
+	for i := 0; i < limit.Burst; i++ {
+		allowed, err := limiter.Allow(ctx, "key", limit)
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, err := limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.False(t, allowed, "bucket must be empty after burst")
+
+	allowed, err = limiter.Allow(ctx, "other", limit)
+	require.NoError(t, err)
+	assert.True(t, allowed, "buckets must be independent")
+
+	now = now.Add(time.Second)
+
+	allowed, err = limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.True(t, allowed, "bucket must be refilled")
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	limiter := New()
+	limiter.now = func() time.Time { return now }
+
+	_, err := limiter.Allow(ctx, "key", ratelimit.Limit{Rate: 1, Burst: 1})
+	require.NoError(t, err)
+	require.Len(t, limiter.buckets, 1)
+
+	now = now.Add(sweepInterval + time.Second)
+
+	_, err = limiter.Allow(ctx, "other", ratelimit.Limit{Rate: 1, Burst: 1})
+	require.NoError(t, err)
+	assert.Len(t, limiter.buckets, 1)
+	assert.Contains(t, limiter.buckets, "other")
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket: Rate tokens per second are added
+// to the bucket up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the limit should be enforced
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Limiter takes a token from the bucket identified by key
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (allowed bool, err error)
+}
+
+// Bucket is the state of a single token bucket
+type Bucket struct {
+	Tokens float64
+	Last   time.Time
+}
+
+// Take refills the bucket up to now and takes one token if there is one.
+// A zero bucket is treated as full.
+func (b *Bucket) Take(now time.Time, limit Limit) bool {
+	if b.Last.IsZero() {
+		b.Tokens = float64(limit.Burst)
+		b.Last = now
+	}
+
+	if elapsed := now.Sub(b.Last).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(float64(limit.Burst), b.Tokens+elapsed*limit.Rate)
+		b.Last = now
+	}
+
+	if b.Tokens < 1 {
+		return false
+	}
+
+	b.Tokens--
+
+	return true
+}
+
+// RefillTime returns the time needed to refill an empty bucket
+func (l Limit) RefillTime() time.Duration {
+	if !l.Enabled() {
+		return 0
+	}
+
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
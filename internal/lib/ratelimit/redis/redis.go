@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"grpc-sso/internal/lib/ratelimit"
+	"time"
+)
+
+// tokenBucket refills and takes a token atomically on the Redis side.
+// KEYS[1] - bucket key
+// ARGV[1] - rate (tokens per second), ARGV[2] - burst, ARGV[3] - now (ms)
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) / 1000 * rate)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+
+return allowed
+`)
+
+// Limiter keeps token buckets in Redis, so the limits are shared
+// by every node of the cluster.
+type Limiter struct {
+	client redis.Scripter
+	prefix string
+	now    func() time.Time
+}
+
+// New creates a new Redis limiter. Keys are stored with the given prefix.
+func New(client redis.Scripter, prefix string) *Limiter {
+	return &Limiter{
+		client: client,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+// Allow takes a token from the bucket identified by key
+func (l *Limiter) Allow(ctx context.Context, key string, limit ratelimit.Limit) (bool, error) {
+	const op = "ratelimit.redis.Allow"
+
+	allowed, err := tokenBucket.Run(ctx, l.client,
+		[]string{l.prefix + key},
+		limit.Rate, limit.Burst, l.now().UnixMilli(),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return allowed == 1, nil
+}
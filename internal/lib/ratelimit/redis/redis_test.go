@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/lib/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	limiter := New(client, "test:")
+	limiter.now = func() time.Time { return now }
+
+	limit := ratelimit.Limit{Rate: 2, Burst: 3}
+
+	for i := 0; i < limit.Burst; i++ {
+		allowed, err := limiter.Allow(ctx, "key", limit)
+		require.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, err := limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.False(t, allowed, "bucket must be empty after burst")
+
+	assert.True(t, server.Exists("test:key"))
+	assert.Greater(t, server.TTL("test:key"), time.Duration(0))
+
+	now = now.Add(500 * time.Millisecond)
+
+	allowed, err = limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.True(t, allowed, "bucket must be refilled")
+
+	allowed, err = limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+func TestLimiter_SharedState(t *testing.T) {
+	ctx := context.Background()
+
+	server := miniredis.RunT(t)
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+
+	first := New(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test:")
+	second := New(redis.NewClient(&redis.Options{Addr: server.Addr()}), "test:")
+
+	allowed, err := first.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	allowed, err = second.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.False(t, allowed, "nodes must share the bucket")
+}
+
+func TestLimiter_Unavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	server.Close()
+
+	_, err := New(client, "test:").Allow(context.Background(), "key", ratelimit.Limit{Rate: 1, Burst: 1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,28 @@
+package requestid
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+// MetadataKey is the gRPC metadata key carrying the request ID
+const MetadataKey = "x-request-id"
+
+type ctxKey struct{}
+
+// New generates a new request ID
+func New() string {
+	return uuid.NewString()
+}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx or empty string
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+
+	return id
+}
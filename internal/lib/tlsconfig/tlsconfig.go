@@ -0,0 +1,278 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Client authentication modes
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+var ErrNoClientCA = errors.New("client CA file is required for client authentication")
+
+// Options describe the server side TLS setup
+type Options struct {
+	CertFile string
+	KeyFile  string
+	// MinVersion is one of "1.0", "1.1", "1.2" or "1.3"
+	MinVersion string
+	// CipherSuites are IANA names of the cipher suites.
+	// Empty means Go defaults. Ignored for TLS 1.3.
+	CipherSuites []string
+	// ClientCAFile is the CA bundle used to verify client certificates
+	ClientCAFile string
+	// ClientAuth is one of ClientAuthNone, ClientAuthRequest or ClientAuthRequire
+	ClientAuth string
+}
+
+// Reloader serves the certificate and the client CA pool from files
+// and reloads them when the files change, so rotation does not need a restart
+type Reloader struct {
+	log  *slog.Logger
+	opts Options
+	base *tls.Config
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// New loads the certificate files and starts watching them for changes
+func New(log *slog.Logger, opts Options) (*Reloader, error) {
+	const op = "tlsconfig.New"
+
+	base, err := baseConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	r := &Reloader{
+		log:  log,
+		opts: opts,
+		base: base,
+		done: make(chan struct{}),
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := r.watch(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return r, nil
+}
+
+// Config returns the server TLS config that always uses the latest loaded files
+func (r *Reloader) Config() *tls.Config {
+	cfg := r.base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		current := r.base.Clone()
+		current.Certificates = []tls.Certificate{*r.cert}
+		current.ClientCAs = r.clientCAs
+
+		return current, nil
+	}
+
+	return cfg
+}
+
+// Close stops watching the files
+func (r *Reloader) Close() error {
+	close(r.done)
+
+	return r.watcher.Close()
+}
+
+// reload reads the files and swaps the certificate and the client CA pool
+func (r *Reloader) reload() error {
+	const op = "tlsconfig.reload"
+
+	cert, err := tls.LoadX509KeyPair(r.opts.CertFile, r.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates in %s", op, r.opts.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.mu.Unlock()
+
+	return nil
+}
+
+// watch watches the directories of the files, because certificates are usually
+// rotated by renaming or re-linking rather than writing in place
+func (r *Reloader) watch() error {
+	const op = "tlsconfig.watch"
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	r.watcher = watcher
+
+	files := make(map[string]bool)
+	for _, file := range []string{r.opts.CertFile, r.opts.KeyFile, r.opts.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+
+		files[filepath.Clean(file)] = true
+
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			_ = watcher.Close()
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-r.done:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if !files[filepath.Clean(event.Name)] && !isSymlinkSwap(event) {
+					continue
+				}
+
+				if err := r.reload(); err != nil {
+					// Files may be half written, keep the previous certificate
+					r.log.Warn("failed to reload TLS certificates", slog.String("error", err.Error()))
+
+					continue
+				}
+
+				r.log.Info("TLS certificates reloaded", slog.String("file", event.Name))
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				r.log.Error("TLS certificates watcher failed", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// isSymlinkSwap reports the "..data" link swap used by Kubernetes secret volumes
+func isSymlinkSwap(event fsnotify.Event) bool {
+	return filepath.Base(event.Name) == "..data" && event.Has(fsnotify.Create)
+}
+
+func baseConfig(opts Options) (*tls.Config, error) {
+	minVersion, err := parseVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherSuites, err := parseCipherSuites(opts.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := parseClientAuth(opts.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	if clientAuth != tls.NoClientCert && opts.ClientCAFile == "" {
+		return nil, ErrNoClientCA
+	}
+
+	return &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		ClientAuth:   clientAuth,
+		NextProtos:   []string{"h2"},
+	}, nil
+}
+
+func parseVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2", "":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q", version)
+	}
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case ClientAuthNone, "":
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unknown client auth mode %q", mode)
+	}
+}
@@ -0,0 +1,237 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, commonName string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeCert(t *testing.T, dir string, cert *testCert) (certFile, keyFile string) {
+	t.Helper()
+
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+
+	// Write and rename, like cert managers do
+	for file, data := range map[string][]byte{certFile: cert.certPEM, keyFile: cert.keyPEM} {
+		tmp := file + ".tmp"
+		require.NoError(t, os.WriteFile(tmp, data, 0o600))
+		require.NoError(t, os.Rename(tmp, file))
+	}
+
+	return certFile, keyFile
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestReloader_HotReload(t *testing.T) {
+	dir := t.TempDir()
+
+	first := newTestCert(t, "first", nil, false)
+	certFile, keyFile := writeCert(t, dir, first)
+
+	reloader, err := New(discardLogger(), Options{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = reloader.Close() })
+
+	cfg := reloader.Config()
+	assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+
+	assert.Equal(t, "first", servedCommonName(t, cfg))
+
+	second := newTestCert(t, "second", nil, false)
+	writeCert(t, dir, second)
+
+	assert.Eventually(t, func() bool {
+		return servedCommonName(t, cfg) == "second"
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestReloader_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "server", ca, false)
+	client := newTestCert(t, "client", ca, false)
+	stranger := newTestCert(t, "stranger", nil, false)
+
+	certFile, keyFile := writeCert(t, dir, server)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.certPEM, 0o600))
+
+	reloader, err := New(discardLogger(), Options{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		ClientAuth:   ClientAuthRequire,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = reloader.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := []struct {
+		name    string
+		client  *testCert
+		wantErr bool
+	}{
+		{name: "Client signed by the CA", client: client},
+		{name: "Client without certificate", wantErr: true},
+		{name: "Client signed by unknown CA", client: stranger, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientCfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if test.client != nil {
+				pair, err := tls.X509KeyPair(test.client.certPEM, test.client.keyPEM)
+				require.NoError(t, err)
+				clientCfg.Certificates = []tls.Certificate{pair}
+			}
+
+			err := handshake(t, reloader.Config(), clientCfg)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, newTestCert(t, "server", nil, false))
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "Unknown version", opts: Options{CertFile: certFile, KeyFile: keyFile, MinVersion: "2.0"}},
+		{name: "Unknown cipher suite", opts: Options{CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"NOPE"}}},
+		{name: "Client auth without CA", opts: Options{CertFile: certFile, KeyFile: keyFile, ClientAuth: ClientAuthRequire}},
+		{name: "Missing key", opts: Options{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(discardLogger(), test.opts)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// servedCommonName returns the common name of the certificate the server would present
+func servedCommonName(t *testing.T, cfg *tls.Config) string {
+	t.Helper()
+
+	current, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(current.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+
+	return leaf.Subject.CommonName
+}
+
+// handshake runs a TLS handshake between the configs over an in-memory connection
+func handshake(t *testing.T, serverCfg, clientCfg *tls.Config) error {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+	})
+
+	serverErr := make(chan error, 1)
+	go func() {
+		server := tls.Server(serverConn, serverCfg)
+		serverErr <- server.Handshake()
+		_ = server.Close()
+	}()
+
+	client := tls.Client(clientConn, clientCfg)
+	clientErr := client.Handshake()
+	if clientErr == nil {
+		// TLS 1.3 reports client certificate errors on the first read
+		_, clientErr = client.Read(make([]byte, 1))
+		if clientErr == io.EOF {
+			clientErr = nil
+		}
+	}
+
+	if err := <-serverErr; err != nil {
+		return err
+	}
+
+	return clientErr
+}
@@ -0,0 +1,88 @@
+// Package webhook signs the webhook requests and defines their payload.
+// Receivers verify the requests with Verify
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of the webhook requests
+const (
+	HeaderEvent     = "X-Sso-Event"
+	HeaderEventID   = "X-Sso-Event-Id"
+	HeaderTimestamp = "X-Sso-Timestamp"
+	HeaderSignature = "X-Sso-Signature"
+)
+
+const signaturePrefix = "sha256="
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrTimestampExpired = errors.New("timestamp is too old")
+)
+
+// Payload is the JSON body of the webhook requests
+type Payload struct {
+	// ID is the same in the copies of the event sent to every app, and in retries
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      UserData  `json:"data"`
+}
+
+// UserData is the user the event is about
+type UserData struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
+	// PreviousEmail is set in user.email_changed events
+	PreviousEmail string `json:"previous_email,omitempty"`
+	// SessionID is set in user.token_revoked events
+	SessionID string `json:"session_id,omitempty"`
+	// AccessTokenID is set in user.token_revoked events of personal access tokens
+	AccessTokenID int64 `json:"access_token_id,omitempty"`
+}
+
+// Sign returns the signature header of the body sent at the time:
+// the hex HMAC-SHA256 of "<unix timestamp>.<body>" keyed with the app webhook secret
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(secret, strconv.FormatInt(timestamp.Unix(), 10), body))
+}
+
+// Verify checks the signature and timestamp headers of the request body.
+// Requests signed more than tolerance before now are rejected to prevent replays
+func Verify(secret, timestamp, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal(got, mac(secret, timestamp, body)) {
+		return ErrInvalidSignature
+	}
+
+	if tolerance > 0 && now.Sub(time.Unix(unix, 0)) > tolerance {
+		return ErrTimestampExpired
+	}
+
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"ev-1","type":"user.registered"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	signature := Sign("secret", now, body)
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, signature)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		signature string
+		body      []byte
+		now       time.Time
+		wantErr   error
+	}{
+		{name: "valid", secret: "secret", timestamp: timestamp, signature: signature, body: body, now: now},
+		{name: "within tolerance", secret: "secret", timestamp: timestamp, signature: signature, body: body,
+			now: now.Add(4 * time.Minute)},
+		{name: "other secret", secret: "other", timestamp: timestamp, signature: signature, body: body, now: now,
+			wantErr: ErrInvalidSignature},
+		{name: "changed body", secret: "secret", timestamp: timestamp, signature: signature, body: []byte(`{}`), now: now,
+			wantErr: ErrInvalidSignature},
+		{name: "changed timestamp", secret: "secret", timestamp: strconv.FormatInt(now.Unix()+1, 10),
+			signature: signature, body: body, now: now, wantErr: ErrInvalidSignature},
+		{name: "malformed timestamp", secret: "secret", timestamp: "yesterday", signature: signature, body: body,
+			now: now, wantErr: ErrInvalidSignature},
+		{name: "without prefix", secret: "secret", timestamp: timestamp, signature: signature[len("sha256="):],
+			body: body, now: now, wantErr: ErrInvalidSignature},
+		{name: "expired", secret: "secret", timestamp: timestamp, signature: signature, body: body,
+			now: now.Add(6 * time.Minute), wantErr: ErrTimestampExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.timestamp, tt.signature, tt.body, tt.now, 5*time.Minute)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
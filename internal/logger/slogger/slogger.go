@@ -1,6 +1,7 @@
 package slogger
 
 import (
+	"context"
 	"log/slog"
 	"os"
 )
@@ -35,3 +36,19 @@ func SetupLogger(env string) *slog.Logger {
 
 	return logger
 }
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger
+func NewContext(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, log)
+}
+
+// FromContext returns the logger carried by ctx or fallback if there is none
+func FromContext(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if log, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return log
+	}
+
+	return fallback
+}
@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/accesstoken"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrAccessTokenNotFound = errors.New("access token not found")
+	ErrInvalidExpiry       = errors.New("expiry must be in the future")
+	// ErrAccessTokensDisabled is returned by the access token APIs if the service has no access tokens storage
+	ErrAccessTokensDisabled = errors.New("access tokens are not enabled")
+)
+
+// AccessTokens keeps the hashes of the personal access tokens
+type AccessTokens interface {
+	SaveAccessToken(ctx context.Context, token models.AccessToken) (tokenID int64, err error)
+	AccessTokenByHash(ctx context.Context, hash string) (models.AccessToken, error)
+	UserAccessTokens(ctx context.Context, userID int64) ([]models.AccessToken, error)
+	TouchAccessToken(ctx context.Context, tokenID int64, lastUsedAt time.Time) error
+	RevokeAccessToken(ctx context.Context, userID int64, tokenID int64, revokedAt time.Time) error
+}
+
+// WithAccessTokens enables the personal access tokens: users create them,
+// and VerifyToken accepts them like the tokens issued by Login
+func WithAccessTokens(tokens AccessTokens) Option {
+	return func(a *Auth) {
+		a.accessTokens = tokens
+	}
+}
+
+// CreateAccessToken creates a personal access token of the caller for the app, the app of the caller's token
+// if appID is zero. The scopes must be declared by the app and granted to it by the caller at a login,
+// and the token never expires if expiresAt is zero.
+// The token is returned once, only its hash is kept. Access tokens can't create other ones
+func (a *Auth) CreateAccessToken(
+	ctx context.Context,
+	name string,
+	appID int,
+	scopes []string,
+	expiresAt time.Time,
+) (token string, created models.AccessToken, err error) {
+	const op = "auth.CreateAccessToken"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	c, err := a.requireAccessTokens(ctx)
+	if err != nil {
+		return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if c.AccessTokenID != 0 {
+		log.Warn("access token used to create another one")
+
+		return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	now := time.Now()
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, ErrInvalidExpiry)
+	}
+
+	if appID == models.EmptyAppID {
+		appID = c.AppID
+	}
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", slog.String("error", err.Error()))
+
+		return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if app.TenantID != c.TenantID {
+		return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+	}
+
+	scopes, err = a.checkScopes(app, scopes)
+	if err != nil {
+		return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The token can't outlive the consent, see RevokeConsent
+	if len(scopes) > 0 {
+		user := models.User{ID: c.UserID, TenantID: c.TenantID, Email: c.Email}
+		if err := a.grantScopes(ctx, user, app, scopes, false); err != nil {
+			if !errors.Is(err, ErrConsentRequired) {
+				log.Error("failed to check consent", slog.String("error", err.Error()))
+			}
+
+			return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	token, err = accesstoken.Generate()
+	if err != nil {
+		log.Error("failed to generate access token", slog.String("error", err.Error()))
+
+		return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	created = models.AccessToken{
+		UserID:    c.UserID,
+		AppID:     app.ID,
+		Name:      name,
+		Hint:      accesstoken.Hint(token),
+		Hash:      accesstoken.Hash(token),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+
+	created.ID, err = a.accessTokens.SaveAccessToken(ctx, created)
+	if err != nil {
+		log.Error("failed to save access token", slog.String("error", err.Error()))
+
+		return "", models.AccessToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("Access token created", slog.Int64("tokenID", created.ID))
+
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditAccessTokenCreate,
+		TenantID: c.TenantID,
+		ActorID:  c.UserID,
+		UserID:   c.UserID,
+		Email:    c.Email,
+		AppID:    app.ID,
+		Reason:   created.Hint,
+	})
+
+	return token, created, nil
+}
+
+// ListAccessTokens returns the personal access tokens of the caller not revoked yet, the newest first
+func (a *Auth) ListAccessTokens(ctx context.Context) (tokens []models.AccessToken, err error) {
+	const op = "auth.ListAccessTokens"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	c, err := a.requireAccessTokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err = a.accessTokens.UserAccessTokens(ctx, c.UserID)
+	if err != nil {
+		slogger.FromContext(ctx, a.log).Error("failed to list access tokens",
+			slog.String("op", op),
+			slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// RevokeAccessToken revokes the personal access token of the caller, it is rejected from then on.
+// If the caller has no such token not revoked yet, returns ErrAccessTokenNotFound
+func (a *Auth) RevokeAccessToken(ctx context.Context, tokenID int64) (err error) {
+	const op = "auth.RevokeAccessToken"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op), slog.Int64("tokenID", tokenID))
+
+	c, err := a.requireAccessTokens(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var revoked []models.AccessToken
+
+	err = a.withTx(ctx, func(ctx context.Context) (err error) {
+		revoked, err = a.revokeAccessTokens(ctx, c.UserID, time.Now(), func(token models.AccessToken) bool {
+			return token.ID == tokenID
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(revoked) == 0 {
+			return storage.ErrAccessTokenNotFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrAccessTokenNotFound) {
+			log.Warn("Access token not found", slog.String("error", err.Error()))
+
+			return fmt.Errorf("%s: %w", op, ErrAccessTokenNotFound)
+		}
+
+		log.Error("failed to revoke access token", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("Access token revoked")
+
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditAccessTokenRevoke,
+		TenantID: c.TenantID,
+		ActorID:  c.UserID,
+		UserID:   c.UserID,
+		Email:    c.Email,
+		AppID:    revoked[0].AppID,
+		Reason:   revoked[0].Hint,
+	})
+
+	return nil
+}
+
+// revokeAccessTokens revokes the access tokens of the user not revoked yet the match function selects,
+// emits a token revoked event to the app of every revoked token and returns them.
+// Call it in the transaction of the change, see withTx
+func (a *Auth) revokeAccessTokens(
+	ctx context.Context,
+	userID int64,
+	now time.Time,
+	match func(token models.AccessToken) bool,
+) ([]models.AccessToken, error) {
+	if a.accessTokens == nil {
+		return nil, nil
+	}
+
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := a.accessTokens.UserAccessTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []models.AccessToken
+
+	for _, token := range tokens {
+		if !match(token) {
+			continue
+		}
+
+		if err := a.accessTokens.RevokeAccessToken(ctx, userID, token.ID, now); err != nil {
+			return nil, err
+		}
+
+		err := a.emit(ctx, models.EventTokenRevoked, user.TenantID, token.AppID, webhook.UserData{
+			UserID:        user.ID,
+			Email:         user.Email,
+			AccessTokenID: token.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		token.RevokedAt = now
+		revoked = append(revoked, token)
+	}
+
+	return revoked, nil
+}
+
+// requireAccessTokens returns the caller if the access tokens are enabled.
+// An impersonating admin can't manage the tokens of the user
+func (a *Auth) requireAccessTokens(ctx context.Context) (models.Caller, error) {
+	c, err := requireUser(ctx)
+	if err != nil {
+		return models.Caller{}, err
+	}
+
+	if a.accessTokens == nil {
+		return models.Caller{}, ErrAccessTokensDisabled
+	}
+
+	return c, nil
+}
+
+// verifyAccessToken returns the caller of the personal access token. Unknown, revoked and expired
+// tokens and the tokens of disabled users fail with jwt.ErrInvalidToken, like the JWTs do
+func (a *Auth) verifyAccessToken(ctx context.Context, token string) (models.Caller, time.Time, error) {
+	if a.accessTokens == nil || !accesstoken.Valid(token) {
+		return models.Caller{}, time.Time{}, fmt.Errorf("%w: malformed access token", jwt.ErrInvalidToken)
+	}
+
+	pat, err := a.accessTokens.AccessTokenByHash(ctx, accesstoken.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrAccessTokenNotFound) {
+			return models.Caller{}, time.Time{}, fmt.Errorf("%w: unknown access token", jwt.ErrInvalidToken)
+		}
+
+		return models.Caller{}, time.Time{}, err
+	}
+
+	now := time.Now()
+	if !pat.Active(now) {
+		return models.Caller{}, time.Time{}, fmt.Errorf("%w: access token is revoked or expired", jwt.ErrInvalidToken)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, pat.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Caller{}, time.Time{}, fmt.Errorf("%w: unknown user", jwt.ErrInvalidToken)
+		}
+
+		return models.Caller{}, time.Time{}, err
+	}
+
+	if user.Disabled {
+		return models.Caller{}, time.Time{}, fmt.Errorf("%w: user is disabled", jwt.ErrInvalidToken)
+	}
+
+	c := models.Caller{
+		UserID:        user.ID,
+		TenantID:      user.TenantID,
+		Email:         user.Email,
+		AppID:         pat.AppID,
+		Scopes:        pat.Scopes,
+		AccessTokenID: pat.ID,
+	}
+
+	if a.permissions != nil {
+		perms, err := a.permissions.UserPermissions(ctx, user.ID, pat.AppID)
+		if err != nil {
+			return models.Caller{}, time.Time{}, err
+		}
+
+		c.Roles = perms.Roles
+	}
+
+	// Like the sessions, the last use is updated at most once per sessionTouchInterval
+	if now.Sub(pat.LastUsedAt) >= sessionTouchInterval {
+		if err := a.accessTokens.TouchAccessToken(ctx, pat.ID, now); err != nil {
+			slogger.FromContext(ctx, a.log).Error("failed to touch access token",
+				slog.Int64("tokenID", pat.ID),
+				slog.String("error", err.Error()))
+		}
+	}
+
+	return c, pat.ExpiresAt, nil
+}
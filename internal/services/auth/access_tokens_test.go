@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/accesstoken"
+	"grpc-sso/internal/lib/caller"
+	"testing"
+	"time"
+)
+
+func TestAccessTokens_Verify(t *testing.T) {
+	a, st := newTestAuth(t)
+	st.AddApp(scopedApp)
+	register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+	userCtx := caller.NewContext(ctx, user)
+
+	groupID, err := st.SaveGroup(ctx, models.DefaultTenantID, "ci")
+	require.NoError(t, err)
+	require.NoError(t, st.AddGroupUser(ctx, groupID, user.UserID))
+	require.NoError(t, st.GrantGroupRole(ctx, groupID, models.GroupRole{AppID: 2, Role: "deployer"}))
+
+	token, created, err := a.CreateAccessToken(userCtx, "ci", 2, nil, time.Time{})
+	require.NoError(t, err)
+	assert.True(t, accesstoken.Valid(token))
+	assert.Equal(t, accesstoken.Hint(token), created.Hint)
+	assert.True(t, created.ExpiresAt.IsZero())
+
+	// Only the hash is stored
+	stored, err := st.AccessTokenByHash(ctx, accesstoken.Hash(token))
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, stored.ID)
+	assert.True(t, stored.LastUsedAt.IsZero())
+
+	c, err := a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, models.Caller{
+		UserID:        user.UserID,
+		TenantID:      models.DefaultTenantID,
+		Email:         "user@example.com",
+		AppID:         2,
+		Roles:         []string{"deployer"},
+		AccessTokenID: created.ID,
+	}, c)
+
+	stored, err = st.AccessTokenByHash(ctx, accesstoken.Hash(token))
+	require.NoError(t, err)
+	assert.False(t, stored.LastUsedAt.IsZero())
+
+	// The scopes go into the caller like the scope claim of a login token
+	_, err = a.Login(ctx, "user@example.com", "password", 1, WithScopes("email", "profile"), WithConsentGrant())
+	require.NoError(t, err)
+
+	scoped, _, err := a.CreateAccessToken(userCtx, "scoped", 0, []string{"profile", "email"},
+		time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	c, err = a.VerifyToken(ctx, scoped)
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.AppID)
+	assert.Equal(t, []string{"email", "profile"}, c.Scopes)
+
+	tokens, err := a.ListAccessTokens(userCtx)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, "scoped", tokens[0].Name)
+
+	require.NoError(t, a.RevokeAccessToken(userCtx, created.ID))
+	assert.ErrorIs(t, a.RevokeAccessToken(userCtx, created.ID), ErrAccessTokenNotFound)
+
+	_, err = a.VerifyToken(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// The app of the token learns about the revocation
+	revoked := payloads(t, st, models.EventTokenRevoked)
+	require.Len(t, revoked, 1)
+	assert.Equal(t, created.ID, revoked[0].Data.AccessTokenID)
+	assert.Empty(t, revoked[0].Data.SessionID)
+
+	// Disabling the user disables the tokens
+	require.NoError(t, st.DisableUser(ctx, user.UserID))
+
+	_, err = a.VerifyToken(ctx, scoped)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{
+		Types: []string{models.AuditAccessTokenCreate, models.AuditAccessTokenRevoke},
+	})
+	require.NoError(t, err)
+	require.Len(t, audit, 3)
+	assert.Equal(t, models.AuditAccessTokenRevoke, audit[0].Type)
+	assert.Equal(t, created.Hint, audit[2].Reason)
+}
+
+func TestAccessTokens_FailCases(t *testing.T) {
+	a, st := newTestAuth(t)
+	st.AddApp(scopedApp)
+	register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+	userCtx := caller.NewContext(ctx, user)
+
+	token, created, err := a.CreateAccessToken(userCtx, "ci", 0, nil, time.Time{})
+	require.NoError(t, err)
+
+	patCtx := caller.NewContext(ctx, models.Caller{UserID: created.UserID, AppID: 1, AccessTokenID: created.ID})
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{
+			name: "unauthenticated",
+			call: func() error {
+				_, _, err := a.CreateAccessToken(ctx, "ci", 0, nil, time.Time{})
+				return err
+			},
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name: "created with an access token",
+			call: func() error {
+				_, _, err := a.CreateAccessToken(patCtx, "more", 0, nil, time.Time{})
+				return err
+			},
+			wantErr: ErrPermissionDenied,
+		},
+		{
+			name: "expired",
+			call: func() error {
+				_, _, err := a.CreateAccessToken(userCtx, "ci", 0, nil, time.Now().Add(-time.Minute))
+				return err
+			},
+			wantErr: ErrInvalidExpiry,
+		},
+		{
+			name: "undeclared scope",
+			call: func() error {
+				_, _, err := a.CreateAccessToken(userCtx, "ci", 1, []string{"admin"}, time.Time{})
+				return err
+			},
+			wantErr: ErrInvalidScope,
+		},
+		{
+			name: "scope not consented",
+			call: func() error {
+				_, _, err := a.CreateAccessToken(userCtx, "ci", 1, []string{"email"}, time.Time{})
+				return err
+			},
+			wantErr: ErrConsentRequired,
+		},
+		{
+			name: "app of another tenant",
+			call: func() error {
+				_, _, err := a.CreateAccessToken(userCtx, "ci", 4, nil, time.Time{})
+				return err
+			},
+			wantErr: ErrInvalidAppID,
+		},
+		{
+			name: "unknown token",
+			call: func() error {
+				return a.RevokeAccessToken(userCtx, 404)
+			},
+			wantErr: ErrAccessTokenNotFound,
+		},
+		{
+			name: "malformed token",
+			call: func() error {
+				_, err := a.VerifyToken(ctx, token[:len(token)-1]+"x")
+				return err
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "unknown well-formed token",
+			call: func() error {
+				other, err := accesstoken.Generate()
+				require.NoError(t, err)
+
+				_, err = a.VerifyToken(ctx, other)
+				return err
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.call(), tt.wantErr)
+		})
+	}
+}
+
+func TestAccessTokens_Disabled(t *testing.T) {
+	a, _ := newTestAuth(t, WithAccessTokens(nil))
+	register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	c, _ := login(t, a, ctx, "user@example.com", 1)
+
+	_, _, err := a.CreateAccessToken(caller.NewContext(ctx, c), "ci", 0, nil, time.Time{})
+	assert.ErrorIs(t, err, ErrAccessTokensDisabled)
+
+	token, err := accesstoken.Generate()
+	require.NoError(t, err)
+
+	_, err = a.VerifyToken(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
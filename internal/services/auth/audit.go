@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/requestid"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/logger/slogger"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Audit events page sizes
+const (
+	DefaultAuditPageSize = 50
+	MaxAuditPageSize     = 500
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+type AuditLog interface {
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+	AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+}
+
+// WithAuditLog sets the storage of the audit log, without it no events are recorded
+func WithAuditLog(auditLog AuditLog) Option {
+	return func(a *Auth) {
+		a.auditLog = auditLog
+	}
+}
+
+// nopAuditLog is used when the audit log is not configured
+type nopAuditLog struct{}
+
+func (nopAuditLog) SaveAuditEvent(context.Context, models.AuditEvent) error { return nil }
+
+func (nopAuditLog) AuditEvents(context.Context, models.AuditFilter) ([]models.AuditEvent, error) {
+	return nil, nil
+}
+
+// audit records the event with the details of the call.
+// A failure is logged and does not fail the call
+func (a *Auth) audit(ctx context.Context, event models.AuditEvent) {
+	if err := a.saveAudit(ctx, event); err != nil {
+		slogger.FromContext(ctx, a.log).Error("failed to save audit event",
+			slog.String("type", event.Type),
+			slog.String("error", err.Error()))
+	}
+}
+
+// saveAudit records the event with the details of the call. The admin impersonating
+// the caller is the actor of the events of the caller
+func (a *Auth) saveAudit(ctx context.Context, event models.AuditEvent) error {
+	if c, ok := caller.FromContext(ctx); ok {
+		if event.ActorID == 0 {
+			event.ActorID = c.UserID
+		}
+		if c.Actor != nil && event.ActorID == c.UserID {
+			event.ActorID = c.Actor.UserID
+		}
+		if event.TenantID == 0 {
+			event.TenantID = c.TenantID
+		}
+	}
+	if event.TenantID == 0 {
+		event.TenantID = models.DefaultTenantID
+	}
+
+	event.IP = clientinfo.IP(ctx)
+	event.UserAgent = clientinfo.UserAgent(ctx)
+	event.RequestID = requestid.FromContext(ctx)
+	event.CreatedAt = time.Now()
+
+	return a.auditLog.SaveAuditEvent(ctx, event)
+}
+
+// ListAuditEvents returns a page of the audit events matching the filter, the newest first,
+// and the token of the next page, empty on the last page. Only admins can list the events of their tenant
+func (a *Auth) ListAuditEvents(
+	ctx context.Context,
+	filter models.AuditFilter,
+	pageSize int,
+	pageToken string,
+) (events []models.AuditEvent, nextPageToken string, err error) {
+	const op = "auth.ListAuditEvents"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to list audit events", slog.String("error", err.Error()))
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Admins see the events of their tenant only
+	filter.TenantID = admin.TenantID
+
+	if pageToken != "" {
+		filter.BeforeID, err = decodePageToken(pageToken)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	pageSize = clampPageSize(pageSize)
+
+	// One more event tells if there is a next page
+	filter.Limit = pageSize + 1
+
+	events, err = a.auditLog.AuditEvents(ctx, filter)
+	if err != nil {
+		log.Error("failed to list audit events", slog.String("error", err.Error()))
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		nextPageToken = encodePageToken(events[pageSize-1].ID)
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditAdminAction,
+		ActorID: admin.UserID,
+		AppID:   admin.AppID,
+		Reason:  "list_audit_events",
+	})
+
+	return events, nextPageToken, nil
+}
+
+// clampPageSize applies the default and the maximum to the requested page size
+func clampPageSize(pageSize int) int {
+	switch {
+	case pageSize <= 0:
+		return DefaultAuditPageSize
+	case pageSize > MaxAuditPageSize:
+		return MaxAuditPageSize
+	default:
+		return pageSize
+	}
+}
+
+func encodePageToken(lastID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(lastID, 10)))
+}
+
+func decodePageToken(token string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, ErrInvalidPageToken
+	}
+
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidPageToken
+	}
+
+	return id, nil
+}
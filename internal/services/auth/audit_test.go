@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/requestid"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAudit_RegisterAndLogin(t *testing.T) {
+	a, st := newTestAuth(t)
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000},
+	})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("user-agent", "test-agent"))
+	ctx = requestid.NewContext(ctx, "req-1")
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	_, err = a.Login(ctx, "user@example.com", "password", 1)
+	require.NoError(t, err)
+
+	_, err = a.Login(ctx, "user@example.com", "wrong", 1)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = a.Login(ctx, "nobody@example.com", "password", 1)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	events, err := st.AuditEvents(context.Background(), models.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+
+	// The newest first
+	failedUnknown, failedPassword, login, register := events[0], events[1], events[2], events[3]
+
+	assert.Equal(t, models.AuditRegister, register.Type)
+	assert.Equal(t, userID, register.UserID)
+	assert.Equal(t, userID, register.ActorID)
+
+	assert.Equal(t, models.AuditLogin, login.Type)
+	assert.Equal(t, userID, login.UserID)
+	assert.Equal(t, 1, login.AppID)
+
+	assert.Equal(t, models.AuditLoginFailed, failedPassword.Type)
+	assert.Equal(t, userID, failedPassword.UserID)
+	assert.Equal(t, models.AuditReasonWrongPassword, failedPassword.Reason)
+
+	assert.Equal(t, models.AuditLoginFailed, failedUnknown.Type)
+	assert.Equal(t, "nobody@example.com", failedUnknown.Email)
+	assert.Equal(t, models.AuditReasonUserNotFound, failedUnknown.Reason)
+
+	for _, e := range events {
+		assert.Equal(t, "10.0.0.1", e.IP)
+		assert.Equal(t, "req-1", e.RequestID)
+		assert.False(t, e.CreatedAt.IsZero())
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	adminID, err := a.RegisterNewUser(ctx, "admin@example.com", "password", 0)
+	require.NoError(t, err)
+	require.NoError(t, st.SetAdmin(adminID, true))
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	var token string
+
+	for range 4 {
+		token, err = a.Login(ctx, "user@example.com", "password", 1)
+		require.NoError(t, err)
+	}
+
+	user, err := a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+
+	admin, _ := login(t, a, ctx, "admin@example.com", 1)
+
+	t.Run("anonymous", func(t *testing.T) {
+		_, _, err := a.ListAuditEvents(ctx, models.AuditFilter{}, 0, "")
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("not an admin", func(t *testing.T) {
+		ctx := caller.NewContext(ctx, user)
+
+		_, _, err := a.ListAuditEvents(ctx, models.AuditFilter{}, 0, "")
+		assert.ErrorIs(t, err, ErrPermissionDenied)
+	})
+
+	t.Run("invalid page token", func(t *testing.T) {
+		ctx := caller.NewContext(ctx, admin)
+
+		_, _, err := a.ListAuditEvents(ctx, models.AuditFilter{}, 0, "not a token")
+		assert.ErrorIs(t, err, ErrInvalidPageToken)
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		ctx := caller.NewContext(ctx, admin)
+		filter := models.AuditFilter{UserID: userID, Types: []string{models.AuditLogin}}
+
+		first, next, err := a.ListAuditEvents(ctx, filter, 3, "")
+		require.NoError(t, err)
+		require.Len(t, first, 3)
+		require.NotEmpty(t, next)
+
+		second, next, err := a.ListAuditEvents(ctx, filter, 3, next)
+		require.NoError(t, err)
+		require.Len(t, second, 1)
+		assert.Empty(t, next)
+
+		assert.Greater(t, first[2].ID, second[0].ID)
+	})
+
+	t.Run("listing is audited", func(t *testing.T) {
+		events, err := st.AuditEvents(ctx, models.AuditFilter{
+			ActorID: adminID,
+			Types:   []string{models.AuditAdminAction},
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, events)
+		assert.Equal(t, "list_audit_events", events[0].Reason)
+	})
+}
+
+func TestListAuditEvents_AdminToken(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	adminID := register(t, a, "admin@example.com", 1)
+	require.NoError(t, st.SetAdmin(adminID, true))
+
+	admin, _ := login(t, a, ctx, "admin@example.com", 1)
+
+	// Anyone with the secret of the app signs a token without a session
+	forged, err := jwt.NewToken(models.Caller{UserID: adminID, Email: admin.Email, AppID: 1}, "secret", time.Hour)
+	require.NoError(t, err)
+
+	c, err := a.VerifyToken(ctx, forged)
+	require.NoError(t, err)
+
+	_, _, err = a.ListAuditEvents(caller.NewContext(ctx, c), models.AuditFilter{}, 0, "")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	// The tokens of a session and the personal access tokens are accepted
+	_, _, err = a.ListAuditEvents(caller.NewContext(ctx, admin), models.AuditFilter{}, 0, "")
+	require.NoError(t, err)
+
+	accessToken, _, err := a.CreateAccessToken(caller.NewContext(ctx, admin), "ci", 0, nil, time.Time{})
+	require.NoError(t, err)
+
+	c, err = a.VerifyToken(ctx, accessToken)
+	require.NoError(t, err)
+
+	_, _, err = a.ListAuditEvents(caller.NewContext(ctx, c), models.AuditFilter{}, 0, "")
+	require.NoError(t, err)
+}
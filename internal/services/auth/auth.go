@@ -4,11 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel"
 	"golang.org/x/crypto/bcrypt"
 	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/lib/caller"
 	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/logger/slogger"
 	"grpc-sso/internal/storage"
 	"log/slog"
+	"strconv"
 	"time"
 )
 
@@ -18,29 +26,79 @@ type Auth struct {
 	userProvider UserProvider
 	appProvider  AppProvider
 	tokenTTL     time.Duration
+	invalidator  storage.Invalidator
+	auditLog     AuditLog
+	outbox       Outbox
+	eventLog     EventLog
+	// sessions is nil if the sessions are not kept
+	sessions Sessions
+	// groups and permissions are nil if the groups are not enabled
+	groups      Groups
+	permissions PermissionsProvider
+	// consents is nil if the scopes are not enabled
+	consents Consents
+	// accessTokens is nil if the personal access tokens are not enabled
+	accessTokens AccessTokens
+	// identities is nil if the federation is not enabled, providers are the upstream identity providers by name.
+	// ticketKey signs the login tickets, only the SSO holds it
+	identities Identities
+	providers  map[string]FederationProvider
+	ticketKey  string
+	// directories are the LDAP backends Login uses instead of the local passwords, see WithLDAP
+	directories []LDAPBackend
+	// impersonationTTL is how long the tokens issued by Impersonate are valid
+	impersonationTTL time.Duration
+	// watchers are woken up by the committed changes
+	watchers          notifier
+	watchPollInterval time.Duration
 }
 
+type Option func(a *Auth)
+
+// WithInvalidator sets the hook notified when the admin APIs change apps or user roles
+func WithInvalidator(invalidator storage.Invalidator) Option {
+	return func(a *Auth) {
+		a.invalidator = invalidator
+	}
+}
+
+// nopInvalidator is used when nothing is cached
+type nopInvalidator struct{}
+
+func (nopInvalidator) InvalidateUser(int64)   {}
+func (nopInvalidator) InvalidatePermissions() {}
+
 type UserSaver interface {
 	SaveUser(ctx context.Context,
+		tenantID int64,
 		email string,
 		passHash []byte,
 	) (userID int64, err error)
+	UpdateEmail(ctx context.Context, userID int64, email string) error
+	DisableUser(ctx context.Context, userID int64) error
+	UpdatePassword(ctx context.Context, userID int64, passHash []byte) error
 }
 
 type UserProvider interface {
-	User(ctx context.Context, email string) (user models.User, err error)
+	User(ctx context.Context, tenantID int64, email string) (user models.User, err error)
+	UserByID(ctx context.Context, userID int64) (user models.User, err error)
 	IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err error)
 }
 
 type AppProvider interface {
 	App(ctx context.Context, appID int) (app models.App, err error)
+	Tenant(ctx context.Context, tenantID int64) (tenant models.Tenant, err error)
 }
 
+var tracer = otel.Tracer("grpc-sso/internal/services/auth")
+
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrInvalidAppID       = errors.New("invalid app id")
+	ErrInvalidTenant      = errors.New("invalid tenant")
 	ErrUserExists         = errors.New("user already exists")
 	ErrUserNotFound       = errors.New("user not found")
+	ErrUserDisabled       = errors.New("user is disabled")
 )
 
 // New returns a new instance of Auth service
@@ -50,102 +108,316 @@ func New(
 	userProvider UserProvider,
 	appProvider AppProvider,
 	tokenTTL time.Duration,
+	opts ...Option,
 ) *Auth {
-	return &Auth{
+	a := &Auth{
 		log:          log,
 		userSaver:    userSaver,
 		userProvider: userProvider,
 		appProvider:  appProvider,
 		tokenTTL:     tokenTTL,
+		invalidator:  nopInvalidator{},
+		auditLog:     nopAuditLog{},
+		outbox:       nopOutbox{},
+		eventLog:     nopEventLog{},
+
+		impersonationTTL:  DefaultImpersonationTTL,
+		watchPollInterval: DefaultWatchPollInterval,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// LoginOption changes the token issued by Login
+type LoginOption func(o *loginOptions)
+
+type loginOptions struct {
+	groups       bool
+	scopes       []string
+	grantConsent bool
+}
+
+// WithGroupsClaim adds the names of the groups of the user to the token
+func WithGroupsClaim() LoginOption {
+	return func(o *loginOptions) {
+		o.groups = true
 	}
 }
 
 // Login checks is user exists.
 // If user does not exist, returns error.
 // If user exists, but password is incorrect, returns error.
+// The user is looked up in the tenant of the app, the tenant named in the request must be the same.
+// The password of an email served by an LDAP backend is checked in the directory, see WithLDAP
 func (a *Auth) Login(
 	ctx context.Context,
 	email string,
 	password string,
 	appID int,
+	opts ...LoginOption,
 ) (token string, err error) {
 	const op = "auth.Login"
 
-	log := a.log.With(slog.String("op", op))
+	var o loginOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
 
 	log.Info("Try to login user")
 	log.Debug("User", slog.String("email", email))
 
-	user, err := a.userProvider.User(ctx, email)
+	app, err := a.appProvider.App(ctx, appID)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			log.Warn("User not found", slog.String("error", err.Error()))
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Error("App not found",
+				slog.Int("appID", appID),
+				slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInvalidAppID).Inc()
+			a.auditLoginFailed(ctx, models.User{Email: email}, appID, models.AuditReasonInvalidAppID)
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
 		}
 
-		log.Error("filed to get user", slog.String("error", err.Error()))
+		log.Error("filed to get app",
+			slog.Int("appID", appID),
+			slog.String("error", err.Error()))
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+		a.auditLoginFailed(ctx, models.User{Email: email}, appID, models.AuditReasonInternal)
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		log.Info("invalid credentials", slog.String("error", err.Error()))
+	if tenantID, ok := clientinfo.TenantID(ctx); ok && tenantID != app.TenantID {
+		log.Warn("App of another tenant",
+			slog.Int("appID", appID),
+			slog.Int64("tenantID", tenantID))
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonInvalidAppID).Inc()
+		a.auditLoginFailed(ctx, models.User{TenantID: tenantID, Email: email}, appID, models.AuditReasonInvalidAppID)
 
-		return "", fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
 	}
 
-	app, err := a.appProvider.App(ctx, appID)
+	scopes, err := a.checkScopes(app, o.scopes)
 	if err != nil {
-		if errors.Is(err, storage.ErrAppNotFound) {
-			log.Error("App not found",
-				slog.Int("appID", appID),
-				slog.String("error", err.Error()))
+		log.Warn("Invalid scopes", slog.String("error", err.Error()))
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonInvalidScope).Inc()
+		a.auditLoginFailed(ctx, models.User{TenantID: app.TenantID, Email: email}, appID,
+			models.AuditReasonInvalidScope)
 
-			return "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	var user models.User
+	if b, ok := a.directory(app.TenantID, email); ok {
+		user, err = a.directoryLogin(ctx, log, b, app, email, password)
+	} else {
+		user, err = a.passwordLogin(ctx, log, app, email, password)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Checked after the password, so the response doesn't tell disabled users to strangers
+	if user.Disabled {
+		log.Info("user is disabled")
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonUserDisabled).Inc()
+		a.auditLoginFailed(ctx, user, appID, models.AuditReasonUserDisabled)
+
+		return "", fmt.Errorf("%s: %w", op, ErrUserDisabled)
+	}
+
+	if len(scopes) > 0 {
+		if err := a.grantScopes(ctx, user, app, scopes, o.grantConsent); err != nil {
+			if errors.Is(err, ErrConsentRequired) {
+				log.Info("consent required", slog.String("error", err.Error()))
+				metrics.LoginFailures.WithLabelValues(metrics.ReasonNoConsent).Inc()
+				a.auditLoginFailed(ctx, user, appID, models.AuditReasonNoConsent)
+
+				return "", fmt.Errorf("%s: %w", op, err)
+			}
+
+			log.Error("failed to grant scopes", slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+			a.auditLoginFailed(ctx, user, appID, models.AuditReasonInternal)
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	c := models.Caller{UserID: user.ID, TenantID: user.TenantID, Email: user.Email, AppID: app.ID, Scopes: scopes}
+
+	if a.permissions != nil {
+		perms, err := a.permissions.UserPermissions(ctx, user.ID, app.ID)
+		if err != nil {
+			log.Error("failed to get permissions", slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+			a.auditLoginFailed(ctx, user, appID, models.AuditReasonInternal)
+
+			return "", fmt.Errorf("%s: %w", op, err)
 		}
 
-		log.Error("filed to get app",
-			slog.Int("appID", appID),
-			slog.String("error", err.Error()))
+		c.Roles = perms.Roles
+		if o.groups {
+			c.Groups = perms.Groups
+		}
+	}
 
-		return "", fmt.Errorf("%s: %w", op, err)
+	if a.sessions != nil {
+		c.SessionID, err = a.startSession(ctx, user, app)
+		if err != nil {
+			log.Error("failed to save session", slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+			a.auditLoginFailed(ctx, user, appID, models.AuditReasonInternal)
+
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
 	}
 
-	token, err = jwt.NewToken(user, app, a.tokenTTL)
+	token, err = jwt.NewToken(c, app.Secret, a.tokenTTL)
 	if err != nil {
 		log.Error("filed to create token", slog.String("error", err.Error()))
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+		a.auditLoginFailed(ctx, user, appID, models.AuditReasonInternal)
 
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
 	log.Info("User login successful")
+	metrics.Logins.Inc()
+	metrics.TokensIssued.WithLabelValues(strconv.Itoa(app.ID)).Inc()
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditLogin,
+		TenantID: user.TenantID,
+		ActorID:  user.ID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		AppID:    app.ID,
+	})
 
 	return token, nil
 }
 
+// auditLoginFailed records the failed login of the user, the user ID is unknown if the email is not registered
+// and the tenant is unknown if the app is
+func (a *Auth) auditLoginFailed(ctx context.Context, user models.User, appID int, reason string) {
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditLoginFailed,
+		TenantID: user.TenantID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		AppID:    appID,
+		Reason:   reason,
+	})
+}
+
+// passwordLogin authenticates the user of the app with the local password. It audits the failures like Login
+func (a *Auth) passwordLogin(
+	ctx context.Context,
+	log *slog.Logger,
+	app models.App,
+	email string,
+	password string,
+) (models.User, error) {
+	user, err := a.userProvider.User(ctx, app.TenantID, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("User not found", slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInvalidCredentials).Inc()
+			a.auditLoginFailed(ctx, models.User{TenantID: app.TenantID, Email: email}, app.ID,
+				models.AuditReasonUserNotFound)
+
+			return models.User{}, ErrInvalidCredentials
+		}
+
+		log.Error("filed to get user", slog.String("error", err.Error()))
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+		a.auditLoginFailed(ctx, models.User{TenantID: app.TenantID, Email: email}, app.ID, models.AuditReasonInternal)
+
+		return models.User{}, err
+	}
+
+	_, hashSpan := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	observeHash := metrics.ObservePasswordHash(metrics.HashCompare)
+	hashErr := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password))
+	observeHash()
+	hashSpan.End()
+
+	if hashErr != nil {
+		log.Info("invalid credentials", slog.String("error", hashErr.Error()))
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonInvalidCredentials).Inc()
+		a.auditLoginFailed(ctx, user, app.ID, models.AuditReasonWrongPassword)
+
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
 // RegisterNewUser registers new user and returns user ID.
 // If user with this email already exists, returns error.
+// The user is registered in the tenant of the app if appID is set, see resolveTenant.
+// The users of an LDAP backend are registered by their first login, their emails are ErrDirectoryUser
 func (a *Auth) RegisterNewUser(
 	ctx context.Context,
 	email string,
 	password string,
+	appID int,
 ) (userID int64, err error) {
 	const op = "auth.RegisterNewUser"
 
-	log := a.log.With(slog.String("op", op))
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
 
 	log.Info("Registering new user")
 	log.Debug("User", slog.String("email", email))
 
+	tenantID, err := a.resolveTenant(ctx, appID)
+	if err != nil {
+		log.Warn("failed to resolve tenant", slog.String("error", err.Error()))
+
+		return models.EmptyUserID, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, ok := a.directory(tenantID, email); ok {
+		log.Warn("Email of a directory")
+
+		return models.EmptyUserID, fmt.Errorf("%s: %w", op, ErrDirectoryUser)
+	}
+
+	_, hashSpan := tracer.Start(ctx, "bcrypt.GenerateFromPassword")
+	observeHash := metrics.ObservePasswordHash(metrics.HashGenerate)
 	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	observeHash()
+	hashSpan.End()
+
 	if err != nil {
 		log.Error("Failed to generate password hash", slog.String("error", err.Error()))
 
 		return models.EmptyUserID, fmt.Errorf("%s: %w", op, err)
 	}
 
-	userID, err = a.userSaver.SaveUser(ctx, email, passHash)
+	err = a.withTx(ctx, func(ctx context.Context) error {
+		userID, err = a.userSaver.SaveUser(ctx, tenantID, email, passHash)
+		if err != nil {
+			return err
+		}
+
+		return a.emit(ctx, models.EventUserRegistered, tenantID, 0, webhook.UserData{
+			UserID: userID,
+			Email:  email,
+		})
+	})
 	if err != nil {
 		if errors.Is(err, storage.ErrUserExists) {
 			log.Warn("User already exists", slog.String("error", err.Error()))
@@ -159,6 +431,20 @@ func (a *Auth) RegisterNewUser(
 	}
 
 	log.Info("User registered")
+	metrics.Registrations.Inc()
+	// Users register themselves unless an admin does it for them
+	actorID := userID
+	if c, ok := caller.FromContext(ctx); ok {
+		actorID = c.UserID
+	}
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditRegister,
+		TenantID: tenantID,
+		ActorID:  actorID,
+		UserID:   userID,
+		Email:    email,
+		AppID:    appID,
+	})
 
 	return userID, nil
 }
@@ -170,7 +456,10 @@ func (a *Auth) IsAdmin(
 ) (isAdmin bool, err error) {
 	const op = "auth.IsAdmin"
 
-	log := a.log.With(
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
 		slog.String("op", op),
 		slog.Int64("userID", userID))
 
@@ -189,7 +478,21 @@ func (a *Auth) IsAdmin(
 		return false, fmt.Errorf("%s: %w", op, err)
 	}
 
+	// The call is anonymous, the event goes to the tenant of the user
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		log.Error("failed to get user", slog.String("error", err.Error()))
+
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
 	log.Info("Checked if user is admin", slog.Bool("isAdmin", isAdmin))
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditAdminCheck,
+		TenantID: user.TenantID,
+		UserID:   userID,
+		Email:    user.Email,
+	})
 
 	return isAdmin, nil
 }
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/crypto/bcrypt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage"
+	"grpc-sso/internal/storage/memory"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeStorage has the default tenant only
+type fakeStorage struct {
+	users map[string]models.User
+	apps  map[int]models.App
+}
+
+func (f *fakeStorage) SaveUser(_ context.Context, tenantID int64, email string, passHash []byte) (int64, error) {
+	if _, ok := f.users[email]; ok {
+		return models.EmptyUserID, storage.ErrUserExists
+	}
+
+	id := int64(len(f.users) + 1)
+	f.users[email] = models.User{ID: id, TenantID: tenantID, Email: email, PassHash: passHash}
+
+	return id, nil
+}
+
+func (f *fakeStorage) User(_ context.Context, tenantID int64, email string) (models.User, error) {
+	user, ok := f.users[email]
+	if !ok || user.TenantID != tenantID {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (f *fakeStorage) UpdateEmail(_ context.Context, userID int64, email string) error {
+	user, err := f.UserByID(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := f.users[email]; ok {
+		return storage.ErrUserExists
+	}
+
+	delete(f.users, user.Email)
+	user.Email = email
+	f.users[email] = user
+
+	return nil
+}
+
+func (f *fakeStorage) DisableUser(_ context.Context, userID int64) error {
+	user, err := f.UserByID(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+
+	user.Disabled = true
+	f.users[user.Email] = user
+
+	return nil
+}
+
+func (f *fakeStorage) UpdatePassword(_ context.Context, userID int64, passHash []byte) error {
+	user, err := f.UserByID(context.Background(), userID)
+	if err != nil {
+		return err
+	}
+
+	user.PassHash = passHash
+	f.users[user.Email] = user
+
+	return nil
+}
+
+func (f *fakeStorage) UserByID(_ context.Context, userID int64) (models.User, error) {
+	for _, user := range f.users {
+		if user.ID == userID {
+			return user, nil
+		}
+	}
+
+	return models.User{}, storage.ErrUserNotFound
+}
+
+func (f *fakeStorage) IsAdmin(_ context.Context, _ int64) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeStorage) App(_ context.Context, appID int) (models.App, error) {
+	app, ok := f.apps[appID]
+	if !ok {
+		return models.App{}, storage.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (f *fakeStorage) Tenant(_ context.Context, tenantID int64) (models.Tenant, error) {
+	if tenantID != models.DefaultTenantID {
+		return models.Tenant{}, storage.ErrTenantNotFound
+	}
+
+	return models.Tenant{ID: tenantID, Name: "default"}, nil
+}
+
+// exporter collects the spans of all tests.
+// The global tracer provider can be installed only once per process.
+var exporter = tracetest.NewInMemoryExporter()
+
+func TestMain(m *testing.M) {
+	tracing.Install(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	os.Exit(m.Run())
+}
+
+// newTestAuth is the service on the memory storage with the options after the features backed by it.
+// The default tenant has apps 1 and 2 with webhooks and app 3 without, tenant 2 has app 4
+func newTestAuth(t *testing.T, opts ...Option) (*Auth, *memory.Storage) {
+	t.Helper()
+
+	st := memory.New()
+	st.AddApp(models.App{ID: 1, Name: "test", Secret: "secret", WebhookURL: "http://hooks.test/1", WebhookSecret: "hook"})
+	st.AddApp(models.App{ID: 2, Name: "other", Secret: "secret", WebhookURL: "http://hooks.test/2", WebhookSecret: "hook"})
+	st.AddApp(models.App{ID: 3, Name: "no webhook", Secret: "secret"})
+	st.AddTenant(models.Tenant{ID: 2, Name: "other"})
+	st.AddApp(models.App{ID: 4, TenantID: 2, Name: "tenant app", Secret: "secret4",
+		WebhookURL: "http://hooks.test/4", WebhookSecret: "hook"})
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	a := New(log, st, st, st, time.Hour, append([]Option{
+		WithAuditLog(st),
+		WithOutbox(st),
+		WithEventLog(st, time.Hour),
+		WithSessions(st),
+		WithConsents(st),
+		WithAccessTokens(st),
+		WithGroups(st, st),
+	}, opts...)...)
+
+	return a, st
+}
+
+// newTracedAuth is the service on the fake storage, its spans are collected by the exporter
+func newTracedAuth(t *testing.T) (*Auth, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter.Reset()
+
+	passHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	st := &fakeStorage{
+		users: map[string]models.User{
+			"user@example.com": {ID: 1, TenantID: models.DefaultTenantID, Email: "user@example.com", PassHash: passHash},
+		},
+		apps: map[int]models.App{1: {ID: 1, TenantID: models.DefaultTenantID, Name: "test", Secret: "secret"}},
+	}
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return New(log, st, st, st, time.Hour), exporter
+}
+
+func TestLogin_Spans(t *testing.T) {
+	a, exporter := newTracedAuth(t)
+
+	_, err := a.Login(context.Background(), "user@example.com", "password", 1)
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+
+	hash, login := spans[0], spans[1]
+	assert.Equal(t, "bcrypt.CompareHashAndPassword", hash.Name)
+	assert.Equal(t, "auth.Login", login.Name)
+	assert.Equal(t, login.SpanContext.SpanID(), hash.Parent.SpanID())
+	assert.Equal(t, codes.Unset, login.Status.Code)
+}
+
+func TestLogin_FailedSpan(t *testing.T) {
+	a, exporter := newTracedAuth(t)
+
+	_, err := a.Login(context.Background(), "user@example.com", "password", 2)
+	require.ErrorIs(t, err, ErrInvalidAppID)
+
+	spans := exporter.GetSpans()
+	require.NotEmpty(t, spans)
+
+	login := spans[len(spans)-1]
+	assert.Equal(t, "auth.Login", login.Name)
+	assert.Equal(t, codes.Error, login.Status.Code)
+	require.NotEmpty(t, login.Events)
+	assert.Equal(t, "exception", login.Events[0].Name)
+}
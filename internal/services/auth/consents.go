@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidScope = errors.New("invalid scope")
+	// ErrConsentRequired is returned by Login if the user hasn't granted the requested scopes to the app yet
+	ErrConsentRequired = errors.New("consent required")
+	ErrConsentNotFound = errors.New("consent not found")
+	// ErrConsentsDisabled is returned if scopes are requested but the service has no consents storage
+	ErrConsentsDisabled = errors.New("consents are not enabled")
+)
+
+// Consents keeps the scopes the users granted to the apps
+type Consents interface {
+	SaveConsent(ctx context.Context, consent models.Consent) error
+	Consent(ctx context.Context, userID int64, appID int) (models.Consent, error)
+	UserConsents(ctx context.Context, userID int64) ([]models.Consent, error)
+	DeleteConsent(ctx context.Context, userID int64, appID int) error
+}
+
+// WithConsents enables the scopes: Login accepts the scopes declared by the app
+// and puts the ones the user consented to into the token
+func WithConsents(consents Consents) Option {
+	return func(a *Auth) {
+		a.consents = consents
+	}
+}
+
+// WithScopes requests the scopes for the token, they must be declared by the app.
+// Without it the token has no scope claim
+func WithScopes(scopes ...string) LoginOption {
+	return func(o *loginOptions) {
+		o.scopes = append(o.scopes, scopes...)
+	}
+}
+
+// WithConsentGrant grants the requested scopes the user hasn't consented to yet,
+// without it Login fails with ErrConsentRequired
+func WithConsentGrant() LoginOption {
+	return func(o *loginOptions) {
+		o.grantConsent = true
+	}
+}
+
+// checkScopes returns the scopes requested at the login, see appScopes
+func (a *Auth) checkScopes(app models.App, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, nil
+	}
+
+	if a.consents == nil {
+		return nil, ErrConsentsDisabled
+	}
+
+	return appScopes(app, requested)
+}
+
+// appScopes returns the requested scopes sorted and without duplicates,
+// all of them must be declared by the app
+func appScopes(app models.App, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, nil
+	}
+
+	scopes := slices.Compact(slices.Sorted(slices.Values(requested)))
+
+	for _, scope := range scopes {
+		if !slices.Contains(app.Scopes, scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+
+	return scopes, nil
+}
+
+// grantScopes checks the user consented to the scopes for the app. The missing scopes are
+// granted if grant is set, otherwise it fails with ErrConsentRequired
+func (a *Auth) grantScopes(ctx context.Context, user models.User, app models.App, scopes []string, grant bool) error {
+	consent, err := a.consents.Consent(ctx, user.ID, app.ID)
+	if err != nil && !errors.Is(err, storage.ErrConsentNotFound) {
+		return err
+	}
+
+	var missing []string
+
+	for _, scope := range scopes {
+		if !slices.Contains(consent.Scopes, scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if !grant {
+		return fmt.Errorf("%w: %s", ErrConsentRequired, strings.Join(missing, " "))
+	}
+
+	err = a.consents.SaveConsent(ctx, models.Consent{
+		UserID:    user.ID,
+		AppID:     app.ID,
+		Scopes:    missing,
+		GrantedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditConsentGrant,
+		TenantID: user.TenantID,
+		ActorID:  user.ID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		AppID:    app.ID,
+		Reason:   strings.Join(missing, " "),
+	})
+
+	return nil
+}
+
+// ListConsents returns the consents of the caller ordered by app
+func (a *Auth) ListConsents(ctx context.Context) (consents []models.Consent, err error) {
+	const op = "auth.ListConsents"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	if a.consents == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrConsentsDisabled)
+	}
+
+	consents, err = a.consents.UserConsents(ctx, c.UserID)
+	if err != nil {
+		slogger.FromContext(ctx, a.log).Error("failed to list consents",
+			slog.String("op", op),
+			slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return consents, nil
+}
+
+// RevokeConsent revokes all the scopes the caller granted to the app, the sessions of the caller in the app
+// and the caller's personal access tokens of the app, so their tokens and the ones exchanged from the sessions
+// are rejected from then on. The tokens other apps exchanged for the app stay valid until they expire.
+// If the caller has no consent for the app, returns ErrConsentNotFound. An impersonating admin can't revoke it
+func (a *Auth) RevokeConsent(ctx context.Context, appID int) (err error) {
+	const op = "auth.RevokeConsent"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	c, err := requireUser(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if a.consents == nil {
+		return fmt.Errorf("%s: %w", op, ErrConsentsDisabled)
+	}
+
+	var accessTokens []models.AccessToken
+
+	_, err = a.revoke(ctx, c.UserID, func(ctx context.Context, now time.Time) ([]models.Session, error) {
+		if err := a.consents.DeleteConsent(ctx, c.UserID, appID); err != nil {
+			return nil, err
+		}
+
+		accessTokens, err = a.revokeAccessTokens(ctx, c.UserID, now, func(token models.AccessToken) bool {
+			return token.AppID == appID
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return a.revokeAppSessions(ctx, c.UserID, appID, now)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditConsentRevoke,
+		TenantID: c.TenantID,
+		ActorID:  c.UserID,
+		UserID:   c.UserID,
+		Email:    c.Email,
+		AppID:    appID,
+	})
+
+	for _, token := range accessTokens {
+		a.audit(ctx, models.AuditEvent{
+			Type:     models.AuditAccessTokenRevoke,
+			TenantID: c.TenantID,
+			ActorID:  c.UserID,
+			UserID:   c.UserID,
+			Email:    c.Email,
+			AppID:    token.AppID,
+			Reason:   token.Hint,
+		})
+	}
+
+	return nil
+}
+
+// revokeAppSessions revokes the sessions of the user in the app active at now and returns them
+func (a *Auth) revokeAppSessions(ctx context.Context, userID int64, appID int, now time.Time) ([]models.Session, error) {
+	if a.sessions == nil {
+		return nil, nil
+	}
+
+	sessions, err := a.sessions.UserSessions(ctx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var revoked []models.Session
+
+	for _, session := range sessions {
+		if session.AppID != appID {
+			continue
+		}
+
+		if err := a.sessions.RevokeSession(ctx, session.ID, now); err != nil {
+			return nil, err
+		}
+
+		session.RevokedAt = now
+		revoked = append(revoked, session)
+	}
+
+	return revoked, nil
+}
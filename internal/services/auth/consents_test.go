@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"testing"
+	"time"
+)
+
+// scopedApp is app 1 declaring the email and profile scopes
+var scopedApp = models.App{ID: 1, Name: "test", Secret: "secret", Scopes: []string{"email", "profile"}}
+
+func TestLogin_Scopes(t *testing.T) {
+	a, st := newTestAuth(t)
+	st.AddApp(scopedApp)
+	register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	// The first login with the scopes asks for the consent
+	_, err := a.Login(ctx, "user@example.com", "password", 1, WithScopes("profile"))
+	require.ErrorIs(t, err, ErrConsentRequired)
+
+	token, err := a.Login(ctx, "user@example.com", "password", 1,
+		WithScopes("profile", "email", "profile"), WithConsentGrant())
+	require.NoError(t, err)
+
+	c, err := a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email", "profile"}, c.Scopes)
+
+	// The granted scopes need no consent again, the token carries the requested ones only
+	token, err = a.Login(ctx, "user@example.com", "password", 1, WithScopes("email"))
+	require.NoError(t, err)
+
+	c, err = a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email"}, c.Scopes)
+
+	// Tokens without scopes keep working as before
+	c, _ = login(t, a, ctx, "user@example.com", 1)
+	assert.Empty(t, c.Scopes)
+
+	consents, err := a.ListConsents(caller.NewContext(ctx, c))
+	require.NoError(t, err)
+	require.Len(t, consents, 1)
+	assert.Equal(t, 1, consents[0].AppID)
+	assert.Equal(t, []string{"email", "profile"}, consents[0].Scopes)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditConsentGrant}})
+	require.NoError(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, "email profile", audit[0].Reason)
+
+	audit, err = st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditLoginFailed}})
+	require.NoError(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, models.AuditReasonNoConsent, audit[0].Reason)
+}
+
+func TestLogin_ScopesFailCases(t *testing.T) {
+	a, st := newTestAuth(t)
+	st.AddApp(scopedApp)
+	register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		appID   int
+		opts    []LoginOption
+		wantErr error
+	}{
+		{
+			name:    "scope not declared by the app",
+			appID:   1,
+			opts:    []LoginOption{WithScopes("profile", "admin"), WithConsentGrant()},
+			wantErr: ErrInvalidScope,
+		},
+		{
+			name:    "app without scopes",
+			appID:   2,
+			opts:    []LoginOption{WithScopes("profile"), WithConsentGrant()},
+			wantErr: ErrInvalidScope,
+		},
+		{
+			name:    "no consent",
+			appID:   1,
+			opts:    []LoginOption{WithScopes("email")},
+			wantErr: ErrConsentRequired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Login(ctx, "user@example.com", "password", tt.appID, tt.opts...)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	// Without the consents storage the scopes can't be requested
+	b, _ := newTestAuth(t, WithConsents(nil))
+	register(t, b, "user@example.com", 1)
+
+	_, err := b.Login(ctx, "user@example.com", "password", 1, WithScopes("profile"))
+	assert.ErrorIs(t, err, ErrConsentsDisabled)
+}
+
+func TestRevokeConsent(t *testing.T) {
+	a, st := newTestAuth(t)
+	st.AddApp(scopedApp)
+	register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	scoped, err := a.Login(ctx, "user@example.com", "password", 1, WithScopes("profile"), WithConsentGrant())
+	require.NoError(t, err)
+
+	// The session in another app survives the revocation
+	other, otherToken := login(t, a, ctx, "user@example.com", 2)
+	otherCtx := caller.NewContext(ctx, other)
+
+	// The access tokens of the app are revoked too, the ones of other apps survive
+	pat, _, err := a.CreateAccessToken(otherCtx, "ci", 1, []string{"profile"}, time.Time{})
+	require.NoError(t, err)
+	otherPAT, _, err := a.CreateAccessToken(otherCtx, "ci", 2, nil, time.Time{})
+	require.NoError(t, err)
+
+	require.ErrorIs(t, a.RevokeConsent(otherCtx, 2), ErrConsentNotFound)
+	require.NoError(t, a.RevokeConsent(otherCtx, 1))
+	assert.ErrorIs(t, a.RevokeConsent(otherCtx, 1), ErrConsentNotFound)
+
+	for _, token := range []string{scoped, pat} {
+		_, err = a.VerifyToken(ctx, token)
+		assert.ErrorIs(t, err, jwt.ErrInvalidToken)
+	}
+
+	for _, token := range []string{otherToken, otherPAT} {
+		_, err = a.VerifyToken(ctx, token)
+		assert.NoError(t, err)
+	}
+
+	// One event for the session and one for the access token
+	events, err := st.UserEvents(ctx, models.UserEventFilter{AppID: 1, Types: []string{models.EventTokenRevoked}})
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	consents, err := a.ListConsents(otherCtx)
+	require.NoError(t, err)
+	assert.Empty(t, consents)
+
+	// The consent has to be granted again
+	_, err = a.Login(ctx, "user@example.com", "password", 1, WithScopes("profile"))
+	assert.ErrorIs(t, err, ErrConsentRequired)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditConsentRevoke}})
+	require.NoError(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, 1, audit[0].AppID)
+
+	_, err = a.ListConsents(ctx)
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
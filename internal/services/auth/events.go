@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/logger/slogger"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultWatchPollInterval is how often the watchers read the events log
+// for the events appended by other instances
+const DefaultWatchPollInterval = time.Second
+
+// watchBatchSize is the number of events read from the log at once
+const watchBatchSize = 100
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EventLog is the log of the user events streamed to the watchers.
+// Events are appended in the transaction of the change they are about
+type EventLog interface {
+	AppendUserEvent(ctx context.Context, event models.UserEvent) error
+	UserEvents(ctx context.Context, filter models.UserEventFilter) ([]models.UserEvent, error)
+	LastUserEventID(ctx context.Context) (id int64, err error)
+}
+
+// WithEventLog sets the log of the user events, without it nothing is streamed to the watchers.
+// The watchers are woken up by the changes made by this instance right away
+// and read the log every pollInterval for the changes of the other ones,
+// DefaultWatchPollInterval if it is not positive
+func WithEventLog(eventLog EventLog, pollInterval time.Duration) Option {
+	return func(a *Auth) {
+		a.eventLog = eventLog
+		if pollInterval > 0 {
+			a.watchPollInterval = pollInterval
+		}
+	}
+}
+
+// nopEventLog is used when the events log is not configured
+type nopEventLog struct{}
+
+func (nopEventLog) AppendUserEvent(context.Context, models.UserEvent) error { return nil }
+
+func (nopEventLog) UserEvents(context.Context, models.UserEventFilter) ([]models.UserEvent, error) {
+	return nil, nil
+}
+
+func (nopEventLog) LastUserEventID(context.Context) (int64, error) { return 0, nil }
+
+// notifier wakes up the watchers when the events are appended
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns the channel closed by the next notify
+func (n *notifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+
+	return n.ch
+}
+
+func (n *notifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// withTx runs fn in a transaction and wakes up the watchers after the commit
+func (a *Auth) withTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := a.outbox.WithTx(ctx, fn); err != nil {
+		return err
+	}
+
+	a.watchers.notify()
+
+	return nil
+}
+
+// emit adds the event about the user to the outbox and to the events log.
+// appID is zero for the events that concern every app of the tenant, otherwise only its webhook gets it.
+// Call it in the transaction of the change, see withTx
+func (a *Auth) emit(ctx context.Context, eventType string, tenantID int64, appID int, data webhook.UserData) error {
+	payload := webhook.Payload{
+		ID:        uuid.NewString(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	err = a.outbox.EnqueueEvent(ctx, models.OutboxEvent{
+		EventID:   payload.ID,
+		Type:      eventType,
+		TenantID:  tenantID,
+		AppID:     appID,
+		UserID:    data.UserID,
+		Payload:   body,
+		CreatedAt: payload.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.eventLog.AppendUserEvent(ctx, models.UserEvent{
+		TenantID:  tenantID,
+		Type:      eventType,
+		UserID:    data.UserID,
+		Email:     data.Email,
+		AppID:     appID,
+		CreatedAt: payload.CreatedAt,
+	})
+}
+
+// WatchUserEvents sends the user events matching the filter, with the cursor to resume after each one,
+// as they happen until ctx is done or send fails. An empty cursor starts with the next event.
+// filter.AfterID and filter.Limit are set from the cursor. Admin only, the events of the admin's tenant.
+// The admin is checked again every poll interval: the stream ends with ErrUnauthenticated once its token is revoked
+// or the admin disabled, and with ErrPermissionDenied once the admin rights are taken
+func (a *Auth) WatchUserEvents(
+	ctx context.Context,
+	filter models.UserEventFilter,
+	cursor string,
+	send func(event models.UserEvent, cursor string) error,
+) (err error) {
+	const op = "auth.WatchUserEvents"
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to watch user events", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Admins see the events of their tenant only
+	filter.TenantID = admin.TenantID
+
+	if cursor == "" {
+		filter.AfterID, err = a.eventLog.LastUserEventID(ctx)
+		if err != nil {
+			log.Error("failed to get last user event", slog.String("error", err.Error()))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	} else {
+		filter.AfterID, err = decodePageToken(cursor)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, ErrInvalidCursor)
+		}
+	}
+
+	filter.Limit = watchBatchSize
+
+	log.Info("watching user events", slog.Int64("after_id", filter.AfterID))
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditAdminAction,
+		ActorID: admin.UserID,
+		AppID:   filter.AppID,
+		Reason:  "watch_user_events",
+	})
+
+	ticker := time.NewTicker(a.watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Taken before the read, so the events appended meanwhile wake it up
+		appended := a.watchers.wait()
+
+		events, err := a.eventLog.UserEvents(ctx, filter)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%s: %w", op, ctx.Err())
+			}
+
+			log.Error("failed to read user events", slog.String("error", err.Error()))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		for _, event := range events {
+			filter.AfterID = event.ID
+
+			if err := send(event, encodePageToken(event.ID)); err != nil {
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+
+		if len(events) == filter.Limit {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		case <-appended:
+		case <-ticker.C:
+			if err := a.recheckAdmin(ctx, admin); err != nil {
+				if ctx.Err() != nil {
+					return fmt.Errorf("%s: %w", op, ctx.Err())
+				}
+
+				log.Warn("no longer allowed to watch user events", slog.String("error", err.Error()))
+
+				return fmt.Errorf("%s: %w", op, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"testing"
+	"time"
+)
+
+type watched struct {
+	event  models.UserEvent
+	cursor string
+}
+
+// watch runs WatchUserEvents until ctx is done, the events are sent to the returned channel
+// and its error to the second one
+func watch(
+	ctx context.Context,
+	a *Auth,
+	filter models.UserEventFilter,
+	cursor string,
+) (<-chan watched, <-chan error) {
+	events := make(chan watched, 100)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- a.WatchUserEvents(ctx, filter, cursor, func(event models.UserEvent, cursor string) error {
+			events <- watched{event: event, cursor: cursor}
+
+			return nil
+		})
+	}()
+
+	return events, done
+}
+
+func next(t *testing.T, events <-chan watched) watched {
+	t.Helper()
+
+	select {
+	case w := <-events:
+		return w
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+
+		return watched{}
+	}
+}
+
+func TestWatchUserEvents(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	adminID, err := a.RegisterNewUser(ctx, "admin@example.com", "password", 0)
+	require.NoError(t, err)
+	require.NoError(t, st.SetAdmin(adminID, true))
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	admin, _ := login(t, a, ctx, "admin@example.com", 1)
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+	adminCtx := caller.NewContext(ctx, admin)
+	userCtx := caller.NewContext(ctx, user)
+
+	nop := func(models.UserEvent, string) error { return nil }
+
+	registered, err := st.UserEvents(ctx, models.UserEventFilter{})
+	require.NoError(t, err)
+	require.Len(t, registered, 2)
+
+	// Resumes after the registration of the admin
+	first := encodePageToken(registered[0].ID)
+
+	t.Run("not admin", func(t *testing.T) {
+		assert.ErrorIs(t, a.WatchUserEvents(ctx, models.UserEventFilter{}, "", nop), ErrUnauthenticated)
+		assert.ErrorIs(t, a.WatchUserEvents(userCtx, models.UserEventFilter{}, "", nop), ErrPermissionDenied)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		err := a.WatchUserEvents(adminCtx, models.UserEventFilter{}, "garbage", nop)
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+
+	t.Run("live and resume", func(t *testing.T) {
+		watchCtx, cancel := context.WithCancel(adminCtx)
+		events, done := watch(watchCtx, a, models.UserEventFilter{}, "")
+
+		// Only the events after the start are sent, the registrations above are not
+		require.Eventually(t, func() bool {
+			audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditAdminAction}})
+			require.NoError(t, err)
+
+			return len(audit) > 0 && audit[0].Reason == "watch_user_events"
+		}, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, a.ChangePassword(userCtx, "password", "new-password"))
+		require.NoError(t, a.DisableUser(adminCtx, userID))
+
+		changed := next(t, events)
+		assert.Equal(t, models.EventUserPasswordChanged, changed.event.Type)
+		assert.Equal(t, userID, changed.event.UserID)
+		assert.Equal(t, "user@example.com", changed.event.Email)
+		assert.Equal(t, models.EventUserDisabled, next(t, events).event.Type)
+		assert.Equal(t, models.EventTokenRevoked, next(t, events).event.Type)
+
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+
+		resumeCtx, cancel := context.WithCancel(adminCtx)
+		defer cancel()
+
+		resumed, done := watch(resumeCtx, a, models.UserEventFilter{}, changed.cursor)
+		assert.Equal(t, models.EventUserDisabled, next(t, resumed).event.Type)
+		assert.Equal(t, models.EventTokenRevoked, next(t, resumed).event.Type)
+
+		select {
+		case w := <-resumed:
+			t.Fatalf("unexpected event %s", w.event.Type)
+		case err := <-done:
+			t.Fatalf("watch ended: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		watchCtx, cancel := context.WithCancel(adminCtx)
+		defer cancel()
+
+		filter := models.UserEventFilter{AppID: 2, Types: []string{models.EventUserRegistered}}
+		events, _ := watch(watchCtx, a, filter, first)
+
+		w := next(t, events)
+		assert.Equal(t, models.EventUserRegistered, w.event.Type)
+		assert.Equal(t, "user@example.com", w.event.Email)
+
+		_, err := a.RegisterNewUser(ctx, "late@example.com", "password", 0)
+		require.NoError(t, err)
+		assert.Equal(t, "late@example.com", next(t, events).event.Email)
+	})
+
+	t.Run("send fails", func(t *testing.T) {
+		errSend := errors.New("client gone")
+
+		err := a.WatchUserEvents(adminCtx, models.UserEventFilter{}, first,
+			func(models.UserEvent, string) error { return errSend })
+		assert.ErrorIs(t, err, errSend)
+	})
+}
+
+func TestWatchUserEvents_Recheck(t *testing.T) {
+	a, st := newTestAuth(t)
+	WithEventLog(st, 10*time.Millisecond)(a)
+	ctx := context.Background()
+
+	nop := func(models.UserEvent, string) error { return nil }
+
+	// watchAs starts watching as the admin and returns the end of the stream
+	watchAs := func(admin models.Caller) <-chan error {
+		done := make(chan error, 1)
+
+		go func() {
+			done <- a.WatchUserEvents(caller.NewContext(ctx, admin), models.UserEventFilter{}, "", nop)
+		}()
+
+		return done
+	}
+
+	ended := func(t *testing.T, done <-chan error) error {
+		t.Helper()
+
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("the stream didn't end")
+
+			return nil
+		}
+	}
+
+	for _, email := range []string{"revoked@example.com", "disabled@example.com", "demoted@example.com"} {
+		adminID := register(t, a, email, 1)
+		require.NoError(t, st.SetAdmin(adminID, true))
+	}
+
+	t.Run("session revoked", func(t *testing.T) {
+		admin, _ := login(t, a, ctx, "revoked@example.com", 1)
+		done := watchAs(admin)
+
+		require.NoError(t, a.RevokeSession(caller.NewContext(ctx, admin), admin.SessionID))
+		assert.ErrorIs(t, ended(t, done), ErrUnauthenticated)
+	})
+
+	t.Run("admin disabled", func(t *testing.T) {
+		admin, _ := login(t, a, ctx, "disabled@example.com", 1)
+		done := watchAs(admin)
+
+		require.NoError(t, st.DisableUser(ctx, admin.UserID))
+		assert.ErrorIs(t, ended(t, done), ErrUnauthenticated)
+	})
+
+	t.Run("admin rights taken", func(t *testing.T) {
+		admin, _ := login(t, a, ctx, "demoted@example.com", 1)
+		done := watchAs(admin)
+
+		// The stream goes on while the admin is still one
+		select {
+		case err := <-done:
+			t.Fatalf("watch ended: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		require.NoError(t, st.SetAdmin(admin.UserID, false))
+		assert.ErrorIs(t, ended(t, done), ErrPermissionDenied)
+	})
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// TokenTypeAccessToken is the type of the tokens ExchangeToken accepts and issues, see RFC 8693 section 3
+const TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+
+var (
+	// ErrInvalidClient is returned by ExchangeToken if the app ID or secret is wrong
+	ErrInvalidClient = errors.New("invalid client")
+	// ErrInvalidGrant is returned by ExchangeToken if the subject token is invalid, expired,
+	// of a disabled user or not issued for the app exchanging it
+	ErrInvalidGrant = errors.New("invalid subject token")
+	// ErrAudienceNotAllowed is returned by ExchangeToken if the app may not exchange tokens for the audience
+	ErrAudienceNotAllowed = errors.New("audience not allowed")
+)
+
+// ExchangeToken exchanges the token of a user issued for the app for a token of the user for the audience app,
+// the token exchange of RFC 8693. The app authenticates with its ID and secret and may only request
+// the audiences listed in its ExchangeAudiences, in its tenant. The issued token:
+//   - names the app in the act claim, the actors of the subject token are nested in it
+//   - has the requested scopes, they must be in the subject token and declared by the audience;
+//     the scopes of the subject token declared by the audience if none are requested
+//   - has the roles of the user in the audience and the session of the subject token
+//   - expires with the subject token at the latest
+func (a *Auth) ExchangeToken(
+	ctx context.Context,
+	subjectToken string,
+	appID int,
+	appSecret string,
+	audience int,
+	scopes []string,
+) (token string, issued models.Caller, expiresAt time.Time, err error) {
+	const op = "auth.ExchangeToken"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int("appID", appID),
+		slog.Int("audience", audience))
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("App not found", slog.String("error", err.Error()))
+
+			return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+		}
+
+		log.Error("failed to get app", slog.String("error", err.Error()))
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Apps without a secret can't authenticate
+	if app.Secret == "" || subtle.ConstantTimeCompare([]byte(app.Secret), []byte(appSecret)) != 1 {
+		log.Warn("invalid app secret")
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidClient)
+	}
+
+	subject, subjectExpiresAt, err := a.verifyToken(ctx, subjectToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidToken) {
+			log.Warn("invalid subject token", slog.String("error", err.Error()))
+
+			return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidGrant, err)
+		}
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// An app exchanges only the tokens issued for it, not the ones it happens to see
+	if subject.AppID != app.ID {
+		log.Warn("subject token of another app", slog.Int("subjectAppID", subject.AppID))
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: issued for another app", op, ErrInvalidGrant)
+	}
+
+	if !slices.Contains(app.ExchangeAudiences, audience) {
+		log.Warn("audience not allowed")
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, ErrAudienceNotAllowed)
+	}
+
+	target, err := a.appProvider.App(ctx, audience)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("Audience not found", slog.String("error", err.Error()))
+
+			return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get audience", slog.String("error", err.Error()))
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if target.TenantID != app.TenantID {
+		log.Warn("audience of another tenant")
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, ErrAudienceNotAllowed)
+	}
+
+	scopes, err = exchangeScopes(subject, target, scopes)
+	if err != nil {
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, subject.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: unknown user", op, ErrInvalidGrant)
+		}
+
+		log.Error("failed to get user", slog.String("error", err.Error()))
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.Disabled {
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: user is disabled", op, ErrInvalidGrant)
+	}
+
+	issued = models.Caller{
+		UserID:    user.ID,
+		TenantID:  user.TenantID,
+		Email:     user.Email,
+		AppID:     target.ID,
+		SessionID: subject.SessionID,
+		Scopes:    scopes,
+		Actor:     &models.Actor{AppID: app.ID, Actor: subject.Actor},
+	}
+
+	if a.permissions != nil {
+		perms, err := a.permissions.UserPermissions(ctx, user.ID, target.ID)
+		if err != nil {
+			log.Error("failed to get permissions", slog.String("error", err.Error()))
+
+			return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		issued.Roles = perms.Roles
+	}
+
+	// Access tokens may never expire
+	ttl := a.tokenTTL
+	if !subjectExpiresAt.IsZero() {
+		ttl = min(ttl, time.Until(subjectExpiresAt))
+	}
+	expiresAt = time.Now().Add(ttl)
+
+	token, err = jwt.NewToken(issued, target.Secret, ttl)
+	if err != nil {
+		log.Error("failed to create token", slog.String("error", err.Error()))
+
+		return "", models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("Token exchanged", slog.Int64("userID", user.ID))
+	metrics.TokensIssued.WithLabelValues(strconv.Itoa(target.ID)).Inc()
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditTokenExchange,
+		TenantID: user.TenantID,
+		ActorID:  user.ID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		AppID:    target.ID,
+		Reason:   strconv.Itoa(app.ID),
+	})
+
+	return token, issued, expiresAt, nil
+}
+
+// exchangeScopes returns the requested scopes if the subject has them and the audience declares them,
+// or the scopes of the subject the audience declares if none are requested
+func exchangeScopes(subject models.Caller, audience models.App, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		var scopes []string
+
+		for _, scope := range subject.Scopes {
+			if slices.Contains(audience.Scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		return scopes, nil
+	}
+
+	scopes, err := appScopes(audience, requested)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(subject.Scopes, scope) {
+			return nil, fmt.Errorf("%w: %q is not in the subject token", ErrInvalidScope, scope)
+		}
+	}
+
+	return scopes, nil
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/storage/memory"
+	"testing"
+	"time"
+)
+
+// exchangeSubject makes app 1 exchange tokens for apps 2 and 4 and app 2 for app 3. It returns the token
+// of the registered user logged in to app 1 with the email and profile scopes
+func exchangeSubject(t *testing.T, a *Auth, st *memory.Storage) string {
+	t.Helper()
+
+	st.AddApp(models.App{ID: 1, Name: "frontend", Secret: "secret1", Scopes: []string{"email", "profile"},
+		ExchangeAudiences: []int{2, 4, 404}})
+	st.AddApp(models.App{ID: 2, Name: "orders", Secret: "secret2", Scopes: []string{"email", "orders"},
+		ExchangeAudiences: []int{3}})
+	st.AddApp(models.App{ID: 3, Name: "billing", Secret: "secret3"})
+
+	register(t, a, "user@example.com", 1)
+
+	token, err := a.Login(context.Background(), "user@example.com", "password", 1,
+		WithScopes("email", "profile"), WithConsentGrant())
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestExchangeToken(t *testing.T) {
+	a, st := newTestAuth(t)
+	subjectToken := exchangeSubject(t, a, st)
+	ctx := context.Background()
+
+	subject, err := a.VerifyToken(ctx, subjectToken)
+	require.NoError(t, err)
+
+	groupID, err := st.SaveGroup(ctx, models.DefaultTenantID, "buyers")
+	require.NoError(t, err)
+	require.NoError(t, st.AddGroupUser(ctx, groupID, subject.UserID))
+	require.NoError(t, st.GrantGroupRole(ctx, groupID, models.GroupRole{AppID: 2, Role: "buyer"}))
+
+	token, issued, expiresAt, err := a.ExchangeToken(ctx, subjectToken, 1, "secret1", 2, nil)
+	require.NoError(t, err)
+
+	subjectExpiresAt, err := jwt.ExpiresAt(subjectToken)
+	require.NoError(t, err)
+	assert.False(t, expiresAt.After(subjectExpiresAt.Add(time.Second)))
+
+	// The token is for app 2 with the scopes of the subject token app 2 declares
+	c, err := a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, models.Caller{
+		UserID:    subject.UserID,
+		TenantID:  models.DefaultTenantID,
+		Email:     "user@example.com",
+		AppID:     2,
+		SessionID: subject.SessionID,
+		Roles:     []string{"buyer"},
+		Scopes:    []string{"email"},
+		Actor:     &models.Actor{AppID: 1},
+	}, c)
+	assert.Equal(t, issued, c)
+
+	events, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditTokenExchange}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, 2, events[0].AppID)
+	assert.Equal(t, "1", events[0].Reason)
+
+	// App 2 passes it on to app 3, the actors are nested
+	chained, _, _, err := a.ExchangeToken(ctx, token, 2, "secret2", 3, nil)
+	require.NoError(t, err)
+
+	c, err = a.VerifyToken(ctx, chained)
+	require.NoError(t, err)
+	assert.Equal(t, 3, c.AppID)
+	assert.Empty(t, c.Scopes)
+	assert.Equal(t, &models.Actor{AppID: 2, Actor: &models.Actor{AppID: 1}}, c.Actor)
+
+	// The exchanged tokens act on behalf of the user, not as the user
+	assert.ErrorIs(t, a.ChangePassword(caller.NewContext(ctx, c), "password", "new password"), ErrImpersonated)
+
+	// and end with the session of the subject token
+	require.NoError(t, a.RevokeSession(caller.NewContext(ctx, subject), subject.SessionID))
+
+	_, err = a.VerifyToken(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestExchangeToken_Scopes(t *testing.T) {
+	a, st := newTestAuth(t)
+	subjectToken := exchangeSubject(t, a, st)
+	ctx := context.Background()
+
+	_, issued, _, err := a.ExchangeToken(ctx, subjectToken, 1, "secret1", 2, []string{"email", "email"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"email"}, issued.Scopes)
+
+	// The scope must be declared by the audience
+	_, _, _, err = a.ExchangeToken(ctx, subjectToken, 1, "secret1", 2, []string{"profile"})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+
+	// and granted in the subject token, the exchange only narrows the scopes
+	_, _, _, err = a.ExchangeToken(ctx, subjectToken, 1, "secret1", 2, []string{"orders"})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestExchangeToken_FailCases(t *testing.T) {
+	a, st := newTestAuth(t)
+	subjectToken := exchangeSubject(t, a, st)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "other@example.com", "password", 1)
+	require.NoError(t, err)
+
+	_, otherAppToken := login(t, a, ctx, "other@example.com", 2)
+
+	expired, err := jwt.NewToken(models.Caller{UserID: 1, Email: "user@example.com", AppID: 1}, "secret1", -time.Minute)
+	require.NoError(t, err)
+
+	disabledID, err := a.RegisterNewUser(ctx, "disabled@example.com", "password", 1)
+	require.NoError(t, err)
+
+	_, disabledToken := login(t, a, ctx, "disabled@example.com", 1)
+	require.NoError(t, st.DisableUser(ctx, disabledID))
+
+	tests := []struct {
+		name         string
+		subjectToken string
+		appID        int
+		appSecret    string
+		audience     int
+		wantErr      error
+	}{
+		{name: "unknown app", subjectToken: subjectToken, appID: 404, appSecret: "secret1", audience: 2,
+			wantErr: ErrInvalidClient},
+		{name: "wrong secret", subjectToken: subjectToken, appID: 1, appSecret: "secret2", audience: 2,
+			wantErr: ErrInvalidClient},
+		{name: "invalid subject token", subjectToken: "not.a.token", appID: 1, appSecret: "secret1", audience: 2,
+			wantErr: ErrInvalidGrant},
+		{name: "expired subject token", subjectToken: expired, appID: 1, appSecret: "secret1", audience: 2,
+			wantErr: ErrInvalidGrant},
+		{name: "subject token of another app", subjectToken: otherAppToken, appID: 1, appSecret: "secret1",
+			audience: 2, wantErr: ErrInvalidGrant},
+		{name: "disabled user", subjectToken: disabledToken, appID: 1, appSecret: "secret1", audience: 2,
+			wantErr: ErrInvalidGrant},
+		{name: "audience not in the policy", subjectToken: subjectToken, appID: 1, appSecret: "secret1", audience: 3,
+			wantErr: ErrAudienceNotAllowed},
+		{name: "audience of another tenant", subjectToken: subjectToken, appID: 1, appSecret: "secret1",
+			audience: 4, wantErr: ErrAudienceNotAllowed},
+		{name: "unknown audience", subjectToken: subjectToken, appID: 1, appSecret: "secret1", audience: 404,
+			wantErr: ErrInvalidAppID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := a.ExchangeToken(ctx, tt.subjectToken, tt.appID, tt.appSecret, tt.audience, nil)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("app without secret", func(t *testing.T) {
+		st.AddApp(models.App{ID: 5, Name: "public", ExchangeAudiences: []int{2}})
+
+		_, _, _, err := a.ExchangeToken(ctx, subjectToken, 5, "", 2, nil)
+		assert.ErrorIs(t, err, ErrInvalidClient)
+	})
+}
+
+func TestExchangeToken_AccessToken(t *testing.T) {
+	a, st := newTestAuth(t)
+	subjectToken := exchangeSubject(t, a, st)
+	ctx := context.Background()
+
+	subject, err := a.VerifyToken(ctx, subjectToken)
+	require.NoError(t, err)
+
+	pat, _, err := a.CreateAccessToken(caller.NewContext(ctx, subject), "ci", 0, []string{"email"}, time.Time{})
+	require.NoError(t, err)
+
+	token, issued, expiresAt, err := a.ExchangeToken(ctx, pat, 1, "secret1", 2, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, []string{"email"}, issued.Scopes)
+	assert.Zero(t, issued.AccessTokenID)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+}
+
+func TestExchangeToken_ExpiringAccessToken(t *testing.T) {
+	a, st := newTestAuth(t)
+	subjectToken := exchangeSubject(t, a, st)
+	ctx := context.Background()
+
+	subject, err := a.VerifyToken(ctx, subjectToken)
+	require.NoError(t, err)
+
+	pat, created, err := a.CreateAccessToken(caller.NewContext(ctx, subject), "ci", 0, []string{"email"},
+		time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	// The token expires with the access token, not after the token TTL
+	_, _, expiresAt, err := a.ExchangeToken(ctx, pat, 1, "secret1", 2, nil)
+	require.NoError(t, err)
+	assert.False(t, expiresAt.After(created.ExpiresAt.Add(time.Second)))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expiresAt, 5*time.Second)
+}
@@ -0,0 +1,596 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/federation"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// federationTicketTTL is how long the user has to sign in at the provider
+const federationTicketTTL = 10 * time.Minute
+
+var (
+	// ErrFederationDisabled is returned by the federation APIs if the service has no identities storage
+	ErrFederationDisabled = errors.New("federation is not enabled")
+	ErrUnknownProvider    = errors.New("unknown identity provider")
+	// ErrInvalidLoginTicket is returned by CompleteFederatedLogin if the ticket is invalid, expired,
+	// of another app, or the state the provider sent back is not the one of the ticket
+	ErrInvalidLoginTicket = errors.New("invalid login ticket")
+	// ErrFederatedLoginFailed is returned by CompleteFederatedLogin if the provider rejects the code
+	// or returns an invalid identity
+	ErrFederatedLoginFailed = errors.New("federated login failed")
+	// ErrEmailNotVerified is returned if the account to link or sign up with has no email verified by the provider
+	ErrEmailNotVerified = errors.New("email is not verified by the provider")
+	// ErrSignupDisabled is returned by CompleteFederatedLogin if no user has the email of the account
+	// and the provider doesn't sign up new users
+	ErrSignupDisabled = errors.New("signup is not enabled for the provider")
+	// ErrIdentityLinked is returned if the account is linked to another user,
+	// or the user has another account of the provider linked
+	ErrIdentityLinked   = errors.New("identity is linked to another user")
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrLastIdentity is returned by UnlinkIdentity if the user would have no way to sign in left
+	ErrLastIdentity = errors.New("last sign-in method")
+)
+
+// Identities keeps the accounts at the upstream identity providers linked to the users
+type Identities interface {
+	SaveIdentity(ctx context.Context, identity models.Identity) (identityID int64, err error)
+	Identity(ctx context.Context, tenantID int64, provider string, subject string) (models.Identity, error)
+	UserIdentities(ctx context.Context, userID int64) ([]models.Identity, error)
+	DeleteIdentity(ctx context.Context, userID int64, provider string) error
+}
+
+// Upstream signs the users in at an upstream identity provider, see federation.Provider
+type Upstream interface {
+	AuthCodeURL(ctx context.Context, login federation.Login) (string, error)
+	Exchange(ctx context.Context, code string, login federation.Login) (federation.Identity, error)
+}
+
+// FederationProvider is an upstream identity provider the users sign in with
+type FederationProvider struct {
+	Name     string
+	Upstream Upstream
+	// TenantID limits the provider to the apps of the tenant, e.g. the corporate IdP to the staff, any if zero
+	TenantID int64
+	// Signup provisions the users signing in with an account not linked yet and an email not registered
+	Signup bool
+}
+
+// FederatedLogin is the result of CompleteFederatedLogin
+type FederatedLogin struct {
+	Token  string
+	UserID int64
+	// Created tells the user was provisioned at the login
+	Created bool
+	// Linked tells the account was linked to the user at the login
+	Linked bool
+}
+
+// WithFederation enables the sign in with the upstream identity providers.
+// The ticketKey signs the login tickets, unlike the app secrets it must not be known to the apps
+func WithFederation(identities Identities, ticketKey string, providers ...FederationProvider) Option {
+	return func(a *Auth) {
+		a.identities = identities
+		a.ticketKey = ticketKey
+		a.providers = make(map[string]FederationProvider, len(providers))
+
+		for _, p := range providers {
+			a.providers[p.Name] = p
+		}
+	}
+}
+
+// StartFederatedLogin starts the login of a user of the app at the provider. It returns the URL of the
+// provider to send the user to, and the ticket the app keeps until the provider sends the user back
+// and passes to CompleteFederatedLogin. With link the account is linked to the caller instead
+func (a *Auth) StartFederatedLogin(
+	ctx context.Context,
+	provider string,
+	appID int,
+	link bool,
+) (authURL string, ticket string, err error) {
+	const op = "auth.StartFederatedLogin"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.String("provider", provider),
+		slog.Int("appID", appID))
+
+	p, err := a.federationProvider(provider)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", slog.String("error", err.Error()))
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tenantID, ok := clientinfo.TenantID(ctx); ok && tenantID != app.TenantID {
+		return "", "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+	}
+
+	// The providers of other tenants are not told apart from the unknown ones
+	if p.TenantID != 0 && p.TenantID != app.TenantID {
+		return "", "", fmt.Errorf("%s: %w", op, ErrUnknownProvider)
+	}
+
+	t := federation.Ticket{Provider: p.Name, AppID: app.ID}
+
+	if link {
+		c, err := requireUser(ctx)
+		if err != nil {
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		if c.TenantID != app.TenantID {
+			return "", "", fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		t.UserID = c.UserID
+	}
+
+	t.Login, err = federation.NewLogin()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	authURL, err = p.Upstream.AuthCodeURL(ctx, t.Login)
+	if err != nil {
+		log.Error("failed to get auth URL", slog.String("error", err.Error()))
+
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	ticket, err = federation.SignTicket(t, a.ticketKey, federationTicketTTL)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("Federated login started", slog.Bool("link", link))
+
+	return authURL, ticket, nil
+}
+
+// CompleteFederatedLogin exchanges the code the provider sent the user back with for a token of the app.
+// The account at the provider is looked up in the tenant of the app:
+//   - the user it is linked to signs in
+//   - otherwise it is linked to the user with its email, only if the provider verified the email
+//   - otherwise a user without a password is provisioned, if the provider signs up new users
+//
+// If the login was started to link the account, it is linked to that user, with a verified email too
+func (a *Auth) CompleteFederatedLogin(
+	ctx context.Context,
+	appID int,
+	code string,
+	state string,
+	ticket string,
+) (login FederatedLogin, err error) {
+	const op = "auth.CompleteFederatedLogin"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int("appID", appID))
+
+	if a.identities == nil {
+		return FederatedLogin{}, fmt.Errorf("%s: %w", op, ErrFederationDisabled)
+	}
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return FederatedLogin{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", slog.String("error", err.Error()))
+
+		return FederatedLogin{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The ticket names the user to link the account to, an app must not be able to sign one
+	t, err := federation.ParseTicket(ticket, a.ticketKey)
+	if err != nil {
+		log.Warn("invalid login ticket", slog.String("error", err.Error()))
+
+		return FederatedLogin{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidLoginTicket, err)
+	}
+
+	// The state ties the callback to the browser the login was started in
+	if t.AppID != app.ID || subtle.ConstantTimeCompare([]byte(t.State), []byte(state)) != 1 {
+		log.Warn("login ticket of another login")
+
+		return FederatedLogin{}, fmt.Errorf("%s: %w", op, ErrInvalidLoginTicket)
+	}
+
+	p, err := a.federationProvider(t.Provider)
+	if err != nil {
+		return FederatedLogin{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.String("provider", p.Name))
+
+	identity, err := p.Upstream.Exchange(ctx, code, t.Login)
+	if err != nil {
+		log.Warn("failed to exchange code", slog.String("error", err.Error()))
+
+		return FederatedLogin{}, fmt.Errorf("%s: %w: %w", op, ErrFederatedLoginFailed, err)
+	}
+
+	var user models.User
+
+	if t.UserID != 0 {
+		user, login.Linked, err = a.linkIdentity(ctx, p, t.UserID, identity)
+	} else {
+		user, login.Created, login.Linked, err = a.federatedUser(ctx, app, p, identity)
+	}
+	if err != nil {
+		log.Warn("failed to resolve user", slog.String("error", err.Error()))
+
+		return FederatedLogin{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.Disabled {
+		log.Info("user is disabled")
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonUserDisabled).Inc()
+		a.auditLoginFailed(ctx, user, appID, models.AuditReasonUserDisabled)
+
+		return FederatedLogin{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+	}
+
+	login.UserID = user.ID
+
+	login.Token, err = a.federatedToken(ctx, user, app)
+	if err != nil {
+		log.Error("failed to issue token", slog.String("error", err.Error()))
+
+		return FederatedLogin{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("User federated login successful", slog.Int64("userID", user.ID))
+	metrics.Logins.Inc()
+	metrics.TokensIssued.WithLabelValues(strconv.Itoa(app.ID)).Inc()
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditLogin,
+		TenantID: user.TenantID,
+		ActorID:  user.ID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		AppID:    app.ID,
+		Reason:   p.Name,
+	})
+
+	return login, nil
+}
+
+// ListIdentities returns the accounts linked to the caller ordered by provider
+func (a *Auth) ListIdentities(ctx context.Context) (identities []models.Identity, err error) {
+	const op = "auth.ListIdentities"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	if a.identities == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrFederationDisabled)
+	}
+
+	identities, err = a.identities.UserIdentities(ctx, c.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return identities, nil
+}
+
+// UnlinkIdentity unlinks the account of the provider from the caller.
+// The last account of a user without a password stays, so the user can still sign in
+func (a *Auth) UnlinkIdentity(ctx context.Context, provider string) (err error) {
+	const op = "auth.UnlinkIdentity"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.String("provider", provider))
+
+	c, err := requireUser(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if a.identities == nil {
+		return fmt.Errorf("%s: %w", op, ErrFederationDisabled)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, c.UserID)
+	if err != nil {
+		log.Error("failed to get user", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(user.PassHash) == 0 {
+		identities, err := a.identities.UserIdentities(ctx, user.ID)
+		if err != nil {
+			log.Error("failed to get identities", slog.String("error", err.Error()))
+
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if !hasOtherIdentity(identities, provider) {
+			return fmt.Errorf("%s: %w", op, ErrLastIdentity)
+		}
+	}
+
+	if err := a.identities.DeleteIdentity(ctx, user.ID, provider); err != nil {
+		if errors.Is(err, storage.ErrIdentityNotFound) {
+			return fmt.Errorf("%s: %w", op, ErrIdentityNotFound)
+		}
+
+		log.Error("failed to delete identity", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("Identity unlinked")
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditIdentityUnlink,
+		TenantID: user.TenantID,
+		ActorID:  user.ID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		AppID:    c.AppID,
+		Reason:   provider,
+	})
+
+	return nil
+}
+
+// federationProvider returns the provider by name
+func (a *Auth) federationProvider(name string) (FederationProvider, error) {
+	if a.identities == nil {
+		return FederationProvider{}, ErrFederationDisabled
+	}
+
+	p, ok := a.providers[name]
+	if !ok {
+		return FederationProvider{}, ErrUnknownProvider
+	}
+
+	return p, nil
+}
+
+// federatedUser returns the user the account signs in as in the tenant of the app, see CompleteFederatedLogin
+func (a *Auth) federatedUser(
+	ctx context.Context,
+	app models.App,
+	p FederationProvider,
+	identity federation.Identity,
+) (user models.User, created bool, linked bool, err error) {
+	link, err := a.identities.Identity(ctx, app.TenantID, p.Name, identity.Subject)
+	if err == nil {
+		user, err = a.userProvider.UserByID(ctx, link.UserID)
+
+		return user, false, false, err
+	}
+
+	if !errors.Is(err, storage.ErrIdentityNotFound) {
+		return models.User{}, false, false, err
+	}
+
+	// Otherwise anyone registering the email of a user at the provider would take the user over
+	if identity.Email == "" || !identity.EmailVerified {
+		return models.User{}, false, false, ErrEmailNotVerified
+	}
+
+	user, err = a.userProvider.User(ctx, app.TenantID, identity.Email)
+	if err == nil {
+		return user, false, true, a.saveIdentity(ctx, user, federatedIdentity(p, identity))
+	}
+
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return models.User{}, false, false, err
+	}
+
+	if !p.Signup {
+		return models.User{}, false, false, ErrSignupDisabled
+	}
+
+	user, err = a.provisionUser(ctx, app, federatedIdentity(p, identity))
+	if err != nil {
+		return models.User{}, false, false, err
+	}
+
+	return user, true, false, nil
+}
+
+// linkIdentity links the account to the user who started the login to link it
+func (a *Auth) linkIdentity(
+	ctx context.Context,
+	p FederationProvider,
+	userID int64,
+	identity federation.Identity,
+) (user models.User, linked bool, err error) {
+	user, err = a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.User{}, false, ErrUserNotFound
+		}
+
+		return models.User{}, false, err
+	}
+
+	if !identity.EmailVerified {
+		return models.User{}, false, ErrEmailNotVerified
+	}
+
+	link, err := a.identities.Identity(ctx, user.TenantID, p.Name, identity.Subject)
+	if err == nil {
+		if link.UserID != user.ID {
+			return models.User{}, false, ErrIdentityLinked
+		}
+
+		return user, false, nil
+	}
+
+	if !errors.Is(err, storage.ErrIdentityNotFound) {
+		return models.User{}, false, err
+	}
+
+	if err := a.saveIdentity(ctx, user, federatedIdentity(p, identity)); err != nil {
+		return models.User{}, false, err
+	}
+
+	return user, true, nil
+}
+
+// saveIdentity links the account of the provider to the user
+func (a *Auth) saveIdentity(ctx context.Context, user models.User, identity models.Identity) error {
+	identity.TenantID = user.TenantID
+	identity.UserID = user.ID
+	identity.CreatedAt = time.Now()
+
+	_, err := a.identities.SaveIdentity(ctx, identity)
+	if err != nil {
+		if errors.Is(err, storage.ErrIdentityExists) {
+			return ErrIdentityLinked
+		}
+
+		return err
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditIdentityLink,
+		TenantID: user.TenantID,
+		ActorID:  user.ID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		Reason:   identity.Provider,
+	})
+
+	return nil
+}
+
+// provisionUser registers the user of the account with its email in the tenant of the app, just in time
+// for the login. The user has no password, Login refuses every local password of the user
+func (a *Auth) provisionUser(ctx context.Context, app models.App, identity models.Identity) (models.User, error) {
+	user := models.User{TenantID: app.TenantID, Email: identity.Email, PassHash: []byte{}}
+
+	err := a.withTx(ctx, func(ctx context.Context) error {
+		var err error
+
+		user.ID, err = a.userSaver.SaveUser(ctx, user.TenantID, user.Email, user.PassHash)
+		if err != nil {
+			return err
+		}
+
+		identity.TenantID = user.TenantID
+		identity.UserID = user.ID
+		identity.CreatedAt = time.Now()
+
+		_, err = a.identities.SaveIdentity(ctx, identity)
+		if err != nil {
+			return err
+		}
+
+		return a.emit(ctx, models.EventUserRegistered, user.TenantID, 0, webhook.UserData{
+			UserID: user.ID,
+			Email:  user.Email,
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUserExists):
+			return models.User{}, ErrUserExists
+		case errors.Is(err, storage.ErrIdentityExists):
+			return models.User{}, ErrIdentityLinked
+		default:
+			return models.User{}, err
+		}
+	}
+
+	metrics.Registrations.Inc()
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditRegister,
+		TenantID: user.TenantID,
+		ActorID:  user.ID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		AppID:    app.ID,
+		Reason:   identity.Provider,
+	})
+
+	return user, nil
+}
+
+// federatedToken issues the token of the user for the app, like Login without scopes
+func (a *Auth) federatedToken(ctx context.Context, user models.User, app models.App) (string, error) {
+	c := models.Caller{UserID: user.ID, TenantID: user.TenantID, Email: user.Email, AppID: app.ID}
+
+	if a.permissions != nil {
+		perms, err := a.permissions.UserPermissions(ctx, user.ID, app.ID)
+		if err != nil {
+			return "", err
+		}
+
+		c.Roles = perms.Roles
+	}
+
+	if a.sessions != nil {
+		sessionID, err := a.startSession(ctx, user, app)
+		if err != nil {
+			return "", err
+		}
+
+		c.SessionID = sessionID
+	}
+
+	return jwt.NewToken(c, app.Secret, a.tokenTTL)
+}
+
+// federatedIdentity is the identity linking the account at the provider
+func federatedIdentity(p FederationProvider, identity federation.Identity) models.Identity {
+	return models.Identity{Provider: p.Name, Subject: identity.Subject, Email: identity.Email}
+}
+
+// hasOtherIdentity tells if an account of another provider than the one is linked
+func hasOtherIdentity(identities []models.Identity, provider string) bool {
+	for _, identity := range identities {
+		if identity.Provider != provider {
+			return true
+		}
+	}
+
+	return false
+}
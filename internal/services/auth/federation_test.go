@@ -0,0 +1,408 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/federation"
+	"grpc-sso/internal/lib/federation/federationtest"
+	"grpc-sso/internal/storage/memory"
+	"testing"
+	"time"
+)
+
+// testTicketKey signs the login tickets, the apps don't know it
+const testTicketKey = "federation-ticket-key-of-the-tests"
+
+// newFederationAuth is newTestAuth with the providers at the mock IdP: google signs up new users,
+// corp is limited to tenant 2 and doesn't, and github has no OpenID Connect
+func newFederationAuth(t *testing.T) (*Auth, *memory.Storage, *federationtest.IdP) {
+	t.Helper()
+
+	idp := federationtest.New(t)
+
+	newProvider := func(opts federation.Options) Upstream {
+		opts.ClientID = federationtest.ClientID
+		opts.ClientSecret = federationtest.ClientSecret
+		opts.RedirectURL = "https://app.example.com/callback"
+
+		p, err := federation.New(opts, idp.Client())
+		require.NoError(t, err)
+
+		return p
+	}
+
+	a, st := newTestAuth(t)
+	WithFederation(st, testTicketKey,
+		FederationProvider{Name: "google", Upstream: newProvider(federation.Options{Issuer: idp.URL}), Signup: true},
+		FederationProvider{Name: "corp", Upstream: newProvider(federation.Options{Issuer: idp.URL}), TenantID: 2},
+		FederationProvider{Name: "github", Upstream: newProvider(federation.Options{
+			AuthURL:     idp.URL + "/authorize",
+			TokenURL:    idp.URL + "/token",
+			UserInfoURL: idp.URL + "/user",
+			EmailsURL:   idp.URL + "/user/emails",
+		}), Signup: true},
+	)(a)
+
+	return a, st, idp
+}
+
+// federatedLogin signs the account in at the provider for the app, as the caller of ctx if link is set
+func federatedLogin(
+	t *testing.T,
+	a *Auth,
+	idp *federationtest.IdP,
+	ctx context.Context,
+	account federationtest.Account,
+	provider string,
+	appID int,
+	link bool,
+) (FederatedLogin, error) {
+	t.Helper()
+
+	authURL, ticket, err := a.StartFederatedLogin(ctx, provider, appID, link)
+	require.NoError(t, err)
+
+	idp.SignIn(account)
+	callback := idp.Authorize(t, authURL)
+
+	return a.CompleteFederatedLogin(context.Background(), appID, callback.Get("code"), callback.Get("state"), ticket)
+}
+
+func TestFederatedLogin_Signup(t *testing.T) {
+	a, st, idp := newFederationAuth(t)
+	ctx := context.Background()
+
+	account := federationtest.Account{Subject: "g-1", Email: "new@example.com", EmailVerified: true}
+
+	login, err := federatedLogin(t, a, idp, ctx, account, "google", 1, false)
+	require.NoError(t, err)
+	assert.True(t, login.Created)
+	assert.False(t, login.Linked)
+
+	c, err := a.VerifyToken(ctx, login.Token)
+	require.NoError(t, err)
+	assert.Equal(t, login.UserID, c.UserID)
+	assert.Equal(t, "new@example.com", c.Email)
+	assert.Equal(t, 1, c.AppID)
+	assert.NotEmpty(t, c.SessionID)
+
+	identities, err := a.ListIdentities(caller.NewContext(ctx, c))
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "google", identities[0].Provider)
+	assert.Equal(t, "g-1", identities[0].Subject)
+
+	events, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditRegister}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "google", events[0].Reason)
+	// One event per app with a webhook
+	assert.Len(t, payloads(t, st, models.EventUserRegistered), 2)
+
+	// The account signs in as the same user, even with another email at the provider
+	account.Email = "renamed@example.com"
+
+	again, err := federatedLogin(t, a, idp, ctx, account, "google", 2, false)
+	require.NoError(t, err)
+	assert.Equal(t, login.UserID, again.UserID)
+	assert.False(t, again.Created)
+	assert.False(t, again.Linked)
+
+	// The user has no password
+	_, err = a.Login(ctx, "new@example.com", "", 1)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestFederatedLogin_LinkByEmail(t *testing.T) {
+	a, st, idp := newFederationAuth(t)
+	ctx := context.Background()
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 1)
+	require.NoError(t, err)
+
+	// An email the provider didn't verify could be anyone's
+	unverified := federationtest.Account{Subject: "g-1", Email: "user@example.com"}
+
+	_, err = federatedLogin(t, a, idp, ctx, unverified, "google", 1, false)
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+
+	verified := federationtest.Account{Subject: "g-1", Email: "user@example.com", EmailVerified: true}
+
+	login, err := federatedLogin(t, a, idp, ctx, verified, "google", 1, false)
+	require.NoError(t, err)
+	assert.Equal(t, userID, login.UserID)
+	assert.True(t, login.Linked)
+	assert.False(t, login.Created)
+
+	events, err := st.AuditEvents(ctx, models.AuditFilter{UserID: userID, Types: []string{models.AuditIdentityLink}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "google", events[0].Reason)
+
+	// The GitHub account has the verified email among its emails
+	github := federationtest.Account{Subject: "583231", Email: "user@example.com", EmailVerified: true}
+
+	login, err = federatedLogin(t, a, idp, ctx, github, "github", 1, false)
+	require.NoError(t, err)
+	assert.Equal(t, userID, login.UserID)
+	assert.True(t, login.Linked)
+
+	// The password still works
+	_, err = a.Login(ctx, "user@example.com", "password", 1)
+	require.NoError(t, err)
+}
+
+func TestFederatedLogin_FailCases(t *testing.T) {
+	a, st, idp := newFederationAuth(t)
+	ctx := context.Background()
+
+	disabledID, err := a.RegisterNewUser(ctx, "disabled@example.com", "password", 1)
+	require.NoError(t, err)
+	require.NoError(t, st.DisableUser(ctx, disabledID))
+
+	tests := []struct {
+		name     string
+		account  federationtest.Account
+		provider string
+		appID    int
+		wantErr  error
+	}{
+		{name: "unverified email", account: federationtest.Account{Subject: "g-1", Email: "new@example.com"},
+			provider: "google", appID: 1, wantErr: ErrEmailNotVerified},
+		{name: "no email", account: federationtest.Account{Subject: "g-1", EmailVerified: true},
+			provider: "google", appID: 1, wantErr: ErrEmailNotVerified},
+		{name: "signup disabled", account: federationtest.Account{Subject: "c-1", Email: "staff@example.com",
+			EmailVerified: true}, provider: "corp", appID: 4, wantErr: ErrSignupDisabled},
+		{name: "disabled user", account: federationtest.Account{Subject: "g-2", Email: "disabled@example.com",
+			EmailVerified: true}, provider: "google", appID: 1, wantErr: ErrUserDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := federatedLogin(t, a, idp, ctx, tt.account, tt.provider, tt.appID, false)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("unknown provider", func(t *testing.T) {
+		_, _, err := a.StartFederatedLogin(ctx, "facebook", 1, false)
+		assert.ErrorIs(t, err, ErrUnknownProvider)
+
+		// The provider of another tenant
+		_, _, err = a.StartFederatedLogin(ctx, "corp", 1, false)
+		assert.ErrorIs(t, err, ErrUnknownProvider)
+	})
+
+	t.Run("unknown app", func(t *testing.T) {
+		_, _, err := a.StartFederatedLogin(ctx, "google", 404, false)
+		assert.ErrorIs(t, err, ErrInvalidAppID)
+	})
+
+	t.Run("app of another tenant", func(t *testing.T) {
+		_, _, err := a.StartFederatedLogin(withTenant(ctx, "2"), "google", 1, false)
+		assert.ErrorIs(t, err, ErrInvalidAppID)
+	})
+
+	t.Run("link unauthenticated", func(t *testing.T) {
+		_, _, err := a.StartFederatedLogin(ctx, "google", 1, true)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	authURL, ticket, err := a.StartFederatedLogin(ctx, "google", 1, false)
+	require.NoError(t, err)
+
+	idp.SignIn(federationtest.Account{Subject: "g-3", Email: "other@example.com", EmailVerified: true})
+	callback := idp.Authorize(t, authURL)
+
+	t.Run("state of another login", func(t *testing.T) {
+		_, err := a.CompleteFederatedLogin(ctx, 1, callback.Get("code"), "forged", ticket)
+		assert.ErrorIs(t, err, ErrInvalidLoginTicket)
+	})
+
+	t.Run("ticket of another app", func(t *testing.T) {
+		_, err := a.CompleteFederatedLogin(ctx, 2, callback.Get("code"), callback.Get("state"), ticket)
+		assert.ErrorIs(t, err, ErrInvalidLoginTicket)
+	})
+
+	t.Run("invalid ticket", func(t *testing.T) {
+		_, err := a.CompleteFederatedLogin(ctx, 1, callback.Get("code"), callback.Get("state"), "not.a.ticket")
+		assert.ErrorIs(t, err, ErrInvalidLoginTicket)
+	})
+
+	t.Run("invalid code", func(t *testing.T) {
+		_, err := a.CompleteFederatedLogin(ctx, 1, "forged", callback.Get("state"), ticket)
+		assert.ErrorIs(t, err, ErrFederatedLoginFailed)
+	})
+
+	t.Run("federation disabled", func(t *testing.T) {
+		a, _ := newTestAuth(t)
+
+		_, _, err := a.StartFederatedLogin(ctx, "google", 1, false)
+		assert.ErrorIs(t, err, ErrFederationDisabled)
+
+		_, err = a.CompleteFederatedLogin(ctx, 1, callback.Get("code"), callback.Get("state"), ticket)
+		assert.ErrorIs(t, err, ErrFederationDisabled)
+	})
+}
+
+func TestFederatedLogin_Tenants(t *testing.T) {
+	a, _, idp := newFederationAuth(t)
+	ctx := context.Background()
+
+	staffID, err := a.RegisterNewUser(ctx, "staff@example.com", "password", 4)
+	require.NoError(t, err)
+
+	account := federationtest.Account{Subject: "c-1", Email: "staff@example.com", EmailVerified: true}
+
+	login, err := federatedLogin(t, a, idp, ctx, account, "corp", 4, false)
+	require.NoError(t, err)
+	assert.Equal(t, staffID, login.UserID)
+	assert.True(t, login.Linked)
+
+	// The same account is another user in the default tenant
+	account.Subject = "g-1"
+
+	staff, err := federatedLogin(t, a, idp, ctx, account, "google", 4, false)
+	require.NoError(t, err)
+	assert.Equal(t, staffID, staff.UserID)
+
+	customer, err := federatedLogin(t, a, idp, ctx, account, "google", 1, false)
+	require.NoError(t, err)
+	assert.NotEqual(t, staffID, customer.UserID)
+	assert.True(t, customer.Created)
+}
+
+func TestLinkIdentity(t *testing.T) {
+	a, _, idp := newFederationAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "user@example.com", "password", 1)
+	require.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "other@example.com", "password", 1)
+	require.NoError(t, err)
+
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+	other, _ := login(t, a, ctx, "other@example.com", 1)
+	userCtx := caller.NewContext(ctx, user)
+
+	// The account has another email, but a verified one
+	account := federationtest.Account{Subject: "583231", Email: "octocat@example.com", EmailVerified: true}
+
+	linked, err := federatedLogin(t, a, idp, userCtx, account, "github", 1, true)
+	require.NoError(t, err)
+	assert.Equal(t, user.UserID, linked.UserID)
+	assert.True(t, linked.Linked)
+	assert.NotEmpty(t, linked.Token)
+
+	// Linking it again changes nothing
+	linked, err = federatedLogin(t, a, idp, userCtx, account, "github", 1, true)
+	require.NoError(t, err)
+	assert.False(t, linked.Linked)
+
+	// and the account signs in as the user
+	signedIn, err := federatedLogin(t, a, idp, ctx, account, "github", 1, false)
+	require.NoError(t, err)
+	assert.Equal(t, user.UserID, signedIn.UserID)
+
+	t.Run("linked to another user", func(t *testing.T) {
+		_, err := federatedLogin(t, a, idp, caller.NewContext(ctx, other), account, "github", 1, true)
+		assert.ErrorIs(t, err, ErrIdentityLinked)
+	})
+
+	t.Run("another account of the provider", func(t *testing.T) {
+		second := federationtest.Account{Subject: "1", Email: "second@example.com", EmailVerified: true}
+
+		_, err := federatedLogin(t, a, idp, userCtx, second, "github", 1, true)
+		assert.ErrorIs(t, err, ErrIdentityLinked)
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		unverified := federationtest.Account{Subject: "g-1", Email: "user@example.com"}
+
+		_, err := federatedLogin(t, a, idp, userCtx, unverified, "google", 1, true)
+		assert.ErrorIs(t, err, ErrEmailNotVerified)
+	})
+
+	t.Run("ticket signed with the app secret", func(t *testing.T) {
+		// The app forges the ticket of a link to the other user, to sign in as it with its own account
+		forged := federation.Ticket{Provider: "google", AppID: 1, UserID: other.UserID}
+		forged.Login, err = federation.NewLogin()
+		require.NoError(t, err)
+
+		authURL, err := a.providers["google"].Upstream.AuthCodeURL(ctx, forged.Login)
+		require.NoError(t, err)
+
+		idp.SignIn(federationtest.Account{Subject: "g-9", Email: "attacker@example.com", EmailVerified: true})
+		callback := idp.Authorize(t, authURL)
+
+		ticket, err := federation.SignTicket(forged, "secret", time.Minute)
+		require.NoError(t, err)
+
+		_, err = a.CompleteFederatedLogin(ctx, 1, callback.Get("code"), callback.Get("state"), ticket)
+		assert.ErrorIs(t, err, ErrInvalidLoginTicket)
+
+		identities, err := a.ListIdentities(caller.NewContext(ctx, other))
+		require.NoError(t, err)
+		assert.Empty(t, identities)
+	})
+
+	t.Run("impersonated", func(t *testing.T) {
+		impersonated := user
+		impersonated.Actor = &models.Actor{UserID: other.UserID, Email: other.Email}
+
+		_, _, err := a.StartFederatedLogin(caller.NewContext(ctx, impersonated), "google", 1, true)
+		assert.ErrorIs(t, err, ErrImpersonated)
+	})
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	a, st, idp := newFederationAuth(t)
+	ctx := context.Background()
+
+	account := federationtest.Account{Subject: "g-1", Email: "new@example.com", EmailVerified: true}
+
+	signedUp, err := federatedLogin(t, a, idp, ctx, account, "google", 1, false)
+	require.NoError(t, err)
+
+	c, err := a.VerifyToken(ctx, signedUp.Token)
+	require.NoError(t, err)
+	userCtx := caller.NewContext(ctx, c)
+
+	// The provisioned user has no password, the only account stays linked
+	assert.ErrorIs(t, a.UnlinkIdentity(userCtx, "google"), ErrLastIdentity)
+	assert.ErrorIs(t, a.UnlinkIdentity(userCtx, "github"), ErrIdentityNotFound)
+
+	_, err = federatedLogin(t, a, idp, userCtx,
+		federationtest.Account{Subject: "583231", Email: "new@example.com", EmailVerified: true}, "github", 1, true)
+	require.NoError(t, err)
+
+	require.NoError(t, a.UnlinkIdentity(userCtx, "google"))
+
+	identities, err := a.ListIdentities(userCtx)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "github", identities[0].Provider)
+
+	events, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditIdentityUnlink}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "google", events[0].Reason)
+
+	// The users with a password unlink every account
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "password", 1)
+	require.NoError(t, err)
+
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+	userCtx = caller.NewContext(ctx, user)
+
+	_, err = federatedLogin(t, a, idp, ctx,
+		federationtest.Account{Subject: "g-2", Email: "user@example.com", EmailVerified: true}, "google", 1, false)
+	require.NoError(t, err)
+
+	require.NoError(t, a.UnlinkIdentity(userCtx, "google"))
+
+	assert.ErrorIs(t, a.UnlinkIdentity(ctx, "google"), ErrUnauthenticated)
+}
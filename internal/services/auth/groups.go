@@ -0,0 +1,430 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+)
+
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupExists   = errors.New("group already exists")
+	ErrGroupCycle    = errors.New("group would be nested in itself")
+	ErrInvalidMember = errors.New("member must be a user or a group")
+	ErrInvalidRole   = errors.New("invalid role")
+	// ErrGroupsDisabled is returned by the group APIs if the service has no groups storage
+	ErrGroupsDisabled = errors.New("groups are not enabled")
+)
+
+// Groups keeps the user groups, their members and the roles granted to them
+type Groups interface {
+	SaveGroup(ctx context.Context, tenantID int64, name string) (groupID int64, err error)
+	Group(ctx context.Context, groupID int64) (models.Group, error)
+	Groups(ctx context.Context, tenantID int64) ([]models.Group, error)
+	DeleteGroup(ctx context.Context, groupID int64) error
+	AddGroupUser(ctx context.Context, groupID int64, userID int64) error
+	RemoveGroupUser(ctx context.Context, groupID int64, userID int64) error
+	AddSubgroup(ctx context.Context, groupID int64, subgroupID int64) error
+	RemoveSubgroup(ctx context.Context, groupID int64, subgroupID int64) error
+	GrantGroupRole(ctx context.Context, groupID int64, role models.GroupRole) error
+	RevokeGroupRole(ctx context.Context, groupID int64, role models.GroupRole) error
+}
+
+// PermissionsProvider expands the group membership of the user into its effective permissions
+type PermissionsProvider interface {
+	UserPermissions(ctx context.Context, userID int64, appID int) (models.Permissions, error)
+}
+
+// WithGroups enables the groups: admins manage them, Login puts the roles granted through
+// them into the token. The permissions are usually the cached storage, see storage/cached
+func WithGroups(groups Groups, permissions PermissionsProvider) Option {
+	return func(a *Auth) {
+		a.groups = groups
+		a.permissions = permissions
+	}
+}
+
+// CreateGroup creates an empty group in the tenant of the admin and returns its ID.
+// The name is unique within the tenant, a taken one is ErrGroupExists
+func (a *Auth) CreateGroup(ctx context.Context, name string) (groupID int64, err error) {
+	const op = "auth.CreateGroup"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	admin, err := a.requireGroupsAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to create group", slog.String("error", err.Error()))
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	groupID, err = a.groups.SaveGroup(ctx, admin.TenantID, name)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, a.groupError(log, err))
+	}
+
+	log.Info("Group created", slog.Int64("groupID", groupID))
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditAdminAction,
+		ActorID: admin.UserID,
+		AppID:   admin.AppID,
+		Reason:  "create_group",
+	})
+
+	return groupID, nil
+}
+
+// ListGroups returns the groups of the tenant of the admin ordered by name, without their members
+func (a *Auth) ListGroups(ctx context.Context) (groups []models.Group, err error) {
+	const op = "auth.ListGroups"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	admin, err := a.requireGroupsAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to list groups", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	groups, err = a.groups.Groups(ctx, admin.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, a.groupError(log, err))
+	}
+
+	return groups, nil
+}
+
+// GetGroup returns the group with its direct members and roles. Admin of the group's tenant only
+func (a *Auth) GetGroup(ctx context.Context, groupID int64) (group models.Group, err error) {
+	const op = "auth.GetGroup"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int64("groupID", groupID))
+
+	admin, err := a.requireGroupsAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to get group", slog.String("error", err.Error()))
+
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	group, err = a.tenantGroup(ctx, admin, groupID)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("%s: %w", op, a.groupError(log, err))
+	}
+
+	return group, nil
+}
+
+// DeleteGroup deletes the group, its members lose the roles granted through it
+func (a *Auth) DeleteGroup(ctx context.Context, groupID int64) (err error) {
+	const op = "auth.DeleteGroup"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = a.updateGroup(ctx, op, groupID, "delete_group", 0, func(ctx context.Context, _ models.Caller) error {
+		return a.groups.DeleteGroup(ctx, groupID)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AddGroupMember adds the user or nests the group in the group, both of the same tenant.
+// Adding a member twice does nothing, nesting a group in itself is ErrGroupCycle
+func (a *Auth) AddGroupMember(ctx context.Context, groupID int64, member models.GroupMember) (err error) {
+	const op = "auth.AddGroupMember"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = a.updateGroup(ctx, op, groupID, "add_group_member", member.UserID,
+		func(ctx context.Context, admin models.Caller) error {
+			if err := a.checkMember(ctx, admin, member); err != nil {
+				return err
+			}
+
+			if member.UserID != 0 {
+				return a.groups.AddGroupUser(ctx, groupID, member.UserID)
+			}
+
+			return a.groups.AddSubgroup(ctx, groupID, member.SubgroupID)
+		})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveGroupMember removes the user or the nested group from the group, removing a non-member does nothing
+func (a *Auth) RemoveGroupMember(ctx context.Context, groupID int64, member models.GroupMember) (err error) {
+	const op = "auth.RemoveGroupMember"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = a.updateGroup(ctx, op, groupID, "remove_group_member", member.UserID,
+		func(ctx context.Context, _ models.Caller) error {
+			if (member.UserID == 0) == (member.SubgroupID == 0) {
+				return ErrInvalidMember
+			}
+
+			if member.UserID != 0 {
+				return a.groups.RemoveGroupUser(ctx, groupID, member.UserID)
+			}
+
+			return a.groups.RemoveSubgroup(ctx, groupID, member.SubgroupID)
+		})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// GrantGroupRole grants the role in the app of the tenant to the group and so to all its members,
+// those of the nested groups included
+func (a *Auth) GrantGroupRole(ctx context.Context, groupID int64, role models.GroupRole) (err error) {
+	const op = "auth.GrantGroupRole"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = a.updateGroup(ctx, op, groupID, "grant_group_role", 0, func(ctx context.Context, admin models.Caller) error {
+		if role.Role == "" {
+			return ErrInvalidRole
+		}
+
+		if err := a.checkTenantApp(ctx, admin, role.AppID); err != nil {
+			return err
+		}
+
+		return a.groups.GrantGroupRole(ctx, groupID, role)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeGroupRole revokes the role in the app from the group, revoking a missing role does nothing
+func (a *Auth) RevokeGroupRole(ctx context.Context, groupID int64, role models.GroupRole) (err error) {
+	const op = "auth.RevokeGroupRole"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = a.updateGroup(ctx, op, groupID, "revoke_group_role", 0, func(ctx context.Context, _ models.Caller) error {
+		return a.groups.RevokeGroupRole(ctx, groupID, role)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// GetUserPermissions returns the effective groups and roles of the user in the app.
+// Users get their own, admins those of the users of their tenant. The app is the caller's one if not set
+func (a *Auth) GetUserPermissions(ctx context.Context, userID int64, appID int) (perms models.Permissions, err error) {
+	const op = "auth.GetUserPermissions"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int64("userID", userID))
+
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return models.Permissions{}, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	if c.TenantID == 0 {
+		c.TenantID = models.DefaultTenantID
+	}
+
+	if c.UserID != userID {
+		admin, err := a.requireAdmin(ctx)
+		if err != nil {
+			log.Warn("not allowed to get permissions", slog.String("error", err.Error()))
+
+			return models.Permissions{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if err := a.checkTenantUser(ctx, admin, userID); err != nil {
+			return models.Permissions{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if a.permissions == nil {
+		return models.Permissions{}, nil
+	}
+
+	if appID == 0 {
+		appID = c.AppID
+	}
+
+	if err := a.checkTenantApp(ctx, c, appID); err != nil {
+		return models.Permissions{}, fmt.Errorf("%s: %w", op, a.groupError(log, err))
+	}
+
+	perms, err = a.permissions.UserPermissions(ctx, userID, appID)
+	if err != nil {
+		log.Error("failed to get permissions", slog.String("error", err.Error()))
+
+		return models.Permissions{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return perms, nil
+}
+
+// requireGroupsAdmin is requireAdmin for the group APIs
+func (a *Auth) requireGroupsAdmin(ctx context.Context) (models.Caller, error) {
+	if a.groups == nil {
+		return models.Caller{}, ErrGroupsDisabled
+	}
+
+	return a.requireAdmin(ctx)
+}
+
+// updateGroup runs the change of the group in a transaction once the caller is checked to be
+// an admin of its tenant, then drops the cached permissions and audits the change
+func (a *Auth) updateGroup(
+	ctx context.Context,
+	op string,
+	groupID int64,
+	reason string,
+	userID int64,
+	change func(ctx context.Context, admin models.Caller) error,
+) error {
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int64("groupID", groupID))
+
+	admin, err := a.requireGroupsAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to change group", slog.String("error", err.Error()))
+
+		return err
+	}
+
+	err = a.withTx(ctx, func(ctx context.Context) error {
+		if _, err := a.tenantGroup(ctx, admin, groupID); err != nil {
+			return err
+		}
+
+		return change(ctx, admin)
+	})
+	if err != nil {
+		return a.groupError(log, err)
+	}
+
+	log.Info("Group changed", slog.String("change", reason))
+	a.invalidator.InvalidatePermissions()
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditAdminAction,
+		ActorID: admin.UserID,
+		UserID:  userID,
+		AppID:   admin.AppID,
+		Reason:  reason,
+	})
+
+	return nil
+}
+
+// tenantGroup returns the group if it belongs to the tenant of the admin,
+// groups of other tenants are reported as missing
+func (a *Auth) tenantGroup(ctx context.Context, admin models.Caller, groupID int64) (models.Group, error) {
+	group, err := a.groups.Group(ctx, groupID)
+	if err != nil {
+		return models.Group{}, err
+	}
+
+	if group.TenantID != admin.TenantID {
+		return models.Group{}, storage.ErrGroupNotFound
+	}
+
+	return group, nil
+}
+
+// checkMember checks that exactly one of the user and the subgroup is set and it is in the tenant of the admin
+func (a *Auth) checkMember(ctx context.Context, admin models.Caller, member models.GroupMember) error {
+	if (member.UserID == 0) == (member.SubgroupID == 0) {
+		return ErrInvalidMember
+	}
+
+	if member.UserID != 0 {
+		_, err := a.tenantUser(ctx, admin, member.UserID)
+
+		return err
+	}
+
+	_, err := a.tenantGroup(ctx, admin, member.SubgroupID)
+
+	return err
+}
+
+// checkTenantApp returns ErrInvalidAppID if the app is not in the tenant of the caller
+func (a *Auth) checkTenantApp(ctx context.Context, c models.Caller, appID int) error {
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return ErrInvalidAppID
+		}
+
+		return err
+	}
+
+	if app.TenantID != c.TenantID {
+		return ErrInvalidAppID
+	}
+
+	return nil
+}
+
+// groupError maps the storage errors of the group APIs to the service ones and logs the unexpected ones
+func (a *Auth) groupError(log *slog.Logger, err error) error {
+	for _, known := range []struct{ from, to error }{
+		{storage.ErrGroupNotFound, ErrGroupNotFound},
+		{storage.ErrGroupExists, ErrGroupExists},
+		{storage.ErrGroupCycle, ErrGroupCycle},
+		{storage.ErrUserNotFound, ErrUserNotFound},
+		{ErrInvalidAppID, ErrInvalidAppID},
+		{ErrInvalidMember, ErrInvalidMember},
+		{ErrInvalidRole, ErrInvalidRole},
+	} {
+		if errors.Is(err, known.from) {
+			log.Warn("invalid group request", slog.String("error", err.Error()))
+
+			return known.to
+		}
+	}
+
+	log.Error("group request failed", slog.String("error", err.Error()))
+
+	return err
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/storage/memory"
+	"testing"
+)
+
+// countingInvalidator counts the invalidations of the permissions
+type countingInvalidator struct {
+	nopInvalidator
+	permissions int
+}
+
+func (i *countingInvalidator) InvalidatePermissions() {
+	i.permissions++
+}
+
+// adminContext registers the admin of the default tenant and returns the context of the admin logged in to app 1
+func adminContext(t *testing.T, a *Auth, st *memory.Storage) context.Context {
+	t.Helper()
+
+	adminID := register(t, a, "admin@example.com", 1)
+	require.NoError(t, st.SetAdmin(adminID, true))
+
+	admin, _ := login(t, a, context.Background(), "admin@example.com", 1)
+
+	return caller.NewContext(context.Background(), admin)
+}
+
+func TestGroups_RolesInToken(t *testing.T) {
+	inv := &countingInvalidator{}
+	a, st := newTestAuth(t, WithInvalidator(inv))
+	adminCtx := adminContext(t, a, st)
+	userID := register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	engineering, err := a.CreateGroup(adminCtx, "engineering")
+	require.NoError(t, err)
+	backend, err := a.CreateGroup(adminCtx, "backend")
+	require.NoError(t, err)
+
+	require.NoError(t, a.AddGroupMember(adminCtx, engineering, models.GroupMember{SubgroupID: backend}))
+	require.NoError(t, a.AddGroupMember(adminCtx, backend, models.GroupMember{UserID: userID}))
+	require.NoError(t, a.GrantGroupRole(adminCtx, engineering, models.GroupRole{AppID: 1, Role: "editor"}))
+	require.NoError(t, a.GrantGroupRole(adminCtx, backend, models.GroupRole{AppID: 2, Role: "operator"}))
+	assert.Equal(t, 4, inv.permissions)
+
+	c, _ := login(t, a, ctx, "user@example.com", 1)
+	assert.Equal(t, []string{"editor"}, c.Roles)
+	assert.Empty(t, c.Groups)
+
+	token, err := a.Login(ctx, "user@example.com", "password", 2, WithGroupsClaim())
+	require.NoError(t, err)
+
+	c, err = a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"operator"}, c.Roles)
+	assert.Equal(t, []string{"backend", "engineering"}, c.Groups)
+
+	group, err := a.GetGroup(adminCtx, backend)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{userID}, group.UserIDs)
+
+	groups, err := a.ListGroups(adminCtx)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "backend", groups[0].Name)
+
+	// The roles are gone with the membership
+	require.NoError(t, a.RemoveGroupMember(adminCtx, engineering, models.GroupMember{SubgroupID: backend}))
+
+	c, _ = login(t, a, ctx, "user@example.com", 1)
+	assert.Empty(t, c.Roles)
+
+	require.NoError(t, a.DeleteGroup(adminCtx, backend))
+
+	perms, err := a.GetUserPermissions(adminCtx, userID, 2)
+	require.NoError(t, err)
+	assert.Empty(t, perms.Groups)
+	assert.Empty(t, perms.Roles)
+}
+
+func TestGroups_FailCases(t *testing.T) {
+	a, st := newTestAuth(t)
+	adminCtx := adminContext(t, a, st)
+	userID := register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	group, err := a.CreateGroup(adminCtx, "group")
+	require.NoError(t, err)
+	child, err := a.CreateGroup(adminCtx, "child")
+	require.NoError(t, err)
+	require.NoError(t, a.AddGroupMember(adminCtx, group, models.GroupMember{SubgroupID: child}))
+
+	otherGroup, err := st.SaveGroup(ctx, 2, "other")
+	require.NoError(t, err)
+	otherUserID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 4)
+	require.NoError(t, err)
+
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+	userCtx := caller.NewContext(ctx, user)
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{
+			name: "not an admin",
+			call: func() error {
+				_, err := a.CreateGroup(userCtx, "mine")
+				return err
+			},
+			wantErr: ErrPermissionDenied,
+		},
+		{
+			name: "unauthenticated",
+			call: func() error {
+				_, err := a.ListGroups(ctx)
+				return err
+			},
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name: "taken name",
+			call: func() error {
+				_, err := a.CreateGroup(adminCtx, "group")
+				return err
+			},
+			wantErr: ErrGroupExists,
+		},
+		{
+			name: "unknown group",
+			call: func() error {
+				return a.AddGroupMember(adminCtx, 404, models.GroupMember{UserID: userID})
+			},
+			wantErr: ErrGroupNotFound,
+		},
+		{
+			name: "group of another tenant",
+			call: func() error {
+				_, err := a.GetGroup(adminCtx, otherGroup)
+				return err
+			},
+			wantErr: ErrGroupNotFound,
+		},
+		{
+			name: "subgroup of another tenant",
+			call: func() error {
+				return a.AddGroupMember(adminCtx, group, models.GroupMember{SubgroupID: otherGroup})
+			},
+			wantErr: ErrGroupNotFound,
+		},
+		{
+			name: "user of another tenant",
+			call: func() error {
+				return a.AddGroupMember(adminCtx, group, models.GroupMember{UserID: otherUserID})
+			},
+			wantErr: ErrUserNotFound,
+		},
+		{
+			name: "cycle",
+			call: func() error {
+				return a.AddGroupMember(adminCtx, child, models.GroupMember{SubgroupID: group})
+			},
+			wantErr: ErrGroupCycle,
+		},
+		{
+			name: "both user and subgroup",
+			call: func() error {
+				return a.AddGroupMember(adminCtx, group, models.GroupMember{UserID: userID, SubgroupID: child})
+			},
+			wantErr: ErrInvalidMember,
+		},
+		{
+			name: "app of another tenant",
+			call: func() error {
+				return a.GrantGroupRole(adminCtx, group, models.GroupRole{AppID: 4, Role: "editor"})
+			},
+			wantErr: ErrInvalidAppID,
+		},
+		{
+			name: "permissions of another user",
+			call: func() error {
+				_, err := a.GetUserPermissions(userCtx, otherUserID, 1)
+				return err
+			},
+			wantErr: ErrPermissionDenied,
+		},
+		{
+			name: "permissions of a user of another tenant",
+			call: func() error {
+				_, err := a.GetUserPermissions(adminCtx, otherUserID, 1)
+				return err
+			},
+			wantErr: ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.call(), tt.wantErr)
+		})
+	}
+
+	// The users get their own permissions
+	_, err = a.GetUserPermissions(userCtx, userID, 0)
+	assert.NoError(t, err)
+}
+
+func TestGroups_Disabled(t *testing.T) {
+	a, st := newTestAuth(t, WithGroups(nil, nil))
+	adminCtx := adminContext(t, a, st)
+
+	admin, _ := caller.FromContext(adminCtx)
+	assert.Empty(t, admin.Roles)
+
+	_, err := a.CreateGroup(adminCtx, "group")
+	assert.ErrorIs(t, err, ErrGroupsDisabled)
+}
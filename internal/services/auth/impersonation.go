@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// DefaultImpersonationTTL is how long the tokens issued by Impersonate are valid by default
+const DefaultImpersonationTTL = 15 * time.Minute
+
+// ErrImpersonated is returned by the sensitive operations called with a token issued to an actor
+// on behalf of the user, by Impersonate or ExchangeToken
+var ErrImpersonated = errors.New("not allowed on behalf of the user")
+
+// WithImpersonationTTL sets how long the tokens issued by Impersonate are valid,
+// they never outlive the tokens issued by Login
+func WithImpersonationTTL(ttl time.Duration) Option {
+	return func(a *Auth) {
+		a.impersonationTTL = ttl
+	}
+}
+
+// Impersonate issues a token of the user for the app, the app of the admin's token if appID is zero,
+// so the admin sees what the user sees. Admin of the user's tenant only.
+// The token names the admin in the act claim, has no session and is refused by the sensitive
+// operations, see requireUser. Every impersonation is audited with the reason given by the admin
+func (a *Auth) Impersonate(
+	ctx context.Context,
+	userID int64,
+	appID int,
+	reason string,
+) (token string, expiresAt time.Time, err error) {
+	const op = "auth.Impersonate"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int64("userID", userID))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to impersonate", slog.String("error", err.Error()))
+
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if userID == admin.UserID {
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, ErrPermissionDenied)
+	}
+
+	if appID == models.EmptyAppID {
+		appID = admin.AppID
+	}
+
+	app, err := a.appProvider.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return "", time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+		}
+
+		log.Error("failed to get app", slog.String("error", err.Error()))
+
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if app.TenantID != admin.TenantID {
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, ErrInvalidAppID)
+	}
+
+	user, err := a.tenantUser(ctx, admin, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("User not found", slog.String("error", err.Error()))
+
+			return "", time.Time{}, fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", slog.String("error", err.Error()))
+
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if user.Disabled {
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, ErrUserDisabled)
+	}
+
+	c := models.Caller{
+		UserID:   user.ID,
+		TenantID: user.TenantID,
+		Email:    user.Email,
+		AppID:    app.ID,
+		Actor:    &models.Actor{UserID: admin.UserID, Email: admin.Email},
+	}
+
+	if a.permissions != nil {
+		perms, err := a.permissions.UserPermissions(ctx, user.ID, app.ID)
+		if err != nil {
+			log.Error("failed to get permissions", slog.String("error", err.Error()))
+
+			return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		c.Roles = perms.Roles
+	}
+
+	// The token has the scopes the user consented to, as the user's own tokens may
+	if a.consents != nil {
+		consent, err := a.consents.Consent(ctx, user.ID, app.ID)
+		if err != nil && !errors.Is(err, storage.ErrConsentNotFound) {
+			log.Error("failed to get consent", slog.String("error", err.Error()))
+
+			return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		c.Scopes = consent.Scopes
+	}
+
+	ttl := min(a.impersonationTTL, a.tokenTTL)
+	expiresAt = time.Now().Add(ttl)
+
+	token, err = jwt.NewToken(c, app.Secret, ttl)
+	if err != nil {
+		log.Error("failed to create token", slog.String("error", err.Error()))
+
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Unlike the other events, the token is not issued unless the impersonation is audited
+	err = a.saveAudit(ctx, models.AuditEvent{
+		Type:     models.AuditImpersonate,
+		TenantID: user.TenantID,
+		ActorID:  admin.UserID,
+		UserID:   user.ID,
+		Email:    user.Email,
+		AppID:    app.ID,
+		Reason:   reason,
+	})
+	if err != nil {
+		log.Error("failed to audit impersonation", slog.String("error", err.Error()))
+
+		return "", time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("User impersonated", slog.Int64("adminID", admin.UserID))
+	metrics.TokensIssued.WithLabelValues(strconv.Itoa(app.ID)).Inc()
+
+	return token, expiresAt, nil
+}
+
+// requireUser returns the caller if the token was issued to the user themself: the sensitive
+// operations such as a password change refuse the tokens of an impersonating admin or of an app
+func requireUser(ctx context.Context) (models.Caller, error) {
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return models.Caller{}, ErrUnauthenticated
+	}
+
+	if c.Actor != nil {
+		return models.Caller{}, ErrImpersonated
+	}
+
+	return c, nil
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// failingAuditLog fails to save every event
+type failingAuditLog struct {
+	nopAuditLog
+}
+
+func (failingAuditLog) SaveAuditEvent(context.Context, models.AuditEvent) error {
+	return errors.New("disk full")
+}
+
+func TestImpersonate(t *testing.T) {
+	a, st := newTestAuth(t)
+	adminCtx := adminContext(t, a, st)
+	userID := register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	admin, _ := caller.FromContext(adminCtx)
+
+	groupID, err := st.SaveGroup(ctx, models.DefaultTenantID, "support")
+	require.NoError(t, err)
+	require.NoError(t, st.AddGroupUser(ctx, groupID, userID))
+	require.NoError(t, st.GrantGroupRole(ctx, groupID, models.GroupRole{AppID: 2, Role: "viewer"}))
+
+	token, expiresAt, err := a.Impersonate(adminCtx, userID, 2, "ticket 42")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(DefaultImpersonationTTL), expiresAt, time.Minute)
+
+	c, err := a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, models.Caller{
+		UserID:   userID,
+		TenantID: models.DefaultTenantID,
+		Email:    "user@example.com",
+		AppID:    2,
+		Roles:    []string{"viewer"},
+		Actor:    &models.Actor{UserID: admin.UserID, Email: "admin@example.com"},
+	}, c)
+
+	events, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditImpersonate}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, admin.UserID, events[0].ActorID)
+	assert.Equal(t, userID, events[0].UserID)
+	assert.Equal(t, "ticket 42", events[0].Reason)
+
+	// The sensitive operations refuse the token
+	impersonatedCtx := caller.NewContext(ctx, c)
+
+	assert.ErrorIs(t, a.ChangePassword(impersonatedCtx, "password", "new password"), ErrImpersonated)
+	assert.ErrorIs(t, a.ChangeEmail(impersonatedCtx, "new@example.com", "password"), ErrImpersonated)
+
+	// nor logs the user out
+	session, sessionToken := login(t, a, ctx, "user@example.com", 1)
+
+	assert.ErrorIs(t, a.RevokeSession(impersonatedCtx, session.SessionID), ErrImpersonated)
+	assert.ErrorIs(t, a.RevokeConsent(impersonatedCtx, 1), ErrImpersonated)
+
+	_, err = a.RevokeAllSessions(impersonatedCtx, false)
+	assert.ErrorIs(t, err, ErrImpersonated)
+
+	_, err = a.VerifyToken(ctx, sessionToken)
+	require.NoError(t, err)
+
+	_, err = a.ListUserSessions(impersonatedCtx, userID)
+	assert.ErrorIs(t, err, ErrImpersonated)
+
+	_, _, err = a.Impersonate(impersonatedCtx, admin.UserID, 0, "chain")
+	assert.ErrorIs(t, err, ErrImpersonated)
+
+	// The other events of the impersonated user name the admin as the actor
+	a.audit(impersonatedCtx, models.AuditEvent{Type: models.AuditTokenRevoke, UserID: userID, AppID: 2})
+
+	events, err = st.AuditEvents(ctx, models.AuditFilter{UserID: userID, Types: []string{models.AuditTokenRevoke}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, admin.UserID, events[0].ActorID)
+}
+
+func TestImpersonate_FailCases(t *testing.T) {
+	a, st := newTestAuth(t)
+	adminCtx := adminContext(t, a, st)
+	userID := register(t, a, "user@example.com", 1)
+	ctx := context.Background()
+
+	admin, _ := caller.FromContext(adminCtx)
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+
+	otherID, err := a.RegisterNewUser(ctx, "other@example.com", "password", 4)
+	require.NoError(t, err)
+
+	disabledID, err := a.RegisterNewUser(ctx, "disabled@example.com", "password", 1)
+	require.NoError(t, err)
+	require.NoError(t, a.DisableUser(adminCtx, disabledID))
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		userID  int64
+		appID   int
+		wantErr error
+	}{
+		{name: "unauthenticated", ctx: ctx, userID: userID, wantErr: ErrUnauthenticated},
+		{name: "not admin", ctx: caller.NewContext(ctx, user), userID: admin.UserID, wantErr: ErrPermissionDenied},
+		{name: "themself", ctx: adminCtx, userID: admin.UserID, wantErr: ErrPermissionDenied},
+		{name: "unknown user", ctx: adminCtx, userID: 404, wantErr: ErrUserNotFound},
+		{name: "user of another tenant", ctx: adminCtx, userID: otherID, wantErr: ErrUserNotFound},
+		{name: "app of another tenant", ctx: adminCtx, userID: userID, appID: 4, wantErr: ErrInvalidAppID},
+		{name: "unknown app", ctx: adminCtx, userID: userID, appID: 404, wantErr: ErrInvalidAppID},
+		{name: "disabled user", ctx: adminCtx, userID: disabledID, wantErr: ErrUserDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := a.Impersonate(tt.ctx, tt.userID, tt.appID, "ticket 42")
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("not audited", func(t *testing.T) {
+		log := slog.New(slog.NewTextHandler(io.Discard, nil))
+		a := New(log, st, st, st, time.Hour, WithAuditLog(failingAuditLog{}))
+
+		token, _, err := a.Impersonate(adminCtx, userID, 0, "ticket 42")
+		assert.Error(t, err)
+		assert.Empty(t, token)
+	})
+}
+
+func TestImpersonate_TTL(t *testing.T) {
+	b, st := newTestAuth(t)
+	adminCtx := adminContext(t, b, st)
+	userID := register(t, b, "user@example.com", 1)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// The token never outlives the tokens issued by Login
+	a := New(log, st, st, st, 5*time.Minute, WithSessions(st), WithImpersonationTTL(time.Hour))
+
+	_, expiresAt, err := a.Impersonate(adminCtx, userID, 0, "ticket 42")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), expiresAt, time.Minute)
+}
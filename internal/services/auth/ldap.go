@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/ldap"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrDirectoryUnavailable is returned by Login if the directory of the user can't be reached
+	ErrDirectoryUnavailable = errors.New("directory is unavailable")
+	// ErrDirectoryUser is returned by RegisterNewUser, ChangeEmail and ChangePassword for the emails
+	// of an LDAP backend, its users are registered by their first login and keep their passwords in it
+	ErrDirectoryUser = errors.New("email belongs to a directory")
+)
+
+// Directory authenticates the users with their directory passwords, see ldap.Directory
+type Directory interface {
+	Authenticate(ctx context.Context, email string, password string) (ldap.Entry, error)
+}
+
+// LDAPBackend is a directory the users of a tenant, or of some email domains of the tenant,
+// log in with instead of their local passwords
+type LDAPBackend struct {
+	// Name is the provider of the identities linking the users to their entries
+	Name      string
+	Directory Directory
+	TenantID  int64
+	// Domains limit the backend to the emails of the domains, it serves the whole tenant if empty.
+	// A backend of the domain of the email is preferred to the one of the whole tenant
+	Domains []string
+	// Provision registers the users of the directory at their first login
+	Provision bool
+	// SyncEmail updates the email of the user to the email of the entry at every login
+	SyncEmail bool
+	// Groups maps the DNs of the directory groups to the names of the local groups of the tenant.
+	// At every login the user is added to the local groups of the user's directory groups and removed
+	// from the other mapped ones, the groups not mapped are left alone
+	Groups map[string]string
+}
+
+// WithLDAP enables the login with the directory passwords, the entries are linked to the users like
+// the accounts of the upstream identity providers
+func WithLDAP(identities Identities, backends ...LDAPBackend) Option {
+	return func(a *Auth) {
+		a.identities = identities
+
+		for _, b := range backends {
+			// The DNs are case-insensitive
+			groups := make(map[string]string, len(b.Groups))
+			for dn, name := range b.Groups {
+				groups[strings.ToLower(dn)] = name
+			}
+
+			b.Groups = groups
+			a.directories = append(a.directories, b)
+		}
+	}
+}
+
+// directory returns the LDAP backend of the email in the tenant, false if the user logs in with the local password
+func (a *Auth) directory(tenantID int64, email string) (LDAPBackend, bool) {
+	_, domain, _ := strings.Cut(email, "@")
+
+	var (
+		tenantWide LDAPBackend
+		found      bool
+	)
+
+	for _, b := range a.directories {
+		if b.TenantID != tenantID {
+			continue
+		}
+
+		if len(b.Domains) == 0 {
+			if !found {
+				tenantWide, found = b, true
+			}
+
+			continue
+		}
+
+		if slices.ContainsFunc(b.Domains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+			return b, true
+		}
+	}
+
+	return tenantWide, found
+}
+
+// directoryLogin authenticates the user of the app with the password in the directory and syncs the user
+// with the entry, see LDAPBackend. It audits the failures like Login
+func (a *Auth) directoryLogin(
+	ctx context.Context,
+	log *slog.Logger,
+	b LDAPBackend,
+	app models.App,
+	email string,
+	password string,
+) (models.User, error) {
+	log = log.With(slog.String("directory", b.Name))
+	failed := models.User{TenantID: app.TenantID, Email: email}
+
+	entry, err := b.Directory.Authenticate(ctx, email, password)
+	if err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			log.Info("invalid credentials", slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInvalidCredentials).Inc()
+			a.auditLoginFailed(ctx, failed, app.ID, models.AuditReasonWrongPassword)
+
+			return models.User{}, ErrInvalidCredentials
+		}
+
+		log.Error("failed to authenticate in directory", slog.String("error", err.Error()))
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+		a.auditLoginFailed(ctx, failed, app.ID, models.AuditReasonInternal)
+
+		return models.User{}, fmt.Errorf("%w: %w", ErrDirectoryUnavailable, err)
+	}
+
+	// The entry may be found by another email, e.g. the user principal name
+	if entry.Email == "" {
+		entry.Email = email
+	}
+
+	user, err := a.directoryUser(ctx, b, app, entry)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			log.Warn("User not found", slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInvalidCredentials).Inc()
+			a.auditLoginFailed(ctx, failed, app.ID, models.AuditReasonUserNotFound)
+
+			return models.User{}, ErrInvalidCredentials
+		}
+
+		log.Error("failed to get directory user", slog.String("error", err.Error()))
+		metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+		a.auditLoginFailed(ctx, failed, app.ID, models.AuditReasonInternal)
+
+		return models.User{}, err
+	}
+
+	if b.SyncEmail {
+		user, err = a.syncEmail(ctx, log, b, user, entry.Email)
+		if err != nil {
+			log.Error("failed to sync email", slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+			a.auditLoginFailed(ctx, user, app.ID, models.AuditReasonInternal)
+
+			return models.User{}, err
+		}
+	}
+
+	if len(b.Groups) > 0 && a.groups != nil {
+		if err := a.syncGroups(ctx, log, b, user, entry.Groups); err != nil {
+			log.Error("failed to sync groups", slog.String("error", err.Error()))
+			metrics.LoginFailures.WithLabelValues(metrics.ReasonInternal).Inc()
+			a.auditLoginFailed(ctx, user, app.ID, models.AuditReasonInternal)
+
+			return models.User{}, err
+		}
+	}
+
+	return user, nil
+}
+
+// directoryUser returns the user linked to the entry, otherwise links the user with the email of the entry
+// or registers one if the backend provisions the users. The directory is trusted with the emails
+// of its entries, it serves them in the tenant
+func (a *Auth) directoryUser(ctx context.Context, b LDAPBackend, app models.App, entry ldap.Entry) (models.User, error) {
+	identity := models.Identity{Provider: b.Name, Subject: entry.ID, Email: entry.Email}
+
+	link, err := a.identities.Identity(ctx, app.TenantID, b.Name, entry.ID)
+	if err == nil {
+		return a.userProvider.UserByID(ctx, link.UserID)
+	}
+
+	if !errors.Is(err, storage.ErrIdentityNotFound) {
+		return models.User{}, err
+	}
+
+	user, err := a.userProvider.User(ctx, app.TenantID, entry.Email)
+	if err == nil {
+		return user, a.saveIdentity(ctx, user, identity)
+	}
+
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return models.User{}, err
+	}
+
+	if !b.Provision {
+		return models.User{}, ErrUserNotFound
+	}
+
+	return a.provisionUser(ctx, app, identity)
+}
+
+// syncEmail changes the email of the user to the one of the entry. An email taken by another user
+// is left unchanged, the entries of the directory may share aliases
+func (a *Auth) syncEmail(
+	ctx context.Context,
+	log *slog.Logger,
+	b LDAPBackend,
+	user models.User,
+	email string,
+) (models.User, error) {
+	if user.Email == email {
+		return user, nil
+	}
+
+	err := a.withTx(ctx, func(ctx context.Context) error {
+		if err := a.userSaver.UpdateEmail(ctx, user.ID, email); err != nil {
+			return err
+		}
+
+		return a.emit(ctx, models.EventUserEmailChanged, user.TenantID, 0, webhook.UserData{
+			UserID:        user.ID,
+			Email:         email,
+			PreviousEmail: user.Email,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			log.Warn("Email of the entry is taken", slog.String("error", err.Error()))
+
+			return user, nil
+		}
+
+		return user, err
+	}
+
+	log.Info("Email synced from directory")
+	a.invalidator.InvalidateUser(user.ID)
+	a.audit(ctx, models.AuditEvent{
+		Type:     models.AuditEmailChange,
+		TenantID: user.TenantID,
+		ActorID:  user.ID,
+		UserID:   user.ID,
+		Email:    email,
+		Reason:   b.Name,
+	})
+
+	user.Email = email
+
+	return user, nil
+}
+
+// syncGroups makes the user a member of the local groups mapped from the directory groups of the entry
+// and of none of the other mapped groups
+func (a *Auth) syncGroups(
+	ctx context.Context,
+	log *slog.Logger,
+	b LDAPBackend,
+	user models.User,
+	memberOf []string,
+) error {
+	want := make(map[string]bool, len(memberOf))
+	for _, dn := range memberOf {
+		if name, ok := b.Groups[strings.ToLower(dn)]; ok {
+			want[name] = true
+		}
+	}
+
+	mapped := make(map[string]bool, len(b.Groups))
+	for _, name := range b.Groups {
+		mapped[name] = true
+	}
+
+	var changed []string
+
+	err := a.withTx(ctx, func(ctx context.Context) error {
+		groups, err := a.groups.Groups(ctx, user.TenantID)
+		if err != nil {
+			return err
+		}
+
+		for _, g := range groups {
+			if !mapped[g.Name] {
+				continue
+			}
+
+			group, err := a.groups.Group(ctx, g.ID)
+			if err != nil {
+				return err
+			}
+
+			switch member := slices.Contains(group.UserIDs, user.ID); {
+			case want[g.Name] && !member:
+				err = a.groups.AddGroupUser(ctx, g.ID, user.ID)
+			case !want[g.Name] && member:
+				err = a.groups.RemoveGroupUser(ctx, g.ID, user.ID)
+			default:
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			changed = append(changed, g.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(changed) > 0 {
+		log.Info("Groups synced from directory", slog.Any("groups", changed))
+		a.invalidator.InvalidatePermissions()
+	}
+
+	return nil
+}
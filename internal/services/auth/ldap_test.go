@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/ldap"
+	"grpc-sso/internal/lib/ldap/ldaptest"
+	"grpc-sso/internal/storage/memory"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+const (
+	adminsDN = "cn=admins,ou=groups,dc=example,dc=com"
+	staffDN  = "cn=staff,ou=groups,dc=example,dc=com"
+)
+
+func aliceEntry(mail string, groups ...string) ldaptest.Entry {
+	return ldaptest.Entry{DN: "uid=alice,ou=people,dc=example,dc=com", Password: "password",
+		Attributes: map[string][]string{"mail": {mail}, "entryUUID": {"u-1"}, "memberOf": groups}}
+}
+
+// newLDAPAuth is newTestAuth with two LDAP backends at the in-process server: corp serves
+// the corp.example.com emails of the default tenant, provisions the users and syncs their emails and groups,
+// acme serves the whole tenant 2
+func newLDAPAuth(t *testing.T) (*Auth, *memory.Storage, *ldaptest.Server, *countingInvalidator) {
+	t.Helper()
+
+	inv := &countingInvalidator{}
+
+	srv := ldaptest.New(t,
+		aliceEntry("alice@corp.example.com", adminsDN),
+		ldaptest.Entry{DN: "uid=bob,ou=people,dc=example,dc=com", Password: "password",
+			Attributes: map[string][]string{"mail": {"bob@corp.example.com"}, "entryUUID": {"u-2"}}},
+		ldaptest.Entry{DN: "uid=eve,ou=people,dc=example,dc=com", Password: "password",
+			Attributes: map[string][]string{"mail": {"eve@acme.example.com"}, "entryUUID": {"u-3"}}},
+	)
+
+	newDirectory := func() Directory {
+		d, err := ldap.New(ldap.Options{
+			URL:          srv.URL,
+			BindDN:       ldaptest.BindDN,
+			BindPassword: ldaptest.BindPassword,
+			BaseDN:       ldaptest.BaseDN,
+			IDAttribute:  "entryUUID",
+		})
+		require.NoError(t, err)
+
+		return d
+	}
+
+	a, st := newTestAuth(t, WithInvalidator(inv))
+	WithLDAP(st,
+		LDAPBackend{
+			Name:      "corp",
+			Directory: newDirectory(),
+			TenantID:  models.DefaultTenantID,
+			Domains:   []string{"corp.example.com"},
+			Provision: true,
+			SyncEmail: true,
+			Groups:    map[string]string{"CN=Admins,OU=Groups,DC=example,DC=com": "admins", staffDN: "staff"},
+		},
+		LDAPBackend{Name: "acme", Directory: newDirectory(), TenantID: 2},
+	)(a)
+
+	return a, st, srv, inv
+}
+
+func TestLogin_LDAPProvision(t *testing.T) {
+	a, st, srv, inv := newLDAPAuth(t)
+	ctx := context.Background()
+
+	admins, err := st.SaveGroup(ctx, models.DefaultTenantID, "admins")
+	require.NoError(t, err)
+	require.NoError(t, st.GrantGroupRole(ctx, admins, models.GroupRole{AppID: 1, Role: "admin"}))
+	staff, err := st.SaveGroup(ctx, models.DefaultTenantID, "staff")
+	require.NoError(t, err)
+	require.NoError(t, st.GrantGroupRole(ctx, staff, models.GroupRole{AppID: 1, Role: "viewer"}))
+	// Not mapped, left alone by the sync
+	local, err := st.SaveGroup(ctx, models.DefaultTenantID, "local")
+	require.NoError(t, err)
+	require.NoError(t, st.GrantGroupRole(ctx, local, models.GroupRole{AppID: 1, Role: "editor"}))
+
+	// The directory passwords are "password", like the local ones of login
+	_, err = a.Login(ctx, "alice@corp.example.com", "wrong", 1)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	alice, _ := login(t, a, ctx, "alice@corp.example.com", 1)
+	assert.Equal(t, []string{"admin"}, alice.Roles)
+	assert.Equal(t, 1, inv.permissions)
+	assert.Equal(t, 1, srv.Binds())
+
+	identities, err := a.ListIdentities(caller.NewContext(ctx, alice))
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "corp", identities[0].Provider)
+	assert.Equal(t, "u-1", identities[0].Subject)
+
+	events, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditRegister}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "corp", events[0].Reason)
+
+	// The user is moved from the admins to the staff in the directory
+	require.NoError(t, st.AddGroupUser(ctx, local, alice.UserID))
+	srv.Put(aliceEntry("alice@corp.example.com", staffDN, "cn=unmapped,ou=groups,dc=example,dc=com"))
+
+	again, _ := login(t, a, ctx, "alice@corp.example.com", 1)
+	assert.Equal(t, alice.UserID, again.UserID)
+	assert.ElementsMatch(t, []string{"viewer", "editor"}, again.Roles)
+	assert.Equal(t, 2, inv.permissions)
+
+	// Nothing changed, the cache is kept
+	_, _ = login(t, a, ctx, "alice@corp.example.com", 1)
+	assert.Equal(t, 2, inv.permissions)
+}
+
+func TestLogin_LDAPExistingUser(t *testing.T) {
+	a, st, _, _ := newLDAPAuth(t)
+	ctx := context.Background()
+
+	// Registered before the backend was configured
+	passHash, err := bcrypt.GenerateFromPassword([]byte("local-password"), bcrypt.MinCost)
+	require.NoError(t, err)
+	bobID, err := st.SaveUser(ctx, models.DefaultTenantID, "bob@corp.example.com", passHash)
+	require.NoError(t, err)
+
+	// The directory password replaces the local one
+	_, err = a.Login(ctx, "bob@corp.example.com", "local-password", 1)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	bob, _ := login(t, a, ctx, "bob@corp.example.com", 1)
+	assert.Equal(t, bobID, bob.UserID)
+
+	identities, err := st.UserIdentities(ctx, bobID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "u-2", identities[0].Subject)
+
+	t.Run("disabled", func(t *testing.T) {
+		require.NoError(t, st.DisableUser(ctx, bobID))
+
+		_, err := a.Login(ctx, "bob@corp.example.com", "password", 1)
+		assert.ErrorIs(t, err, ErrUserDisabled)
+	})
+
+	t.Run("other domains", func(t *testing.T) {
+		_, err := a.RegisterNewUser(ctx, "user@example.com", "password", 1)
+		require.NoError(t, err)
+
+		_, _ = login(t, a, ctx, "user@example.com", 1)
+	})
+}
+
+func TestLogin_LDAPNoProvision(t *testing.T) {
+	a, st, _, _ := newLDAPAuth(t)
+	ctx := context.Background()
+
+	_, err := a.Login(ctx, "eve@acme.example.com", "password", 4)
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+
+	events, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditLoginFailed}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.AuditReasonUserNotFound, events[0].Reason)
+
+	eveID, err := st.SaveUser(ctx, 2, "eve@acme.example.com", []byte{})
+	require.NoError(t, err)
+
+	eve, _ := login(t, a, ctx, "eve@acme.example.com", 4)
+	assert.Equal(t, eveID, eve.UserID)
+
+	// The backend of tenant 2 doesn't serve the default tenant
+	_, err = a.Login(ctx, "eve@acme.example.com", "password", 1)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestLogin_LDAPSyncEmail(t *testing.T) {
+	a, st, srv, _ := newLDAPAuth(t)
+	ctx := context.Background()
+
+	alice, _ := login(t, a, ctx, "alice@corp.example.com", 1)
+
+	// The user is renamed in the directory
+	srv.Put(aliceEntry("alice.smith@corp.example.com"))
+
+	renamed, _ := login(t, a, ctx, "alice.smith@corp.example.com", 1)
+	assert.Equal(t, alice.UserID, renamed.UserID)
+	assert.Equal(t, "alice.smith@corp.example.com", renamed.Email)
+
+	user, err := st.UserByID(ctx, alice.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice.smith@corp.example.com", user.Email)
+
+	changed := payloads(t, st, models.EventUserEmailChanged)
+	require.NotEmpty(t, changed)
+	assert.Equal(t, "alice@corp.example.com", changed[0].Data.PreviousEmail)
+
+	// The email of the entry is taken by another user, the user keeps the email
+	_, err = st.SaveUser(ctx, models.DefaultTenantID, "taken@corp.example.com", []byte{})
+	require.NoError(t, err)
+	srv.Put(aliceEntry("taken@corp.example.com"))
+
+	kept, _ := login(t, a, ctx, "taken@corp.example.com", 1)
+	assert.Equal(t, alice.UserID, kept.UserID)
+	assert.Equal(t, "alice.smith@corp.example.com", kept.Email)
+}
+
+func TestLogin_LDAPUnavailable(t *testing.T) {
+	d, err := ldap.New(ldap.Options{URL: "ldap://127.0.0.1:1", BaseDN: ldaptest.BaseDN, Timeout: time.Second})
+	require.NoError(t, err)
+
+	a, st := newTestAuth(t)
+	WithLDAP(st, LDAPBackend{Name: "corp", Directory: d, TenantID: models.DefaultTenantID})(a)
+
+	_, err = a.Login(context.Background(), "alice@corp.example.com", "password", 1)
+	assert.ErrorIs(t, err, ErrDirectoryUnavailable)
+}
+
+func TestRegisterNewUser_LDAP(t *testing.T) {
+	a, _, _, _ := newLDAPAuth(t)
+	ctx := context.Background()
+
+	_, err := a.RegisterNewUser(ctx, "mallory@CORP.example.com", "password", 1)
+	assert.ErrorIs(t, err, ErrDirectoryUser)
+
+	// Every email of tenant 2
+	_, err = a.RegisterNewUser(ctx, "mallory@example.com", "password", 4)
+	assert.ErrorIs(t, err, ErrDirectoryUser)
+
+	_, err = a.RegisterNewUser(ctx, "mallory@example.com", "password", 1)
+	require.NoError(t, err)
+
+	mallory, _ := login(t, a, ctx, "mallory@example.com", 1)
+
+	err = a.ChangeEmail(caller.NewContext(ctx, mallory), "alice@corp.example.com", "password")
+	assert.ErrorIs(t, err, ErrDirectoryUser)
+}
+
+func TestChangePassword_LDAP(t *testing.T) {
+	a, st, _, _ := newLDAPAuth(t)
+	ctx := context.Background()
+
+	alice, _ := login(t, a, ctx, "alice@corp.example.com", 1)
+
+	err := a.ChangePassword(caller.NewContext(ctx, alice), "password", "new password")
+	assert.ErrorIs(t, err, ErrDirectoryUser)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditPasswordChange}})
+	require.NoError(t, err)
+	assert.Empty(t, audit)
+
+	// The local users keep changing theirs
+	register(t, a, "local@example.com", 1)
+	local, _ := login(t, a, ctx, "local@example.com", 1)
+
+	require.NoError(t, a.ChangePassword(caller.NewContext(ctx, local), "password", "new password"))
+}
+
+func TestDirectory_Backends(t *testing.T) {
+	a := New(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, nil, nil, time.Hour,
+		WithLDAP(nil,
+			LDAPBackend{Name: "tenant", TenantID: 2},
+			LDAPBackend{Name: "domain", TenantID: 2, Domains: []string{"corp.example.com"}},
+			LDAPBackend{Name: "default", TenantID: 1, Domains: []string{"corp.example.com"}},
+		))
+
+	tests := []struct {
+		tenantID int64
+		email    string
+		want     string
+	}{
+		{tenantID: 2, email: "user@corp.example.com", want: "domain"},
+		{tenantID: 2, email: "user@Corp.Example.com", want: "domain"},
+		{tenantID: 2, email: "user@example.com", want: "tenant"},
+		{tenantID: 2, email: "user", want: "tenant"},
+		{tenantID: 1, email: "user@corp.example.com", want: "default"},
+		{tenantID: 1, email: "user@example.com"},
+		{tenantID: 3, email: "user@corp.example.com"},
+	}
+
+	for _, tt := range tests {
+		b, ok := a.directory(tt.tenantID, tt.email)
+		assert.Equal(t, tt.want != "", ok, tt.email)
+		assert.Equal(t, tt.want, b.Name, tt.email)
+	}
+}
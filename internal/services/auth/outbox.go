@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/logger/slogger"
+	"log/slog"
+	"time"
+)
+
+// Outbox keeps the domain events for the webhook dispatcher.
+// Events are enqueued in the transaction of the change they are about
+type Outbox interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+	EnqueueEvent(ctx context.Context, event models.OutboxEvent) error
+	OutboxEvents(ctx context.Context, filter models.OutboxFilter) ([]models.OutboxEvent, error)
+	ReplayEvents(ctx context.Context, filter models.ReplayFilter, now time.Time) (replayed int64, err error)
+}
+
+// WithOutbox sets the outbox of the domain events and the transactions they are written in.
+// Without it no webhooks are sent and the changes are not transactional
+func WithOutbox(outbox Outbox) Option {
+	return func(a *Auth) {
+		a.outbox = outbox
+	}
+}
+
+// nopOutbox is used when the outbox is not configured, the changes are not transactional
+type nopOutbox struct{}
+
+func (nopOutbox) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (nopOutbox) EnqueueEvent(context.Context, models.OutboxEvent) error { return nil }
+
+func (nopOutbox) OutboxEvents(context.Context, models.OutboxFilter) ([]models.OutboxEvent, error) {
+	return nil, nil
+}
+
+func (nopOutbox) ReplayEvents(context.Context, models.ReplayFilter, time.Time) (int64, error) {
+	return 0, nil
+}
+
+// ListWebhookEvents returns a page of the outbox events matching the filter, the newest first,
+// and the token of the next page, empty on the last page. Only admins can list the events of their tenant
+func (a *Auth) ListWebhookEvents(
+	ctx context.Context,
+	filter models.OutboxFilter,
+	pageSize int,
+	pageToken string,
+) (events []models.OutboxEvent, nextPageToken string, err error) {
+	const op = "auth.ListWebhookEvents"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to list webhook events", slog.String("error", err.Error()))
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Admins see the events of their tenant only
+	filter.TenantID = admin.TenantID
+
+	if pageToken != "" {
+		filter.BeforeID, err = decodePageToken(pageToken)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	pageSize = clampPageSize(pageSize)
+
+	// One more event tells if there is a next page
+	filter.Limit = pageSize + 1
+
+	events, err = a.outbox.OutboxEvents(ctx, filter)
+	if err != nil {
+		log.Error("failed to list webhook events", slog.String("error", err.Error()))
+
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		nextPageToken = encodePageToken(events[pageSize-1].ID)
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditAdminAction,
+		ActorID: admin.UserID,
+		AppID:   admin.AppID,
+		Reason:  "list_webhook_events",
+	})
+
+	return events, nextPageToken, nil
+}
+
+// ReplayWebhookEvents makes the selected events pending again, so they are delivered
+// with a fresh set of attempts. Returns the number of events replayed. Admin only, the events of the admin's tenant
+func (a *Auth) ReplayWebhookEvents(ctx context.Context, filter models.ReplayFilter) (replayed int64, err error) {
+	const op = "auth.ReplayWebhookEvents"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to replay webhook events", slog.String("error", err.Error()))
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Admins see the events of their tenant only
+	filter.TenantID = admin.TenantID
+
+	replayed, err = a.outbox.ReplayEvents(ctx, filter, time.Now())
+	if err != nil {
+		log.Error("failed to replay webhook events", slog.String("error", err.Error()))
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("webhook events replayed", slog.Int64("replayed", replayed))
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditAdminAction,
+		ActorID: admin.UserID,
+		AppID:   filter.AppID,
+		Reason:  "replay_webhook_events",
+	})
+
+	return replayed, nil
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/storage/memory"
+	"testing"
+)
+
+// payloads returns the payloads of the outbox events of the type, the newest first
+func payloads(t *testing.T, st *memory.Storage, eventType string) []webhook.Payload {
+	t.Helper()
+
+	events, err := st.OutboxEvents(context.Background(), models.OutboxFilter{})
+	require.NoError(t, err)
+
+	var got []webhook.Payload
+
+	for _, e := range events {
+		if e.Type != eventType {
+			continue
+		}
+
+		var p webhook.Payload
+		require.NoError(t, json.Unmarshal(e.Payload, &p))
+		assert.Equal(t, e.EventID, p.ID)
+		assert.Equal(t, e.UserID, p.Data.UserID)
+
+		got = append(got, p)
+	}
+
+	return got
+}
+
+func TestRegisterNewUser_EnqueuesEvent(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	// One event per app with a webhook, sharing the event ID
+	got := payloads(t, st, models.EventUserRegistered)
+	require.Len(t, got, 2)
+	assert.Equal(t, got[0], got[1])
+	assert.Equal(t, models.EventUserRegistered, got[0].Type)
+	assert.Equal(t, webhook.UserData{UserID: userID, Email: "user@example.com"}, got[0].Data)
+
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.ErrorIs(t, err, ErrUserExists)
+
+	assert.Len(t, payloads(t, st, models.EventUserRegistered), 2, "event of a failed registration")
+}
+
+func TestWebhookEvents_Admin(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	adminID, err := a.RegisterNewUser(ctx, "admin@example.com", "password", 0)
+	require.NoError(t, err)
+	require.NoError(t, st.SetAdmin(adminID, true))
+
+	_, err = a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	admin, _ := login(t, a, ctx, "admin@example.com", 1)
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+	adminCtx := caller.NewContext(ctx, admin)
+	userCtx := caller.NewContext(ctx, user)
+
+	t.Run("not admin", func(t *testing.T) {
+		_, _, err := a.ListWebhookEvents(userCtx, models.OutboxFilter{}, 0, "")
+		assert.ErrorIs(t, err, ErrPermissionDenied)
+
+		_, err = a.ReplayWebhookEvents(ctx, models.ReplayFilter{})
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		first, next, err := a.ListWebhookEvents(adminCtx, models.OutboxFilter{}, 3, "")
+		require.NoError(t, err)
+		require.Len(t, first, 3)
+		require.NotEmpty(t, next)
+
+		rest, next, err := a.ListWebhookEvents(adminCtx, models.OutboxFilter{}, 3, next)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		assert.Empty(t, next)
+		assert.Greater(t, first[2].ID, rest[0].ID)
+
+		_, _, err = a.ListWebhookEvents(adminCtx, models.OutboxFilter{}, 3, "garbage")
+		assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+		app2, _, err := a.ListWebhookEvents(adminCtx, models.OutboxFilter{AppID: 2}, 0, "")
+		require.NoError(t, err)
+		assert.Len(t, app2, 2)
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		events, err := st.OutboxEvents(ctx, models.OutboxFilter{AppID: 2})
+		require.NoError(t, err)
+		require.NoError(t, st.DeadLetterEvent(ctx, events[0].ID, "unexpected status 500"))
+
+		replayed, err := a.ReplayWebhookEvents(adminCtx, models.ReplayFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), replayed)
+
+		events, err = st.OutboxEvents(ctx, models.OutboxFilter{Status: models.OutboxDead})
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditAdminAction}})
+		require.NoError(t, err)
+		require.NotEmpty(t, audit)
+		assert.Equal(t, "replay_webhook_events", audit[0].Reason)
+		assert.Equal(t, adminID, audit[0].ActorID)
+	})
+}
@@ -0,0 +1,392 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"time"
+)
+
+// sessionTouchInterval is how old the last use of a session gets before VerifyToken
+// updates it, so not every call writes to the storage
+const sessionTouchInterval = time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Sessions keeps the login sessions, so their tokens can be listed and revoked
+type Sessions interface {
+	SaveSession(ctx context.Context, session models.Session) error
+	Session(ctx context.Context, id string) (models.Session, error)
+	UserSessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error)
+	TouchSession(ctx context.Context, id string, lastUsedAt time.Time) error
+	RevokeSession(ctx context.Context, id string, revokedAt time.Time) error
+	RevokeUserSessions(ctx context.Context, userID int64, exceptID string, revokedAt time.Time) ([]models.Session, error)
+}
+
+// WithSessions saves a session at every login and links its token to it.
+// Without it the tokens can't be listed or revoked and are valid until they expire
+func WithSessions(sessions Sessions) Option {
+	return func(a *Auth) {
+		a.sessions = sessions
+	}
+}
+
+// startSession saves the session of the login and returns its ID
+func (a *Auth) startSession(ctx context.Context, user models.User, app models.App) (string, error) {
+	now := time.Now()
+
+	session := models.Session{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		AppID:      app.ID,
+		IP:         clientinfo.IP(ctx),
+		UserAgent:  clientinfo.UserAgent(ctx),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(a.tokenTTL),
+	}
+
+	if err := a.sessions.SaveSession(ctx, session); err != nil {
+		return "", err
+	}
+
+	return session.ID, nil
+}
+
+// checkSession fails with jwt.ErrInvalidToken if the session of the token is revoked
+// and records its use
+func (a *Auth) checkSession(ctx context.Context, c models.Caller) error {
+	session, err := a.sessions.Session(ctx, c.SessionID)
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return fmt.Errorf("%w: unknown session", jwt.ErrInvalidToken)
+		}
+
+		return err
+	}
+
+	if session.UserID != c.UserID || !session.RevokedAt.IsZero() {
+		return fmt.Errorf("%w: session is revoked", jwt.ErrInvalidToken)
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastUsedAt) < sessionTouchInterval {
+		return nil
+	}
+
+	if err := a.sessions.TouchSession(ctx, session.ID, now); err != nil {
+		slogger.FromContext(ctx, a.log).Error("failed to touch session",
+			slog.String("session_id", session.ID),
+			slog.String("error", err.Error()))
+	}
+
+	return nil
+}
+
+// ListSessions returns the active sessions of the caller, the newest first
+func (a *Auth) ListSessions(ctx context.Context) (sessions []models.Session, err error) {
+	const op = "auth.ListSessions"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrUnauthenticated)
+	}
+
+	sessions, err = a.userSessions(ctx, c.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes the session of the caller, its token is rejected from then on.
+// If the caller has no such active session, returns ErrSessionNotFound. An impersonating admin can't revoke it
+func (a *Auth) RevokeSession(ctx context.Context, sessionID string) (err error) {
+	const op = "auth.RevokeSession"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	c, err := requireUser(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeSession(ctx, c.UserID, sessionID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeAllSessions revokes the active sessions of the caller, but the current one if keepCurrent is set,
+// and returns how many were revoked. An impersonating admin can't revoke them
+func (a *Auth) RevokeAllSessions(ctx context.Context, keepCurrent bool) (revoked int64, err error) {
+	const op = "auth.RevokeAllSessions"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	c, err := requireUser(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	exceptID := ""
+	if keepCurrent {
+		exceptID = c.SessionID
+	}
+
+	revoked, err = a.revokeAllSessions(ctx, c.UserID, exceptID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// ListUserSessions returns the active sessions of the user, the newest first. Admin of the user's tenant only
+func (a *Auth) ListUserSessions(ctx context.Context, userID int64) (sessions []models.Session, err error) {
+	const op = "auth.ListUserSessions"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int64("userID", userID))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to list sessions", slog.String("error", err.Error()))
+
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkTenantUser(ctx, admin, userID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessions, err = a.userSessions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditAdminAction,
+		ActorID: admin.UserID,
+		UserID:  userID,
+		AppID:   admin.AppID,
+		Reason:  "list_user_sessions",
+	})
+
+	return sessions, nil
+}
+
+// RevokeUserSession revokes the session of the user. Admin of the user's tenant only.
+// If the user has no such active session, returns ErrSessionNotFound
+func (a *Auth) RevokeUserSession(ctx context.Context, userID int64, sessionID string) (err error) {
+	const op = "auth.RevokeUserSession"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int64("userID", userID))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to revoke session", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkTenantUser(ctx, admin, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.revokeSession(ctx, userID, sessionID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeAllUserSessions revokes the active sessions of the user and returns how many were revoked. Admin of the user's tenant only
+func (a *Auth) RevokeAllUserSessions(ctx context.Context, userID int64) (revoked int64, err error) {
+	const op = "auth.RevokeAllUserSessions"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int64("userID", userID))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to revoke sessions", slog.String("error", err.Error()))
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkTenantUser(ctx, admin, userID); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	revoked, err = a.revokeAllSessions(ctx, userID, "")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+func (a *Auth) userSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	if a.sessions == nil {
+		return nil, nil
+	}
+
+	sessions, err := a.sessions.UserSessions(ctx, userID, time.Now())
+	if err != nil {
+		slogger.FromContext(ctx, a.log).Error("failed to list sessions", slog.String("error", err.Error()))
+
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// revokeSession revokes the active session of the user
+func (a *Auth) revokeSession(ctx context.Context, userID int64, sessionID string) error {
+	if a.sessions == nil || sessionID == "" {
+		return ErrSessionNotFound
+	}
+
+	_, err := a.revoke(ctx, userID, func(ctx context.Context, now time.Time) ([]models.Session, error) {
+		session, err := a.sessions.Session(ctx, sessionID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Other users' sessions are reported as missing, not to tell they exist
+		if session.UserID != userID || !session.Active(now) {
+			return nil, ErrSessionNotFound
+		}
+
+		if err := a.sessions.RevokeSession(ctx, sessionID, now); err != nil {
+			return nil, err
+		}
+
+		session.RevokedAt = now
+
+		return []models.Session{session}, nil
+	})
+
+	return err
+}
+
+// revokeAllSessions revokes the active sessions of the user but exceptID and returns how many were revoked
+func (a *Auth) revokeAllSessions(ctx context.Context, userID int64, exceptID string) (int64, error) {
+	if a.sessions == nil {
+		return 0, nil
+	}
+
+	revoked, err := a.revoke(ctx, userID, func(ctx context.Context, now time.Time) ([]models.Session, error) {
+		return a.sessions.RevokeUserSessions(ctx, userID, exceptID, now)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(revoked)), nil
+}
+
+// revoke runs the revocation of the sessions of the user in a transaction,
+// emits a token revoked event to the app of every revoked session and audits them
+func (a *Auth) revoke(
+	ctx context.Context,
+	userID int64,
+	revokeFn func(ctx context.Context, now time.Time) ([]models.Session, error),
+) ([]models.Session, error) {
+	log := slogger.FromContext(ctx, a.log).With(slog.Int64("userID", userID))
+
+	var (
+		user    models.User
+		revoked []models.Session
+	)
+
+	err := a.withTx(ctx, func(ctx context.Context) (err error) {
+		user, err = a.userProvider.UserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		revoked, err = revokeFn(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+
+		for _, session := range revoked {
+			err := a.emit(ctx, models.EventTokenRevoked, user.TenantID, session.AppID, webhook.UserData{
+				UserID:    user.ID,
+				Email:     user.Email,
+				SessionID: session.ID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUserNotFound):
+			log.Warn("User not found", slog.String("error", err.Error()))
+
+			return nil, ErrUserNotFound
+		case errors.Is(err, storage.ErrSessionNotFound), errors.Is(err, ErrSessionNotFound):
+			log.Warn("Session not found", slog.String("error", err.Error()))
+
+			return nil, ErrSessionNotFound
+		case errors.Is(err, storage.ErrConsentNotFound):
+			log.Warn("Consent not found", slog.String("error", err.Error()))
+
+			return nil, ErrConsentNotFound
+		}
+
+		log.Error("failed to revoke sessions", slog.String("error", err.Error()))
+
+		return nil, err
+	}
+
+	log.Info("Sessions revoked", slog.Int("revoked", len(revoked)))
+
+	for _, session := range revoked {
+		a.audit(ctx, models.AuditEvent{
+			Type:     models.AuditTokenRevoke,
+			TenantID: user.TenantID,
+			UserID:   user.ID,
+			Email:    user.Email,
+			AppID:    session.AppID,
+		})
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/webhook"
+	"net"
+	"testing"
+	"time"
+)
+
+// register registers the user with the password of login in the tenant of the app
+func register(t *testing.T, a *Auth, email string, appID int) int64 {
+	t.Helper()
+
+	userID, err := a.RegisterNewUser(context.Background(), email, "password", appID)
+	require.NoError(t, err)
+
+	return userID
+}
+
+// login logs the user in to the app and returns the caller of the token
+func login(t *testing.T, a *Auth, ctx context.Context, email string, appID int) (models.Caller, string) {
+	t.Helper()
+
+	token, err := a.Login(ctx, email, "password", appID)
+	require.NoError(t, err)
+
+	c, err := a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	require.NotEmpty(t, c.SessionID)
+
+	return c, token
+}
+
+func TestLogin_SavesSession(t *testing.T) {
+	a, st := newTestAuth(t)
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000},
+	})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("user-agent", "test-agent"))
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	before := time.Now()
+	c, _ := login(t, a, ctx, "user@example.com", 2)
+
+	session, err := st.Session(ctx, c.SessionID)
+	require.NoError(t, err)
+	assert.Equal(t, userID, session.UserID)
+	assert.Equal(t, 2, session.AppID)
+	assert.Equal(t, "10.0.0.1", session.IP)
+	assert.Equal(t, "test-agent", session.UserAgent)
+	assert.False(t, session.CreatedAt.Before(before.UTC().Truncate(time.Second)))
+	assert.WithinDuration(t, session.CreatedAt.Add(time.Hour), session.ExpiresAt, time.Second)
+}
+
+func TestVerifyToken_TouchesSession(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	usedAt := time.Now().Add(-time.Hour).UTC()
+	require.NoError(t, st.SaveSession(ctx, models.Session{
+		ID:         "old",
+		UserID:     userID,
+		AppID:      1,
+		CreatedAt:  usedAt,
+		LastUsedAt: usedAt,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}))
+
+	token, err := jwt.NewToken(models.Caller{UserID: userID, Email: "user@example.com", AppID: 1, SessionID: "old"},
+		"secret", time.Hour)
+	require.NoError(t, err)
+
+	_, err = a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+
+	session, err := st.Session(ctx, "old")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), session.LastUsedAt, time.Minute)
+
+	// A session of another user does not make the token valid
+	forged, err := jwt.NewToken(models.Caller{UserID: userID + 1, Email: "other@example.com", AppID: 1, SessionID: "old"},
+		"secret", time.Hour)
+	require.NoError(t, err)
+
+	_, err = a.VerifyToken(ctx, forged)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	unknown, err := jwt.NewToken(models.Caller{UserID: userID, Email: "user@example.com", AppID: 1, SessionID: "unknown"},
+		"secret", time.Hour)
+	require.NoError(t, err)
+
+	_, err = a.VerifyToken(ctx, unknown)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestSessions(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "other@example.com", "password", 0)
+	require.NoError(t, err)
+
+	first, firstToken := login(t, a, ctx, "user@example.com", 1)
+	second, secondToken := login(t, a, ctx, "user@example.com", 2)
+	third, thirdToken := login(t, a, ctx, "user@example.com", 3)
+	other, _ := login(t, a, ctx, "other@example.com", 1)
+
+	userCtx := caller.NewContext(ctx, third)
+
+	t.Run("anonymous", func(t *testing.T) {
+		_, err := a.ListSessions(ctx)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+		assert.ErrorIs(t, a.RevokeSession(ctx, first.SessionID), ErrUnauthenticated)
+		_, err = a.RevokeAllSessions(ctx, false)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		sessions, err := a.ListSessions(userCtx)
+		require.NoError(t, err)
+		require.Len(t, sessions, 3)
+
+		ids := []string{sessions[0].ID, sessions[1].ID, sessions[2].ID}
+		assert.ElementsMatch(t, []string{first.SessionID, second.SessionID, third.SessionID}, ids)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		// Sessions of other users look missing
+		assert.ErrorIs(t, a.RevokeSession(userCtx, other.SessionID), ErrSessionNotFound)
+		assert.ErrorIs(t, a.RevokeSession(userCtx, "unknown"), ErrSessionNotFound)
+
+		require.NoError(t, a.RevokeSession(userCtx, second.SessionID))
+		assert.ErrorIs(t, a.RevokeSession(userCtx, second.SessionID), ErrSessionNotFound, "revoked twice")
+
+		_, err := a.VerifyToken(ctx, secondToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		_, err = a.VerifyToken(ctx, firstToken)
+		assert.NoError(t, err)
+
+		// Only the app of the token is notified
+		got := payloads(t, st, models.EventTokenRevoked)
+		require.Len(t, got, 1)
+		assert.Equal(t, webhook.UserData{
+			UserID:    userID,
+			Email:     "user@example.com",
+			SessionID: second.SessionID,
+		}, got[0].Data)
+
+		events, err := st.OutboxEvents(ctx, models.OutboxFilter{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, events[0].AppID)
+
+		audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditTokenRevoke}})
+		require.NoError(t, err)
+		require.Len(t, audit, 1)
+		assert.Equal(t, userID, audit[0].ActorID)
+		assert.Equal(t, userID, audit[0].UserID)
+		assert.Equal(t, 2, audit[0].AppID)
+	})
+
+	t.Run("revoke all but current", func(t *testing.T) {
+		revoked, err := a.RevokeAllSessions(userCtx, true)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), revoked)
+
+		_, err = a.VerifyToken(ctx, firstToken)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+
+		_, err = a.VerifyToken(ctx, thirdToken)
+		assert.NoError(t, err)
+
+		sessions, err := a.ListSessions(caller.NewContext(ctx, other))
+		require.NoError(t, err)
+		assert.Len(t, sessions, 1, "sessions of other users")
+	})
+
+	t.Run("revoke all", func(t *testing.T) {
+		revoked, err := a.RevokeAllSessions(userCtx, false)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), revoked)
+
+		sessions, err := a.ListSessions(userCtx)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+
+		events, err := st.UserEvents(ctx, models.UserEventFilter{Types: []string{models.EventTokenRevoked}})
+		require.NoError(t, err)
+		require.Len(t, events, 3)
+		assert.Equal(t, []int{2, 1, 3}, []int{events[0].AppID, events[1].AppID, events[2].AppID})
+	})
+}
+
+func TestUserSessions_Admin(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	adminID, err := a.RegisterNewUser(ctx, "admin@example.com", "password", 0)
+	require.NoError(t, err)
+	require.NoError(t, st.SetAdmin(adminID, true))
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	admin, _ := login(t, a, ctx, "admin@example.com", 1)
+	user, userToken := login(t, a, ctx, "user@example.com", 1)
+	_, _ = login(t, a, ctx, "user@example.com", 2)
+
+	adminCtx := caller.NewContext(ctx, admin)
+	userCtx := caller.NewContext(ctx, user)
+
+	t.Run("not admin", func(t *testing.T) {
+		_, err := a.ListUserSessions(userCtx, adminID)
+		assert.ErrorIs(t, err, ErrPermissionDenied)
+		assert.ErrorIs(t, a.RevokeUserSession(userCtx, adminID, admin.SessionID), ErrPermissionDenied)
+		_, err = a.RevokeAllUserSessions(ctx, adminID)
+		assert.ErrorIs(t, err, ErrUnauthenticated)
+	})
+
+	sessions, err := a.ListUserSessions(adminCtx, userID)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	assert.ErrorIs(t, a.RevokeUserSession(adminCtx, userID, admin.SessionID), ErrSessionNotFound)
+	require.NoError(t, a.RevokeUserSession(adminCtx, userID, user.SessionID))
+
+	_, err = a.VerifyToken(ctx, userToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	revoked, err := a.RevokeAllUserSessions(adminCtx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), revoked)
+
+	_, err = a.RevokeAllUserSessions(adminCtx, 404)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditTokenRevoke}})
+	require.NoError(t, err)
+	require.Len(t, audit, 2)
+	assert.Equal(t, adminID, audit[0].ActorID)
+	assert.Equal(t, userID, audit[0].UserID)
+
+	audit, err = st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditAdminAction}})
+	require.NoError(t, err)
+	require.NotEmpty(t, audit)
+	assert.Equal(t, "list_user_sessions", audit[0].Reason)
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+)
+
+// resolveTenant returns the tenant of the request: the tenant of the app if appID is set,
+// otherwise the tenant named in the metadata, the caller's or the default one.
+// The app of another tenant than the named one is ErrInvalidAppID, an unknown tenant is ErrInvalidTenant
+func (a *Auth) resolveTenant(ctx context.Context, appID int) (int64, error) {
+	named, isNamed := clientinfo.TenantID(ctx)
+
+	if appID != 0 {
+		app, err := a.appProvider.App(ctx, appID)
+		if err != nil {
+			if errors.Is(err, storage.ErrAppNotFound) {
+				return 0, ErrInvalidAppID
+			}
+
+			return 0, err
+		}
+
+		if isNamed && named != app.TenantID {
+			return 0, ErrInvalidAppID
+		}
+
+		return app.TenantID, nil
+	}
+
+	tenantID := models.DefaultTenantID
+	if c, ok := caller.FromContext(ctx); ok && c.TenantID != 0 {
+		tenantID = c.TenantID
+	}
+	if isNamed {
+		tenantID = named
+	}
+
+	if _, err := a.appProvider.Tenant(ctx, tenantID); err != nil {
+		if errors.Is(err, storage.ErrTenantNotFound) {
+			return 0, fmt.Errorf("%w: %d", ErrInvalidTenant, tenantID)
+		}
+
+		return 0, err
+	}
+
+	return tenantID, nil
+}
+
+// tenantUser returns the user if it belongs to the tenant of the admin.
+// Users of other tenants are reported as missing, admins don't manage them
+func (a *Auth) tenantUser(ctx context.Context, admin models.Caller, userID int64) (models.User, error) {
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if user.TenantID != admin.TenantID {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+// checkTenantUser returns ErrUserNotFound if the user is not in the tenant of the admin
+func (a *Auth) checkTenantUser(ctx context.Context, admin models.Caller, userID int64) error {
+	if _, err := a.tenantUser(ctx, admin, userID); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return ErrUserNotFound
+		}
+
+		slogger.FromContext(ctx, a.log).Error("failed to get user", slog.String("error", err.Error()))
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/clientinfo"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"testing"
+	"time"
+)
+
+// withTenant names the tenant in the request metadata
+func withTenant(ctx context.Context, tenantID string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(clientinfo.TenantIDKey, tenantID))
+}
+
+func TestRegisterNewUser_Tenants(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	defaultID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	// The same email joins another tenant with its app
+	otherID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 4)
+	require.NoError(t, err)
+	assert.NotEqual(t, defaultID, otherID)
+
+	user, err := st.UserByID(ctx, otherID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), user.TenantID)
+
+	// Or by naming the tenant
+	_, err = a.RegisterNewUser(withTenant(ctx, "2"), "user@example.com", "password", 0)
+	assert.ErrorIs(t, err, ErrUserExists)
+
+	_, err = a.RegisterNewUser(withTenant(ctx, "2"), "named@example.com", "password", 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		appID   int
+		wantErr error
+	}{
+		{name: "unknown tenant", ctx: withTenant(ctx, "3"), wantErr: ErrInvalidTenant},
+		{name: "malformed tenant", ctx: withTenant(ctx, "other"), wantErr: ErrInvalidTenant},
+		{name: "app of another tenant", ctx: withTenant(ctx, "1"), appID: 4, wantErr: ErrInvalidAppID},
+		{name: "unknown app", ctx: ctx, appID: 404, wantErr: ErrInvalidAppID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.RegisterNewUser(tt.ctx, "new@example.com", "password", tt.appID)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+
+	// Only the apps of the tenant are notified
+	events, err := st.OutboxEvents(ctx, models.OutboxFilter{AppID: 4})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(2), events[0].TenantID)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{TenantID: 2, Types: []string{models.AuditRegister}})
+	require.NoError(t, err)
+	assert.Len(t, audit, 2)
+}
+
+func TestLogin_Tenants(t *testing.T) {
+	a, _ := newTestAuth(t)
+	ctx := context.Background()
+
+	defaultID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 1)
+	require.NoError(t, err)
+	otherID, err := a.RegisterNewUser(ctx, "user@example.com", "other password", 4)
+	require.NoError(t, err)
+
+	c, _ := login(t, a, ctx, "user@example.com", 1)
+	assert.Equal(t, defaultID, c.UserID)
+	assert.Equal(t, models.DefaultTenantID, c.TenantID)
+
+	token, err := a.Login(ctx, "user@example.com", "other password", 4)
+	require.NoError(t, err)
+
+	c, err = a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, otherID, c.UserID)
+	assert.Equal(t, int64(2), c.TenantID)
+
+	// The user is looked up in the tenant of the app
+	_, err = a.Login(ctx, "user@example.com", "other password", 1)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	_, err = a.Login(withTenant(ctx, "1"), "user@example.com", "other password", 4)
+	assert.ErrorIs(t, err, ErrInvalidAppID)
+
+	_, err = a.Login(withTenant(ctx, "2"), "user@example.com", "other password", 4)
+	assert.NoError(t, err)
+}
+
+func TestAdmin_Tenants(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 1)
+	require.NoError(t, err)
+	adminID, err := a.RegisterNewUser(ctx, "admin@example.com", "password", 4)
+	require.NoError(t, err)
+	require.NoError(t, st.SetAdmin(adminID, true))
+
+	tenantUserID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 4)
+	require.NoError(t, err)
+
+	_, _ = login(t, a, ctx, "user@example.com", 1)
+	admin, _ := login(t, a, ctx, "admin@example.com", 4)
+	adminCtx := caller.NewContext(ctx, admin)
+
+	// The users of other tenants look missing
+	assert.ErrorIs(t, a.DisableUser(adminCtx, userID), ErrUserNotFound)
+	_, err = a.ListUserSessions(adminCtx, userID)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	_, err = a.RevokeAllUserSessions(adminCtx, userID)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	user, err := st.UserByID(ctx, userID)
+	require.NoError(t, err)
+	assert.False(t, user.Disabled)
+
+	require.NoError(t, a.DisableUser(adminCtx, tenantUserID))
+
+	// The admin sees the events of its tenant only, whatever the filter says
+	audit, _, err := a.ListAuditEvents(adminCtx, models.AuditFilter{TenantID: models.DefaultTenantID}, 100, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, audit)
+
+	for _, e := range audit {
+		assert.Equal(t, int64(2), e.TenantID, e.Type)
+	}
+
+	webhooks, _, err := a.ListWebhookEvents(adminCtx, models.OutboxFilter{}, 100, "")
+	require.NoError(t, err)
+	require.NotEmpty(t, webhooks)
+
+	for _, e := range webhooks {
+		assert.Equal(t, 4, e.AppID, e.Type)
+	}
+}
+
+func TestIsAdmin_AuditTenant(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	userID := register(t, a, "user@example.com", 4)
+
+	isAdmin, err := a.IsAdmin(ctx, userID)
+	require.NoError(t, err)
+	assert.False(t, isAdmin)
+
+	// The anonymous check is audited in the tenant of the user, not the default one
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditAdminCheck}})
+	require.NoError(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, int64(2), audit[0].TenantID)
+	assert.Equal(t, userID, audit[0].UserID)
+
+	_, err = a.IsAdmin(ctx, 404)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestVerifyToken_Tenants(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	adminID := register(t, a, "admin@example.com", 1)
+	require.NoError(t, st.SetAdmin(adminID, true))
+	tenantUserID := register(t, a, "user@example.com", 4)
+
+	admin, _ := login(t, a, ctx, "admin@example.com", 1)
+
+	// The app of the tenant 2 can't sign the tokens of the default tenant
+	forged, err := jwt.NewToken(models.Caller{UserID: adminID, TenantID: models.DefaultTenantID,
+		Email: admin.Email, AppID: 4, SessionID: admin.SessionID}, "secret4", time.Hour)
+	require.NoError(t, err)
+
+	_, err = a.VerifyToken(ctx, forged)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Nor put the users of the default tenant in its own
+	forged, err = jwt.NewToken(models.Caller{UserID: adminID, TenantID: 2,
+		Email: admin.Email, AppID: 4, SessionID: admin.SessionID}, "secret4", time.Hour)
+	require.NoError(t, err)
+
+	_, err = a.VerifyToken(ctx, forged)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, token := login(t, a, ctx, "user@example.com", 4)
+
+	c, err := a.VerifyToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, tenantUserID, c.UserID)
+	assert.Equal(t, int64(2), c.TenantID)
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/accesstoken"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/jwt"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid token")
+	ErrUnauthenticated  = errors.New("unauthenticated")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// VerifyToken checks the token issued by Login and returns the caller it was issued to.
+// The token of a revoked session is invalid. Personal access tokens are accepted too,
+// see CreateAccessToken
+func (a *Auth) VerifyToken(ctx context.Context, token string) (models.Caller, error) {
+	c, _, err := a.verifyToken(ctx, token)
+
+	return c, err
+}
+
+// verifyToken is VerifyToken also returning when the token expires, zero for access tokens without expiry
+func (a *Auth) verifyToken(ctx context.Context, token string) (c models.Caller, expiresAt time.Time, err error) {
+	const op = "auth.VerifyToken"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	if accesstoken.Is(token) {
+		c, expiresAt, err = a.verifyAccessToken(ctx, token)
+		if err != nil {
+			if errors.Is(err, jwt.ErrInvalidToken) {
+				return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+			}
+
+			log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+			log.Error("failed to verify access token", slog.String("error", err.Error()))
+
+			return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return c, expiresAt, nil
+	}
+
+	var app models.App
+
+	c, err = jwt.ParseToken(token, func(appID int) (string, error) {
+		app, err = a.appProvider.App(ctx, appID)
+		if err != nil {
+			if errors.Is(err, storage.ErrAppNotFound) {
+				return "", jwt.ErrInvalidToken
+			}
+
+			return "", err
+		}
+
+		return app.Secret, nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrInvalidToken) || errors.Is(err, jwt.ErrTokenExpired) {
+			return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+		}
+
+		log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+		log.Error("failed to verify token", slog.String("error", err.Error()))
+
+		return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// The secret of an app signs the tokens of its tenant only
+	if c.TenantID != app.TenantID {
+		return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: tenant of another app", op, ErrInvalidToken)
+	}
+
+	if err := a.checkUser(ctx, c); err != nil {
+		if errors.Is(err, jwt.ErrInvalidToken) {
+			return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+		}
+
+		log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+		log.Error("failed to check user", slog.String("error", err.Error()))
+
+		return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Tokens without a session were issued before the sessions were kept
+	if a.sessions != nil && c.SessionID != "" {
+		if err := a.checkSession(ctx, c); err != nil {
+			if errors.Is(err, jwt.ErrInvalidToken) {
+				return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+			}
+
+			log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+			log.Error("failed to check session", slog.String("error", err.Error()))
+
+			return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	expiresAt, err = jwt.ExpiresAt(token)
+	if err != nil {
+		return models.Caller{}, time.Time{}, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+	}
+
+	return c, expiresAt, nil
+}
+
+// checkUser checks the user of the token exists in the tenant of the token and is not disabled
+func (a *Auth) checkUser(ctx context.Context, c models.Caller) error {
+	user, err := a.userProvider.UserByID(ctx, c.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return fmt.Errorf("%w: unknown user", jwt.ErrInvalidToken)
+		}
+
+		return err
+	}
+
+	if user.TenantID != c.TenantID {
+		return fmt.Errorf("%w: user of another tenant", jwt.ErrInvalidToken)
+	}
+
+	if user.Disabled {
+		return fmt.Errorf("%w: user is disabled", jwt.ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// checkCaller checks the token of the caller is still valid: its user is enabled in its tenant, and its session
+// or personal access token is active. Long calls check it again, the token was verified when they started
+func (a *Auth) checkCaller(ctx context.Context, c models.Caller) error {
+	if err := a.checkUser(ctx, c); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	switch {
+	case c.AccessTokenID != 0 && a.accessTokens != nil:
+		tokens, err := a.accessTokens.UserAccessTokens(ctx, c.UserID)
+		if err != nil {
+			return err
+		}
+
+		active := slices.ContainsFunc(tokens, func(token models.AccessToken) bool {
+			return token.ID == c.AccessTokenID && token.Active(now)
+		})
+		if !active {
+			return fmt.Errorf("%w: access token is revoked or expired", jwt.ErrInvalidToken)
+		}
+	case c.SessionID != "" && a.sessions != nil:
+		session, err := a.sessions.Session(ctx, c.SessionID)
+		if err != nil {
+			if errors.Is(err, storage.ErrSessionNotFound) {
+				return fmt.Errorf("%w: unknown session", jwt.ErrInvalidToken)
+			}
+
+			return err
+		}
+
+		if session.UserID != c.UserID || !session.Active(now) {
+			return fmt.Errorf("%w: session is revoked or expired", jwt.ErrInvalidToken)
+		}
+	}
+
+	return nil
+}
+
+// recheckAdmin checks the admin returned by requireAdmin again, for the calls outliving its token
+// or its admin rights. Returns ErrUnauthenticated if the token is no longer valid
+func (a *Auth) recheckAdmin(ctx context.Context, admin models.Caller) error {
+	if err := a.checkCaller(ctx, admin); err != nil {
+		if errors.Is(err, jwt.ErrInvalidToken) {
+			return fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+		}
+
+		return err
+	}
+
+	isAdmin, err := a.userProvider.IsAdmin(ctx, admin.UserID)
+	if err != nil {
+		return err
+	}
+
+	if !isAdmin {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// requireAdmin returns the caller if it's an admin, the admin manages its tenant only.
+// Tokens issued by Impersonate are refused, and so are the tokens without a session
+// other than personal access tokens
+func (a *Auth) requireAdmin(ctx context.Context) (models.Caller, error) {
+	c, ok := caller.FromContext(ctx)
+	if !ok {
+		return models.Caller{}, ErrUnauthenticated
+	}
+
+	// The admin APIs are sensitive, the impersonated user can't call them
+	if c.Actor != nil {
+		return models.Caller{}, ErrImpersonated
+	}
+
+	// The secret of an app signs any token, the admin APIs need the record of one that can be revoked
+	if c.AccessTokenID == 0 && (a.sessions == nil || c.SessionID == "") {
+		return models.Caller{}, ErrUnauthenticated
+	}
+
+	isAdmin, err := a.userProvider.IsAdmin(ctx, c.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Caller{}, ErrPermissionDenied
+		}
+
+		return models.Caller{}, err
+	}
+
+	if !isAdmin {
+		return models.Caller{}, ErrPermissionDenied
+	}
+
+	if c.TenantID == 0 {
+		c.TenantID = models.DefaultTenantID
+	}
+
+	return c, nil
+}
@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/logger/slogger"
+	"grpc-sso/internal/storage"
+	"log/slog"
+	"time"
+)
+
+// ChangeEmail changes the email of the caller, the current password confirms the change.
+// An impersonating admin can't change it.
+// If the email is taken by another user, returns ErrUserExists, if it belongs to an LDAP backend ErrDirectoryUser
+func (a *Auth) ChangeEmail(ctx context.Context, newEmail string, password string) (err error) {
+	const op = "auth.ChangeEmail"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	c, err := requireUser(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", c.UserID))
+
+	user, err := a.userProvider.UserByID(ctx, c.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("User not found", slog.String("error", err.Error()))
+
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, hashSpan := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	observeHash := metrics.ObservePasswordHash(metrics.HashCompare)
+	hashErr := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password))
+	observeHash()
+	hashSpan.End()
+
+	if hashErr != nil {
+		log.Info("invalid credentials", slog.String("error", hashErr.Error()))
+
+		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	if user.Email == newEmail {
+		return nil
+	}
+
+	// Otherwise the user would take over the entry with the email at its first login
+	if _, ok := a.directory(user.TenantID, newEmail); ok {
+		log.Warn("Email of a directory")
+
+		return fmt.Errorf("%s: %w", op, ErrDirectoryUser)
+	}
+
+	err = a.withTx(ctx, func(ctx context.Context) error {
+		if err := a.userSaver.UpdateEmail(ctx, user.ID, newEmail); err != nil {
+			return err
+		}
+
+		return a.emit(ctx, models.EventUserEmailChanged, user.TenantID, 0, webhook.UserData{
+			UserID:        user.ID,
+			Email:         newEmail,
+			PreviousEmail: user.Email,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrUserExists) {
+			log.Warn("Email is taken", slog.String("error", err.Error()))
+
+			return fmt.Errorf("%s: %w", op, ErrUserExists)
+		}
+
+		log.Error("failed to change email", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("Email changed")
+	a.invalidator.InvalidateUser(user.ID)
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditEmailChange,
+		ActorID: c.UserID,
+		UserID:  user.ID,
+		Email:   newEmail,
+		AppID:   c.AppID,
+	})
+
+	return nil
+}
+
+// DisableUser disables the user and revokes its sessions and personal access tokens, disabled users can't login
+// and their tokens are rejected. Admin of the user's tenant only. Disabling a disabled user does nothing
+func (a *Auth) DisableUser(ctx context.Context, userID int64) (err error) {
+	const op = "auth.DisableUser"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(
+		slog.String("op", op),
+		slog.Int64("userID", userID))
+
+	admin, err := a.requireAdmin(ctx)
+	if err != nil {
+		log.Warn("not allowed to disable user", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	var (
+		user         models.User
+		accessTokens []models.AccessToken
+	)
+
+	// The sessions and the access tokens of the user are revoked with it, so its tokens are rejected at once
+	_, err = a.revoke(ctx, userID, func(ctx context.Context, now time.Time) (_ []models.Session, err error) {
+		user, err = a.tenantUser(ctx, admin, userID)
+		if err != nil || user.Disabled {
+			return nil, err
+		}
+
+		if err := a.userSaver.DisableUser(ctx, userID); err != nil {
+			return nil, err
+		}
+
+		err = a.emit(ctx, models.EventUserDisabled, user.TenantID, 0, webhook.UserData{
+			UserID: user.ID,
+			Email:  user.Email,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		accessTokens, err = a.revokeAccessTokens(ctx, userID, now, func(models.AccessToken) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+
+		if a.sessions == nil {
+			return nil, nil
+		}
+
+		return a.sessions.RevokeUserSessions(ctx, userID, "", now)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("User disabled")
+	a.invalidator.InvalidateUser(userID)
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditAdminAction,
+		ActorID: admin.UserID,
+		UserID:  user.ID,
+		Email:   user.Email,
+		AppID:   admin.AppID,
+		Reason:  "disable_user",
+	})
+
+	for _, token := range accessTokens {
+		a.audit(ctx, models.AuditEvent{
+			Type:     models.AuditAccessTokenRevoke,
+			TenantID: user.TenantID,
+			ActorID:  admin.UserID,
+			UserID:   user.ID,
+			Email:    user.Email,
+			AppID:    token.AppID,
+			Reason:   token.Hint,
+		})
+	}
+
+	return nil
+}
+
+// ChangePassword changes the password of the caller, the old password confirms the change.
+// An impersonating admin can't change it. The users of an LDAP backend change it in the directory,
+// ErrDirectoryUser is returned for them
+func (a *Auth) ChangePassword(ctx context.Context, oldPassword string, newPassword string) (err error) {
+	const op = "auth.ChangePassword"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	log := slogger.FromContext(ctx, a.log).With(slog.String("op", op))
+
+	c, err := requireUser(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("userID", c.UserID))
+
+	user, err := a.userProvider.UserByID(ctx, c.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("User not found", slog.String("error", err.Error()))
+
+			return fmt.Errorf("%s: %w", op, ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// Login never checks the local password of these users
+	if _, ok := a.directory(user.TenantID, user.Email); ok {
+		log.Warn("User of a directory")
+
+		return fmt.Errorf("%s: %w", op, ErrDirectoryUser)
+	}
+
+	_, hashSpan := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	observeHash := metrics.ObservePasswordHash(metrics.HashCompare)
+	hashErr := bcrypt.CompareHashAndPassword(user.PassHash, []byte(oldPassword))
+	observeHash()
+	hashSpan.End()
+
+	if hashErr != nil {
+		log.Info("invalid credentials", slog.String("error", hashErr.Error()))
+
+		return fmt.Errorf("%s: %w", op, ErrInvalidCredentials)
+	}
+
+	_, hashSpan = tracer.Start(ctx, "bcrypt.GenerateFromPassword")
+	observeHash = metrics.ObservePasswordHash(metrics.HashGenerate)
+	passHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	observeHash()
+	hashSpan.End()
+
+	if err != nil {
+		log.Error("Failed to generate password hash", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.withTx(ctx, func(ctx context.Context) error {
+		if err := a.userSaver.UpdatePassword(ctx, user.ID, passHash); err != nil {
+			return err
+		}
+
+		return a.emit(ctx, models.EventUserPasswordChanged, user.TenantID, 0, webhook.UserData{
+			UserID: user.ID,
+			Email:  user.Email,
+		})
+	})
+	if err != nil {
+		log.Error("failed to change password", slog.String("error", err.Error()))
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("Password changed")
+	a.invalidator.InvalidateUser(user.ID)
+	a.audit(ctx, models.AuditEvent{
+		Type:    models.AuditPasswordChange,
+		ActorID: c.UserID,
+		UserID:  user.ID,
+		Email:   user.Email,
+		AppID:   c.AppID,
+	})
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/caller"
+	"grpc-sso/internal/lib/webhook"
+	"testing"
+	"time"
+)
+
+func TestChangeEmail(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+	_, err = a.RegisterNewUser(ctx, "taken@example.com", "password", 0)
+	require.NoError(t, err)
+
+	userCtx := caller.NewContext(ctx, models.Caller{UserID: userID, AppID: 1})
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		email   string
+		pass    string
+		wantErr error
+	}{
+		{name: "anonymous", ctx: ctx, email: "new@example.com", pass: "password", wantErr: ErrUnauthenticated},
+		{name: "wrong password", ctx: userCtx, email: "new@example.com", pass: "wrong", wantErr: ErrInvalidCredentials},
+		{name: "taken email", ctx: userCtx, email: "taken@example.com", pass: "password", wantErr: ErrUserExists},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := a.ChangeEmail(test.ctx, test.email, test.pass)
+			assert.ErrorIs(t, err, test.wantErr)
+		})
+	}
+
+	assert.Empty(t, payloads(t, st, models.EventUserEmailChanged), "event of a failed change")
+
+	require.NoError(t, a.ChangeEmail(userCtx, "new@example.com", "password"))
+
+	_, err = a.Login(ctx, "user@example.com", "password", 1)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = a.Login(ctx, "new@example.com", "password", 1)
+	assert.NoError(t, err)
+
+	got := payloads(t, st, models.EventUserEmailChanged)
+	require.Len(t, got, 2)
+	assert.Equal(t, webhook.UserData{
+		UserID:        userID,
+		Email:         "new@example.com",
+		PreviousEmail: "user@example.com",
+	}, got[0].Data)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditEmailChange}})
+	require.NoError(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, userID, audit[0].UserID)
+}
+
+func TestDisableUser(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	adminID, err := a.RegisterNewUser(ctx, "admin@example.com", "password", 0)
+	require.NoError(t, err)
+	require.NoError(t, st.SetAdmin(adminID, true))
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	admin, _ := login(t, a, ctx, "admin@example.com", 1)
+	user, _ := login(t, a, ctx, "user@example.com", 1)
+	adminCtx := caller.NewContext(ctx, admin)
+	userCtx := caller.NewContext(ctx, user)
+
+	assert.ErrorIs(t, a.DisableUser(ctx, userID), ErrUnauthenticated)
+	assert.ErrorIs(t, a.DisableUser(userCtx, adminID), ErrPermissionDenied)
+	assert.ErrorIs(t, a.DisableUser(adminCtx, 404), ErrUserNotFound)
+
+	require.NoError(t, a.DisableUser(adminCtx, userID))
+
+	_, err = a.Login(ctx, "user@example.com", "password", 1)
+	assert.ErrorIs(t, err, ErrUserDisabled)
+
+	// The wrong password is reported as usual
+	_, err = a.Login(ctx, "user@example.com", "wrong", 1)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Disabling again emits no event
+	require.NoError(t, a.DisableUser(adminCtx, userID))
+
+	got := payloads(t, st, models.EventUserDisabled)
+	require.Len(t, got, 2)
+	assert.Equal(t, webhook.UserData{UserID: userID, Email: "user@example.com"}, got[0].Data)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditLoginFailed}})
+	require.NoError(t, err)
+	require.Len(t, audit, 2)
+	assert.Equal(t, models.AuditReasonUserDisabled, audit[1].Reason)
+}
+
+func TestDisableUser_RevokesTokens(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	adminID := register(t, a, "admin@example.com", 1)
+	require.NoError(t, st.SetAdmin(adminID, true))
+	userID := register(t, a, "user@example.com", 1)
+
+	admin, _ := login(t, a, ctx, "admin@example.com", 1)
+	adminCtx := caller.NewContext(ctx, admin)
+	user, token := login(t, a, ctx, "user@example.com", 1)
+
+	accessToken, _, err := a.CreateAccessToken(caller.NewContext(ctx, user), "ci", 2, nil, time.Time{})
+	require.NoError(t, err)
+
+	require.NoError(t, a.DisableUser(adminCtx, userID))
+
+	_, err = a.VerifyToken(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = a.VerifyToken(ctx, accessToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	sessions, err := st.UserSessions(ctx, userID, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	got := payloads(t, st, models.EventTokenRevoked)
+	require.Len(t, got, 2)
+	assert.Equal(t, webhook.UserData{UserID: userID, Email: "user@example.com", SessionID: user.SessionID}, got[0].Data)
+	assert.NotZero(t, got[1].Data.AccessTokenID)
+
+	// The tokens of a user disabled in the storage are rejected too
+	otherID := register(t, a, "other@example.com", 1)
+	_, token = login(t, a, ctx, "other@example.com", 1)
+	require.NoError(t, st.DisableUser(ctx, otherID))
+
+	_, err = a.VerifyToken(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestChangePassword(t *testing.T) {
+	a, st := newTestAuth(t)
+	ctx := context.Background()
+
+	userID, err := a.RegisterNewUser(ctx, "user@example.com", "password", 0)
+	require.NoError(t, err)
+
+	userCtx := caller.NewContext(ctx, models.Caller{UserID: userID, AppID: 1})
+
+	assert.ErrorIs(t, a.ChangePassword(ctx, "password", "new-password"), ErrUnauthenticated)
+	assert.ErrorIs(t, a.ChangePassword(userCtx, "wrong", "new-password"), ErrInvalidCredentials)
+	assert.Empty(t, payloads(t, st, models.EventUserPasswordChanged), "event of a failed change")
+
+	require.NoError(t, a.ChangePassword(userCtx, "password", "new-password"))
+
+	_, err = a.Login(ctx, "user@example.com", "password", 1)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = a.Login(ctx, "user@example.com", "new-password", 1)
+	assert.NoError(t, err)
+
+	got := payloads(t, st, models.EventUserPasswordChanged)
+	require.Len(t, got, 2)
+	assert.Equal(t, webhook.UserData{UserID: userID, Email: "user@example.com"}, got[0].Data)
+
+	events, err := st.UserEvents(ctx, models.UserEventFilter{Types: []string{models.EventUserPasswordChanged}})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, userID, events[0].UserID)
+
+	audit, err := st.AuditEvents(ctx, models.AuditFilter{Types: []string{models.AuditPasswordChange}})
+	require.NoError(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, userID, audit[0].UserID)
+}
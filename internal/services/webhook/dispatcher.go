@@ -0,0 +1,237 @@
+// Package webhook delivers the domain events of the outbox to the app webhooks
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var tracer = otel.Tracer("grpc-sso/internal/services/webhook")
+
+// maxErrorLength bounds the error kept with the event
+const maxErrorLength = 512
+
+type Outbox interface {
+	ClaimEvents(ctx context.Context, now, leaseUntil time.Time, limit int) ([]models.OutboxEvent, error)
+	MarkEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error
+	RetryEvent(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error
+	DeadLetterEvent(ctx context.Context, id int64, lastErr string) error
+}
+
+type AppProvider interface {
+	App(ctx context.Context, appID int) (app models.App, err error)
+}
+
+type Options struct {
+	// BatchSize is the number of events claimed and delivered concurrently at once
+	BatchSize int
+	// MaxAttempts is the number of failed attempts after which the event is dead-lettered
+	MaxAttempts int
+	// MinBackoff is the delay after the first failed attempt, doubled after every next one
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout of a delivery request
+	Timeout time.Duration
+}
+
+// Dispatcher delivers the outbox events as signed POST requests to the webhook URLs of their apps.
+// Several dispatchers may share the outbox: the claimed events are leased for twice
+// the delivery timeout, after that they are claimed again
+type Dispatcher struct {
+	log    *slog.Logger
+	outbox Outbox
+	apps   AppProvider
+	client *http.Client
+	opts   Options
+	now    func() time.Time
+}
+
+// New returns a new dispatcher, the client is used for the delivery requests
+func New(log *slog.Logger, outbox Outbox, apps AppProvider, client *http.Client, opts Options) *Dispatcher {
+	return &Dispatcher{
+		log:    log,
+		outbox: outbox,
+		apps:   apps,
+		client: client,
+		opts:   opts,
+		now:    time.Now,
+	}
+}
+
+// DispatchOnce claims a batch of due events and delivers them.
+// Returns the number of claimed events, 0 when nothing is due.
+// The claimed events are delivered even if ctx is cancelled meanwhile
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	const op = "webhook.DispatchOnce"
+
+	now := d.now()
+
+	events, err := d.outbox.ClaimEvents(ctx, now, now.Add(2*d.opts.Timeout), d.opts.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+
+	var wg sync.WaitGroup
+
+	for _, event := range events {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			d.dispatch(ctx, event)
+		}()
+	}
+
+	wg.Wait()
+
+	return len(events), nil
+}
+
+// Backoff returns the delay before the next attempt after the given number of failed ones
+func (d *Dispatcher) Backoff(failedAttempts int) time.Duration {
+	backoff := d.opts.MinBackoff
+
+	for i := 1; i < failedAttempts && backoff < d.opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, d.opts.MaxBackoff)
+}
+
+// dispatch delivers the event and records the result
+func (d *Dispatcher) dispatch(ctx context.Context, event models.OutboxEvent) {
+	const op = "webhook.dispatch"
+
+	log := d.log.With(
+		slog.String("op", op),
+		slog.Int64("id", event.ID),
+		slog.String("event_id", event.EventID),
+		slog.Int("app_id", event.AppID),
+	)
+
+	appID := strconv.Itoa(event.AppID)
+
+	err := d.deliver(ctx, event)
+	if err == nil {
+		metrics.WebhookDeliveries.WithLabelValues(appID, metrics.WebhookDelivered).Inc()
+
+		if err := d.outbox.MarkEventDelivered(ctx, event.ID, d.now()); err != nil {
+			log.Error("failed to mark event delivered", slog.String("error", err.Error()))
+		}
+
+		return
+	}
+
+	lastErr := err.Error()
+	if len(lastErr) > maxErrorLength {
+		lastErr = lastErr[:maxErrorLength]
+	}
+
+	failedAttempts := event.Attempts + 1
+
+	var permanent *permanentError
+	if errors.As(err, &permanent) || failedAttempts >= d.opts.MaxAttempts {
+		log.Error("webhook delivery failed, event is dead-lettered",
+			slog.Int("attempts", failedAttempts),
+			slog.String("error", lastErr))
+		metrics.WebhookDeliveries.WithLabelValues(appID, metrics.WebhookDead).Inc()
+
+		if err := d.outbox.DeadLetterEvent(ctx, event.ID, lastErr); err != nil {
+			log.Error("failed to dead-letter event", slog.String("error", err.Error()))
+		}
+
+		return
+	}
+
+	next := d.now().Add(d.Backoff(failedAttempts))
+
+	log.Warn("webhook delivery failed, will retry",
+		slog.Int("attempts", failedAttempts),
+		slog.Time("next_attempt_at", next),
+		slog.String("error", lastErr))
+	metrics.WebhookDeliveries.WithLabelValues(appID, metrics.WebhookRetry).Inc()
+
+	if err := d.outbox.RetryEvent(ctx, event.ID, lastErr, next); err != nil {
+		log.Error("failed to schedule retry", slog.String("error", err.Error()))
+	}
+}
+
+// permanentError fails the delivery without retries
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+var errNoWebhook = errors.New("app has no webhook")
+
+// deliver sends the event to the webhook of its app, non 2xx responses are errors
+func (d *Dispatcher) deliver(ctx context.Context, event models.OutboxEvent) (err error) {
+	const op = "webhook.deliver"
+
+	ctx, span := tracer.Start(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	app, err := d.apps.App(ctx, event.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return &permanentError{err: err}
+		}
+
+		return err
+	}
+
+	if app.WebhookURL == "" {
+		return &permanentError{err: errNoWebhook}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, app.WebhookURL, bytes.NewReader(event.Payload))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.HeaderEvent, event.Type)
+	req.Header.Set(webhook.HeaderEventID, event.EventID)
+
+	timestamp := d.now()
+	req.Header.Set(webhook.HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(webhook.HeaderSignature, webhook.Sign(app.WebhookSecret, timestamp, event.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drained, so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/internal/storage/memory"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{
+	BatchSize:   10,
+	MaxAttempts: 3,
+	MinBackoff:  time.Second,
+	MaxBackoff:  10 * time.Second,
+	Timeout:     time.Second,
+}
+
+// receiver is a webhook endpoint answering with status and recording the requests
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+
+	w.WriteHeader(r.status)
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = status
+}
+
+type testEnv struct {
+	storage    *memory.Storage
+	dispatcher *Dispatcher
+	receiver   *receiver
+	now        time.Time
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	env := &testEnv{
+		storage:  memory.New(),
+		receiver: &receiver{status: http.StatusNoContent},
+		now:      time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC),
+	}
+
+	server := httptest.NewServer(env.receiver)
+	t.Cleanup(server.Close)
+
+	env.storage.AddApp(models.App{ID: 1, Name: "test", Secret: "secret", WebhookURL: server.URL, WebhookSecret: "hook-secret"})
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	env.dispatcher = New(log, env.storage, env.storage, server.Client(), testOptions)
+	env.dispatcher.now = func() time.Time { return env.now }
+
+	return env
+}
+
+func (env *testEnv) enqueue(t *testing.T, eventID string) {
+	t.Helper()
+
+	require.NoError(t, env.storage.EnqueueEvent(context.Background(), models.OutboxEvent{
+		EventID:   eventID,
+		Type:      models.EventUserRegistered,
+		TenantID:  models.DefaultTenantID,
+		UserID:    7,
+		Payload:   []byte(`{"id":"` + eventID + `"}`),
+		CreatedAt: env.now,
+	}))
+}
+
+func (env *testEnv) event(t *testing.T) models.OutboxEvent {
+	t.Helper()
+
+	events, err := env.storage.OutboxEvents(context.Background(), models.OutboxFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	return events[0]
+}
+
+func TestDispatchOnce_Delivered(t *testing.T) {
+	env := newTestEnv(t)
+	env.enqueue(t, "ev-1")
+
+	n, err := env.dispatcher.DispatchOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	require.Len(t, env.receiver.requests, 1)
+	req, body := env.receiver.requests[0], env.receiver.bodies[0]
+
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, models.EventUserRegistered, req.Header.Get(webhook.HeaderEvent))
+	assert.Equal(t, "ev-1", req.Header.Get(webhook.HeaderEventID))
+	assert.Equal(t, `{"id":"ev-1"}`, string(body))
+
+	err = webhook.Verify("hook-secret", req.Header.Get(webhook.HeaderTimestamp),
+		req.Header.Get(webhook.HeaderSignature), body, env.now, time.Minute)
+	assert.NoError(t, err)
+
+	e := env.event(t)
+	assert.Equal(t, models.OutboxDelivered, e.Status)
+	assert.True(t, env.now.Equal(e.DeliveredAt))
+
+	n, err = env.dispatcher.DispatchOnce(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, n, "delivered events are dispatched again")
+}
+
+func TestDispatchOnce_RetryAndDeadLetter(t *testing.T) {
+	env := newTestEnv(t)
+	env.receiver.setStatus(http.StatusInternalServerError)
+	env.enqueue(t, "ev-1")
+
+	ctx := context.Background()
+
+	_, err := env.dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+
+	e := env.event(t)
+	assert.Equal(t, models.OutboxPending, e.Status)
+	assert.Equal(t, 1, e.Attempts)
+	assert.Contains(t, e.LastError, "500")
+	assert.True(t, env.now.Add(time.Second).Equal(e.NextAttemptAt), e.NextAttemptAt)
+
+	// Not due until the backoff passes
+	n, err := env.dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	env.now = e.NextAttemptAt
+
+	_, err = env.dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+
+	e = env.event(t)
+	assert.Equal(t, 2, e.Attempts)
+	assert.True(t, env.now.Add(2*time.Second).Equal(e.NextAttemptAt), e.NextAttemptAt)
+
+	env.now = e.NextAttemptAt
+
+	_, err = env.dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+
+	e = env.event(t)
+	assert.Equal(t, models.OutboxDead, e.Status)
+	assert.Equal(t, testOptions.MaxAttempts, e.Attempts)
+	assert.Len(t, env.receiver.requests, testOptions.MaxAttempts)
+
+	// A replayed event is delivered once the endpoint is fixed
+	env.receiver.setStatus(http.StatusOK)
+
+	replayed, err := env.storage.ReplayEvents(ctx, models.ReplayFilter{}, env.now)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), replayed)
+
+	_, err = env.dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, models.OutboxDelivered, env.event(t).Status)
+}
+
+func TestDispatchOnce_NoWebhook(t *testing.T) {
+	env := newTestEnv(t)
+	env.enqueue(t, "ev-1")
+
+	// The webhook is removed after the event was enqueued
+	env.storage.AddApp(models.App{ID: 1, Name: "test", Secret: "secret"})
+
+	_, err := env.dispatcher.DispatchOnce(context.Background())
+	require.NoError(t, err)
+
+	e := env.event(t)
+	assert.Equal(t, models.OutboxDead, e.Status, "there is nothing to retry")
+	assert.Equal(t, 1, e.Attempts)
+	assert.Empty(t, env.receiver.requests)
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{opts: testOptions}
+
+	for failed, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		50: 10 * time.Second,
+	} {
+		assert.Equal(t, want, d.Backoff(failed), failed)
+	}
+}
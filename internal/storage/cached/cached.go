@@ -0,0 +1,174 @@
+// Package cached is a read-through cache in front of the storage
+package cached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/cache"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/services/auth"
+	"grpc-sso/internal/storage"
+	"time"
+)
+
+// Cache names in metrics
+const (
+	appCache         = "app"
+	isAdminCache     = "is_admin"
+	permissionsCache = "permissions"
+)
+
+type Options struct {
+	AppTTL time.Duration
+	// AppNegativeTTL is how long unknown app IDs are remembered
+	AppNegativeTTL time.Duration
+	AppMaxSize     int
+	IsAdminTTL     time.Duration
+	IsAdminMaxSize int
+	// PermissionsTTL bounds how long the effective permissions of a user may stay stale
+	// if a change is made by another instance, changes made by this one invalidate them
+	PermissionsTTL     time.Duration
+	PermissionsMaxSize int
+}
+
+// permissionsKey is the user in the app the permissions are computed for
+type permissionsKey struct {
+	userID int64
+	appID  int
+}
+
+// appEntry is a cached app or a cached ErrAppNotFound
+type appEntry struct {
+	app      models.App
+	notFound bool
+}
+
+// Storage caches apps, admin flags and effective permissions of the wrapped providers.
+// User lookups are passed through, password hashes are never cached
+type Storage struct {
+	apps        auth.AppProvider
+	users       auth.UserProvider
+	permissions auth.PermissionsProvider
+	opts        Options
+
+	appCache         *cache.Cache[int, appEntry]
+	isAdminCache     *cache.Cache[int64, bool]
+	permissionsCache *cache.Cache[permissionsKey, models.Permissions]
+}
+
+// New wraps the providers
+func New(apps auth.AppProvider, users auth.UserProvider, permissions auth.PermissionsProvider, opts Options) *Storage {
+	return &Storage{
+		apps:             apps,
+		users:            users,
+		permissions:      permissions,
+		opts:             opts,
+		appCache:         cache.New[int, appEntry](opts.AppMaxSize),
+		isAdminCache:     cache.New[int64, bool](opts.IsAdminMaxSize),
+		permissionsCache: cache.New[permissionsKey, models.Permissions](opts.PermissionsMaxSize),
+	}
+}
+
+func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
+	const op = "storage.cached.App"
+
+	if e, ok := s.appCache.Get(appID); ok {
+		metrics.CacheRequests.WithLabelValues(appCache, metrics.CacheHit).Inc()
+
+		if e.notFound {
+			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+		}
+
+		return e.app, nil
+	}
+
+	metrics.CacheRequests.WithLabelValues(appCache, metrics.CacheMiss).Inc()
+
+	app, err := s.apps.App(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) && s.opts.AppNegativeTTL > 0 {
+			s.appCache.Set(appID, appEntry{notFound: true}, s.opts.AppNegativeTTL)
+		}
+
+		return models.App{}, err
+	}
+
+	s.appCache.Set(appID, appEntry{app: app}, s.opts.AppTTL)
+
+	return app, nil
+}
+
+// Tenant is not cached, it's looked up only by the registrations that don't name an app
+func (s *Storage) Tenant(ctx context.Context, tenantID int64) (models.Tenant, error) {
+	return s.apps.Tenant(ctx, tenantID)
+}
+
+func (s *Storage) User(ctx context.Context, tenantID int64, email string) (models.User, error) {
+	return s.users.User(ctx, tenantID, email)
+}
+
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	return s.users.UserByID(ctx, userID)
+}
+
+func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	if isAdmin, ok := s.isAdminCache.Get(userID); ok {
+		metrics.CacheRequests.WithLabelValues(isAdminCache, metrics.CacheHit).Inc()
+
+		return isAdmin, nil
+	}
+
+	metrics.CacheRequests.WithLabelValues(isAdminCache, metrics.CacheMiss).Inc()
+
+	isAdmin, err := s.users.IsAdmin(ctx, userID)
+	if err != nil {
+		// Unknown users are not cached, the ID may be taken by the next registration
+		return false, err
+	}
+
+	s.isAdminCache.Set(userID, isAdmin, s.opts.IsAdminTTL)
+
+	return isAdmin, nil
+}
+
+// UserPermissions expands the group membership of the user once per PermissionsTTL
+func (s *Storage) UserPermissions(ctx context.Context, userID int64, appID int) (models.Permissions, error) {
+	key := permissionsKey{userID: userID, appID: appID}
+
+	if perms, ok := s.permissionsCache.Get(key); ok {
+		metrics.CacheRequests.WithLabelValues(permissionsCache, metrics.CacheHit).Inc()
+
+		return perms, nil
+	}
+
+	metrics.CacheRequests.WithLabelValues(permissionsCache, metrics.CacheMiss).Inc()
+
+	perms, err := s.permissions.UserPermissions(ctx, userID, appID)
+	if err != nil {
+		return models.Permissions{}, err
+	}
+
+	s.permissionsCache.Set(key, perms, s.opts.PermissionsTTL)
+
+	return perms, nil
+}
+
+// InvalidateUser drops the cached roles of the user, call it when they are changed
+func (s *Storage) InvalidateUser(userID int64) {
+	s.isAdminCache.Delete(userID)
+}
+
+// InvalidatePermissions drops the cached permissions of all users, call it when a group,
+// its members or roles are changed
+func (s *Storage) InvalidatePermissions() {
+	s.permissionsCache.Purge()
+}
+
+// InvalidateAll drops everything cached
+func (s *Storage) InvalidateAll() {
+	s.appCache.Purge()
+	s.isAdminCache.Purge()
+	s.permissionsCache.Purge()
+}
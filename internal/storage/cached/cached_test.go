@@ -0,0 +1,285 @@
+package cached
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/storage"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{
+	AppTTL:         time.Minute,
+	AppNegativeTTL: time.Minute,
+	AppMaxSize:     10,
+	IsAdminTTL:     time.Minute,
+	IsAdminMaxSize: 10,
+
+	PermissionsTTL:     time.Minute,
+	PermissionsMaxSize: 10,
+}
+
+// fakeStorage counts the calls that reach the storage
+type fakeStorage struct {
+	apps    map[int]models.App
+	admins  map[int64]bool
+	err     error
+	appHits int
+	isAdmin int
+	// permissions are by user, the same in every app
+	permissions     map[int64]models.Permissions
+	permissionsHits int
+}
+
+func (f *fakeStorage) App(_ context.Context, appID int) (models.App, error) {
+	f.appHits++
+
+	if f.err != nil {
+		return models.App{}, f.err
+	}
+
+	app, ok := f.apps[appID]
+	if !ok {
+		return models.App{}, storage.ErrAppNotFound
+	}
+
+	return app, nil
+}
+
+func (f *fakeStorage) Tenant(_ context.Context, tenantID int64) (models.Tenant, error) {
+	return models.Tenant{ID: tenantID}, nil
+}
+
+func (f *fakeStorage) User(_ context.Context, _ int64, email string) (models.User, error) {
+	return models.User{Email: email}, nil
+}
+
+func (f *fakeStorage) UserByID(_ context.Context, userID int64) (models.User, error) {
+	return models.User{ID: userID}, nil
+}
+
+func (f *fakeStorage) IsAdmin(_ context.Context, userID int64) (bool, error) {
+	f.isAdmin++
+
+	if f.err != nil {
+		return false, f.err
+	}
+
+	isAdmin, ok := f.admins[userID]
+	if !ok {
+		return false, storage.ErrUserNotFound
+	}
+
+	return isAdmin, nil
+}
+
+func (f *fakeStorage) UserPermissions(_ context.Context, userID int64, _ int) (models.Permissions, error) {
+	f.permissionsHits++
+
+	if f.err != nil {
+		return models.Permissions{}, f.err
+	}
+
+	return f.permissions[userID], nil
+}
+
+func newFake() *fakeStorage {
+	return &fakeStorage{
+		apps:   map[int]models.App{1: {ID: 1, Name: "test", Secret: "secret"}},
+		admins: map[int64]bool{1: true, 2: false},
+		permissions: map[int64]models.Permissions{
+			1: {Groups: []string{"engineering"}, Roles: []string{"editor"}},
+		},
+	}
+}
+
+func TestApp_ReadThrough(t *testing.T) {
+	fake := newFake()
+	s := New(fake, fake, fake, testOptions)
+	ctx := context.Background()
+
+	hits := testutil.ToFloat64(metrics.CacheRequests.WithLabelValues(appCache, metrics.CacheHit))
+	misses := testutil.ToFloat64(metrics.CacheRequests.WithLabelValues(appCache, metrics.CacheMiss))
+
+	for range 3 {
+		app, err := s.App(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, fake.apps[1], app)
+	}
+
+	assert.Equal(t, 1, fake.appHits)
+	assert.Equal(t, hits+2, testutil.ToFloat64(metrics.CacheRequests.WithLabelValues(appCache, metrics.CacheHit)))
+	assert.Equal(t, misses+1, testutil.ToFloat64(metrics.CacheRequests.WithLabelValues(appCache, metrics.CacheMiss)))
+}
+
+func TestApp_NegativeCaching(t *testing.T) {
+	fake := newFake()
+	s := New(fake, fake, fake, testOptions)
+	ctx := context.Background()
+
+	for range 3 {
+		_, err := s.App(ctx, 42)
+		assert.ErrorIs(t, err, storage.ErrAppNotFound)
+	}
+
+	assert.Equal(t, 1, fake.appHits)
+
+	// The app is created in the database, the cache sees it once the unknown ID is dropped
+	fake.apps[42] = models.App{ID: 42, Name: "new", Secret: "new_secret"}
+	s.InvalidateAll()
+
+	app, err := s.App(ctx, 42)
+	require.NoError(t, err)
+	assert.Equal(t, "new", app.Name)
+}
+
+func TestApp_NegativeCachingDisabled(t *testing.T) {
+	fake := newFake()
+	opts := testOptions
+	opts.AppNegativeTTL = 0
+	s := New(fake, fake, fake, opts)
+
+	for range 2 {
+		_, err := s.App(context.Background(), 42)
+		assert.ErrorIs(t, err, storage.ErrAppNotFound)
+	}
+
+	assert.Equal(t, 2, fake.appHits)
+}
+
+func TestApp_ErrorsNotCached(t *testing.T) {
+	fake := newFake()
+	fake.err = errors.New("connection refused")
+	s := New(fake, fake, fake, testOptions)
+	ctx := context.Background()
+
+	_, err := s.App(ctx, 1)
+	assert.ErrorIs(t, err, fake.err)
+
+	fake.err = nil
+
+	_, err = s.App(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.appHits)
+}
+
+func TestApp_Expires(t *testing.T) {
+	fake := newFake()
+	opts := testOptions
+	opts.AppTTL = time.Millisecond
+	s := New(fake, fake, fake, opts)
+	ctx := context.Background()
+
+	_, err := s.App(ctx, 1)
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = s.App(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, fake.appHits)
+}
+
+func TestIsAdmin_ReadThroughAndInvalidation(t *testing.T) {
+	fake := newFake()
+	s := New(fake, fake, fake, testOptions)
+	ctx := context.Background()
+
+	for range 2 {
+		isAdmin, err := s.IsAdmin(ctx, 2)
+		require.NoError(t, err)
+		assert.False(t, isAdmin)
+	}
+	assert.Equal(t, 1, fake.isAdmin)
+
+	fake.admins[2] = true
+	s.InvalidateUser(2)
+
+	isAdmin, err := s.IsAdmin(ctx, 2)
+	require.NoError(t, err)
+	assert.True(t, isAdmin)
+	assert.Equal(t, 2, fake.isAdmin)
+}
+
+func TestIsAdmin_UnknownUserNotCached(t *testing.T) {
+	fake := newFake()
+	s := New(fake, fake, fake, testOptions)
+	ctx := context.Background()
+
+	_, err := s.IsAdmin(ctx, 3)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	fake.admins[3] = false
+
+	isAdmin, err := s.IsAdmin(ctx, 3)
+	require.NoError(t, err)
+	assert.False(t, isAdmin)
+}
+
+func TestInvalidateAll(t *testing.T) {
+	fake := newFake()
+	s := New(fake, fake, fake, testOptions)
+	ctx := context.Background()
+
+	_, _ = s.App(ctx, 1)
+	_, _ = s.IsAdmin(ctx, 1)
+	_, _ = s.UserPermissions(ctx, 1, 1)
+
+	s.InvalidateAll()
+
+	_, _ = s.App(ctx, 1)
+	_, _ = s.IsAdmin(ctx, 1)
+	_, _ = s.UserPermissions(ctx, 1, 1)
+
+	assert.Equal(t, 2, fake.appHits)
+	assert.Equal(t, 2, fake.isAdmin)
+	assert.Equal(t, 2, fake.permissionsHits)
+}
+
+func TestUserPermissions_ReadThroughAndInvalidation(t *testing.T) {
+	fake := newFake()
+	s := New(fake, fake, fake, testOptions)
+	ctx := context.Background()
+
+	// Each app of the user is cached apart
+	for range 2 {
+		perms, err := s.UserPermissions(ctx, 1, 1)
+		require.NoError(t, err)
+		assert.Equal(t, fake.permissions[1], perms)
+
+		_, err = s.UserPermissions(ctx, 1, 2)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, fake.permissionsHits)
+
+	// A group change may affect anyone, all users are dropped
+	fake.permissions[1] = models.Permissions{Groups: []string{"sales"}}
+	s.InvalidatePermissions()
+
+	perms, err := s.UserPermissions(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sales"}, perms.Groups)
+	assert.Equal(t, 3, fake.permissionsHits)
+}
+
+func TestUserPermissions_ErrorsNotCached(t *testing.T) {
+	fake := newFake()
+	fake.err = errors.New("connection refused")
+	s := New(fake, fake, fake, testOptions)
+	ctx := context.Background()
+
+	_, err := s.UserPermissions(ctx, 1, 1)
+	assert.ErrorIs(t, err, fake.err)
+
+	fake.err = nil
+
+	perms, err := s.UserPermissions(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"engineering"}, perms.Groups)
+	assert.Equal(t, 2, fake.permissionsHits)
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/storage"
+	"slices"
+	"time"
+)
+
+// SaveAccessToken saves the new personal access token and returns its ID
+func (s *Storage) SaveAccessToken(ctx context.Context, token models.AccessToken) (int64, error) {
+	const op = "storage.memory.SaveAccessToken"
+
+	defer metrics.ObserveStorageQuery(backend, "save_access_token")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	s.lastAccessTokenID++
+
+	token.ID = s.lastAccessTokenID
+	token.Scopes = slices.Clone(token.Scopes)
+	token.CreatedAt = token.CreatedAt.UTC()
+	if !token.ExpiresAt.IsZero() {
+		token.ExpiresAt = token.ExpiresAt.UTC()
+	}
+
+	s.accessTokens[token.ID] = token
+
+	s.onRollback(ctx, func() { delete(s.accessTokens, token.ID) })
+
+	return token.ID, nil
+}
+
+// AccessTokenByHash returns the personal access token by the hash of the token, revoked and expired ones included
+func (s *Storage) AccessTokenByHash(ctx context.Context, hash string) (models.AccessToken, error) {
+	const op = "storage.memory.AccessTokenByHash"
+
+	defer metrics.ObserveStorageQuery(backend, "access_token_by_hash")()
+
+	if err := ctx.Err(); err != nil {
+		return models.AccessToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	for _, token := range s.accessTokens {
+		if token.Hash == hash {
+			return token, nil
+		}
+	}
+
+	return models.AccessToken{}, fmt.Errorf("%s: %w", op, storage.ErrAccessTokenNotFound)
+}
+
+// UserAccessTokens returns the personal access tokens of the user not revoked yet, the newest first
+func (s *Storage) UserAccessTokens(ctx context.Context, userID int64) ([]models.AccessToken, error) {
+	const op = "storage.memory.UserAccessTokens"
+
+	defer metrics.ObserveStorageQuery(backend, "user_access_tokens")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	var tokens []models.AccessToken
+
+	for _, token := range s.accessTokens {
+		if token.UserID == userID && token.RevokedAt.IsZero() {
+			tokens = append(tokens, token)
+		}
+	}
+
+	slices.SortFunc(tokens, func(a, b models.AccessToken) int { return int(b.ID - a.ID) })
+
+	return tokens, nil
+}
+
+// TouchAccessToken sets the time the token was last used at, unknown tokens are ignored
+func (s *Storage) TouchAccessToken(ctx context.Context, tokenID int64, lastUsedAt time.Time) error {
+	const op = "storage.memory.TouchAccessToken"
+
+	defer metrics.ObserveStorageQuery(backend, "touch_access_token")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	token, ok := s.accessTokens[tokenID]
+	if !ok || !lastUsedAt.After(token.LastUsedAt) {
+		return nil
+	}
+
+	saved := token
+	token.LastUsedAt = lastUsedAt.UTC()
+	s.accessTokens[tokenID] = token
+
+	s.onRollback(ctx, func() { s.accessTokens[tokenID] = saved })
+
+	return nil
+}
+
+// RevokeAccessToken revokes the token of the user. If the user has no such token
+// not revoked yet, returns storage.ErrAccessTokenNotFound
+func (s *Storage) RevokeAccessToken(ctx context.Context, userID int64, tokenID int64, revokedAt time.Time) error {
+	const op = "storage.memory.RevokeAccessToken"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_access_token")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	token, ok := s.accessTokens[tokenID]
+	if !ok || token.UserID != userID || !token.RevokedAt.IsZero() {
+		return fmt.Errorf("%s: %w", op, storage.ErrAccessTokenNotFound)
+	}
+
+	saved := token
+	token.RevokedAt = revokedAt.UTC()
+	s.accessTokens[tokenID] = token
+
+	s.onRollback(ctx, func() { s.accessTokens[tokenID] = saved })
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"slices"
+	"time"
+)
+
+// SaveAuditEvent appends the event to the audit log
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	const op = "storage.memory.SaveAuditEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "save_audit_event")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	s.lastEventID++
+
+	event.ID = s.lastEventID
+	event.CreatedAt = event.CreatedAt.UTC()
+
+	s.auditEvents = append(s.auditEvents, event)
+
+	s.onRollback(ctx, func() {
+		s.auditEvents = slices.DeleteFunc(s.auditEvents, func(e models.AuditEvent) bool {
+			return e.ID == event.ID
+		})
+	})
+
+	return nil
+}
+
+// AuditEvents returns the events matching the filter, the newest first
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	const op = "storage.memory.AuditEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "audit_events")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	var events []models.AuditEvent
+
+	for i := len(s.auditEvents) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+
+		if e := s.auditEvents[i]; matches(e, filter) {
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+func matches(e models.AuditEvent, filter models.AuditFilter) bool {
+	switch {
+	case filter.TenantID != 0 && e.TenantID != filter.TenantID,
+		filter.ActorID != 0 && e.ActorID != filter.ActorID,
+		filter.UserID != 0 && e.UserID != filter.UserID,
+		filter.AppID != 0 && e.AppID != filter.AppID,
+		len(filter.Types) > 0 && !slices.Contains(filter.Types, e.Type),
+		!filter.Since.IsZero() && e.CreatedAt.Before(filter.Since),
+		!filter.Until.IsZero() && !e.CreatedAt.Before(filter.Until),
+		filter.BeforeID != 0 && e.ID >= filter.BeforeID:
+		return false
+	}
+
+	return true
+}
+
+// PruneAuditEvents deletes the events created before the time
+func (s *Storage) PruneAuditEvents(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.memory.PruneAuditEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_audit_events")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	kept := s.auditEvents[:0:0]
+	var pruned []models.AuditEvent
+
+	for _, e := range s.auditEvents {
+		if e.CreatedAt.Before(before) {
+			pruned = append(pruned, e)
+
+			continue
+		}
+
+		kept = append(kept, e)
+	}
+
+	s.auditEvents = kept
+
+	s.onRollback(ctx, func() {
+		s.auditEvents = append(s.auditEvents, pruned...)
+		slices.SortFunc(s.auditEvents, func(a, b models.AuditEvent) int {
+			return cmp.Compare(a.ID, b.ID)
+		})
+	})
+
+	return int64(len(pruned)), nil
+}
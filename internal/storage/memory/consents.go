@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/storage"
+	"maps"
+	"slices"
+	"time"
+)
+
+type consentKey struct {
+	userID int64
+	appID  int
+}
+
+// SaveConsent adds the scopes of the consent to the ones the user already granted to the app
+func (s *Storage) SaveConsent(ctx context.Context, consent models.Consent) error {
+	const op = "storage.memory.SaveConsent"
+
+	defer metrics.ObserveStorageQuery(backend, "save_consent")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(consent.Scopes) == 0 {
+		return nil
+	}
+
+	defer s.lock(ctx)()
+
+	key := consentKey{userID: consent.UserID, appID: consent.AppID}
+
+	scopes, ok := s.consents[key]
+	if !ok {
+		scopes = make(map[string]time.Time)
+		s.consents[key] = scopes
+	}
+
+	var added []string
+
+	for _, scope := range consent.Scopes {
+		if _, ok := scopes[scope]; ok {
+			continue
+		}
+
+		scopes[scope] = consent.GrantedAt.UTC()
+		added = append(added, scope)
+	}
+
+	s.onRollback(ctx, func() {
+		for _, scope := range added {
+			delete(scopes, scope)
+		}
+
+		if len(scopes) == 0 {
+			delete(s.consents, key)
+		}
+	})
+
+	return nil
+}
+
+// Consent returns the consent of the user to the app
+func (s *Storage) Consent(ctx context.Context, userID int64, appID int) (models.Consent, error) {
+	const op = "storage.memory.Consent"
+
+	defer metrics.ObserveStorageQuery(backend, "consent")()
+
+	if err := ctx.Err(); err != nil {
+		return models.Consent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	key := consentKey{userID: userID, appID: appID}
+
+	scopes, ok := s.consents[key]
+	if !ok {
+		return models.Consent{}, fmt.Errorf("%s: %w", op, storage.ErrConsentNotFound)
+	}
+
+	return toConsent(key, scopes), nil
+}
+
+// UserConsents returns the consents of the user ordered by app
+func (s *Storage) UserConsents(ctx context.Context, userID int64) ([]models.Consent, error) {
+	const op = "storage.memory.UserConsents"
+
+	defer metrics.ObserveStorageQuery(backend, "user_consents")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	var consents []models.Consent
+
+	for key, scopes := range s.consents {
+		if key.userID == userID {
+			consents = append(consents, toConsent(key, scopes))
+		}
+	}
+
+	slices.SortFunc(consents, func(a, b models.Consent) int { return a.AppID - b.AppID })
+
+	return consents, nil
+}
+
+// DeleteConsent deletes the consent of the user to the app with all its scopes
+func (s *Storage) DeleteConsent(ctx context.Context, userID int64, appID int) error {
+	const op = "storage.memory.DeleteConsent"
+
+	defer metrics.ObserveStorageQuery(backend, "delete_consent")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	key := consentKey{userID: userID, appID: appID}
+
+	scopes, ok := s.consents[key]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrConsentNotFound)
+	}
+
+	delete(s.consents, key)
+
+	s.onRollback(ctx, func() { s.consents[key] = scopes })
+
+	return nil
+}
+
+func toConsent(key consentKey, scopes map[string]time.Time) models.Consent {
+	consent := models.Consent{
+		UserID: key.userID,
+		AppID:  key.appID,
+		Scopes: slices.Sorted(maps.Keys(scopes)),
+	}
+
+	for _, grantedAt := range scopes {
+		if grantedAt.After(consent.GrantedAt) {
+			consent.GrantedAt = grantedAt
+		}
+	}
+
+	return consent
+}
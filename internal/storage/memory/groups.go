@@ -0,0 +1,377 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/storage"
+	"slices"
+	"sort"
+)
+
+// group keeps the direct members and roles of the group as sets
+type group struct {
+	models.Group
+	users     map[int64]struct{}
+	subgroups map[int64]struct{}
+	roles     map[models.GroupRole]struct{}
+}
+
+// SaveGroup saves the group to the tenant, the name must be unique within it
+func (s *Storage) SaveGroup(ctx context.Context, tenantID int64, name string) (int64, error) {
+	const op = "storage.memory.SaveGroup"
+
+	defer metrics.ObserveStorageQuery(backend, "save_group")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	for _, g := range s.groups {
+		if g.TenantID == tenantID && g.Name == name {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrGroupExists)
+		}
+	}
+
+	s.lastGroupID++
+
+	g := &group{
+		Group:     models.Group{ID: s.lastGroupID, TenantID: tenantID, Name: name},
+		users:     make(map[int64]struct{}),
+		subgroups: make(map[int64]struct{}),
+		roles:     make(map[models.GroupRole]struct{}),
+	}
+
+	s.groups[g.ID] = g
+
+	s.onRollback(ctx, func() { delete(s.groups, g.ID) })
+
+	return g.ID, nil
+}
+
+// Group returns the group with its direct members and roles
+func (s *Storage) Group(ctx context.Context, groupID int64) (models.Group, error) {
+	const op = "storage.memory.Group"
+
+	defer metrics.ObserveStorageQuery(backend, "group")()
+
+	if err := ctx.Err(); err != nil {
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	g, ok := s.groups[groupID]
+	if !ok {
+		return models.Group{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+	}
+
+	res := g.Group
+
+	for id := range g.users {
+		res.UserIDs = append(res.UserIDs, id)
+	}
+	slices.Sort(res.UserIDs)
+
+	for id := range g.subgroups {
+		res.SubgroupIDs = append(res.SubgroupIDs, id)
+	}
+	slices.Sort(res.SubgroupIDs)
+
+	for role := range g.roles {
+		res.Roles = append(res.Roles, role)
+	}
+	sort.Slice(res.Roles, func(i, j int) bool {
+		if res.Roles[i].AppID != res.Roles[j].AppID {
+			return res.Roles[i].AppID < res.Roles[j].AppID
+		}
+
+		return res.Roles[i].Role < res.Roles[j].Role
+	})
+
+	return res, nil
+}
+
+// Groups returns the groups of the tenant ordered by name, without their members and roles
+func (s *Storage) Groups(ctx context.Context, tenantID int64) ([]models.Group, error) {
+	const op = "storage.memory.Groups"
+
+	defer metrics.ObserveStorageQuery(backend, "groups")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	var res []models.Group
+
+	for _, g := range s.groups {
+		if g.TenantID == tenantID {
+			res = append(res, g.Group)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+	return res, nil
+}
+
+// DeleteGroup deletes the group with its members, roles and its membership in other groups
+func (s *Storage) DeleteGroup(ctx context.Context, groupID int64) error {
+	const op = "storage.memory.DeleteGroup"
+
+	defer metrics.ObserveStorageQuery(backend, "delete_group")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	g, ok := s.groups[groupID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+	}
+
+	delete(s.groups, groupID)
+
+	var parents []*group
+
+	for _, parent := range s.groups {
+		if _, ok := parent.subgroups[groupID]; ok {
+			delete(parent.subgroups, groupID)
+			parents = append(parents, parent)
+		}
+	}
+
+	s.onRollback(ctx, func() {
+		s.groups[groupID] = g
+		for _, parent := range parents {
+			parent.subgroups[groupID] = struct{}{}
+		}
+	})
+
+	return nil
+}
+
+// AddGroupUser adds the user to the group, adding a member twice does nothing
+func (s *Storage) AddGroupUser(ctx context.Context, groupID int64, userID int64) error {
+	const op = "storage.memory.AddGroupUser"
+
+	defer metrics.ObserveStorageQuery(backend, "add_group_user")()
+
+	return s.updateGroupSet(ctx, op, groupID, func(g *group) map[int64]struct{} { return g.users }, userID, true)
+}
+
+// RemoveGroupUser removes the user from the group, removing a non-member does nothing
+func (s *Storage) RemoveGroupUser(ctx context.Context, groupID int64, userID int64) error {
+	const op = "storage.memory.RemoveGroupUser"
+
+	defer metrics.ObserveStorageQuery(backend, "remove_group_user")()
+
+	return s.updateGroupSet(ctx, op, groupID, func(g *group) map[int64]struct{} { return g.users }, userID, false)
+}
+
+// AddSubgroup nests the subgroup in the group, nesting it twice does nothing.
+// A group can't be nested in itself, directly or through other groups: it's ErrGroupCycle
+func (s *Storage) AddSubgroup(ctx context.Context, groupID int64, subgroupID int64) error {
+	const op = "storage.memory.AddSubgroup"
+
+	defer metrics.ObserveStorageQuery(backend, "add_subgroup")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	// The group must not be the subgroup or one of its descendants
+	seen := map[int64]bool{subgroupID: true}
+	queue := []int64{subgroupID}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		if id == groupID {
+			return fmt.Errorf("%s: %w", op, storage.ErrGroupCycle)
+		}
+
+		if g, ok := s.groups[id]; ok {
+			for child := range g.subgroups {
+				if !seen[child] {
+					seen[child] = true
+					queue = append(queue, child)
+				}
+			}
+		}
+	}
+
+	return s.setGroupMember(ctx, groupID, func(g *group) map[int64]struct{} { return g.subgroups }, subgroupID, true)
+}
+
+// RemoveSubgroup removes the subgroup from the group, removing a non-member does nothing
+func (s *Storage) RemoveSubgroup(ctx context.Context, groupID int64, subgroupID int64) error {
+	const op = "storage.memory.RemoveSubgroup"
+
+	defer metrics.ObserveStorageQuery(backend, "remove_subgroup")()
+
+	return s.updateGroupSet(ctx, op, groupID, func(g *group) map[int64]struct{} { return g.subgroups }, subgroupID, false)
+}
+
+// updateGroupSet adds the ID to the set of the group or removes it
+func (s *Storage) updateGroupSet(
+	ctx context.Context,
+	op string,
+	groupID int64,
+	set func(g *group) map[int64]struct{},
+	id int64,
+	add bool,
+) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	return s.setGroupMember(ctx, groupID, set, id, add)
+}
+
+// setGroupMember is updateGroupSet with the lock held. Like the SQL storages it doesn't check
+// the group exists, the members of a missing group are never read
+func (s *Storage) setGroupMember(
+	ctx context.Context,
+	groupID int64,
+	set func(g *group) map[int64]struct{},
+	id int64,
+	add bool,
+) error {
+	g, ok := s.groups[groupID]
+	if !ok {
+		return nil
+	}
+
+	members := set(g)
+	_, had := members[id]
+
+	if add == had {
+		return nil
+	}
+
+	if add {
+		members[id] = struct{}{}
+		s.onRollback(ctx, func() { delete(members, id) })
+	} else {
+		delete(members, id)
+		s.onRollback(ctx, func() { members[id] = struct{}{} })
+	}
+
+	return nil
+}
+
+// GrantGroupRole grants the role in the app to the group, granting it twice does nothing
+func (s *Storage) GrantGroupRole(ctx context.Context, groupID int64, role models.GroupRole) error {
+	const op = "storage.memory.GrantGroupRole"
+
+	defer metrics.ObserveStorageQuery(backend, "grant_group_role")()
+
+	return s.updateGroupRole(ctx, op, groupID, role, true)
+}
+
+// RevokeGroupRole revokes the role in the app from the group, revoking a missing role does nothing
+func (s *Storage) RevokeGroupRole(ctx context.Context, groupID int64, role models.GroupRole) error {
+	const op = "storage.memory.RevokeGroupRole"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_group_role")()
+
+	return s.updateGroupRole(ctx, op, groupID, role, false)
+}
+
+// updateGroupRole grants the role to the group or revokes it
+func (s *Storage) updateGroupRole(ctx context.Context, op string, groupID int64, role models.GroupRole, grant bool) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	g, ok := s.groups[groupID]
+	if !ok {
+		return nil
+	}
+
+	_, had := g.roles[role]
+	if grant == had {
+		return nil
+	}
+
+	if grant {
+		g.roles[role] = struct{}{}
+		s.onRollback(ctx, func() { delete(g.roles, role) })
+	} else {
+		delete(g.roles, role)
+		s.onRollback(ctx, func() { g.roles[role] = struct{}{} })
+	}
+
+	return nil
+}
+
+// UserPermissions returns the groups of the user, nested ones expanded, and the roles granted to them in the app
+func (s *Storage) UserPermissions(ctx context.Context, userID int64, appID int) (models.Permissions, error) {
+	const op = "storage.memory.UserPermissions"
+
+	defer metrics.ObserveStorageQuery(backend, "user_permissions")()
+
+	if err := ctx.Err(); err != nil {
+		return models.Permissions{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	effective := make(map[int64]bool)
+
+	var queue []int64
+
+	for id, g := range s.groups {
+		if _, ok := g.users[userID]; ok {
+			effective[id] = true
+			queue = append(queue, id)
+		}
+	}
+
+	// The groups containing an effective group are effective too
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		for parentID, parent := range s.groups {
+			if _, ok := parent.subgroups[id]; ok && !effective[parentID] {
+				effective[parentID] = true
+				queue = append(queue, parentID)
+			}
+		}
+	}
+
+	var perms models.Permissions
+
+	for id := range effective {
+		g := s.groups[id]
+		perms.Groups = append(perms.Groups, g.Name)
+
+		for role := range g.roles {
+			if role.AppID == appID {
+				perms.Roles = append(perms.Roles, role.Role)
+			}
+		}
+	}
+
+	slices.Sort(perms.Groups)
+	slices.Sort(perms.Roles)
+	perms.Roles = slices.Compact(perms.Roles)
+
+	return perms, nil
+}
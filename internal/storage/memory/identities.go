@@ -0,0 +1,125 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/storage"
+	"slices"
+	"strings"
+)
+
+// SaveIdentity links the account at the provider to the user and returns the ID of the link.
+// If the account is linked in the tenant or the user has an account of the provider linked already,
+// returns storage.ErrIdentityExists
+func (s *Storage) SaveIdentity(ctx context.Context, identity models.Identity) (int64, error) {
+	const op = "storage.memory.SaveIdentity"
+
+	defer metrics.ObserveStorageQuery(backend, "save_identity")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	for _, linked := range s.identities {
+		if linked.Provider != identity.Provider {
+			continue
+		}
+
+		if linked.TenantID == identity.TenantID && linked.Subject == identity.Subject ||
+			linked.UserID == identity.UserID {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrIdentityExists)
+		}
+	}
+
+	s.lastIdentityID++
+
+	identity.ID = s.lastIdentityID
+	identity.CreatedAt = identity.CreatedAt.UTC()
+
+	s.identities[identity.ID] = identity
+
+	s.onRollback(ctx, func() { delete(s.identities, identity.ID) })
+
+	return identity.ID, nil
+}
+
+// Identity returns the link of the account at the provider in the tenant
+func (s *Storage) Identity(
+	ctx context.Context,
+	tenantID int64,
+	provider string,
+	subject string,
+) (models.Identity, error) {
+	const op = "storage.memory.Identity"
+
+	defer metrics.ObserveStorageQuery(backend, "identity")()
+
+	if err := ctx.Err(); err != nil {
+		return models.Identity{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	for _, identity := range s.identities {
+		if identity.TenantID == tenantID && identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+
+	return models.Identity{}, fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+}
+
+// UserIdentities returns the accounts linked to the user ordered by provider
+func (s *Storage) UserIdentities(ctx context.Context, userID int64) ([]models.Identity, error) {
+	const op = "storage.memory.UserIdentities"
+
+	defer metrics.ObserveStorageQuery(backend, "user_identities")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	var identities []models.Identity
+
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+
+	slices.SortFunc(identities, func(a, b models.Identity) int { return strings.Compare(a.Provider, b.Provider) })
+
+	return identities, nil
+}
+
+// DeleteIdentity unlinks the account of the provider from the user.
+// If the user has no account of the provider linked, returns storage.ErrIdentityNotFound
+func (s *Storage) DeleteIdentity(ctx context.Context, userID int64, provider string) error {
+	const op = "storage.memory.DeleteIdentity"
+
+	defer metrics.ObserveStorageQuery(backend, "delete_identity")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	for id, identity := range s.identities {
+		if identity.UserID == userID && identity.Provider == provider {
+			delete(s.identities, id)
+
+			s.onRollback(ctx, func() { s.identities[id] = identity })
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s: %w", op, storage.ErrIdentityNotFound)
+}
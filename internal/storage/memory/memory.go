@@ -0,0 +1,485 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/storage"
+	"sync"
+	"time"
+)
+
+const backend = "memory"
+
+type user struct {
+	models.User
+	isAdmin bool
+}
+
+// emailKey identifies a user by email, the emails are unique within the tenant
+type emailKey struct {
+	tenantID int64
+	email    string
+}
+
+// Storage keeps everything in memory, the data is lost on restart.
+// It is safe for concurrent use
+type Storage struct {
+	mu      sync.RWMutex
+	users   map[int64]*user
+	byEmail map[emailKey]*user
+	apps    map[int]models.App
+	tenants map[int64]models.Tenant
+	lastID  int64
+	// adminEmails are granted admin rights when registered
+	adminEmails map[emailKey]bool
+
+	// auditEvents are in insertion order, so by ID
+	auditEvents []models.AuditEvent
+	lastEventID int64
+
+	// outbox is in insertion order, so by ID
+	outbox       []models.OutboxEvent
+	lastOutboxID int64
+
+	// userEvents are in insertion order, so by ID
+	userEvents      []models.UserEvent
+	lastUserEventID int64
+
+	sessions map[string]models.Session
+
+	groups      map[int64]*group
+	lastGroupID int64
+
+	// consents are the granted scopes with the time they were granted at
+	consents map[consentKey]map[string]time.Time
+
+	accessTokens      map[int64]models.AccessToken
+	lastAccessTokenID int64
+
+	identities     map[int64]models.Identity
+	lastIdentityID int64
+}
+
+// New creates a new in-memory storage with the default tenant only
+func New() *Storage {
+	return &Storage{
+		users:   make(map[int64]*user),
+		byEmail: make(map[emailKey]*user),
+		apps:    make(map[int]models.App),
+		tenants: map[int64]models.Tenant{
+			models.DefaultTenantID: {ID: models.DefaultTenantID, Name: "default"},
+		},
+
+		sessions:     make(map[string]models.Session),
+		groups:       make(map[int64]*group),
+		consents:     make(map[consentKey]map[string]time.Time),
+		accessTokens: make(map[int64]models.AccessToken),
+		identities:   make(map[int64]models.Identity),
+		adminEmails:  make(map[emailKey]bool),
+	}
+}
+
+// SaveUser saves the user to the tenant, the email must be unique within it
+func (s *Storage) SaveUser(ctx context.Context, tenantID int64, email string, passHash []byte) (int64, error) {
+	const op = "storage.memory.SaveUser"
+
+	defer metrics.ObserveStorageQuery(backend, "save_user")()
+
+	if err := ctx.Err(); err != nil {
+		return models.EmptyUserID, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	key := emailKey{tenantID: tenantID, email: email}
+
+	if _, ok := s.byEmail[key]; ok {
+		return models.EmptyUserID, fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+	}
+
+	s.lastID++
+
+	u := &user{
+		User: models.User{
+			ID:       s.lastID,
+			TenantID: tenantID,
+			Email:    email,
+			PassHash: clone(passHash),
+		},
+		isAdmin: s.adminEmails[key],
+	}
+
+	s.users[u.ID] = u
+	s.byEmail[key] = u
+
+	s.onRollback(ctx, func() {
+		delete(s.users, u.ID)
+		delete(s.byEmail, key)
+	})
+
+	return u.ID, nil
+}
+
+// User returns the user of the tenant by email
+func (s *Storage) User(ctx context.Context, tenantID int64, email string) (models.User, error) {
+	const op = "storage.memory.User"
+
+	defer metrics.ObserveStorageQuery(backend, "user")()
+
+	if err := ctx.Err(); err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	u, ok := s.byEmail[emailKey{tenantID: tenantID, email: email}]
+	if !ok {
+		return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	res := u.User
+	res.PassHash = clone(u.PassHash)
+
+	return res, nil
+}
+
+// UserByID returns user by ID
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.memory.UserByID"
+
+	defer metrics.ObserveStorageQuery(backend, "user_by_id")()
+
+	if err := ctx.Err(); err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	res := u.User
+	res.PassHash = clone(u.PassHash)
+
+	return res, nil
+}
+
+// UpdateEmail changes the email of the user
+func (s *Storage) UpdateEmail(ctx context.Context, userID int64, email string) error {
+	const op = "storage.memory.UpdateEmail"
+
+	defer metrics.ObserveStorageQuery(backend, "update_email")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	key := emailKey{tenantID: u.TenantID, email: email}
+
+	if other, ok := s.byEmail[key]; ok && other != u {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+	}
+
+	old := emailKey{tenantID: u.TenantID, email: u.Email}
+
+	delete(s.byEmail, old)
+	u.Email = email
+	s.byEmail[key] = u
+
+	s.onRollback(ctx, func() {
+		delete(s.byEmail, key)
+		u.Email = old.email
+		s.byEmail[old] = u
+	})
+
+	return nil
+}
+
+// DisableUser disables the user, disabling a disabled user is not an error
+func (s *Storage) DisableUser(ctx context.Context, userID int64) error {
+	const op = "storage.memory.DisableUser"
+
+	defer metrics.ObserveStorageQuery(backend, "disable_user")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	old := u.Disabled
+	u.Disabled = true
+
+	s.onRollback(ctx, func() { u.Disabled = old })
+
+	return nil
+}
+
+// UpdatePassword replaces the password hash of the user
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte) error {
+	const op = "storage.memory.UpdatePassword"
+
+	defer metrics.ObserveStorageQuery(backend, "update_password")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	old := u.PassHash
+	u.PassHash = clone(passHash)
+
+	s.onRollback(ctx, func() { u.PassHash = old })
+
+	return nil
+}
+
+func (s *Storage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	const op = "storage.memory.IsAdmin"
+
+	defer metrics.ObserveStorageQuery(backend, "is_admin")()
+
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return false, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return u.isAdmin, nil
+}
+
+func (s *Storage) App(ctx context.Context, appID int) (models.App, error) {
+	const op = "storage.memory.App"
+
+	defer metrics.ObserveStorageQuery(backend, "app")()
+
+	if err := ctx.Err(); err != nil {
+		return models.App{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
+	}
+
+	return app, nil
+}
+
+// Tenant returns the tenant by ID
+func (s *Storage) Tenant(ctx context.Context, tenantID int64) (models.Tenant, error) {
+	const op = "storage.memory.Tenant"
+
+	defer metrics.ObserveStorageQuery(backend, "tenant")()
+
+	if err := ctx.Err(); err != nil {
+		return models.Tenant{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	tenant, ok := s.tenants[tenantID]
+	if !ok {
+		return models.Tenant{}, fmt.Errorf("%s: %w", op, storage.ErrTenantNotFound)
+	}
+
+	return tenant, nil
+}
+
+type txKey struct{}
+
+// tx is a transaction of the storage. It holds the storage lock until it ends
+// and keeps the undo functions of its writes for the rollback
+type tx struct {
+	s    *Storage
+	undo []func()
+}
+
+// WithTx runs fn in a transaction, see storage.Transactor.
+// Transactions are serialized: the storage is locked until fn returns
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	const op = "storage.memory.WithTx"
+
+	if s.txFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := &tx{s: s}
+
+	defer func() {
+		if p := recover(); p != nil {
+			t.rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, t)); err != nil {
+		t.rollback()
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (t *tx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+}
+
+func (s *Storage) txFromContext(ctx context.Context) *tx {
+	t, ok := ctx.Value(txKey{}).(*tx)
+	if !ok || t.s != s {
+		return nil
+	}
+
+	return t
+}
+
+// lock locks the storage for writing and returns the unlock function.
+// A transaction in ctx holds the lock already
+func (s *Storage) lock(ctx context.Context) func() {
+	if s.txFromContext(ctx) != nil {
+		return func() {}
+	}
+
+	s.mu.Lock()
+
+	return s.mu.Unlock
+}
+
+// rlock is lock for reading
+func (s *Storage) rlock(ctx context.Context) func() {
+	if s.txFromContext(ctx) != nil {
+		return func() {}
+	}
+
+	s.mu.RLock()
+
+	return s.mu.RUnlock
+}
+
+// onRollback registers undo of a write made in the transaction in ctx, if any
+func (s *Storage) onRollback(ctx context.Context, undo func()) {
+	if t := s.txFromContext(ctx); t != nil {
+		t.undo = append(t.undo, undo)
+	}
+}
+
+// AddApp adds the app or replaces the app with the same ID.
+// There are no migrations to seed the apps with, so it's done with AddApp.
+// Apps without a tenant belong to the default one
+func (s *Storage) AddApp(app models.App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if app.TenantID == 0 {
+		app.TenantID = models.DefaultTenantID
+	}
+
+	s.apps[app.ID] = app
+}
+
+// AddTenant adds the tenant or replaces the tenant with the same ID, like AddApp
+func (s *Storage) AddTenant(tenant models.Tenant) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tenants[tenant.ID] = tenant
+}
+
+// SetAdmin grants or revokes admin rights of the user
+func (s *Storage) SetAdmin(userID int64, isAdmin bool) error {
+	const op = "storage.memory.SetAdmin"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	u.isAdmin = isAdmin
+
+	return nil
+}
+
+// AddAdminEmail makes the user of the tenant with the email an admin when registered.
+// Like AddApp, it replaces the migrations that grant the admin rights
+func (s *Storage) AddAdminEmail(tenantID int64, email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := emailKey{tenantID: tenantID, email: email}
+	s.adminEmails[key] = true
+
+	if u, ok := s.byEmail[key]; ok {
+		u.isAdmin = true
+	}
+}
+
+// Ping always succeeds, the storage lives in the process
+func (s *Storage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// SigningKeys returns the number of apps that have a secret to sign tokens with
+func (s *Storage) SigningKeys(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, app := range s.apps {
+		if app.Secret != "" {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Close does nothing, there is nothing to release
+func (s *Storage) Close() error {
+	return nil
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
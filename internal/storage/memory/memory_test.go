@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storagetest.Storage, storagetest.Fixtures) {
+		s := New()
+
+		return s, fixtures{s}
+	})
+}
+
+func TestAddAdminEmail(t *testing.T) {
+	s := New()
+	s.AddTenant(models.Tenant{ID: 2, Name: "other"})
+	ctx := context.Background()
+
+	existingID, err := s.SaveUser(ctx, models.DefaultTenantID, "existing@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	s.AddAdminEmail(models.DefaultTenantID, "existing@example.com")
+	s.AddAdminEmail(models.DefaultTenantID, "admin@example.com")
+
+	adminID, err := s.SaveUser(ctx, models.DefaultTenantID, "admin@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	userID, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	// The same email in another tenant is another user
+	otherID, err := s.SaveUser(ctx, 2, "admin@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	for id, want := range map[int64]bool{existingID: true, adminID: true, userID: false, otherID: false} {
+		isAdmin, err := s.IsAdmin(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, want, isAdmin, id)
+	}
+}
+
+type fixtures struct {
+	s *Storage
+}
+
+func (f fixtures) AddApp(t testing.TB, app models.App) {
+	f.s.AddApp(app)
+}
+
+func (f fixtures) AddTenant(t testing.TB, tenant models.Tenant) {
+	f.s.AddTenant(tenant)
+}
+
+func (f fixtures) MakeAdmin(t testing.TB, userID int64) {
+	require.NoError(t, f.s.SetAdmin(userID, true))
+}
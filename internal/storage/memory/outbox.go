@@ -0,0 +1,263 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"slices"
+	"sort"
+	"time"
+)
+
+// EnqueueEvent adds a copy of the event for every app of event.TenantID with a webhook,
+// or only for event.AppID if it is set.
+// Call it in the transaction of the change the event is about
+func (s *Storage) EnqueueEvent(ctx context.Context, event models.OutboxEvent) error {
+	const op = "storage.memory.EnqueueEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "enqueue_event")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	appIDs := make([]int, 0, len(s.apps))
+	for id, app := range s.apps {
+		if app.WebhookURL != "" && app.TenantID == event.TenantID && (event.AppID == 0 || event.AppID == id) {
+			appIDs = append(appIDs, id)
+		}
+	}
+	sort.Ints(appIDs)
+
+	s.saveOutbox(ctx)
+
+	for _, appID := range appIDs {
+		s.lastOutboxID++
+
+		e := event
+		e.ID = s.lastOutboxID
+		e.AppID = appID
+		e.Payload = clone(event.Payload)
+		e.Status = models.OutboxPending
+		e.Attempts = 0
+		e.LastError = ""
+		e.CreatedAt = event.CreatedAt.UTC()
+		e.NextAttemptAt = e.CreatedAt
+		e.DeliveredAt = time.Time{}
+
+		s.outbox = append(s.outbox, e)
+	}
+
+	return nil
+}
+
+// ClaimEvents returns up to limit pending events due at now, the oldest first,
+// and leases them until leaseUntil: they are not claimed again until then
+// unless marked with RetryEvent
+func (s *Storage) ClaimEvents(ctx context.Context, now, leaseUntil time.Time, limit int) ([]models.OutboxEvent, error) {
+	const op = "storage.memory.ClaimEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "claim_events")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	s.saveOutbox(ctx)
+
+	var events []models.OutboxEvent
+
+	for i := range s.outbox {
+		if len(events) == limit {
+			break
+		}
+
+		e := &s.outbox[i]
+		if e.Status != models.OutboxPending || e.NextAttemptAt.After(now) {
+			continue
+		}
+
+		events = append(events, copyEvent(*e))
+		e.NextAttemptAt = leaseUntil.UTC()
+	}
+
+	return events, nil
+}
+
+// MarkEventDelivered records the successful attempt
+func (s *Storage) MarkEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	return s.updateEvent(ctx, "storage.memory.MarkEventDelivered", "mark_event_delivered", id,
+		func(e *models.OutboxEvent) {
+			e.Status = models.OutboxDelivered
+			e.Attempts++
+			e.LastError = ""
+			e.DeliveredAt = deliveredAt.UTC()
+		})
+}
+
+// RetryEvent records the failed attempt and schedules the next one
+func (s *Storage) RetryEvent(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error {
+	return s.updateEvent(ctx, "storage.memory.RetryEvent", "retry_event", id,
+		func(e *models.OutboxEvent) {
+			e.Attempts++
+			e.LastError = lastErr
+			e.NextAttemptAt = nextAttemptAt.UTC()
+		})
+}
+
+// DeadLetterEvent records the failed attempt and gives up on the event
+func (s *Storage) DeadLetterEvent(ctx context.Context, id int64, lastErr string) error {
+	return s.updateEvent(ctx, "storage.memory.DeadLetterEvent", "dead_letter_event", id,
+		func(e *models.OutboxEvent) {
+			e.Status = models.OutboxDead
+			e.Attempts++
+			e.LastError = lastErr
+		})
+}
+
+// OutboxEvents returns the events matching the filter, the newest first
+func (s *Storage) OutboxEvents(ctx context.Context, filter models.OutboxFilter) ([]models.OutboxEvent, error) {
+	const op = "storage.memory.OutboxEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "outbox_events")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	var events []models.OutboxEvent
+
+	for i := len(s.outbox) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+
+		e := s.outbox[i]
+		if filter.Status != "" && e.Status != filter.Status ||
+			filter.TenantID != 0 && e.TenantID != filter.TenantID ||
+			filter.AppID != 0 && e.AppID != filter.AppID ||
+			filter.BeforeID != 0 && e.ID >= filter.BeforeID {
+			continue
+		}
+
+		events = append(events, copyEvent(e))
+	}
+
+	return events, nil
+}
+
+// ReplayEvents makes the selected events pending again with the attempts reset,
+// so they are delivered at now. Returns the number of events replayed
+func (s *Storage) ReplayEvents(ctx context.Context, filter models.ReplayFilter, now time.Time) (int64, error) {
+	const op = "storage.memory.ReplayEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "replay_events")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	s.saveOutbox(ctx)
+
+	var replayed int64
+
+	for i := range s.outbox {
+		e := &s.outbox[i]
+
+		selected := e.Status == models.OutboxDead
+		if len(filter.IDs) > 0 {
+			selected = slices.Contains(filter.IDs, e.ID)
+		}
+
+		if !selected ||
+			filter.TenantID != 0 && e.TenantID != filter.TenantID ||
+			filter.AppID != 0 && e.AppID != filter.AppID {
+			continue
+		}
+
+		e.Status = models.OutboxPending
+		e.Attempts = 0
+		e.LastError = ""
+		e.NextAttemptAt = now.UTC()
+		e.DeliveredAt = time.Time{}
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+// PruneDeliveredEvents deletes the events delivered before the time
+func (s *Storage) PruneDeliveredEvents(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.memory.PruneDeliveredEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_delivered_events")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	s.saveOutbox(ctx)
+
+	n := len(s.outbox)
+	s.outbox = slices.DeleteFunc(slices.Clone(s.outbox), func(e models.OutboxEvent) bool {
+		return e.Status == models.OutboxDelivered && e.DeliveredAt.Before(before)
+	})
+
+	return int64(n - len(s.outbox)), nil
+}
+
+func (s *Storage) updateEvent(ctx context.Context, op, query string, id int64, update func(e *models.OutboxEvent)) error {
+	defer metrics.ObserveStorageQuery(backend, query)()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	i, ok := slices.BinarySearchFunc(s.outbox, id, func(e models.OutboxEvent, id int64) int {
+		return cmp.Compare(e.ID, id)
+	})
+	if !ok {
+		return nil
+	}
+
+	s.saveOutbox(ctx)
+	update(&s.outbox[i])
+
+	return nil
+}
+
+// saveOutbox registers the restore of the current outbox for the rollback of the
+// transaction in ctx, if any. Called before the outbox is changed
+func (s *Storage) saveOutbox(ctx context.Context) {
+	if s.txFromContext(ctx) == nil {
+		return
+	}
+
+	saved := slices.Clone(s.outbox)
+	lastID := s.lastOutboxID
+
+	s.onRollback(ctx, func() {
+		s.outbox = saved
+		s.lastOutboxID = lastID
+	})
+}
+
+func copyEvent(e models.OutboxEvent) models.OutboxEvent {
+	e.Payload = clone(e.Payload)
+
+	return e
+}
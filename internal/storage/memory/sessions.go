@@ -0,0 +1,217 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/storage"
+	"sort"
+	"time"
+)
+
+// SaveSession saves the new session
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+	const op = "storage.memory.SaveSession"
+
+	defer metrics.ObserveStorageQuery(backend, "save_session")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	s.sessions[session.ID] = utcSession(session)
+
+	s.onRollback(ctx, func() { delete(s.sessions, session.ID) })
+
+	return nil
+}
+
+// Session returns the session by ID, revoked and expired ones included
+func (s *Storage) Session(ctx context.Context, id string) (models.Session, error) {
+	const op = "storage.memory.Session"
+
+	defer metrics.ObserveStorageQuery(backend, "session")()
+
+	if err := ctx.Err(); err != nil {
+		return models.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	return session, nil
+}
+
+// UserSessions returns the sessions of the user active at now, the newest first
+func (s *Storage) UserSessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error) {
+	const op = "storage.memory.UserSessions"
+
+	defer metrics.ObserveStorageQuery(backend, "user_sessions")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	return s.activeSessions(userID, "", now), nil
+}
+
+// TouchSession sets the time the session was last used at, unknown sessions are ignored
+func (s *Storage) TouchSession(ctx context.Context, id string, lastUsedAt time.Time) error {
+	const op = "storage.memory.TouchSession"
+
+	defer metrics.ObserveStorageQuery(backend, "touch_session")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	session, ok := s.sessions[id]
+	if !ok || !lastUsedAt.After(session.LastUsedAt) {
+		return nil
+	}
+
+	saved := session
+	session.LastUsedAt = lastUsedAt.UTC()
+	s.sessions[id] = session
+
+	s.onRollback(ctx, func() { s.sessions[id] = saved })
+
+	return nil
+}
+
+// RevokeSession revokes the session, it fails with storage.ErrSessionNotFound
+// if there is no such session or it is already revoked
+func (s *Storage) RevokeSession(ctx context.Context, id string, revokedAt time.Time) error {
+	const op = "storage.memory.RevokeSession"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_session")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	session, ok := s.sessions[id]
+	if !ok || !session.RevokedAt.IsZero() {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	saved := session
+	session.RevokedAt = revokedAt.UTC()
+	s.sessions[id] = session
+
+	s.onRollback(ctx, func() { s.sessions[id] = saved })
+
+	return nil
+}
+
+// RevokeUserSessions revokes the sessions of the user active at revokedAt, except the one with exceptID,
+// and returns them
+func (s *Storage) RevokeUserSessions(
+	ctx context.Context,
+	userID int64,
+	exceptID string,
+	revokedAt time.Time,
+) ([]models.Session, error) {
+	const op = "storage.memory.RevokeUserSessions"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_user_sessions")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	revoked := s.activeSessions(userID, exceptID, revokedAt)
+
+	for i := range revoked {
+		revoked[i].RevokedAt = revokedAt.UTC()
+		s.sessions[revoked[i].ID] = revoked[i]
+	}
+
+	s.onRollback(ctx, func() {
+		for _, session := range revoked {
+			session.RevokedAt = time.Time{}
+			s.sessions[session.ID] = session
+		}
+	})
+
+	return revoked, nil
+}
+
+// PruneSessions deletes the sessions expired or revoked before the time
+func (s *Storage) PruneSessions(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.memory.PruneSessions"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_sessions")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	var deleted []models.Session
+
+	for id, session := range s.sessions {
+		revoked := !session.RevokedAt.IsZero() && session.RevokedAt.Before(before)
+		if revoked || session.ExpiresAt.Before(before) {
+			deleted = append(deleted, session)
+			delete(s.sessions, id)
+		}
+	}
+
+	s.onRollback(ctx, func() {
+		for _, session := range deleted {
+			s.sessions[session.ID] = session
+		}
+	})
+
+	return int64(len(deleted)), nil
+}
+
+// activeSessions returns the sessions of the user active at now, except the one with exceptID,
+// the newest first. Call it with the lock held
+func (s *Storage) activeSessions(userID int64, exceptID string, now time.Time) []models.Session {
+	var sessions []models.Session
+
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ID != exceptID && session.Active(now) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+		}
+
+		return sessions[i].ID < sessions[j].ID
+	})
+
+	return sessions
+}
+
+func utcSession(session models.Session) models.Session {
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.LastUsedAt = session.LastUsedAt.UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	if !session.RevokedAt.IsZero() {
+		session.RevokedAt = session.RevokedAt.UTC()
+	}
+
+	return session
+}
@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"slices"
+	"sort"
+	"time"
+)
+
+// AppendUserEvent appends the event to the user events log.
+// Call it in the transaction of the change the event is about
+func (s *Storage) AppendUserEvent(ctx context.Context, event models.UserEvent) error {
+	const op = "storage.memory.AppendUserEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "append_user_event")()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	s.lastUserEventID++
+
+	event.ID = s.lastUserEventID
+	event.CreatedAt = event.CreatedAt.UTC()
+
+	n := len(s.userEvents)
+	s.userEvents = append(s.userEvents, event)
+
+	s.onRollback(ctx, func() { s.userEvents = s.userEvents[:n] })
+
+	return nil
+}
+
+// UserEvents returns the events matching the filter, the oldest first
+func (s *Storage) UserEvents(ctx context.Context, filter models.UserEventFilter) ([]models.UserEvent, error) {
+	const op = "storage.memory.UserEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "user_events")()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	start := sort.Search(len(s.userEvents), func(i int) bool {
+		return s.userEvents[i].ID > filter.AfterID
+	})
+
+	var events []models.UserEvent
+
+	for _, e := range s.userEvents[start:] {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+
+		if filter.TenantID != 0 && e.TenantID != filter.TenantID {
+			continue
+		}
+		if filter.AppID != 0 && e.AppID != 0 && e.AppID != filter.AppID {
+			continue
+		}
+		if len(filter.Types) > 0 && !slices.Contains(filter.Types, e.Type) {
+			continue
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// LastUserEventID returns the ID of the newest event, 0 if the log is empty
+func (s *Storage) LastUserEventID(ctx context.Context) (int64, error) {
+	const op = "storage.memory.LastUserEventID"
+
+	defer metrics.ObserveStorageQuery(backend, "last_user_event_id")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.rlock(ctx)()
+
+	if len(s.userEvents) == 0 {
+		return 0, nil
+	}
+
+	return s.userEvents[len(s.userEvents)-1].ID, nil
+}
+
+// PruneUserEvents deletes the events created before the time
+func (s *Storage) PruneUserEvents(ctx context.Context, before time.Time) (int64, error) {
+	const op = "storage.memory.PruneUserEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_user_events")()
+
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	defer s.lock(ctx)()
+
+	saved := s.userEvents
+
+	s.userEvents = slices.DeleteFunc(slices.Clone(s.userEvents), func(e models.UserEvent) bool {
+		return e.CreatedAt.Before(before)
+	})
+
+	s.onRollback(ctx, func() { s.userEvents = saved })
+
+	return int64(len(saved) - len(s.userEvents)), nil
+}
@@ -0,0 +1,168 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage"
+	"grpc-sso/internal/storage/sqltx"
+	"strings"
+	"time"
+)
+
+const accessTokenColumns = `id, user_id, app_id, name, hint, hash, scopes, expires_at, created_at, last_used_at, revoked_at`
+
+// SaveAccessToken saves the new personal access token and returns its ID
+func (s *Storage) SaveAccessToken(ctx context.Context, token models.AccessToken) (tokenID int64, err error) {
+	const op = "storage.postgres.SaveAccessToken"
+
+	defer metrics.ObserveStorageQuery(backend, "save_access_token")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = sqltx.QuerierFrom(ctx, s.db).QueryRowContext(ctx,
+		`INSERT INTO access_tokens (user_id, app_id, name, hint, hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		token.UserID, token.AppID, token.Name, token.Hint, token.Hash, strings.Join(token.Scopes, " "),
+		nullTime(token.ExpiresAt), token.CreatedAt.UTC(),
+	).Scan(&tokenID)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokenID, nil
+}
+
+// AccessTokenByHash returns the personal access token by the hash of the token, revoked and expired ones included
+func (s *Storage) AccessTokenByHash(ctx context.Context, hash string) (token models.AccessToken, err error) {
+	const op = "storage.postgres.AccessTokenByHash"
+
+	defer metrics.ObserveStorageQuery(backend, "access_token_by_hash")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	token, err = scanAccessToken(sqltx.QuerierFrom(ctx, s.db).QueryRowContext(ctx,
+		"SELECT "+accessTokenColumns+" FROM access_tokens WHERE hash = $1", hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AccessToken{}, fmt.Errorf("%s: %w", op, storage.ErrAccessTokenNotFound)
+		}
+
+		return models.AccessToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// UserAccessTokens returns the personal access tokens of the user not revoked yet, the newest first
+func (s *Storage) UserAccessTokens(ctx context.Context, userID int64) (tokens []models.AccessToken, err error) {
+	const op = "storage.postgres.UserAccessTokens"
+
+	defer metrics.ObserveStorageQuery(backend, "user_access_tokens")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx,
+		"SELECT "+accessTokenColumns+" FROM access_tokens WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id DESC",
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tokens, nil
+}
+
+// TouchAccessToken sets the time the token was last used at, unknown tokens are ignored
+func (s *Storage) TouchAccessToken(ctx context.Context, tokenID int64, lastUsedAt time.Time) (err error) {
+	const op = "storage.postgres.TouchAccessToken"
+
+	defer metrics.ObserveStorageQuery(backend, "touch_access_token")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"UPDATE access_tokens SET last_used_at = $1 WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $1)",
+		lastUsedAt.UTC(), tokenID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeAccessToken revokes the token of the user. If the user has no such token
+// not revoked yet, returns storage.ErrAccessTokenNotFound
+func (s *Storage) RevokeAccessToken(ctx context.Context, userID int64, tokenID int64, revokedAt time.Time) (err error) {
+	const op = "storage.postgres.RevokeAccessToken"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_access_token")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"UPDATE access_tokens SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL",
+		revokedAt.UTC(), tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAccessTokenNotFound)
+	}
+
+	return nil
+}
+
+func scanAccessToken(row interface{ Scan(dest ...any) error }) (models.AccessToken, error) {
+	var (
+		token                            models.AccessToken
+		scopes                           string
+		expiresAt, lastUsedAt, revokedAt sql.NullTime
+	)
+
+	err := row.Scan(&token.ID, &token.UserID, &token.AppID, &token.Name, &token.Hint, &token.Hash, &scopes,
+		&expiresAt, &token.CreatedAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return models.AccessToken{}, err
+	}
+
+	token.Scopes = splitScopes(scopes)
+	token.ExpiresAt = expiresAt.Time
+	token.LastUsedAt = lastUsedAt.Time
+	token.RevokedAt = revokedAt.Time
+
+	return token, nil
+}
+
+// nullTime stores the zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage/sqltx"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SaveAuditEvent appends the event to the audit log
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) (err error) {
+	const op = "storage.postgres.SaveAuditEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "save_audit_event")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx, `INSERT INTO audit_events
+		(tenant_id, type, actor_id, user_id, email, app_id, reason, ip, user_agent, request_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		event.TenantID, event.Type, event.ActorID, event.UserID, event.Email, event.AppID,
+		event.Reason, event.IP, event.UserAgent, event.RequestID, event.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AuditEvents returns the events matching the filter, the newest first
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter) (events []models.AuditEvent, err error) {
+	const op = "storage.postgres.AuditEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "audit_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	var (
+		where []string
+		args  []any
+	)
+
+	// arg adds the argument and returns its placeholder
+	arg := func(v any) string {
+		args = append(args, v)
+
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.TenantID != 0 {
+		where = append(where, "tenant_id = "+arg(filter.TenantID))
+	}
+	if filter.ActorID != 0 {
+		where = append(where, "actor_id = "+arg(filter.ActorID))
+	}
+	if filter.UserID != 0 {
+		where = append(where, "user_id = "+arg(filter.UserID))
+	}
+	if filter.AppID != 0 {
+		where = append(where, "app_id = "+arg(filter.AppID))
+	}
+	if len(filter.Types) > 0 {
+		placeholders := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			placeholders[i] = arg(t)
+		}
+
+		where = append(where, "type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "created_at >= "+arg(filter.Since.UTC()))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "created_at < "+arg(filter.Until.UTC()))
+	}
+	if filter.BeforeID != 0 {
+		where = append(where, "id < "+arg(filter.BeforeID))
+	}
+
+	query := `SELECT id, tenant_id, type, actor_id, user_id, email, app_id, reason, ip, user_agent, request_id, created_at
+		FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEvent
+
+		err := rows.Scan(&e.ID, &e.TenantID, &e.Type, &e.ActorID, &e.UserID, &e.Email, &e.AppID,
+			&e.Reason, &e.IP, &e.UserAgent, &e.RequestID, &e.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// PruneAuditEvents deletes the events created before the time
+func (s *Storage) PruneAuditEvents(ctx context.Context, before time.Time) (deleted int64, err error) {
+	const op = "storage.postgres.PruneAuditEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_audit_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"DELETE FROM audit_events WHERE created_at < $1",
+		before.UTC(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage"
+	"grpc-sso/internal/storage/sqltx"
+	"strings"
+)
+
+// SaveConsent adds the scopes of the consent to the ones the user already granted to the app
+func (s *Storage) SaveConsent(ctx context.Context, consent models.Consent) (err error) {
+	const op = "storage.postgres.SaveConsent"
+
+	defer metrics.ObserveStorageQuery(backend, "save_consent")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		for _, scope := range consent.Scopes {
+			if _, err := sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+				`INSERT INTO consents (user_id, app_id, scope, granted_at) VALUES ($1, $2, $3, $4)
+				ON CONFLICT DO NOTHING`,
+				consent.UserID, consent.AppID, scope, consent.GrantedAt.UTC()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Consent returns the consent of the user to the app
+func (s *Storage) Consent(ctx context.Context, userID int64, appID int) (consent models.Consent, err error) {
+	const op = "storage.postgres.Consent"
+
+	defer metrics.ObserveStorageQuery(backend, "consent")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	consents, err := s.consents(ctx,
+		"SELECT app_id, scope, granted_at FROM consents WHERE user_id = $1 AND app_id = $2 ORDER BY scope",
+		userID, appID)
+	if err != nil {
+		return models.Consent{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(consents) == 0 {
+		return models.Consent{}, fmt.Errorf("%s: %w", op, storage.ErrConsentNotFound)
+	}
+
+	return consents[0], nil
+}
+
+// UserConsents returns the consents of the user ordered by app
+func (s *Storage) UserConsents(ctx context.Context, userID int64) (consents []models.Consent, err error) {
+	const op = "storage.postgres.UserConsents"
+
+	defer metrics.ObserveStorageQuery(backend, "user_consents")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	consents, err = s.consents(ctx,
+		"SELECT app_id, scope, granted_at FROM consents WHERE user_id = $1 ORDER BY app_id, scope", userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return consents, nil
+}
+
+// consents groups the scopes selected by the query, ordered by app and scope, into consents
+func (s *Storage) consents(ctx context.Context, query string, userID int64, args ...any) ([]models.Consent, error) {
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, query, append([]any{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var consents []models.Consent
+
+	for rows.Next() {
+		consent := models.Consent{UserID: userID}
+
+		var scope string
+		if err := rows.Scan(&consent.AppID, &scope, &consent.GrantedAt); err != nil {
+			return nil, err
+		}
+
+		if n := len(consents); n > 0 && consents[n-1].AppID == consent.AppID {
+			last := &consents[n-1]
+			last.Scopes = append(last.Scopes, scope)
+			if consent.GrantedAt.After(last.GrantedAt) {
+				last.GrantedAt = consent.GrantedAt
+			}
+
+			continue
+		}
+
+		consent.Scopes = []string{scope}
+		consents = append(consents, consent)
+	}
+
+	return consents, rows.Err()
+}
+
+// DeleteConsent deletes the consent of the user to the app with all its scopes
+func (s *Storage) DeleteConsent(ctx context.Context, userID int64, appID int) (err error) {
+	const op = "storage.postgres.DeleteConsent"
+
+	defer metrics.ObserveStorageQuery(backend, "delete_consent")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"DELETE FROM consents WHERE user_id = $1 AND app_id = $2", userID, appID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrConsentNotFound)
+	}
+
+	return nil
+}
+
+// splitScopes splits the space-separated scopes of an app, nil if there are none
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return nil
+	}
+
+	return strings.Fields(scopes)
+}
@@ -0,0 +1,376 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage"
+	"grpc-sso/internal/storage/sqltx"
+	"slices"
+)
+
+// SaveGroup saves the group to the tenant, the name must be unique within it
+func (s *Storage) SaveGroup(ctx context.Context, tenantID int64, name string) (groupID int64, err error) {
+	const op = "storage.postgres.SaveGroup"
+
+	defer metrics.ObserveStorageQuery(backend, "save_group")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = sqltx.QuerierFrom(ctx, s.db).QueryRowContext(ctx,
+		"INSERT INTO user_groups (tenant_id, name) VALUES ($1, $2) RETURNING id", tenantID, name,
+	).Scan(&groupID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrGroupExists)
+		}
+
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return groupID, nil
+}
+
+// Group returns the group with its direct members and roles
+func (s *Storage) Group(ctx context.Context, groupID int64) (group models.Group, err error) {
+	const op = "storage.postgres.Group"
+
+	defer metrics.ObserveStorageQuery(backend, "group")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = sqltx.QuerierFrom(ctx, s.db).QueryRowContext(ctx,
+		"SELECT id, tenant_id, name FROM user_groups WHERE id = $1", groupID).
+		Scan(&group.ID, &group.TenantID, &group.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Group{}, fmt.Errorf("%s: %w", op, storage.ErrGroupNotFound)
+		}
+
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if group.UserIDs, err = s.groupIDs(ctx,
+		"SELECT user_id FROM group_members WHERE group_id = $1 ORDER BY user_id", groupID); err != nil {
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if group.SubgroupIDs, err = s.groupIDs(ctx,
+		"SELECT subgroup_id FROM group_subgroups WHERE group_id = $1 ORDER BY subgroup_id", groupID); err != nil {
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx,
+		"SELECT app_id, role FROM group_roles WHERE group_id = $1 ORDER BY app_id, role", groupID)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role models.GroupRole
+		if err := rows.Scan(&role.AppID, &role.Role); err != nil {
+			return models.Group{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		group.Roles = append(group.Roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Group{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return group, nil
+}
+
+// groupIDs returns the IDs selected by the query for the group
+func (s *Storage) groupIDs(ctx context.Context, query string, groupID int64) ([]int64, error) {
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Groups returns the groups of the tenant ordered by name, without their members and roles
+func (s *Storage) Groups(ctx context.Context, tenantID int64) (groups []models.Group, err error) {
+	const op = "storage.postgres.Groups"
+
+	defer metrics.ObserveStorageQuery(backend, "groups")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx,
+		"SELECT id, tenant_id, name FROM user_groups WHERE tenant_id = $1 ORDER BY name", tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.TenantID, &group.Name); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return groups, nil
+}
+
+// DeleteGroup deletes the group with its members, roles and its membership in other groups
+func (s *Storage) DeleteGroup(ctx context.Context, groupID int64) (err error) {
+	const op = "storage.postgres.DeleteGroup"
+
+	defer metrics.ObserveStorageQuery(backend, "delete_group")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		q := sqltx.QuerierFrom(ctx, s.db)
+
+		res, err := q.ExecContext(ctx, "DELETE FROM user_groups WHERE id = $1", groupID)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return storage.ErrGroupNotFound
+		}
+
+		for _, query := range []string{
+			"DELETE FROM group_members WHERE group_id = $1",
+			"DELETE FROM group_subgroups WHERE group_id = $1 OR subgroup_id = $1",
+			"DELETE FROM group_roles WHERE group_id = $1",
+		} {
+			if _, err := q.ExecContext(ctx, query, groupID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AddGroupUser adds the user to the group, adding a member twice does nothing
+func (s *Storage) AddGroupUser(ctx context.Context, groupID int64, userID int64) (err error) {
+	const op = "storage.postgres.AddGroupUser"
+
+	defer metrics.ObserveStorageQuery(backend, "add_group_user")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	if _, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"INSERT INTO group_members (group_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", groupID, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveGroupUser removes the user from the group, removing a non-member does nothing
+func (s *Storage) RemoveGroupUser(ctx context.Context, groupID int64, userID int64) (err error) {
+	const op = "storage.postgres.RemoveGroupUser"
+
+	defer metrics.ObserveStorageQuery(backend, "remove_group_user")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	if _, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"DELETE FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AddSubgroup nests the subgroup in the group, nesting it twice does nothing.
+// A group can't be nested in itself, directly or through other groups: it's ErrGroupCycle
+func (s *Storage) AddSubgroup(ctx context.Context, groupID int64, subgroupID int64) (err error) {
+	const op = "storage.postgres.AddSubgroup"
+
+	defer metrics.ObserveStorageQuery(backend, "add_subgroup")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		var cycle int
+
+		q := sqltx.QuerierFrom(ctx, s.db)
+
+		// Concurrent nestings of unrelated groups can close a cycle together, the groups of
+		// the tenant are locked to check and nest one at a time
+		_, err := q.ExecContext(ctx, `SELECT id FROM user_groups
+			WHERE tenant_id = (SELECT tenant_id FROM user_groups WHERE id = $1) FOR UPDATE`, groupID)
+		if err != nil {
+			return err
+		}
+
+		err = q.QueryRowContext(ctx, `WITH RECURSIVE descendants(id) AS (
+				SELECT CAST($1 AS BIGINT)
+				UNION
+				SELECT gs.subgroup_id FROM group_subgroups gs JOIN descendants d ON gs.group_id = d.id
+			)
+			SELECT COUNT(*) FROM descendants WHERE id = $2`, subgroupID, groupID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+
+		if cycle > 0 {
+			return storage.ErrGroupCycle
+		}
+
+		_, err = q.ExecContext(ctx, "INSERT INTO group_subgroups (group_id, subgroup_id) VALUES ($1, $2) "+
+			"ON CONFLICT DO NOTHING", groupID, subgroupID)
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveSubgroup removes the subgroup from the group, removing a non-member does nothing
+func (s *Storage) RemoveSubgroup(ctx context.Context, groupID int64, subgroupID int64) (err error) {
+	const op = "storage.postgres.RemoveSubgroup"
+
+	defer metrics.ObserveStorageQuery(backend, "remove_subgroup")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	if _, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"DELETE FROM group_subgroups WHERE group_id = $1 AND subgroup_id = $2", groupID, subgroupID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// GrantGroupRole grants the role in the app to the group, granting it twice does nothing
+func (s *Storage) GrantGroupRole(ctx context.Context, groupID int64, role models.GroupRole) (err error) {
+	const op = "storage.postgres.GrantGroupRole"
+
+	defer metrics.ObserveStorageQuery(backend, "grant_group_role")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"INSERT INTO group_roles (group_id, app_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		groupID, role.AppID, role.Role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeGroupRole revokes the role in the app from the group, revoking a missing role does nothing
+func (s *Storage) RevokeGroupRole(ctx context.Context, groupID int64, role models.GroupRole) (err error) {
+	const op = "storage.postgres.RevokeGroupRole"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_group_role")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"DELETE FROM group_roles WHERE group_id = $1 AND app_id = $2 AND role = $3", groupID, role.AppID, role.Role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserPermissions returns the groups of the user, nested ones expanded, and the roles granted to them in the app
+func (s *Storage) UserPermissions(ctx context.Context, userID int64, appID int) (perms models.Permissions, err error) {
+	const op = "storage.postgres.UserPermissions"
+
+	defer metrics.ObserveStorageQuery(backend, "user_permissions")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, `WITH RECURSIVE effective(id) AS (
+			SELECT group_id FROM group_members WHERE user_id = $1
+			UNION
+			SELECT gs.group_id FROM group_subgroups gs JOIN effective e ON gs.subgroup_id = e.id
+		)
+		SELECT g.name, COALESCE(r.role, '') FROM effective e
+		JOIN user_groups g ON g.id = e.id
+		LEFT JOIN group_roles r ON r.group_id = e.id AND r.app_id = $2`, userID, appID)
+	if err != nil {
+		return models.Permissions{}, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group, role string
+		if err := rows.Scan(&group, &role); err != nil {
+			return models.Permissions{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		perms.Groups = append(perms.Groups, group)
+		if role != "" {
+			perms.Roles = append(perms.Roles, role)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Permissions{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return compactPermissions(perms), nil
+}
+
+// compactPermissions sorts the groups and roles and drops the duplicates,
+// a group or a role is reached once per path through the nested groups
+func compactPermissions(perms models.Permissions) models.Permissions {
+	slices.Sort(perms.Groups)
+	slices.Sort(perms.Roles)
+
+	return models.Permissions{Groups: slices.Compact(perms.Groups), Roles: slices.Compact(perms.Roles)}
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage/storagetest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// dsnEnv is the env variable with the DSN of the PostgreSQL server the tests run against.
+// Every test gets its own schema there, dropped when it ends
+const dsnEnv = "POSTGRES_TEST_DSN"
+
+func TestConformance(t *testing.T) {
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", dsnEnv)
+	}
+
+	storagetest.Run(t, func(t *testing.T) (storagetest.Storage, storagetest.Fixtures) {
+		s, err := New(withSearchPath(dsn, newSchema(t, dsn)))
+		require.NoError(t, err)
+
+		t.Cleanup(func() { _ = s.Close() })
+
+		storagetest.Migrate(t, s.db, "../../../migrations/postgres")
+
+		return s, fixtures{s}
+	})
+}
+
+// newSchema creates an empty schema for the test and drops it on cleanup
+func newSchema(t *testing.T, dsn string) string {
+	t.Helper()
+
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+
+	suffix := make([]byte, 8)
+	_, err = rand.Read(suffix)
+	require.NoError(t, err)
+
+	schema := "test_" + hex.EncodeToString(suffix)
+
+	_, err = db.Exec("CREATE SCHEMA " + quoteIdentifier(schema))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_, err := db.Exec("DROP SCHEMA " + quoteIdentifier(schema) + " CASCADE")
+		require.NoError(t, err)
+	})
+
+	return schema
+}
+
+// withSearchPath makes the connections of the dsn use the schema
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+
+	return dsn + sep + "search_path=" + url.QueryEscape(schema)
+}
+
+type fixtures struct {
+	s *Storage
+}
+
+func (f fixtures) AddApp(t testing.TB, app models.App) {
+	tenantID := app.TenantID
+	if tenantID == 0 {
+		tenantID = models.DefaultTenantID
+	}
+
+	_, err := f.s.db.Exec(
+		`INSERT INTO apps (id, tenant_id, name, secret, webhook_url, webhook_secret, scopes, exchange_audiences)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		app.ID, tenantID, app.Name, app.Secret, app.WebhookURL, app.WebhookSecret, strings.Join(app.Scopes, " "),
+		strings.Trim(fmt.Sprint(app.ExchangeAudiences), "[]"))
+	require.NoError(t, err)
+}
+
+func (f fixtures) AddTenant(t testing.TB, tenant models.Tenant) {
+	_, err := f.s.db.Exec("INSERT INTO tenants (id, name) VALUES ($1, $2)", tenant.ID, tenant.Name)
+	require.NoError(t, err)
+}
+
+func (f fixtures) MakeAdmin(t testing.TB, userID int64) {
+	_, err := f.s.db.Exec("UPDATE users SET is_admin = TRUE WHERE id = $1", userID)
+	require.NoError(t, err)
+}
@@ -0,0 +1,236 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage"
+	"grpc-sso/internal/storage/sqltx"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sessionColumns = `id, user_id, app_id, ip, user_agent, created_at, last_used_at, expires_at, revoked_at`
+
+// SaveSession saves the new session
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) (err error) {
+	const op = "storage.postgres.SaveSession"
+
+	defer metrics.ObserveStorageQuery(backend, "save_session")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx, `INSERT INTO sessions
+		(id, user_id, app_id, ip, user_agent, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID, session.UserID, session.AppID, session.IP, session.UserAgent,
+		session.CreatedAt.UTC(), session.LastUsedAt.UTC(), session.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Session returns the session by ID, revoked and expired ones included
+func (s *Storage) Session(ctx context.Context, id string) (session models.Session, err error) {
+	const op = "storage.postgres.Session"
+
+	defer metrics.ObserveStorageQuery(backend, "session")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	session, err = scanSession(sqltx.QuerierFrom(ctx, s.db).QueryRowContext(ctx,
+		"SELECT "+sessionColumns+" FROM sessions WHERE id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Session{}, fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+		}
+
+		return models.Session{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, nil
+}
+
+// UserSessions returns the sessions of the user active at now, the newest first
+func (s *Storage) UserSessions(ctx context.Context, userID int64, now time.Time) (sessions []models.Session, err error) {
+	const op = "storage.postgres.UserSessions"
+
+	defer metrics.ObserveStorageQuery(backend, "user_sessions")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	sessions, err = s.activeSessions(ctx, userID, "", now)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return sessions, nil
+}
+
+// TouchSession sets the time the session was last used at, unknown sessions are ignored
+func (s *Storage) TouchSession(ctx context.Context, id string, lastUsedAt time.Time) (err error) {
+	const op = "storage.postgres.TouchSession"
+
+	defer metrics.ObserveStorageQuery(backend, "touch_session")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"UPDATE sessions SET last_used_at = $1 WHERE id = $2 AND last_used_at < $1", lastUsedAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeSession revokes the session, it fails with storage.ErrSessionNotFound
+// if there is no such session or it is already revoked
+func (s *Storage) RevokeSession(ctx context.Context, id string, revokedAt time.Time) (err error) {
+	const op = "storage.postgres.RevokeSession"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_session")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", revokedAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrSessionNotFound)
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes the sessions of the user active at revokedAt, except the one with exceptID,
+// and returns them
+func (s *Storage) RevokeUserSessions(
+	ctx context.Context,
+	userID int64,
+	exceptID string,
+	revokedAt time.Time,
+) (revoked []models.Session, err error) {
+	const op = "storage.postgres.RevokeUserSessions"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_user_sessions")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		revoked, err = s.activeSessions(ctx, userID, exceptID, revokedAt)
+		if err != nil || len(revoked) == 0 {
+			return err
+		}
+
+		args := []any{revokedAt.UTC()}
+		placeholders := make([]string, len(revoked))
+		for i := range revoked {
+			revoked[i].RevokedAt = revokedAt.UTC()
+			args = append(args, revoked[i].ID)
+			placeholders[i] = "$" + strconv.Itoa(len(args))
+		}
+
+		_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+			"UPDATE sessions SET revoked_at = $1 WHERE id IN ("+strings.Join(placeholders, ", ")+")", args...)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// PruneSessions deletes the sessions expired or revoked before the time
+func (s *Storage) PruneSessions(ctx context.Context, before time.Time) (deleted int64, err error) {
+	const op = "storage.postgres.PruneSessions"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_sessions")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"DELETE FROM sessions WHERE expires_at < $1 OR revoked_at < $1", before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+// activeSessions returns the sessions of the user active at now, except the one with exceptID,
+// the newest first. The rows are locked until the end of the transaction, if any
+func (s *Storage) activeSessions(
+	ctx context.Context,
+	userID int64,
+	exceptID string,
+	now time.Time,
+) ([]models.Session, error) {
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, `SELECT `+sessionColumns+` FROM sessions
+		WHERE user_id = $1 AND id != $2 AND revoked_at IS NULL AND expires_at > $3
+		ORDER BY created_at DESC, id
+		FOR UPDATE`, userID, exceptID, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func scanSession(row interface{ Scan(dest ...any) error }) (models.Session, error) {
+	var (
+		session   models.Session
+		revokedAt sql.NullTime
+	)
+
+	err := row.Scan(&session.ID, &session.UserID, &session.AppID, &session.IP, &session.UserAgent,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &revokedAt)
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	session.RevokedAt = revokedAt.Time
+
+	return session, nil
+}
@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage/sqltx"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AppendUserEvent appends the event to the user events log.
+// Call it in the transaction of the change the event is about.
+// IDs are taken in insert order, so concurrent transactions may commit them
+// out of order and a watcher polling right then may skip an event
+func (s *Storage) AppendUserEvent(ctx context.Context, event models.UserEvent) (err error) {
+	const op = "storage.postgres.AppendUserEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "append_user_event")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx, `INSERT INTO user_events
+		(tenant_id, type, user_id, email, app_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		event.TenantID, event.Type, event.UserID, event.Email, event.AppID, event.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UserEvents returns the events matching the filter, the oldest first
+func (s *Storage) UserEvents(ctx context.Context, filter models.UserEventFilter) (events []models.UserEvent, err error) {
+	const op = "storage.postgres.UserEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "user_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	var args []any
+
+	// arg adds the argument and returns its placeholder
+	arg := func(v any) string {
+		args = append(args, v)
+
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{"id > " + arg(filter.AfterID)}
+
+	if filter.TenantID != 0 {
+		where = append(where, "tenant_id = "+arg(filter.TenantID))
+	}
+	if filter.AppID != 0 {
+		where = append(where, "app_id IN (0, "+arg(filter.AppID)+")")
+	}
+	if len(filter.Types) > 0 {
+		placeholders := make([]string, len(filter.Types))
+		for i, t := range filter.Types {
+			placeholders[i] = arg(t)
+		}
+
+		where = append(where, "type IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	query := `SELECT id, tenant_id, type, user_id, email, app_id, created_at FROM user_events
+		WHERE ` + strings.Join(where, " AND ") + " ORDER BY id"
+	if filter.Limit > 0 {
+		query += " LIMIT " + arg(filter.Limit)
+	}
+
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.UserEvent
+
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.Type, &e.UserID, &e.Email, &e.AppID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return events, nil
+}
+
+// LastUserEventID returns the ID of the newest event, 0 if the log is empty
+func (s *Storage) LastUserEventID(ctx context.Context) (id int64, err error) {
+	const op = "storage.postgres.LastUserEventID"
+
+	defer metrics.ObserveStorageQuery(backend, "last_user_event_id")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = sqltx.QuerierFrom(ctx, s.db).QueryRowContext(ctx,
+		"SELECT COALESCE(MAX(id), 0) FROM user_events",
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// PruneUserEvents deletes the events created before the time
+func (s *Storage) PruneUserEvents(ctx context.Context, before time.Time) (deleted int64, err error) {
+	const op = "storage.postgres.PruneUserEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_user_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+		"DELETE FROM user_events WHERE created_at < $1",
+		before.UTC(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,137 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage/sqltx"
+	"strings"
+	"time"
+)
+
+// SaveAuditEvent appends the event to the audit log
+func (s *Storage) SaveAuditEvent(ctx context.Context, event models.AuditEvent) (err error) {
+	const op = "storage.sqlite.SaveAuditEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "save_audit_event")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.Stmt(ctx, s.db, s.saveAuditEventStmt).ExecContext(ctx,
+		event.TenantID, event.Type, event.ActorID, event.UserID, event.Email, event.AppID,
+		event.Reason, event.IP, event.UserAgent, event.RequestID, event.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// AuditEvents returns the events matching the filter, the newest first
+func (s *Storage) AuditEvents(ctx context.Context, filter models.AuditFilter) (events []models.AuditEvent, err error) {
+	const op = "storage.sqlite.AuditEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "audit_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	var (
+		where []string
+		args  []any
+	)
+
+	add := func(cond string, arg any) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+
+	if filter.TenantID != 0 {
+		add("tenant_id = ?", filter.TenantID)
+	}
+	if filter.ActorID != 0 {
+		add("actor_id = ?", filter.ActorID)
+	}
+	if filter.UserID != 0 {
+		add("user_id = ?", filter.UserID)
+	}
+	if filter.AppID != 0 {
+		add("app_id = ?", filter.AppID)
+	}
+	if len(filter.Types) > 0 {
+		where = append(where, "type IN (?"+strings.Repeat(", ?", len(filter.Types)-1)+")")
+		for _, t := range filter.Types {
+			args = append(args, t)
+		}
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < ?", filter.Until.UTC())
+	}
+	if filter.BeforeID != 0 {
+		add("id < ?", filter.BeforeID)
+	}
+
+	query := `SELECT id, tenant_id, type, actor_id, user_id, email, app_id, reason, ip, user_agent, request_id, created_at
+		FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEvent
+
+		err := rows.Scan(&e.ID, &e.TenantID, &e.Type, &e.ActorID, &e.UserID, &e.Email, &e.AppID,
+			&e.Reason, &e.IP, &e.UserAgent, &e.RequestID, &e.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", op, err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return events, nil
+}
+
+// PruneAuditEvents deletes the events created before the time
+func (s *Storage) PruneAuditEvents(ctx context.Context, before time.Time) (deleted int64, err error) {
+	const op = "storage.sqlite.PruneAuditEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_audit_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.Stmt(ctx, s.db, s.pruneAuditEventsStmt).ExecContext(ctx, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,345 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage"
+	"grpc-sso/internal/storage/sqltx"
+	"slices"
+)
+
+// SaveGroup saves the group to the tenant, the name must be unique within it
+func (s *Storage) SaveGroup(ctx context.Context, tenantID int64, name string) (groupID int64, err error) {
+	const op = "storage.sqlite.SaveGroup"
+
+	defer metrics.ObserveStorageQuery(backend, "save_group")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.Stmt(ctx, s.db, s.saveGroupStmt).ExecContext(ctx, tenantID, name)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s : %w", op, storage.ErrGroupExists)
+		}
+
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	groupID, err = res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return groupID, nil
+}
+
+// Group returns the group with its direct members and roles
+func (s *Storage) Group(ctx context.Context, groupID int64) (group models.Group, err error) {
+	const op = "storage.sqlite.Group"
+
+	defer metrics.ObserveStorageQuery(backend, "group")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = sqltx.Stmt(ctx, s.db, s.groupStmt).QueryRowContext(ctx, groupID).
+		Scan(&group.ID, &group.TenantID, &group.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Group{}, fmt.Errorf("%s : %w", op, storage.ErrGroupNotFound)
+		}
+
+		return models.Group{}, fmt.Errorf("%s : %w", op, err)
+	}
+
+	if group.UserIDs, err = s.groupIDs(ctx, s.groupMembersStmt, groupID); err != nil {
+		return models.Group{}, fmt.Errorf("%s : %w", op, err)
+	}
+
+	if group.SubgroupIDs, err = s.groupIDs(ctx, s.groupSubgroupsStmt, groupID); err != nil {
+		return models.Group{}, fmt.Errorf("%s : %w", op, err)
+	}
+
+	rows, err := sqltx.Stmt(ctx, s.db, s.groupRolesStmt).QueryContext(ctx, groupID)
+	if err != nil {
+		return models.Group{}, fmt.Errorf("%s : %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role models.GroupRole
+		if err := rows.Scan(&role.AppID, &role.Role); err != nil {
+			return models.Group{}, fmt.Errorf("%s : %w", op, err)
+		}
+
+		group.Roles = append(group.Roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Group{}, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return group, nil
+}
+
+// groupIDs returns the IDs selected by the statement for the group
+func (s *Storage) groupIDs(ctx context.Context, stmt *sql.Stmt, groupID int64) ([]int64, error) {
+	rows, err := sqltx.Stmt(ctx, s.db, stmt).QueryContext(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Groups returns the groups of the tenant ordered by name, without their members and roles
+func (s *Storage) Groups(ctx context.Context, tenantID int64) (groups []models.Group, err error) {
+	const op = "storage.sqlite.Groups"
+
+	defer metrics.ObserveStorageQuery(backend, "groups")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := sqltx.Stmt(ctx, s.db, s.groupsStmt).QueryContext(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.TenantID, &group.Name); err != nil {
+			return nil, fmt.Errorf("%s : %w", op, err)
+		}
+
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return groups, nil
+}
+
+// DeleteGroup deletes the group with its members, roles and its membership in other groups
+func (s *Storage) DeleteGroup(ctx context.Context, groupID int64) (err error) {
+	const op = "storage.sqlite.DeleteGroup"
+
+	defer metrics.ObserveStorageQuery(backend, "delete_group")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		q := sqltx.QuerierFrom(ctx, s.db)
+
+		res, err := q.ExecContext(ctx, "DELETE FROM user_groups WHERE id = ?", groupID)
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return storage.ErrGroupNotFound
+		}
+
+		for _, query := range []string{
+			"DELETE FROM group_members WHERE group_id = ?",
+			"DELETE FROM group_subgroups WHERE group_id = ?1 OR subgroup_id = ?1",
+			"DELETE FROM group_roles WHERE group_id = ?",
+		} {
+			if _, err := q.ExecContext(ctx, query, groupID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// AddGroupUser adds the user to the group, adding a member twice does nothing
+func (s *Storage) AddGroupUser(ctx context.Context, groupID int64, userID int64) (err error) {
+	const op = "storage.sqlite.AddGroupUser"
+
+	defer metrics.ObserveStorageQuery(backend, "add_group_user")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	if _, err = sqltx.Stmt(ctx, s.db, s.addGroupUserStmt).ExecContext(ctx, groupID, userID); err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveGroupUser removes the user from the group, removing a non-member does nothing
+func (s *Storage) RemoveGroupUser(ctx context.Context, groupID int64, userID int64) (err error) {
+	const op = "storage.sqlite.RemoveGroupUser"
+
+	defer metrics.ObserveStorageQuery(backend, "remove_group_user")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	if _, err = sqltx.Stmt(ctx, s.db, s.removeGroupUserStmt).ExecContext(ctx, groupID, userID); err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// AddSubgroup nests the subgroup in the group, nesting it twice does nothing.
+// A group can't be nested in itself, directly or through other groups: it's ErrGroupCycle
+func (s *Storage) AddSubgroup(ctx context.Context, groupID int64, subgroupID int64) (err error) {
+	const op = "storage.sqlite.AddSubgroup"
+
+	defer metrics.ObserveStorageQuery(backend, "add_subgroup")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		var cycle int
+
+		err := sqltx.Stmt(ctx, s.db, s.groupCycleStmt).QueryRowContext(ctx, subgroupID, groupID).Scan(&cycle)
+		if err != nil {
+			return err
+		}
+
+		if cycle > 0 {
+			return storage.ErrGroupCycle
+		}
+
+		_, err = sqltx.Stmt(ctx, s.db, s.addSubgroupStmt).ExecContext(ctx, groupID, subgroupID)
+
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveSubgroup removes the subgroup from the group, removing a non-member does nothing
+func (s *Storage) RemoveSubgroup(ctx context.Context, groupID int64, subgroupID int64) (err error) {
+	const op = "storage.sqlite.RemoveSubgroup"
+
+	defer metrics.ObserveStorageQuery(backend, "remove_subgroup")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	if _, err = sqltx.Stmt(ctx, s.db, s.removeSubgroupStmt).ExecContext(ctx, groupID, subgroupID); err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// GrantGroupRole grants the role in the app to the group, granting it twice does nothing
+func (s *Storage) GrantGroupRole(ctx context.Context, groupID int64, role models.GroupRole) (err error) {
+	const op = "storage.sqlite.GrantGroupRole"
+
+	defer metrics.ObserveStorageQuery(backend, "grant_group_role")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.Stmt(ctx, s.db, s.grantGroupRoleStmt).ExecContext(ctx, groupID, role.AppID, role.Role)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeGroupRole revokes the role in the app from the group, revoking a missing role does nothing
+func (s *Storage) RevokeGroupRole(ctx context.Context, groupID int64, role models.GroupRole) (err error) {
+	const op = "storage.sqlite.RevokeGroupRole"
+
+	defer metrics.ObserveStorageQuery(backend, "revoke_group_role")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.Stmt(ctx, s.db, s.revokeGroupRoleStmt).ExecContext(ctx, groupID, role.AppID, role.Role)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// UserPermissions returns the groups of the user, nested ones expanded, and the roles granted to them in the app
+func (s *Storage) UserPermissions(ctx context.Context, userID int64, appID int) (perms models.Permissions, err error) {
+	const op = "storage.sqlite.UserPermissions"
+
+	defer metrics.ObserveStorageQuery(backend, "user_permissions")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := sqltx.Stmt(ctx, s.db, s.userPermissionsStmt).QueryContext(ctx, userID, appID)
+	if err != nil {
+		return models.Permissions{}, fmt.Errorf("%s : %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group, role string
+		if err := rows.Scan(&group, &role); err != nil {
+			return models.Permissions{}, fmt.Errorf("%s : %w", op, err)
+		}
+
+		perms.Groups = append(perms.Groups, group)
+		if role != "" {
+			perms.Roles = append(perms.Roles, role)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return models.Permissions{}, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return compactPermissions(perms), nil
+}
+
+// compactPermissions sorts the groups and roles and drops the duplicates,
+// a group or a role is reached once per path through the nested groups
+func compactPermissions(perms models.Permissions) models.Permissions {
+	slices.Sort(perms.Groups)
+	slices.Sort(perms.Roles)
+
+	return models.Permissions{Groups: slices.Compact(perms.Groups), Roles: slices.Compact(perms.Roles)}
+}
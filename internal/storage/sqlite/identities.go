@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage"
+	"grpc-sso/internal/storage/sqltx"
+)
+
+const identityColumns = `id, tenant_id, user_id, provider, subject, email, created_at`
+
+// SaveIdentity links the account at the provider to the user and returns the ID of the link.
+// If the account is linked in the tenant or the user has an account of the provider linked already,
+// returns storage.ErrIdentityExists
+func (s *Storage) SaveIdentity(ctx context.Context, identity models.Identity) (identityID int64, err error) {
+	const op = "storage.sqlite.SaveIdentity"
+
+	defer metrics.ObserveStorageQuery(backend, "save_identity")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.Stmt(ctx, s.db, s.saveIdentityStmt).ExecContext(ctx,
+		identity.TenantID, identity.UserID, identity.Provider, identity.Subject, identity.Email,
+		identity.CreatedAt.UTC())
+	if err != nil {
+		var sqliteErr sqlite3.Error
+
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s : %w", op, storage.ErrIdentityExists)
+		}
+
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	identityID, err = res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return identityID, nil
+}
+
+// Identity returns the link of the account at the provider in the tenant
+func (s *Storage) Identity(
+	ctx context.Context,
+	tenantID int64,
+	provider string,
+	subject string,
+) (identity models.Identity, err error) {
+	const op = "storage.sqlite.Identity"
+
+	defer metrics.ObserveStorageQuery(backend, "identity")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	identity, err = scanIdentity(sqltx.Stmt(ctx, s.db, s.identityStmt).QueryRowContext(ctx,
+		tenantID, provider, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Identity{}, fmt.Errorf("%s : %w", op, storage.ErrIdentityNotFound)
+		}
+
+		return models.Identity{}, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return identity, nil
+}
+
+// UserIdentities returns the accounts linked to the user ordered by provider
+func (s *Storage) UserIdentities(ctx context.Context, userID int64) (identities []models.Identity, err error) {
+	const op = "storage.sqlite.UserIdentities"
+
+	defer metrics.ObserveStorageQuery(backend, "user_identities")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := sqltx.Stmt(ctx, s.db, s.userIdentitiesStmt).QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s : %w", op, err)
+		}
+
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return identities, nil
+}
+
+// DeleteIdentity unlinks the account of the provider from the user.
+// If the user has no account of the provider linked, returns storage.ErrIdentityNotFound
+func (s *Storage) DeleteIdentity(ctx context.Context, userID int64, provider string) (err error) {
+	const op = "storage.sqlite.DeleteIdentity"
+
+	defer metrics.ObserveStorageQuery(backend, "delete_identity")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.Stmt(ctx, s.db, s.deleteIdentityStmt).ExecContext(ctx, userID, provider)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	if affected == 0 {
+		return fmt.Errorf("%s : %w", op, storage.ErrIdentityNotFound)
+	}
+
+	return nil
+}
+
+func scanIdentity(row interface{ Scan(dest ...any) error }) (models.Identity, error) {
+	var identity models.Identity
+
+	err := row.Scan(&identity.ID, &identity.TenantID, &identity.UserID, &identity.Provider, &identity.Subject,
+		&identity.Email, &identity.CreatedAt)
+	if err != nil {
+		return models.Identity{}, err
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,283 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage/sqltx"
+	"strings"
+	"time"
+)
+
+const outboxColumns = `id, event_id, type, tenant_id, app_id, user_id, payload, status, attempts,
+	next_attempt_at, last_error, created_at, delivered_at`
+
+// EnqueueEvent adds a copy of the event for every app of event.TenantID with a webhook,
+// or only for event.AppID if it is set.
+// Call it in the transaction of the change the event is about
+func (s *Storage) EnqueueEvent(ctx context.Context, event models.OutboxEvent) (err error) {
+	const op = "storage.sqlite.EnqueueEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "enqueue_event")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.Stmt(ctx, s.db, s.enqueueEventStmt).ExecContext(ctx,
+		event.EventID, event.Type, event.UserID, event.Payload,
+		event.CreatedAt.UTC(), event.CreatedAt.UTC(), event.TenantID, event.AppID, event.AppID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// ClaimEvents returns up to limit pending events due at now, the oldest first,
+// and leases them until leaseUntil: they are not claimed again until then
+// unless marked with RetryEvent
+func (s *Storage) ClaimEvents(
+	ctx context.Context,
+	now, leaseUntil time.Time,
+	limit int,
+) (events []models.OutboxEvent, err error) {
+	const op = "storage.sqlite.ClaimEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "claim_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		events, err = s.outboxEvents(ctx, `SELECT `+outboxColumns+` FROM outbox_events
+			WHERE status = 'pending' AND next_attempt_at <= ?
+			ORDER BY id LIMIT ?`, now.UTC(), limit)
+		if err != nil {
+			return err
+		}
+
+		if len(events) == 0 {
+			return nil
+		}
+
+		args := []any{leaseUntil.UTC()}
+		for _, e := range events {
+			args = append(args, e.ID)
+		}
+
+		_, err = sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx,
+			"UPDATE outbox_events SET next_attempt_at = ? WHERE id IN ("+placeholders(len(events))+")",
+			args...)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return events, nil
+}
+
+// MarkEventDelivered records the successful attempt
+func (s *Storage) MarkEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) (err error) {
+	const op = "storage.sqlite.MarkEventDelivered"
+
+	defer metrics.ObserveStorageQuery(backend, "mark_event_delivered")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.Stmt(ctx, s.db, s.markEventDeliveredStmt).ExecContext(ctx, deliveredAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// RetryEvent records the failed attempt and schedules the next one
+func (s *Storage) RetryEvent(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) (err error) {
+	const op = "storage.sqlite.RetryEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "retry_event")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.Stmt(ctx, s.db, s.retryEventStmt).ExecContext(ctx, lastErr, nextAttemptAt.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// DeadLetterEvent records the failed attempt and gives up on the event
+func (s *Storage) DeadLetterEvent(ctx context.Context, id int64, lastErr string) (err error) {
+	const op = "storage.sqlite.DeadLetterEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "dead_letter_event")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.Stmt(ctx, s.db, s.deadLetterEventStmt).ExecContext(ctx, lastErr, id)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// OutboxEvents returns the events matching the filter, the newest first
+func (s *Storage) OutboxEvents(ctx context.Context, filter models.OutboxFilter) (events []models.OutboxEvent, err error) {
+	const op = "storage.sqlite.OutboxEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "outbox_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	var (
+		where []string
+		args  []any
+	)
+
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.TenantID != 0 {
+		where = append(where, "tenant_id = ?")
+		args = append(args, filter.TenantID)
+	}
+	if filter.AppID != 0 {
+		where = append(where, "app_id = ?")
+		args = append(args, filter.AppID)
+	}
+	if filter.BeforeID != 0 {
+		where = append(where, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+
+	query := "SELECT " + outboxColumns + " FROM outbox_events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	events, err = s.outboxEvents(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return events, nil
+}
+
+// ReplayEvents makes the selected events pending again with the attempts reset,
+// so they are delivered at now. Returns the number of events replayed
+func (s *Storage) ReplayEvents(ctx context.Context, filter models.ReplayFilter, now time.Time) (replayed int64, err error) {
+	const op = "storage.sqlite.ReplayEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "replay_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	query := `UPDATE outbox_events
+		SET status = 'pending', attempts = 0, last_error = '', next_attempt_at = ?, delivered_at = NULL`
+	args := []any{now.UTC()}
+
+	if len(filter.IDs) > 0 {
+		query += " WHERE id IN (" + placeholders(len(filter.IDs)) + ")"
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	} else {
+		query += " WHERE status = 'dead'"
+	}
+
+	if filter.TenantID != 0 {
+		query += " AND tenant_id = ?"
+		args = append(args, filter.TenantID)
+	}
+
+	if filter.AppID != 0 {
+		query += " AND app_id = ?"
+		args = append(args, filter.AppID)
+	}
+
+	res, err := sqltx.QuerierFrom(ctx, s.db).ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	replayed, err = res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return replayed, nil
+}
+
+// PruneDeliveredEvents deletes the events delivered before the time
+func (s *Storage) PruneDeliveredEvents(ctx context.Context, before time.Time) (deleted int64, err error) {
+	const op = "storage.sqlite.PruneDeliveredEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_delivered_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.Stmt(ctx, s.db, s.pruneDeliveredEventsStmt).ExecContext(ctx, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return deleted, nil
+}
+
+func (s *Storage) outboxEvents(ctx context.Context, query string, args ...any) ([]models.OutboxEvent, error) {
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.OutboxEvent
+
+	for rows.Next() {
+		var (
+			e           models.OutboxEvent
+			deliveredAt sql.NullTime
+		)
+
+		err := rows.Scan(&e.ID, &e.EventID, &e.Type, &e.TenantID, &e.AppID, &e.UserID, &e.Payload, &e.Status,
+			&e.Attempts, &e.NextAttemptAt, &e.LastError, &e.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+
+		e.DeliveredAt = deliveredAt.Time
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func placeholders(n int) string {
+	return "?" + strings.Repeat(", ?", n-1)
+}
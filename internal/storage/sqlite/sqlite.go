@@ -6,39 +6,325 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
 	"grpc-sso/internal/storage"
+	"grpc-sso/internal/storage/sqltx"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const backend = "sqlite"
+
+var tracer = otel.Tracer("grpc-sso/internal/storage/sqlite")
+
 type Storage struct {
 	db *sql.DB
+
+	// stmts are all prepared statements, to close them
+	stmts []*sql.Stmt
+
+	saveUserStmt             *sql.Stmt
+	userStmt                 *sql.Stmt
+	userByIDStmt             *sql.Stmt
+	updateEmailStmt          *sql.Stmt
+	disableUserStmt          *sql.Stmt
+	updatePasswordStmt       *sql.Stmt
+	isAdminStmt              *sql.Stmt
+	appStmt                  *sql.Stmt
+	tenantStmt               *sql.Stmt
+	saveAuditEventStmt       *sql.Stmt
+	pruneAuditEventsStmt     *sql.Stmt
+	enqueueEventStmt         *sql.Stmt
+	markEventDeliveredStmt   *sql.Stmt
+	retryEventStmt           *sql.Stmt
+	deadLetterEventStmt      *sql.Stmt
+	pruneDeliveredEventsStmt *sql.Stmt
+	appendUserEventStmt      *sql.Stmt
+	lastUserEventIDStmt      *sql.Stmt
+	pruneUserEventsStmt      *sql.Stmt
+	saveSessionStmt          *sql.Stmt
+	sessionStmt              *sql.Stmt
+	touchSessionStmt         *sql.Stmt
+	revokeSessionStmt        *sql.Stmt
+	pruneSessionsStmt        *sql.Stmt
+	saveGroupStmt            *sql.Stmt
+	groupStmt                *sql.Stmt
+	groupsStmt               *sql.Stmt
+	groupMembersStmt         *sql.Stmt
+	groupSubgroupsStmt       *sql.Stmt
+	groupRolesStmt           *sql.Stmt
+	addGroupUserStmt         *sql.Stmt
+	removeGroupUserStmt      *sql.Stmt
+	groupCycleStmt           *sql.Stmt
+	addSubgroupStmt          *sql.Stmt
+	removeSubgroupStmt       *sql.Stmt
+	grantGroupRoleStmt       *sql.Stmt
+	revokeGroupRoleStmt      *sql.Stmt
+	userPermissionsStmt      *sql.Stmt
+	saveConsentStmt          *sql.Stmt
+	consentStmt              *sql.Stmt
+	userConsentsStmt         *sql.Stmt
+	deleteConsentStmt        *sql.Stmt
+	saveAccessTokenStmt      *sql.Stmt
+	accessTokenByHashStmt    *sql.Stmt
+	userAccessTokensStmt     *sql.Stmt
+	touchAccessTokenStmt     *sql.Stmt
+	revokeAccessTokenStmt    *sql.Stmt
+	saveIdentityStmt         *sql.Stmt
+	identityStmt             *sql.Stmt
+	userIdentitiesStmt       *sql.Stmt
+	deleteIdentityStmt       *sql.Stmt
+}
+
+// Options tune the connection. Zero values keep the driver defaults
+type Options struct {
+	// JournalMode is one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF
+	JournalMode string
+	// BusyTimeout is how long a connection waits for a lock held by another one
+	BusyTimeout time.Duration
+	ForeignKeys bool
+	// Synchronous is one of OFF, NORMAL, FULL or EXTRA
+	Synchronous     string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
 }
 
-// New creates a new instance of the SQLite3 storage
-func New(storagePath string) (*Storage, error) {
+var (
+	journalModes      = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	synchronousLevels = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// New creates a new instance of the SQLite3 storage.
+// The schema must be migrated already, the statements are prepared here
+func New(storagePath string, opts Options) (*Storage, error) {
 	const op = "storage.sqlite.New"
 
-	db, err := sql.Open("sqlite3", storagePath)
+	dsn, err := dataSourceName(storagePath, opts)
 	if err != nil {
 		return nil, fmt.Errorf("%s : %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+
+	s := &Storage{db: db}
+
+	if err := s.prepare(); err != nil {
+		_ = s.Close()
+
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return s, nil
+}
+
+// dataSourceName adds the connection options to the path as go-sqlite3 parameters
+func dataSourceName(storagePath string, opts Options) (string, error) {
+	params := url.Values{}
+
+	if opts.JournalMode != "" {
+		if !slices.Contains(journalModes, strings.ToUpper(opts.JournalMode)) {
+			return "", fmt.Errorf("unknown journal mode %q", opts.JournalMode)
+		}
+
+		params.Set("_journal_mode", strings.ToUpper(opts.JournalMode))
+	}
+
+	if opts.Synchronous != "" {
+		if !slices.Contains(synchronousLevels, strings.ToUpper(opts.Synchronous)) {
+			return "", fmt.Errorf("unknown synchronous level %q", opts.Synchronous)
+		}
+
+		params.Set("_synchronous", strings.ToUpper(opts.Synchronous))
+	}
+
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	}
+
+	if opts.ForeignKeys {
+		params.Set("_foreign_keys", "on")
+	}
+
+	// Transactions take the write lock upfront, SQLite can't upgrade
+	// a read lock while another connection is writing
+	params.Set("_txlock", "immediate")
+
+	sep := "?"
+	if strings.Contains(storagePath, "?") {
+		sep = "&"
+	}
+
+	if !strings.HasPrefix(storagePath, "file:") {
+		storagePath = "file:" + storagePath
+	}
+
+	return storagePath + sep + params.Encode(), nil
+}
+
+// prepare prepares the statements of the storage methods
+func (s *Storage) prepare() error {
+	for _, p := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.saveUserStmt, "INSERT INTO users (tenant_id, email, pass_hash) VALUES (?, ?, ?)"},
+		{&s.userStmt, "SELECT id, tenant_id, email, pass_hash, disabled FROM users WHERE tenant_id = ? AND email = ?"},
+		{&s.userByIDStmt, "SELECT id, tenant_id, email, pass_hash, disabled FROM users WHERE id = ?"},
+		{&s.updateEmailStmt, "UPDATE users SET email = ? WHERE id = ?"},
+		{&s.disableUserStmt, "UPDATE users SET disabled = TRUE WHERE id = ?"},
+		{&s.updatePasswordStmt, "UPDATE users SET pass_hash = ? WHERE id = ?"},
+		{&s.isAdminStmt, "SELECT is_admin FROM users WHERE id = ?"},
+		{&s.appStmt, "SELECT id, tenant_id, name, secret, webhook_url, webhook_secret, scopes, exchange_audiences FROM apps WHERE id = ?"},
+		{&s.tenantStmt, "SELECT id, name FROM tenants WHERE id = ?"},
+		{&s.saveAuditEventStmt, `INSERT INTO audit_events
+			(tenant_id, type, actor_id, user_id, email, app_id, reason, ip, user_agent, request_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&s.pruneAuditEventsStmt, "DELETE FROM audit_events WHERE created_at < ?"},
+		{&s.enqueueEventStmt, `INSERT INTO outbox_events
+			(event_id, type, tenant_id, app_id, user_id, payload, status, next_attempt_at, created_at)
+			SELECT ?, ?, tenant_id, id, ?, ?, 'pending', ?, ? FROM apps
+			WHERE webhook_url != '' AND tenant_id = ? AND (? = 0 OR id = ?)`},
+		{&s.markEventDeliveredStmt, `UPDATE outbox_events
+			SET status = 'delivered', attempts = attempts + 1, last_error = '', delivered_at = ?
+			WHERE id = ?`},
+		{&s.retryEventStmt, `UPDATE outbox_events
+			SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+			WHERE id = ?`},
+		{&s.deadLetterEventStmt, `UPDATE outbox_events
+			SET status = 'dead', attempts = attempts + 1, last_error = ?
+			WHERE id = ?`},
+		{&s.pruneDeliveredEventsStmt, "DELETE FROM outbox_events WHERE status = 'delivered' AND delivered_at < ?"},
+		{&s.appendUserEventStmt, `INSERT INTO user_events (tenant_id, type, user_id, email, app_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`},
+		{&s.lastUserEventIDStmt, "SELECT COALESCE(MAX(id), 0) FROM user_events"},
+		{&s.pruneUserEventsStmt, "DELETE FROM user_events WHERE created_at < ?"},
+		{&s.saveSessionStmt, `INSERT INTO sessions
+			(id, user_id, app_id, ip, user_agent, created_at, last_used_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&s.sessionStmt, "SELECT " + sessionColumns + " FROM sessions WHERE id = ?"},
+		{&s.touchSessionStmt, "UPDATE sessions SET last_used_at = ? WHERE id = ? AND last_used_at < ?"},
+		{&s.revokeSessionStmt, "UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"},
+		{&s.pruneSessionsStmt, "DELETE FROM sessions WHERE expires_at < ? OR revoked_at < ?"},
+		{&s.saveGroupStmt, "INSERT INTO user_groups (tenant_id, name) VALUES (?, ?)"},
+		{&s.groupStmt, "SELECT id, tenant_id, name FROM user_groups WHERE id = ?"},
+		{&s.groupsStmt, "SELECT id, tenant_id, name FROM user_groups WHERE tenant_id = ? ORDER BY name"},
+		{&s.groupMembersStmt, "SELECT user_id FROM group_members WHERE group_id = ? ORDER BY user_id"},
+		{&s.groupSubgroupsStmt, "SELECT subgroup_id FROM group_subgroups WHERE group_id = ? ORDER BY subgroup_id"},
+		{&s.groupRolesStmt, "SELECT app_id, role FROM group_roles WHERE group_id = ? ORDER BY app_id, role"},
+		{&s.addGroupUserStmt, "INSERT INTO group_members (group_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING"},
+		{&s.removeGroupUserStmt, "DELETE FROM group_members WHERE group_id = ? AND user_id = ?"},
+		{&s.groupCycleStmt, `WITH RECURSIVE descendants(id) AS (
+				SELECT ?
+				UNION
+				SELECT gs.subgroup_id FROM group_subgroups gs JOIN descendants d ON gs.group_id = d.id
+			)
+			SELECT COUNT(*) FROM descendants WHERE id = ?`},
+		{&s.addSubgroupStmt, "INSERT INTO group_subgroups (group_id, subgroup_id) VALUES (?, ?) ON CONFLICT DO NOTHING"},
+		{&s.removeSubgroupStmt, "DELETE FROM group_subgroups WHERE group_id = ? AND subgroup_id = ?"},
+		{&s.grantGroupRoleStmt, "INSERT INTO group_roles (group_id, app_id, role) VALUES (?, ?, ?) ON CONFLICT DO NOTHING"},
+		{&s.revokeGroupRoleStmt, "DELETE FROM group_roles WHERE group_id = ? AND app_id = ? AND role = ?"},
+		{&s.userPermissionsStmt, `WITH RECURSIVE effective(id) AS (
+				SELECT group_id FROM group_members WHERE user_id = ?
+				UNION
+				SELECT gs.group_id FROM group_subgroups gs JOIN effective e ON gs.subgroup_id = e.id
+			)
+			SELECT g.name, COALESCE(r.role, '') FROM effective e
+			JOIN user_groups g ON g.id = e.id
+			LEFT JOIN group_roles r ON r.group_id = e.id AND r.app_id = ?`},
+		{&s.saveConsentStmt, `INSERT INTO consents (user_id, app_id, scope, granted_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`},
+		{&s.consentStmt, `SELECT app_id, scope, granted_at FROM consents
+			WHERE user_id = ? AND app_id = ? ORDER BY scope`},
+		{&s.userConsentsStmt, "SELECT app_id, scope, granted_at FROM consents WHERE user_id = ? ORDER BY app_id, scope"},
+		{&s.deleteConsentStmt, "DELETE FROM consents WHERE user_id = ? AND app_id = ?"},
+		{&s.saveAccessTokenStmt, `INSERT INTO access_tokens
+			(user_id, app_id, name, hint, hash, scopes, expires_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&s.accessTokenByHashStmt, "SELECT " + accessTokenColumns + " FROM access_tokens WHERE hash = ?"},
+		{&s.userAccessTokensStmt, "SELECT " + accessTokenColumns + ` FROM access_tokens
+			WHERE user_id = ? AND revoked_at IS NULL ORDER BY id DESC`},
+		{&s.touchAccessTokenStmt, `UPDATE access_tokens SET last_used_at = ?
+			WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`},
+		{&s.revokeAccessTokenStmt, `UPDATE access_tokens SET revoked_at = ?
+			WHERE id = ? AND user_id = ? AND revoked_at IS NULL`},
+		{&s.saveIdentityStmt, `INSERT INTO identities (tenant_id, user_id, provider, subject, email, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`},
+		{&s.identityStmt, "SELECT " + identityColumns + ` FROM identities
+			WHERE tenant_id = ? AND provider = ? AND subject = ?`},
+		{&s.userIdentitiesStmt, "SELECT " + identityColumns + " FROM identities WHERE user_id = ? ORDER BY provider"},
+		{&s.deleteIdentityStmt, "DELETE FROM identities WHERE user_id = ? AND provider = ?"},
+	} {
+		stmt, err := s.db.Prepare(p.query)
+		if err != nil {
+			return fmt.Errorf("prepare %q: %w", p.query, err)
+		}
+
+		*p.stmt = stmt
+		s.stmts = append(s.stmts, stmt)
+	}
+
+	return nil
+}
+
+// Close closes the prepared statements and the database
+func (s *Storage) Close() error {
+	var errs []error
+
+	for _, stmt := range s.stmts {
+		errs = append(errs, stmt.Close())
+	}
+
+	return errors.Join(append(errs, s.db.Close())...)
+}
+
+// WithTx runs fn in a transaction, see storage.Transactor
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	const op = "storage.sqlite.WithTx"
+
+	if err := sqltx.WithTx(ctx, s.db, fn); err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
 }
 
 //var _ auth.UserSaver = &Storage{}
 //var _ auth.UserProvider = &Storage{}
 //var _ auth.AppProvider = &Storage{}
 
-func (s Storage) SaveUser(ctx context.Context, email string, passHash []byte) (userID int64, err error) {
+// SaveUser saves the user to the tenant, the email must be unique within it
+func (s *Storage) SaveUser(
+	ctx context.Context,
+	tenantID int64,
+	email string,
+	passHash []byte,
+) (userID int64, err error) {
 	const op = "storage.sqlite.SaveUser"
 
-	stmt, err := s.db.Prepare("INSERT INTO users (email, pass_hash) VALUES (?, ?)")
-	if err != nil {
-		return models.EmptyUserID, fmt.Errorf("%s : %w", op, err)
-	}
+	defer metrics.ObserveStorageQuery(backend, "save_user")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
 
-	res, err := stmt.ExecContext(ctx, email, passHash)
+	res, err := sqltx.Stmt(ctx, s.db, s.saveUserStmt).ExecContext(ctx, tenantID, email, passHash)
 	if err != nil {
 		var sqliteErr sqlite3.Error
 
@@ -57,17 +343,40 @@ func (s Storage) SaveUser(ctx context.Context, email string, passHash []byte) (u
 	return id, nil
 }
 
-// User returns user by email
-func (s Storage) User(ctx context.Context, email string) (user models.User, err error) {
+// User returns the user of the tenant by email
+func (s *Storage) User(ctx context.Context, tenantID int64, email string) (user models.User, err error) {
 	const op = "storage.sqlite.User"
 
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash FROM users WHERE email = ?")
+	defer metrics.ObserveStorageQuery(backend, "user")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	var resUser models.User
+	err = sqltx.Stmt(ctx, s.db, s.userStmt).QueryRowContext(ctx, tenantID, email).
+		Scan(&resUser.ID, &resUser.TenantID, &resUser.Email, &resUser.PassHash, &resUser.Disabled)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s : %w", op, storage.ErrUserNotFound)
+		}
+
 		return models.User{}, fmt.Errorf("%s : %w", op, err)
 	}
 
-	var resUser models.User
-	err = stmt.QueryRowContext(ctx, email).Scan(&resUser.ID, &resUser.Email, &resUser.PassHash)
+	return resUser, nil
+}
+
+// UserByID returns user by ID
+func (s *Storage) UserByID(ctx context.Context, userID int64) (user models.User, err error) {
+	const op = "storage.sqlite.UserByID"
+
+	defer metrics.ObserveStorageQuery(backend, "user_by_id")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = sqltx.Stmt(ctx, s.db, s.userByIDStmt).QueryRowContext(ctx, userID).
+		Scan(&user.ID, &user.TenantID, &user.Email, &user.PassHash, &user.Disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s : %w", op, storage.ErrUserNotFound)
@@ -76,19 +385,90 @@ func (s Storage) User(ctx context.Context, email string) (user models.User, err
 		return models.User{}, fmt.Errorf("%s : %w", op, err)
 	}
 
-	return resUser, nil
+	return user, nil
 }
 
-func (s Storage) IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err error) {
-	const op = "storage.sqlite.IsAdmin"
+// UpdateEmail changes the email of the user
+func (s *Storage) UpdateEmail(ctx context.Context, userID int64, email string) (err error) {
+	const op = "storage.sqlite.UpdateEmail"
+
+	defer metrics.ObserveStorageQuery(backend, "update_email")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
 
-	stmt, err := s.db.Prepare("SELECT is_admin FROM users WHERE id = ?")
+	res, err := sqltx.Stmt(ctx, s.db, s.updateEmailStmt).ExecContext(ctx, email, userID)
 	if err != nil {
-		return false, fmt.Errorf("%s : %w", op, err)
+		var sqliteErr sqlite3.Error
+
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s : %w", op, storage.ErrUserExists)
+		}
+
+		return fmt.Errorf("%s : %w", op, err)
 	}
 
+	return userAffected(op, res)
+}
+
+// DisableUser disables the user, disabling a disabled user is not an error
+func (s *Storage) DisableUser(ctx context.Context, userID int64) (err error) {
+	const op = "storage.sqlite.DisableUser"
+
+	defer metrics.ObserveStorageQuery(backend, "disable_user")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.Stmt(ctx, s.db, s.disableUserStmt).ExecContext(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return userAffected(op, res)
+}
+
+// UpdatePassword replaces the password hash of the user
+func (s *Storage) UpdatePassword(ctx context.Context, userID int64, passHash []byte) (err error) {
+	const op = "storage.sqlite.UpdatePassword"
+
+	defer metrics.ObserveStorageQuery(backend, "update_password")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.Stmt(ctx, s.db, s.updatePasswordStmt).ExecContext(ctx, passHash, userID)
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return userAffected(op, res)
+}
+
+// userAffected returns ErrUserNotFound if the update matched no user
+func userAffected(op string, res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	if n == 0 {
+		return fmt.Errorf("%s : %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+func (s *Storage) IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err error) {
+	const op = "storage.sqlite.IsAdmin"
+
+	defer metrics.ObserveStorageQuery(backend, "is_admin")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
 	var resIsAdmin bool
-	err = stmt.QueryRowContext(ctx, userID).Scan(&resIsAdmin)
+	err = sqltx.Stmt(ctx, s.db, s.isAdminStmt).QueryRowContext(ctx, userID).Scan(&resIsAdmin)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, fmt.Errorf("%s : %w", op, storage.ErrUserNotFound)
@@ -100,16 +480,22 @@ func (s Storage) IsAdmin(ctx context.Context, userID int64) (isAdmin bool, err e
 	return resIsAdmin, nil
 }
 
-func (s Storage) App(ctx context.Context, appID int) (app models.App, err error) {
+func (s *Storage) App(ctx context.Context, appID int) (app models.App, err error) {
 	const op = "storage.sqlite.App"
 
-	stmt, err := s.db.Prepare("SELECT id, name, secret FROM apps WHERE id = ?")
-	if err != nil {
-		return models.App{}, fmt.Errorf("%s : %w", op, err)
-	}
+	defer metrics.ObserveStorageQuery(backend, "app")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
 
-	var resApp models.App
-	err = stmt.QueryRowContext(ctx, appID).Scan(&resApp.ID, &resApp.Name, &resApp.Secret)
+	var (
+		resApp    models.App
+		scopes    string
+		audiences string
+	)
+	err = sqltx.Stmt(ctx, s.db, s.appStmt).QueryRowContext(ctx, appID).
+		Scan(&resApp.ID, &resApp.TenantID, &resApp.Name, &resApp.Secret, &resApp.WebhookURL, &resApp.WebhookSecret,
+			&scopes, &audiences)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s : %w", op, storage.ErrAppNotFound)
@@ -118,5 +504,103 @@ func (s Storage) App(ctx context.Context, appID int) (app models.App, err error)
 		return models.App{}, fmt.Errorf("%s : %w", op, err)
 	}
 
+	resApp.Scopes = splitScopes(scopes)
+
+	resApp.ExchangeAudiences, err = splitAppIDs(audiences)
+	if err != nil {
+		return models.App{}, fmt.Errorf("%s : %w", op, err)
+	}
+
 	return resApp, nil
 }
+
+// splitAppIDs returns the space-separated app IDs, nil if there are none
+func splitAppIDs(ids string) ([]int, error) {
+	var res []int
+
+	for _, id := range strings.Fields(ids) {
+		appID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid app id %q: %w", id, err)
+		}
+
+		res = append(res, appID)
+	}
+
+	return res, nil
+}
+
+// Tenant returns the tenant by ID
+func (s *Storage) Tenant(ctx context.Context, tenantID int64) (tenant models.Tenant, err error) {
+	const op = "storage.sqlite.Tenant"
+
+	defer metrics.ObserveStorageQuery(backend, "tenant")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	err = sqltx.Stmt(ctx, s.db, s.tenantStmt).QueryRowContext(ctx, tenantID).Scan(&tenant.ID, &tenant.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Tenant{}, fmt.Errorf("%s : %w", op, storage.ErrTenantNotFound)
+		}
+
+		return models.Tenant{}, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return tenant, nil
+}
+
+// startSpan starts a client span for the storage query
+func startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", backend)),
+	)
+}
+
+// Ping checks that the database is reachable
+func (s *Storage) Ping(ctx context.Context) error {
+	const op = "storage.sqlite.Ping"
+
+	var one int
+	if err := s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// MigrationVersion returns the schema version recorded by the migrator in the table
+func (s *Storage) MigrationVersion(ctx context.Context, table string) (version uint, dirty bool, err error) {
+	const op = "storage.sqlite.MigrationVersion"
+
+	query := fmt.Sprintf("SELECT version, dirty FROM %s LIMIT 1", quoteIdentifier(table))
+
+	err = s.db.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+
+		return 0, false, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return version, dirty, nil
+}
+
+// SigningKeys returns the number of apps that have a secret to sign tokens with
+func (s *Storage) SigningKeys(ctx context.Context) (count int, err error) {
+	const op = "storage.sqlite.SigningKeys"
+
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM apps WHERE secret != ''").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return count, nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
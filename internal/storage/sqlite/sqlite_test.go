@@ -0,0 +1,195 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage/storagetest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{
+	JournalMode: "WAL",
+	BusyTimeout: 5 * time.Second,
+	ForeignKeys: true,
+	Synchronous: "NORMAL",
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (storagetest.Storage, storagetest.Fixtures) {
+		s := newTestStorage(t, testOptions)
+
+		return s, fixtures{s}
+	})
+}
+
+func TestNew_Options(t *testing.T) {
+	s := newTestStorage(t, Options{
+		JournalMode:  "wal",
+		BusyTimeout:  time.Second,
+		ForeignKeys:  true,
+		Synchronous:  "full",
+		MaxOpenConns: 4,
+	})
+
+	ctx := context.Background()
+
+	var journalMode string
+	require.NoError(t, s.db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&journalMode))
+	assert.Equal(t, "wal", journalMode)
+
+	var busyTimeout, foreignKeys, synchronous int
+	require.NoError(t, s.db.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&busyTimeout))
+	require.NoError(t, s.db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys))
+	require.NoError(t, s.db.QueryRowContext(ctx, "PRAGMA synchronous").Scan(&synchronous))
+	assert.Equal(t, 1000, busyTimeout)
+	assert.Equal(t, 1, foreignKeys)
+	assert.Equal(t, 2, synchronous) // FULL
+
+	assert.Equal(t, 4, s.db.Stats().MaxOpenConnections)
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sso.db")
+
+	_, err := New(path, Options{JournalMode: "fast"})
+	assert.ErrorContains(t, err, "journal mode")
+
+	_, err = New(path, Options{Synchronous: "sometimes"})
+	assert.ErrorContains(t, err, "synchronous level")
+}
+
+func TestNew_NotMigrated(t *testing.T) {
+	_, err := New(filepath.Join(t.TempDir(), "sso.db"), testOptions)
+	assert.ErrorContains(t, err, "no such table")
+}
+
+// BenchmarkLogin runs the queries of a login (user, app) from parallel clients
+// with the statements prepared once by the storage
+func BenchmarkLogin(b *testing.B) {
+	s, emails := newBenchStorage(b)
+	ctx := context.Background()
+
+	var n atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			email := emails[int(n.Add(1))%len(emails)]
+
+			if _, err := s.User(ctx, models.DefaultTenantID, email); err != nil {
+				b.Error(err)
+			}
+
+			if _, err := s.App(ctx, 1); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// BenchmarkLogin_PrepareEachCall is the baseline: the same queries with
+// the statements prepared on every call as the storage used to do
+func BenchmarkLogin_PrepareEachCall(b *testing.B) {
+	s, emails := newBenchStorage(b)
+	ctx := context.Background()
+
+	query := func(query string, args []any, dest ...any) error {
+		stmt, err := s.db.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		return stmt.QueryRowContext(ctx, args...).Scan(dest...)
+	}
+
+	var n atomic.Int64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var user models.User
+		var app models.App
+
+		for pb.Next() {
+			email := emails[int(n.Add(1))%len(emails)]
+
+			if err := query("SELECT id, email, pass_hash FROM users WHERE tenant_id = ? AND email = ?", []any{models.DefaultTenantID, email},
+				&user.ID, &user.Email, &user.PassHash); err != nil {
+				b.Error(err)
+			}
+
+			if err := query("SELECT id, name, secret FROM apps WHERE id = ?", []any{1},
+				&app.ID, &app.Name, &app.Secret); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+func newBenchStorage(b *testing.B) (*Storage, []string) {
+	s := newTestStorage(b, testOptions)
+	fixtures{s}.AddApp(b, models.App{ID: 1, Name: "test", Secret: "test_secret"})
+
+	emails := make([]string, 100)
+	for i := range emails {
+		emails[i] = fmt.Sprintf("user%d@example.com", i)
+
+		_, err := s.SaveUser(context.Background(), models.DefaultTenantID, emails[i], []byte("hash"))
+		require.NoError(b, err)
+	}
+
+	return s, emails
+}
+
+// newTestStorage creates a storage on a migrated database in a temp dir
+func newTestStorage(t testing.TB, opts Options) *Storage {
+	path := filepath.Join(t.TempDir(), "sso.db")
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	storagetest.Migrate(t, db, "../../../migrations")
+	require.NoError(t, db.Close())
+
+	s, err := New(path, opts)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+type fixtures struct {
+	s *Storage
+}
+
+func (f fixtures) AddApp(t testing.TB, app models.App) {
+	tenantID := app.TenantID
+	if tenantID == 0 {
+		tenantID = models.DefaultTenantID
+	}
+
+	_, err := f.s.db.Exec(
+		`INSERT INTO apps (id, tenant_id, name, secret, webhook_url, webhook_secret, scopes, exchange_audiences)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		app.ID, tenantID, app.Name, app.Secret, app.WebhookURL, app.WebhookSecret, strings.Join(app.Scopes, " "),
+		strings.Trim(fmt.Sprint(app.ExchangeAudiences), "[]"))
+	require.NoError(t, err)
+}
+
+func (f fixtures) AddTenant(t testing.TB, tenant models.Tenant) {
+	_, err := f.s.db.Exec("INSERT INTO tenants (id, name) VALUES (?, ?)", tenant.ID, tenant.Name)
+	require.NoError(t, err)
+}
+
+func (f fixtures) MakeAdmin(t testing.TB, userID int64) {
+	_, err := f.s.db.Exec("UPDATE users SET is_admin = TRUE WHERE id = ?", userID)
+	require.NoError(t, err)
+}
@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/lib/metrics"
+	"grpc-sso/internal/lib/tracing"
+	"grpc-sso/internal/storage/sqltx"
+	"strings"
+	"time"
+)
+
+// AppendUserEvent appends the event to the user events log.
+// Call it in the transaction of the change the event is about
+func (s *Storage) AppendUserEvent(ctx context.Context, event models.UserEvent) (err error) {
+	const op = "storage.sqlite.AppendUserEvent"
+
+	defer metrics.ObserveStorageQuery(backend, "append_user_event")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = sqltx.Stmt(ctx, s.db, s.appendUserEventStmt).ExecContext(ctx,
+		event.TenantID, event.Type, event.UserID, event.Email, event.AppID, event.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("%s : %w", op, err)
+	}
+
+	return nil
+}
+
+// UserEvents returns the events matching the filter, the oldest first
+func (s *Storage) UserEvents(ctx context.Context, filter models.UserEventFilter) (events []models.UserEvent, err error) {
+	const op = "storage.sqlite.UserEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "user_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	where := []string{"id > ?"}
+	args := []any{filter.AfterID}
+
+	if filter.TenantID != 0 {
+		where = append(where, "tenant_id = ?")
+		args = append(args, filter.TenantID)
+	}
+	if filter.AppID != 0 {
+		where = append(where, "app_id IN (0, ?)")
+		args = append(args, filter.AppID)
+	}
+	if len(filter.Types) > 0 {
+		where = append(where, "type IN ("+placeholders(len(filter.Types))+")")
+		for _, t := range filter.Types {
+			args = append(args, t)
+		}
+	}
+
+	query := `SELECT id, tenant_id, type, user_id, email, app_id, created_at FROM user_events
+		WHERE ` + strings.Join(where, " AND ") + " ORDER BY id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := sqltx.QuerierFrom(ctx, s.db).QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.UserEvent
+
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.Type, &e.UserID, &e.Email, &e.AppID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("%s : %w", op, err)
+		}
+
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return events, nil
+}
+
+// LastUserEventID returns the ID of the newest event, 0 if the log is empty
+func (s *Storage) LastUserEventID(ctx context.Context) (id int64, err error) {
+	const op = "storage.sqlite.LastUserEventID"
+
+	defer metrics.ObserveStorageQuery(backend, "last_user_event_id")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	if err := sqltx.Stmt(ctx, s.db, s.lastUserEventIDStmt).QueryRowContext(ctx).Scan(&id); err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return id, nil
+}
+
+// PruneUserEvents deletes the events created before the time
+func (s *Storage) PruneUserEvents(ctx context.Context, before time.Time) (deleted int64, err error) {
+	const op = "storage.sqlite.PruneUserEvents"
+
+	defer metrics.ObserveStorageQuery(backend, "prune_user_events")()
+
+	ctx, span := startSpan(ctx, op)
+	defer func() { tracing.End(span, err) }()
+
+	res, err := sqltx.Stmt(ctx, s.db, s.pruneUserEventsStmt).ExecContext(ctx, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s : %w", op, err)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,82 @@
+// Package sqltx carries database/sql transactions in the context
+// for the storages built on database/sql
+package sqltx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ctxKey is per database, so transactions of different storages don't mix
+type ctxKey struct {
+	db *sql.DB
+}
+
+// Querier is implemented by both *sql.DB and *sql.Tx
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// WithTx runs fn in a transaction of db carried in the ctx passed to fn.
+// If ctx already carries a transaction of db, fn joins it
+func WithTx(ctx context.Context, db *sql.DB, fn func(ctx context.Context) error) (err error) {
+	if _, ok := FromContext(ctx, db); ok {
+		return fn(ctx)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, ctxKey{db}, tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return errors.Join(err, fmt.Errorf("rollback transaction: %w", rbErr))
+		}
+
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// FromContext returns the transaction of db carried in ctx
+func FromContext(ctx context.Context, db *sql.DB) (*sql.Tx, bool) {
+	tx, ok := ctx.Value(ctxKey{db}).(*sql.Tx)
+
+	return tx, ok
+}
+
+// QuerierFrom returns the transaction of db carried in ctx or db itself
+func QuerierFrom(ctx context.Context, db *sql.DB) Querier {
+	if tx, ok := FromContext(ctx, db); ok {
+		return tx
+	}
+
+	return db
+}
+
+// Stmt returns the prepared statement bound to the transaction carried in ctx.
+// The statement is closed by the transaction when it ends
+func Stmt(ctx context.Context, db *sql.DB, stmt *sql.Stmt) *sql.Stmt {
+	if tx, ok := FromContext(ctx, db); ok {
+		return tx.StmtContext(ctx, stmt)
+	}
+
+	return stmt
+}
@@ -1,9 +1,48 @@
 package storage
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 var (
 	ErrUserExists   = errors.New("user already exists")
 	ErrUserNotFound = errors.New("user not found")
 	ErrAppNotFound  = errors.New("app not found")
+
+	ErrTenantNotFound = errors.New("tenant not found")
+
+	ErrSessionNotFound = errors.New("session not found")
+
+	ErrGroupExists   = errors.New("group already exists")
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupCycle is returned when a group would become nested in itself
+	ErrGroupCycle = errors.New("group cycle")
+
+	ErrConsentNotFound = errors.New("consent not found")
+
+	ErrAccessTokenNotFound = errors.New("access token not found")
+
+	// ErrIdentityExists is returned when the account at the provider is linked already,
+	// or the user has an account of the provider linked already
+	ErrIdentityExists   = errors.New("identity already exists")
+	ErrIdentityNotFound = errors.New("identity not found")
 )
+
+// Transactor runs fn in a transaction: the writes made by fn through the
+// storage methods with the ctx passed to fn are committed together if fn
+// returns nil and rolled back if it returns an error or panics.
+// WithTx called with a ctx that already carries a transaction joins it.
+// The ctx passed to fn must not be used concurrently
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Invalidator is notified when user roles are changed, so the caches in front of the storage
+// drop stale entries. Apps are changed in the database only, the caches see them after their TTL
+type Invalidator interface {
+	InvalidateUser(userID int64)
+	// InvalidatePermissions is called when groups, their members or roles are changed.
+	// A change of a nested group affects the members of every group above it, so all users are affected
+	InvalidatePermissions()
+}
@@ -0,0 +1,132 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage"
+	"testing"
+	"time"
+)
+
+// AccessTokens are the personal access tokens of a backend
+type AccessTokens interface {
+	SaveAccessToken(ctx context.Context, token models.AccessToken) (int64, error)
+	AccessTokenByHash(ctx context.Context, hash string) (models.AccessToken, error)
+	UserAccessTokens(ctx context.Context, userID int64) ([]models.AccessToken, error)
+	TouchAccessToken(ctx context.Context, tokenID int64, lastUsedAt time.Time) error
+	RevokeAccessToken(ctx context.Context, userID int64, tokenID int64, revokedAt time.Time) error
+}
+
+var tokenBase = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// newAccessToken returns the token of the user named after its hash, created minutes after tokenBase
+func newAccessToken(hash string, userID int64, minutes int) models.AccessToken {
+	return models.AccessToken{
+		UserID:    userID,
+		AppID:     1,
+		Name:      "token " + hash,
+		Hint:      "sso_pat_" + hash,
+		Hash:      hash,
+		CreatedAt: tokenBase.Add(time.Duration(minutes) * time.Minute),
+	}
+}
+
+func testAccessTokens(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	saved := newAccessToken("h1", 1, 0)
+	saved.Scopes = []string{"email", "profile"}
+	saved.ExpiresAt = tokenBase.Add(24 * time.Hour)
+
+	id1, err := s.SaveAccessToken(ctx, saved)
+	require.NoError(t, err)
+	id2, err := s.SaveAccessToken(ctx, newAccessToken("h2", 1, 10))
+	require.NoError(t, err)
+	_, err = s.SaveAccessToken(ctx, newAccessToken("h3", 2, 20))
+	require.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+
+	got, err := s.AccessTokenByHash(ctx, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, id1, got.ID)
+	assert.Equal(t, saved.UserID, got.UserID)
+	assert.Equal(t, saved.AppID, got.AppID)
+	assert.Equal(t, saved.Name, got.Name)
+	assert.Equal(t, saved.Hint, got.Hint)
+	assert.Equal(t, saved.Scopes, got.Scopes)
+	assert.True(t, saved.ExpiresAt.Equal(got.ExpiresAt), got.ExpiresAt)
+	assert.True(t, saved.CreatedAt.Equal(got.CreatedAt), got.CreatedAt)
+	assert.True(t, got.LastUsedAt.IsZero())
+	assert.True(t, got.RevokedAt.IsZero())
+
+	// Tokens that never expire have no expiry
+	got, err = s.AccessTokenByHash(ctx, "h2")
+	require.NoError(t, err)
+	assert.True(t, got.ExpiresAt.IsZero())
+	assert.Empty(t, got.Scopes)
+
+	_, err = s.AccessTokenByHash(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrAccessTokenNotFound)
+
+	// The last use only moves forward
+	usedAt := tokenBase.Add(time.Hour)
+	require.NoError(t, s.TouchAccessToken(ctx, id1, usedAt))
+	require.NoError(t, s.TouchAccessToken(ctx, id1, usedAt.Add(-time.Minute)))
+	require.NoError(t, s.TouchAccessToken(ctx, 404, usedAt))
+
+	got, err = s.AccessTokenByHash(ctx, "h1")
+	require.NoError(t, err)
+	assert.True(t, usedAt.Equal(got.LastUsedAt), got.LastUsedAt)
+
+	tokens, err := s.UserAccessTokens(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Equal(t, []int64{id2, id1}, []int64{tokens[0].ID, tokens[1].ID})
+
+	// Only the owner revokes the token, once
+	assert.ErrorIs(t, s.RevokeAccessToken(ctx, 2, id1, usedAt), storage.ErrAccessTokenNotFound)
+	require.NoError(t, s.RevokeAccessToken(ctx, 1, id1, usedAt))
+	assert.ErrorIs(t, s.RevokeAccessToken(ctx, 1, id1, usedAt), storage.ErrAccessTokenNotFound)
+
+	got, err = s.AccessTokenByHash(ctx, "h1")
+	require.NoError(t, err)
+	assert.True(t, usedAt.Equal(got.RevokedAt), got.RevokedAt)
+
+	tokens, err = s.UserAccessTokens(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, id2, tokens[0].ID)
+}
+
+func testAccessTokensRollback(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	kept, err := s.SaveAccessToken(ctx, newAccessToken("kept", 1, 0))
+	require.NoError(t, err)
+
+	errRollback := errors.New("rollback")
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		_, err := s.SaveAccessToken(ctx, newAccessToken("new", 1, 10))
+		require.NoError(t, err)
+		require.NoError(t, s.TouchAccessToken(ctx, kept, tokenBase.Add(time.Hour)))
+		require.NoError(t, s.RevokeAccessToken(ctx, 1, kept, tokenBase.Add(time.Hour)))
+
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	tokens, err := s.UserAccessTokens(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, kept, tokens[0].ID)
+	assert.True(t, tokens[0].LastUsedAt.IsZero())
+
+	_, err = s.AccessTokenByHash(ctx, "new")
+	assert.ErrorIs(t, err, storage.ErrAccessTokenNotFound)
+}
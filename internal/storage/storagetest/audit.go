@@ -0,0 +1,147 @@
+package storagetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"testing"
+	"time"
+)
+
+// AuditLog is the audit storage of a backend
+type AuditLog interface {
+	SaveAuditEvent(ctx context.Context, event models.AuditEvent) error
+	AuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+	PruneAuditEvents(ctx context.Context, before time.Time) (deleted int64, err error)
+}
+
+func testAuditEvents(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	base := time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC)
+
+	full := models.AuditEvent{
+		Type:      models.AuditLoginFailed,
+		ActorID:   1,
+		UserID:    1,
+		Email:     "user@example.com",
+		AppID:     3,
+		Reason:    "invalid_credentials",
+		IP:        "192.0.2.1",
+		UserAgent: "grpc-go/1.65.0",
+		RequestID: "req-1",
+		CreatedAt: base.Add(500 * time.Millisecond),
+	}
+	require.NoError(t, s.SaveAuditEvent(ctx, full))
+
+	for i, e := range []models.AuditEvent{
+		{Type: models.AuditLogin, ActorID: 1, UserID: 1, AppID: 3},
+		{Type: models.AuditRegister, ActorID: 2, UserID: 2},
+		{Type: models.AuditAdminCheck, ActorID: 2, UserID: 1, AppID: 4},
+		{Type: models.AuditLogin, ActorID: 2, UserID: 2, AppID: 3},
+	} {
+		e.CreatedAt = base.Add(time.Duration(i+1) * time.Second)
+		require.NoError(t, s.SaveAuditEvent(ctx, e))
+	}
+
+	all, err := s.AuditEvents(ctx, models.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+
+	for i := 1; i < len(all); i++ {
+		assert.Greater(t, all[i-1].ID, all[i].ID, "newest first")
+	}
+
+	got := all[4]
+	assert.NotZero(t, got.ID)
+	assert.True(t, full.CreatedAt.Equal(got.CreatedAt), "created at %v, want %v", got.CreatedAt, full.CreatedAt)
+	got.ID, got.CreatedAt = 0, full.CreatedAt
+	assert.Equal(t, full, got)
+
+	types := func(events []models.AuditEvent) []string {
+		res := make([]string, len(events))
+		for i, e := range events {
+			res[i] = e.Type
+		}
+
+		return res
+	}
+
+	tests := []struct {
+		name   string
+		filter models.AuditFilter
+		want   []string
+	}{
+		{
+			name:   "actor",
+			filter: models.AuditFilter{ActorID: 2},
+			want:   []string{models.AuditLogin, models.AuditAdminCheck, models.AuditRegister},
+		},
+		{
+			name:   "user and app",
+			filter: models.AuditFilter{UserID: 1, AppID: 3},
+			want:   []string{models.AuditLogin, models.AuditLoginFailed},
+		},
+		{
+			name:   "types",
+			filter: models.AuditFilter{Types: []string{models.AuditRegister, models.AuditAdminCheck}},
+			want:   []string{models.AuditAdminCheck, models.AuditRegister},
+		},
+		{
+			name:   "time range",
+			filter: models.AuditFilter{Since: base.Add(time.Second), Until: base.Add(3 * time.Second)},
+			want:   []string{models.AuditRegister, models.AuditLogin},
+		},
+		{
+			name:   "since with fraction",
+			filter: models.AuditFilter{Since: base.Add(400 * time.Millisecond), Until: base.Add(time.Second)},
+			want:   []string{models.AuditLoginFailed},
+		},
+		{
+			name:   "page",
+			filter: models.AuditFilter{BeforeID: all[0].ID, Limit: 2},
+			want:   []string{models.AuditAdminCheck, models.AuditRegister},
+		},
+		{
+			name:   "nothing",
+			filter: models.AuditFilter{UserID: 42},
+			want:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := s.AuditEvents(ctx, tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, types(events))
+		})
+	}
+}
+
+func testPruneAuditEvents(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+
+	for _, age := range []time.Duration{48 * time.Hour, 25 * time.Hour, time.Hour, 0} {
+		require.NoError(t, s.SaveAuditEvent(ctx, models.AuditEvent{
+			Type:      models.AuditLogin,
+			CreatedAt: now.Add(-age),
+		}))
+	}
+
+	deleted, err := s.PruneAuditEvents(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	events, err := s.AuditEvents(ctx, models.AuditFilter{})
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	deleted, err = s.PruneAuditEvents(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
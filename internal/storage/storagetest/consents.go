@@ -0,0 +1,99 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage"
+	"testing"
+	"time"
+)
+
+// Consents are the scopes the users granted to the apps
+type Consents interface {
+	SaveConsent(ctx context.Context, consent models.Consent) error
+	Consent(ctx context.Context, userID int64, appID int) (models.Consent, error)
+	UserConsents(ctx context.Context, userID int64) ([]models.Consent, error)
+	DeleteConsent(ctx context.Context, userID int64, appID int) error
+}
+
+func testConsents(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	grantedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err := s.Consent(ctx, 1, 2)
+	assert.ErrorIs(t, err, storage.ErrConsentNotFound)
+
+	require.NoError(t, s.SaveConsent(ctx,
+		models.Consent{UserID: 1, AppID: 2, Scopes: []string{"profile", "email"}, GrantedAt: grantedAt}))
+	require.NoError(t, s.SaveConsent(ctx,
+		models.Consent{UserID: 1, AppID: 1, Scopes: []string{"profile"}, GrantedAt: grantedAt}))
+	require.NoError(t, s.SaveConsent(ctx,
+		models.Consent{UserID: 2, AppID: 2, Scopes: []string{"admin"}, GrantedAt: grantedAt}))
+
+	// Granting again adds the new scopes only
+	later := grantedAt.Add(time.Hour)
+	require.NoError(t, s.SaveConsent(ctx,
+		models.Consent{UserID: 1, AppID: 2, Scopes: []string{"email", "orders"}, GrantedAt: later}))
+
+	consent, err := s.Consent(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), consent.UserID)
+	assert.Equal(t, 2, consent.AppID)
+	assert.Equal(t, []string{"email", "orders", "profile"}, consent.Scopes)
+	assert.True(t, later.Equal(consent.GrantedAt), consent.GrantedAt)
+
+	consents, err := s.UserConsents(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, consents, 2)
+	assert.Equal(t, 1, consents[0].AppID)
+	assert.Equal(t, []string{"profile"}, consents[0].Scopes)
+	assert.True(t, grantedAt.Equal(consents[0].GrantedAt), consents[0].GrantedAt)
+	assert.Equal(t, 2, consents[1].AppID)
+
+	require.NoError(t, s.DeleteConsent(ctx, 1, 2))
+	assert.ErrorIs(t, s.DeleteConsent(ctx, 1, 2), storage.ErrConsentNotFound)
+
+	_, err = s.Consent(ctx, 1, 2)
+	assert.ErrorIs(t, err, storage.ErrConsentNotFound)
+
+	// The consents of the other user to the app stay
+	consent, err = s.Consent(ctx, 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, consent.Scopes)
+
+	consents, err = s.UserConsents(ctx, 3)
+	require.NoError(t, err)
+	assert.Empty(t, consents)
+}
+
+func testConsentsRollback(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	grantedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	require.NoError(t, s.SaveConsent(ctx,
+		models.Consent{UserID: 1, AppID: 1, Scopes: []string{"profile"}, GrantedAt: grantedAt}))
+
+	errRollback := errors.New("rollback")
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		require.NoError(t, s.SaveConsent(ctx,
+			models.Consent{UserID: 1, AppID: 2, Scopes: []string{"email"}, GrantedAt: grantedAt}))
+		require.NoError(t, s.DeleteConsent(ctx, 1, 1))
+
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	consents, err := s.UserConsents(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, consents, 1)
+	assert.Equal(t, 1, consents[0].AppID)
+	assert.Equal(t, []string{"profile"}, consents[0].Scopes)
+}
@@ -0,0 +1,303 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage"
+	"testing"
+)
+
+// Groups are the user groups and their roles of a backend
+type Groups interface {
+	SaveGroup(ctx context.Context, tenantID int64, name string) (int64, error)
+	Group(ctx context.Context, groupID int64) (models.Group, error)
+	Groups(ctx context.Context, tenantID int64) ([]models.Group, error)
+	DeleteGroup(ctx context.Context, groupID int64) error
+	AddGroupUser(ctx context.Context, groupID int64, userID int64) error
+	RemoveGroupUser(ctx context.Context, groupID int64, userID int64) error
+	AddSubgroup(ctx context.Context, groupID int64, subgroupID int64) error
+	RemoveSubgroup(ctx context.Context, groupID int64, subgroupID int64) error
+	GrantGroupRole(ctx context.Context, groupID int64, role models.GroupRole) error
+	RevokeGroupRole(ctx context.Context, groupID int64, role models.GroupRole) error
+	UserPermissions(ctx context.Context, userID int64, appID int) (models.Permissions, error)
+}
+
+// saveGroups saves the groups to the default tenant and returns their IDs by name
+func saveGroups(t *testing.T, s Storage, names ...string) map[string]int64 {
+	t.Helper()
+
+	ids := make(map[string]int64, len(names))
+
+	for _, name := range names {
+		id, err := s.SaveGroup(context.Background(), models.DefaultTenantID, name)
+		require.NoError(t, err)
+
+		ids[name] = id
+	}
+
+	return ids
+}
+
+func testGroups(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	fixtures.AddTenant(t, otherTenant)
+
+	ids := saveGroups(t, s, "engineering", "backend")
+
+	// The name is unique within the tenant only
+	_, err := s.SaveGroup(ctx, models.DefaultTenantID, "backend")
+	assert.ErrorIs(t, err, storage.ErrGroupExists)
+
+	otherID, err := s.SaveGroup(ctx, otherTenant.ID, "backend")
+	require.NoError(t, err)
+
+	groups, err := s.Groups(ctx, models.DefaultTenantID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Group{
+		{ID: ids["backend"], TenantID: models.DefaultTenantID, Name: "backend"},
+		{ID: ids["engineering"], TenantID: models.DefaultTenantID, Name: "engineering"},
+	}, groups)
+
+	groups, err = s.Groups(ctx, otherTenant.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Group{{ID: otherID, TenantID: otherTenant.ID, Name: "backend"}}, groups)
+
+	// Adding twice does nothing
+	for range 2 {
+		require.NoError(t, s.AddGroupUser(ctx, ids["engineering"], 7))
+		require.NoError(t, s.AddGroupUser(ctx, ids["engineering"], 3))
+		require.NoError(t, s.AddSubgroup(ctx, ids["engineering"], ids["backend"]))
+		require.NoError(t, s.GrantGroupRole(ctx, ids["engineering"], models.GroupRole{AppID: 2, Role: "viewer"}))
+		require.NoError(t, s.GrantGroupRole(ctx, ids["engineering"], models.GroupRole{AppID: 1, Role: "viewer"}))
+		require.NoError(t, s.GrantGroupRole(ctx, ids["engineering"], models.GroupRole{AppID: 1, Role: "editor"}))
+	}
+
+	group, err := s.Group(ctx, ids["engineering"])
+	require.NoError(t, err)
+	assert.Equal(t, models.Group{
+		ID:          ids["engineering"],
+		TenantID:    models.DefaultTenantID,
+		Name:        "engineering",
+		UserIDs:     []int64{3, 7},
+		SubgroupIDs: []int64{ids["backend"]},
+		Roles: []models.GroupRole{
+			{AppID: 1, Role: "editor"}, {AppID: 1, Role: "viewer"}, {AppID: 2, Role: "viewer"},
+		},
+	}, group)
+
+	// Removing twice does nothing
+	for range 2 {
+		require.NoError(t, s.RemoveGroupUser(ctx, ids["engineering"], 7))
+		require.NoError(t, s.RemoveSubgroup(ctx, ids["engineering"], ids["backend"]))
+		require.NoError(t, s.RevokeGroupRole(ctx, ids["engineering"], models.GroupRole{AppID: 1, Role: "viewer"}))
+	}
+
+	group, err = s.Group(ctx, ids["engineering"])
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3}, group.UserIDs)
+	assert.Empty(t, group.SubgroupIDs)
+	assert.Equal(t, []models.GroupRole{{AppID: 1, Role: "editor"}, {AppID: 2, Role: "viewer"}}, group.Roles)
+
+	_, err = s.Group(ctx, 404)
+	assert.ErrorIs(t, err, storage.ErrGroupNotFound)
+}
+
+func testDeleteGroup(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	ids := saveGroups(t, s, "parent", "group", "child")
+
+	require.NoError(t, s.AddSubgroup(ctx, ids["parent"], ids["group"]))
+	require.NoError(t, s.AddSubgroup(ctx, ids["group"], ids["child"]))
+	require.NoError(t, s.AddGroupUser(ctx, ids["group"], 1))
+	require.NoError(t, s.AddGroupUser(ctx, ids["child"], 2))
+	require.NoError(t, s.GrantGroupRole(ctx, ids["group"], models.GroupRole{AppID: 1, Role: "editor"}))
+
+	require.NoError(t, s.DeleteGroup(ctx, ids["group"]))
+	assert.ErrorIs(t, s.DeleteGroup(ctx, ids["group"]), storage.ErrGroupNotFound)
+
+	_, err := s.Group(ctx, ids["group"])
+	assert.ErrorIs(t, err, storage.ErrGroupNotFound)
+
+	// The edges of the deleted group are gone, its subgroups stay
+	parent, err := s.Group(ctx, ids["parent"])
+	require.NoError(t, err)
+	assert.Empty(t, parent.SubgroupIDs)
+
+	perms, err := s.UserPermissions(ctx, 1, 1)
+	require.NoError(t, err)
+	assert.Empty(t, perms.Groups)
+	assert.Empty(t, perms.Roles)
+
+	perms, err = s.UserPermissions(ctx, 2, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"child"}, perms.Groups)
+
+	// A group with the same name is a new one
+	id, err := s.SaveGroup(ctx, models.DefaultTenantID, "group")
+	require.NoError(t, err)
+
+	group, err := s.Group(ctx, id)
+	require.NoError(t, err)
+	assert.Empty(t, group.UserIDs)
+	assert.Empty(t, group.Roles)
+}
+
+func testGroupCycle(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	ids := saveGroups(t, s, "a", "b", "c", "d")
+
+	require.NoError(t, s.AddSubgroup(ctx, ids["a"], ids["b"]))
+	require.NoError(t, s.AddSubgroup(ctx, ids["b"], ids["c"]))
+
+	tests := []struct {
+		name     string
+		group    string
+		subgroup string
+		wantErr  error
+	}{
+		{name: "itself", group: "a", subgroup: "a", wantErr: storage.ErrGroupCycle},
+		{name: "direct", group: "b", subgroup: "a", wantErr: storage.ErrGroupCycle},
+		{name: "transitive", group: "c", subgroup: "a", wantErr: storage.ErrGroupCycle},
+		{name: "diamond", group: "a", subgroup: "c"},
+		{name: "unrelated", group: "c", subgroup: "d"},
+		{name: "through new edge", group: "d", subgroup: "b", wantErr: storage.ErrGroupCycle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.AddSubgroup(ctx, ids[tt.group], ids[tt.subgroup])
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+		})
+	}
+
+	// The rejected edges weren't saved
+	a, err := s.Group(ctx, ids["a"])
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int64{ids["b"], ids["c"]}, a.SubgroupIDs)
+}
+
+func testUserPermissions(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	// company > engineering > backend, company > sales; backend > oncall is nested in engineering twice
+	ids := saveGroups(t, s, "company", "engineering", "backend", "oncall", "sales")
+
+	for _, edge := range [][2]string{
+		{"company", "engineering"}, {"engineering", "backend"}, {"company", "sales"},
+		{"backend", "oncall"}, {"engineering", "oncall"},
+	} {
+		require.NoError(t, s.AddSubgroup(ctx, ids[edge[0]], ids[edge[1]]))
+	}
+
+	for _, grant := range []struct {
+		group string
+		role  models.GroupRole
+	}{
+		{"company", models.GroupRole{AppID: 1, Role: "viewer"}},
+		{"engineering", models.GroupRole{AppID: 1, Role: "editor"}},
+		{"backend", models.GroupRole{AppID: 1, Role: "editor"}},
+		{"oncall", models.GroupRole{AppID: 2, Role: "operator"}},
+		{"sales", models.GroupRole{AppID: 1, Role: "billing"}},
+	} {
+		require.NoError(t, s.GrantGroupRole(ctx, ids[grant.group], grant.role))
+	}
+
+	require.NoError(t, s.AddGroupUser(ctx, ids["oncall"], 1))
+	require.NoError(t, s.AddGroupUser(ctx, ids["sales"], 2))
+	require.NoError(t, s.AddGroupUser(ctx, ids["backend"], 2))
+
+	tests := []struct {
+		name   string
+		userID int64
+		appID  int
+		want   models.Permissions
+	}{
+		{
+			name:   "nested through several paths",
+			userID: 1,
+			appID:  1,
+			want: models.Permissions{
+				Groups: []string{"backend", "company", "engineering", "oncall"},
+				Roles:  []string{"editor", "viewer"},
+			},
+		},
+		{
+			name:   "roles of another app",
+			userID: 1,
+			appID:  2,
+			want: models.Permissions{
+				Groups: []string{"backend", "company", "engineering", "oncall"},
+				Roles:  []string{"operator"},
+			},
+		},
+		{
+			name:   "several direct groups",
+			userID: 2,
+			appID:  1,
+			want: models.Permissions{
+				Groups: []string{"backend", "company", "engineering", "sales"},
+				Roles:  []string{"billing", "editor", "viewer"},
+			},
+		},
+		{
+			name:   "no groups",
+			userID: 3,
+			appID:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perms, err := s.UserPermissions(ctx, tt.userID, tt.appID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.Groups, perms.Groups)
+			assert.Equal(t, tt.want.Roles, perms.Roles)
+		})
+	}
+}
+
+func testGroupsRollback(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	ids := saveGroups(t, s, "kept")
+	require.NoError(t, s.AddGroupUser(ctx, ids["kept"], 1))
+
+	errRollback := errors.New("rollback")
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		id, err := s.SaveGroup(ctx, models.DefaultTenantID, "new")
+		require.NoError(t, err)
+		require.NoError(t, s.AddSubgroup(ctx, ids["kept"], id))
+		require.NoError(t, s.RemoveGroupUser(ctx, ids["kept"], 1))
+		require.NoError(t, s.GrantGroupRole(ctx, ids["kept"], models.GroupRole{AppID: 1, Role: "editor"}))
+
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	groups, err := s.Groups(ctx, models.DefaultTenantID)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+
+	group, err := s.Group(ctx, ids["kept"])
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1}, group.UserIDs)
+	assert.Empty(t, group.SubgroupIDs)
+	assert.Empty(t, group.Roles)
+}
@@ -0,0 +1,140 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage"
+	"testing"
+	"time"
+)
+
+// Identities are the accounts at the upstream identity providers linked to the users of a backend
+type Identities interface {
+	SaveIdentity(ctx context.Context, identity models.Identity) (int64, error)
+	Identity(ctx context.Context, tenantID int64, provider string, subject string) (models.Identity, error)
+	UserIdentities(ctx context.Context, userID int64) ([]models.Identity, error)
+	DeleteIdentity(ctx context.Context, userID int64, provider string) error
+}
+
+var identityBase = time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// newIdentity returns the account of the user of the default tenant at the provider with the subject
+func newIdentity(userID int64, provider string, subject string) models.Identity {
+	return models.Identity{
+		TenantID:  models.DefaultTenantID,
+		UserID:    userID,
+		Provider:  provider,
+		Subject:   subject,
+		Email:     subject + "@" + provider + ".example.com",
+		CreatedAt: identityBase,
+	}
+}
+
+func testIdentities(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	saved := newIdentity(1, "google", "g1")
+
+	id1, err := s.SaveIdentity(ctx, saved)
+	require.NoError(t, err)
+	id2, err := s.SaveIdentity(ctx, newIdentity(1, "github", "g1"))
+	require.NoError(t, err)
+	_, err = s.SaveIdentity(ctx, newIdentity(2, "google", "g2"))
+	require.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+
+	got, err := s.Identity(ctx, models.DefaultTenantID, "google", "g1")
+	require.NoError(t, err)
+	assert.Equal(t, id1, got.ID)
+	assert.Equal(t, saved.TenantID, got.TenantID)
+	assert.Equal(t, saved.UserID, got.UserID)
+	assert.Equal(t, saved.Provider, got.Provider)
+	assert.Equal(t, saved.Subject, got.Subject)
+	assert.Equal(t, saved.Email, got.Email)
+	assert.True(t, saved.CreatedAt.Equal(got.CreatedAt), got.CreatedAt)
+
+	// The subjects are unique per provider only
+	got, err = s.Identity(ctx, models.DefaultTenantID, "github", "g1")
+	require.NoError(t, err)
+	assert.Equal(t, id2, got.ID)
+
+	_, err = s.Identity(ctx, models.DefaultTenantID, "google", "missing")
+	assert.ErrorIs(t, err, storage.ErrIdentityNotFound)
+
+	// An account is linked to one user of the tenant, and a user has one account per provider
+	_, err = s.SaveIdentity(ctx, newIdentity(3, "google", "g1"))
+	assert.ErrorIs(t, err, storage.ErrIdentityExists)
+
+	other := newIdentity(4, "google", "g1")
+	other.TenantID = 2
+
+	id4, err := s.SaveIdentity(ctx, other)
+	require.NoError(t, err)
+
+	got, err = s.Identity(ctx, 2, "google", "g1")
+	require.NoError(t, err)
+	assert.Equal(t, id4, got.ID)
+	assert.Equal(t, int64(4), got.UserID)
+
+	_, err = s.Identity(ctx, 3, "google", "g1")
+	assert.ErrorIs(t, err, storage.ErrIdentityNotFound)
+	_, err = s.SaveIdentity(ctx, newIdentity(1, "google", "g3"))
+	assert.ErrorIs(t, err, storage.ErrIdentityExists)
+
+	identities, err := s.UserIdentities(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, identities, 2)
+	assert.Equal(t, []string{"github", "google"}, []string{identities[0].Provider, identities[1].Provider})
+
+	identities, err = s.UserIdentities(ctx, 404)
+	require.NoError(t, err)
+	assert.Empty(t, identities)
+
+	// Only the user unlinks the account, once
+	assert.ErrorIs(t, s.DeleteIdentity(ctx, 2, "github"), storage.ErrIdentityNotFound)
+	require.NoError(t, s.DeleteIdentity(ctx, 1, "github"))
+	assert.ErrorIs(t, s.DeleteIdentity(ctx, 1, "github"), storage.ErrIdentityNotFound)
+
+	_, err = s.Identity(ctx, models.DefaultTenantID, "github", "g1")
+	assert.ErrorIs(t, err, storage.ErrIdentityNotFound)
+
+	// and the account may be linked again, to another user too
+	_, err = s.SaveIdentity(ctx, newIdentity(3, "github", "g1"))
+	require.NoError(t, err)
+
+	identities, err = s.UserIdentities(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, id1, identities[0].ID)
+}
+
+func testIdentitiesRollback(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	_, err := s.SaveIdentity(ctx, newIdentity(1, "google", "kept"))
+	require.NoError(t, err)
+
+	errRollback := errors.New("rollback")
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		_, err := s.SaveIdentity(ctx, newIdentity(1, "github", "new"))
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteIdentity(ctx, 1, "google"))
+
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	identities, err := s.UserIdentities(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "kept", identities[0].Subject)
+
+	_, err = s.Identity(ctx, models.DefaultTenantID, "github", "new")
+	assert.ErrorIs(t, err, storage.ErrIdentityNotFound)
+}
@@ -0,0 +1,40 @@
+package storagetest
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Migrate applies the up migrations from dir in version order
+func Migrate(t testing.TB, db *sql.DB, dir string) {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files, "no migrations in %s", dir)
+
+	sort.Slice(files, func(i, j int) bool {
+		return version(files[i]) < version(files[j])
+	})
+
+	for _, file := range files {
+		query, err := os.ReadFile(file)
+		require.NoError(t, err)
+
+		_, err = db.Exec(string(query))
+		require.NoError(t, err, "migration %s", filepath.Base(file))
+	}
+}
+
+func version(file string) int {
+	prefix, _, _ := strings.Cut(filepath.Base(file), "_")
+	v, _ := strconv.Atoi(prefix)
+
+	return v
+}
@@ -0,0 +1,304 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"testing"
+	"time"
+)
+
+// Outbox is the domain event outbox of a backend
+type Outbox interface {
+	EnqueueEvent(ctx context.Context, event models.OutboxEvent) error
+	ClaimEvents(ctx context.Context, now, leaseUntil time.Time, limit int) ([]models.OutboxEvent, error)
+	MarkEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error
+	RetryEvent(ctx context.Context, id int64, lastErr string, nextAttemptAt time.Time) error
+	DeadLetterEvent(ctx context.Context, id int64, lastErr string) error
+	OutboxEvents(ctx context.Context, filter models.OutboxFilter) ([]models.OutboxEvent, error)
+	ReplayEvents(ctx context.Context, filter models.ReplayFilter, now time.Time) (replayed int64, err error)
+	PruneDeliveredEvents(ctx context.Context, before time.Time) (deleted int64, err error)
+}
+
+var outboxBase = time.Date(2024, 5, 14, 10, 0, 0, 0, time.UTC)
+
+// addWebhookApps adds two apps with webhooks and one without
+func addWebhookApps(t *testing.T, fixtures Fixtures) {
+	fixtures.AddApp(t, models.App{ID: 1, Name: "first", Secret: "s1", WebhookURL: "http://first", WebhookSecret: "w1"})
+	fixtures.AddApp(t, models.App{ID: 2, Name: "second", Secret: "s2", WebhookURL: "http://second", WebhookSecret: "w2"})
+	fixtures.AddApp(t, models.App{ID: 3, Name: "no-webhook", Secret: "s3"})
+}
+
+func newOutboxEvent(eventID string, createdAt time.Time) models.OutboxEvent {
+	return models.OutboxEvent{
+		EventID:   eventID,
+		Type:      models.EventUserRegistered,
+		TenantID:  models.DefaultTenantID,
+		UserID:    42,
+		Payload:   []byte(`{"id":"` + eventID + `"}`),
+		CreatedAt: createdAt,
+	}
+}
+
+func testEnqueueEvent(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	// Without apps with webhooks there is nobody to deliver to
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-0", outboxBase)))
+
+	events, err := s.OutboxEvents(ctx, models.OutboxFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	addWebhookApps(t, fixtures)
+
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-1", outboxBase.Add(500*time.Millisecond))))
+
+	events, err = s.OutboxEvents(ctx, models.OutboxFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	appIDs := []int{events[0].AppID, events[1].AppID}
+	assert.ElementsMatch(t, []int{1, 2}, appIDs)
+	assert.Greater(t, events[0].ID, events[1].ID)
+
+	for _, e := range events {
+		assert.Equal(t, "ev-1", e.EventID)
+		assert.Equal(t, models.EventUserRegistered, e.Type)
+		assert.Equal(t, int64(42), e.UserID)
+		assert.Equal(t, `{"id":"ev-1"}`, string(e.Payload))
+		assert.Equal(t, models.OutboxPending, e.Status)
+		assert.Zero(t, e.Attempts)
+		assert.True(t, outboxBase.Add(500*time.Millisecond).Equal(e.CreatedAt), e.CreatedAt)
+		assert.True(t, e.CreatedAt.Equal(e.NextAttemptAt))
+		assert.True(t, e.DeliveredAt.IsZero())
+	}
+
+	events, err = s.OutboxEvents(ctx, models.OutboxFilter{AppID: 2})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, 2, events[0].AppID)
+
+	// An event of one app is enqueued only for it, if it has a webhook
+	for _, appID := range []int{2, 3} {
+		event := newOutboxEvent(fmt.Sprintf("ev-app-%d", appID), outboxBase.Add(time.Second))
+		event.AppID = appID
+		require.NoError(t, s.EnqueueEvent(ctx, event))
+	}
+
+	events, err = s.OutboxEvents(ctx, models.OutboxFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "ev-app-2", events[0].EventID)
+	assert.Equal(t, 2, events[0].AppID)
+}
+
+func testEnqueueEventRollback(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	addWebhookApps(t, fixtures)
+
+	errAbort := errors.New("abort")
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", []byte("hash")); err != nil {
+			return err
+		}
+
+		if err := s.EnqueueEvent(ctx, newOutboxEvent("ev-1", outboxBase)); err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+
+	events, err := s.OutboxEvents(ctx, models.OutboxFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, events, "the event of a rolled back change is delivered")
+}
+
+func testClaimEvents(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	addWebhookApps(t, fixtures)
+
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-1", outboxBase)))
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-2", outboxBase.Add(time.Minute))))
+
+	// Nothing is due yet
+	events, err := s.ClaimEvents(ctx, outboxBase.Add(-time.Second), outboxBase.Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	now := outboxBase.Add(2 * time.Minute)
+	lease := now.Add(time.Minute)
+
+	first, err := s.ClaimEvents(ctx, now, lease, 3)
+	require.NoError(t, err)
+	require.Len(t, first, 3)
+	assert.Less(t, first[0].ID, first[1].ID, "the oldest first")
+	assert.Equal(t, "ev-1", first[0].EventID)
+
+	second, err := s.ClaimEvents(ctx, now, lease, 3)
+	require.NoError(t, err)
+	require.Len(t, second, 1, "leased events are claimed twice")
+	assert.NotContains(t, []int64{first[0].ID, first[1].ID, first[2].ID}, second[0].ID)
+
+	third, err := s.ClaimEvents(ctx, now, lease, 3)
+	require.NoError(t, err)
+	assert.Empty(t, third)
+
+	// The lease of an event that was not marked expires
+	expired, err := s.ClaimEvents(ctx, lease, lease.Add(time.Minute), 10)
+	require.NoError(t, err)
+	assert.Len(t, expired, 4)
+}
+
+func testEventDelivery(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	addWebhookApps(t, fixtures)
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-1", outboxBase)))
+
+	claimed, err := s.ClaimEvents(ctx, outboxBase, outboxBase.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+
+	delivered, failing := claimed[0], claimed[1]
+	deliveredAt := outboxBase.Add(2 * time.Second)
+
+	require.NoError(t, s.MarkEventDelivered(ctx, delivered.ID, deliveredAt))
+
+	next := outboxBase.Add(10 * time.Second)
+	require.NoError(t, s.RetryEvent(ctx, failing.ID, "status 500", next))
+
+	// Retried events are due at the next attempt, not at the end of the lease
+	retried, err := s.ClaimEvents(ctx, next, next.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, retried, 1)
+	assert.Equal(t, failing.ID, retried[0].ID)
+	assert.Equal(t, 1, retried[0].Attempts)
+	assert.Equal(t, "status 500", retried[0].LastError)
+
+	require.NoError(t, s.DeadLetterEvent(ctx, failing.ID, "status 503"))
+
+	events, err := s.OutboxEvents(ctx, models.OutboxFilter{Status: models.OutboxDelivered})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, delivered.ID, events[0].ID)
+	assert.Equal(t, 1, events[0].Attempts)
+	assert.True(t, deliveredAt.Equal(events[0].DeliveredAt), events[0].DeliveredAt)
+
+	events, err = s.OutboxEvents(ctx, models.OutboxFilter{Status: models.OutboxDead})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, failing.ID, events[0].ID)
+	assert.Equal(t, 2, events[0].Attempts)
+	assert.Equal(t, "status 503", events[0].LastError)
+
+	// Neither delivered nor dead events are claimed
+	claimed, err = s.ClaimEvents(ctx, outboxBase.Add(time.Hour), outboxBase.Add(2*time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+func testReplayEvents(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	addWebhookApps(t, fixtures)
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-1", outboxBase)))
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-2", outboxBase)))
+
+	claimed, err := s.ClaimEvents(ctx, outboxBase, outboxBase.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 4)
+
+	// Both events of the first app are dead, the second app got both
+	for _, e := range claimed {
+		if e.AppID == 1 {
+			require.NoError(t, s.DeadLetterEvent(ctx, e.ID, "status 500"))
+		} else {
+			require.NoError(t, s.MarkEventDelivered(ctx, e.ID, outboxBase))
+		}
+	}
+
+	now := outboxBase.Add(time.Hour)
+
+	replayed, err := s.ReplayEvents(ctx, models.ReplayFilter{AppID: 2}, now)
+	require.NoError(t, err)
+	assert.Zero(t, replayed, "delivered events are replayed without IDs")
+
+	replayed, err = s.ReplayEvents(ctx, models.ReplayFilter{}, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), replayed)
+
+	pending, err := s.OutboxEvents(ctx, models.OutboxFilter{Status: models.OutboxPending})
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+
+	for _, e := range pending {
+		assert.Equal(t, 1, e.AppID)
+		assert.Zero(t, e.Attempts)
+		assert.Empty(t, e.LastError)
+		assert.True(t, now.Equal(e.NextAttemptAt), e.NextAttemptAt)
+	}
+
+	// A delivered event is replayed by ID
+	delivered, err := s.OutboxEvents(ctx, models.OutboxFilter{Status: models.OutboxDelivered, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, delivered, 1)
+
+	replayed, err = s.ReplayEvents(ctx, models.ReplayFilter{IDs: []int64{delivered[0].ID, 1_000_000}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), replayed)
+
+	replayed, err = s.ReplayEvents(ctx, models.ReplayFilter{IDs: []int64{delivered[0].ID}, AppID: 1}, now)
+	require.NoError(t, err)
+	assert.Zero(t, replayed, "the event of another app is replayed")
+
+	claimed, err = s.ClaimEvents(ctx, now, now.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 3)
+
+	for _, e := range claimed {
+		assert.True(t, e.DeliveredAt.IsZero())
+	}
+}
+
+func testPruneDeliveredEvents(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	addWebhookApps(t, fixtures)
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-1", outboxBase)))
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-2", outboxBase)))
+
+	claimed, err := s.ClaimEvents(ctx, outboxBase, outboxBase.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 4)
+
+	require.NoError(t, s.MarkEventDelivered(ctx, claimed[0].ID, outboxBase.Add(time.Hour)))
+	require.NoError(t, s.MarkEventDelivered(ctx, claimed[1].ID, outboxBase.Add(3*time.Hour)))
+	require.NoError(t, s.DeadLetterEvent(ctx, claimed[2].ID, "status 500"))
+
+	deleted, err := s.PruneDeliveredEvents(ctx, outboxBase.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	events, err := s.OutboxEvents(ctx, models.OutboxFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	for _, e := range events {
+		assert.NotEqual(t, claimed[0].ID, e.ID)
+	}
+}
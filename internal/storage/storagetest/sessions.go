@@ -0,0 +1,194 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage"
+	"testing"
+	"time"
+)
+
+// Sessions are the login sessions of a backend
+type Sessions interface {
+	SaveSession(ctx context.Context, session models.Session) error
+	Session(ctx context.Context, id string) (models.Session, error)
+	UserSessions(ctx context.Context, userID int64, now time.Time) ([]models.Session, error)
+	TouchSession(ctx context.Context, id string, lastUsedAt time.Time) error
+	RevokeSession(ctx context.Context, id string, revokedAt time.Time) error
+	RevokeUserSessions(ctx context.Context, userID int64, exceptID string, revokedAt time.Time) ([]models.Session, error)
+	PruneSessions(ctx context.Context, before time.Time) (deleted int64, err error)
+}
+
+var sessionBase = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// newSession returns the session of the user created minutes after sessionBase for an hour
+func newSession(id string, userID int64, minutes int) models.Session {
+	createdAt := sessionBase.Add(time.Duration(minutes) * time.Minute)
+
+	return models.Session{
+		ID:         id,
+		UserID:     userID,
+		AppID:      1,
+		IP:         "192.0.2.1",
+		UserAgent:  "test/1.0",
+		CreatedAt:  createdAt,
+		LastUsedAt: createdAt,
+		ExpiresAt:  createdAt.Add(time.Hour),
+	}
+}
+
+func sessionIDs(sessions []models.Session) []string {
+	ids := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		ids = append(ids, s.ID)
+	}
+
+	return ids
+}
+
+func testSessions(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	saved := newSession("s1", 1, 0)
+	require.NoError(t, s.SaveSession(ctx, saved))
+	require.NoError(t, s.SaveSession(ctx, newSession("s2", 1, 10)))
+	require.NoError(t, s.SaveSession(ctx, newSession("s3", 2, 20)))
+
+	got, err := s.Session(ctx, "s1")
+	require.NoError(t, err)
+	assert.Equal(t, saved.ID, got.ID)
+	assert.Equal(t, saved.UserID, got.UserID)
+	assert.Equal(t, saved.AppID, got.AppID)
+	assert.Equal(t, saved.IP, got.IP)
+	assert.Equal(t, saved.UserAgent, got.UserAgent)
+	assert.True(t, saved.CreatedAt.Equal(got.CreatedAt), got.CreatedAt)
+	assert.True(t, saved.LastUsedAt.Equal(got.LastUsedAt), got.LastUsedAt)
+	assert.True(t, saved.ExpiresAt.Equal(got.ExpiresAt), got.ExpiresAt)
+	assert.True(t, got.RevokedAt.IsZero())
+
+	_, err = s.Session(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+	active, err := s.UserSessions(ctx, 1, sessionBase.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s2", "s1"}, sessionIDs(active), "the newest first")
+
+	// s1 expires an hour after its creation
+	active, err = s.UserSessions(ctx, 1, sessionBase.Add(65*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s2"}, sessionIDs(active))
+
+	t.Run("touch", func(t *testing.T) {
+		usedAt := sessionBase.Add(5 * time.Minute)
+		require.NoError(t, s.TouchSession(ctx, "s1", usedAt))
+
+		// An older time does not move it back
+		require.NoError(t, s.TouchSession(ctx, "s1", sessionBase.Add(time.Minute)))
+		require.NoError(t, s.TouchSession(ctx, "missing", usedAt))
+
+		got, err := s.Session(ctx, "s1")
+		require.NoError(t, err)
+		assert.True(t, usedAt.Equal(got.LastUsedAt), got.LastUsedAt)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		revokedAt := sessionBase.Add(30 * time.Minute)
+		require.NoError(t, s.RevokeSession(ctx, "s2", revokedAt))
+
+		err := s.RevokeSession(ctx, "s2", revokedAt)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound, "revoked twice")
+		assert.ErrorIs(t, s.RevokeSession(ctx, "missing", revokedAt), storage.ErrSessionNotFound)
+
+		got, err := s.Session(ctx, "s2")
+		require.NoError(t, err)
+		assert.True(t, revokedAt.Equal(got.RevokedAt), got.RevokedAt)
+		assert.False(t, got.Active(revokedAt))
+
+		active, err := s.UserSessions(ctx, 1, revokedAt)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"s1"}, sessionIDs(active))
+	})
+}
+
+func testRevokeUserSessions(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	for i, id := range []string{"s1", "s2", "s3", "s4"} {
+		require.NoError(t, s.SaveSession(ctx, newSession(id, 1, i)))
+	}
+	require.NoError(t, s.SaveSession(ctx, newSession("other", 2, 0)))
+	require.NoError(t, s.RevokeSession(ctx, "s1", sessionBase.Add(10*time.Minute)))
+
+	revokedAt := sessionBase.Add(20 * time.Minute)
+
+	revoked, err := s.RevokeUserSessions(ctx, 1, "s4", revokedAt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s3", "s2"}, sessionIDs(revoked))
+
+	for _, session := range revoked {
+		assert.Equal(t, int64(1), session.UserID)
+		assert.True(t, revokedAt.Equal(session.RevokedAt), session.RevokedAt)
+	}
+
+	active, err := s.UserSessions(ctx, 1, revokedAt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s4"}, sessionIDs(active))
+
+	active, err = s.UserSessions(ctx, 2, revokedAt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"other"}, sessionIDs(active))
+
+	// A rolled back revocation leaves the sessions active
+	errRollback := errors.New("rollback")
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		revoked, err := s.RevokeUserSessions(ctx, 1, "", revokedAt)
+		if err != nil {
+			return err
+		}
+
+		assert.Equal(t, []string{"s4"}, sessionIDs(revoked))
+
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	active, err = s.UserSessions(ctx, 1, revokedAt)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"s4"}, sessionIDs(active))
+
+	revoked, err = s.RevokeUserSessions(ctx, 3, "", revokedAt)
+	require.NoError(t, err)
+	assert.Empty(t, revoked)
+}
+
+func testPruneSessions(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	// expired expires at +1h, revoked is revoked at +10m, active expires at +2h
+	require.NoError(t, s.SaveSession(ctx, newSession("expired", 1, 0)))
+	require.NoError(t, s.SaveSession(ctx, newSession("revoked", 1, 1)))
+	require.NoError(t, s.SaveSession(ctx, newSession("active", 1, 60)))
+	require.NoError(t, s.RevokeSession(ctx, "revoked", sessionBase.Add(10*time.Minute)))
+
+	deleted, err := s.PruneSessions(ctx, sessionBase.Add(5*time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	deleted, err = s.PruneSessions(ctx, sessionBase.Add(90*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	for _, id := range []string{"expired", "revoked"} {
+		_, err = s.Session(ctx, id)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound, id)
+	}
+
+	_, err = s.Session(ctx, "active")
+	assert.NoError(t, err)
+}
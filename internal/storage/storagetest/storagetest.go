@@ -0,0 +1,561 @@
+// Package storagetest is the conformance suite every storage backend has to pass
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/services/auth"
+	"grpc-sso/internal/storage"
+	"sync"
+	"testing"
+)
+
+// concurrency is the number of goroutines in the concurrent tests
+const concurrency = 20
+
+// Storage is what the app needs from a backend
+type Storage interface {
+	auth.UserSaver
+	auth.UserProvider
+	auth.AppProvider
+	storage.Transactor
+	AuditLog
+	Outbox
+	UserEvents
+	Sessions
+	Groups
+	Consents
+	AccessTokens
+	Identities
+	Ping(ctx context.Context) error
+	SigningKeys(ctx context.Context) (count int, err error)
+}
+
+// Fixtures seeds the data there is no storage method for.
+// Apps without a tenant are added to the default one
+type Fixtures interface {
+	AddApp(t testing.TB, app models.App)
+	AddTenant(t testing.TB, tenant models.Tenant)
+	MakeAdmin(t testing.TB, userID int64)
+}
+
+// Factory returns a backend with a fresh, migrated database
+type Factory func(t *testing.T) (Storage, Fixtures)
+
+// Run runs the suite against the backend
+func Run(t *testing.T, newStorage Factory) {
+	t.Run("SaveUser", func(t *testing.T) { testSaveUser(t, newStorage) })
+	t.Run("User", func(t *testing.T) { testUser(t, newStorage) })
+	t.Run("UserByID", func(t *testing.T) { testUserByID(t, newStorage) })
+	t.Run("UpdateEmail", func(t *testing.T) { testUpdateEmail(t, newStorage) })
+	t.Run("DisableUser", func(t *testing.T) { testDisableUser(t, newStorage) })
+	t.Run("UpdatePassword", func(t *testing.T) { testUpdatePassword(t, newStorage) })
+	t.Run("IsAdmin", func(t *testing.T) { testIsAdmin(t, newStorage) })
+	t.Run("App", func(t *testing.T) { testApp(t, newStorage) })
+	t.Run("Ping", func(t *testing.T) { testPing(t, newStorage) })
+	t.Run("SigningKeys", func(t *testing.T) { testSigningKeys(t, newStorage) })
+	t.Run("CanceledContext", func(t *testing.T) { testCanceledContext(t, newStorage) })
+	t.Run("ConcurrentSaveUser", func(t *testing.T) { testConcurrentSaveUser(t, newStorage) })
+	t.Run("ConcurrentSaveSameEmail", func(t *testing.T) { testConcurrentSaveSameEmail(t, newStorage) })
+	t.Run("TxCommit", func(t *testing.T) { testTxCommit(t, newStorage) })
+	t.Run("TxRollback", func(t *testing.T) { testTxRollback(t, newStorage) })
+	t.Run("TxRollbackOnPanic", func(t *testing.T) { testTxRollbackOnPanic(t, newStorage) })
+	t.Run("TxNested", func(t *testing.T) { testTxNested(t, newStorage) })
+	t.Run("ConcurrentTx", func(t *testing.T) { testConcurrentTx(t, newStorage) })
+	t.Run("AuditEvents", func(t *testing.T) { testAuditEvents(t, newStorage) })
+	t.Run("PruneAuditEvents", func(t *testing.T) { testPruneAuditEvents(t, newStorage) })
+	t.Run("EnqueueEvent", func(t *testing.T) { testEnqueueEvent(t, newStorage) })
+	t.Run("EnqueueEventRollback", func(t *testing.T) { testEnqueueEventRollback(t, newStorage) })
+	t.Run("ClaimEvents", func(t *testing.T) { testClaimEvents(t, newStorage) })
+	t.Run("EventDelivery", func(t *testing.T) { testEventDelivery(t, newStorage) })
+	t.Run("ReplayEvents", func(t *testing.T) { testReplayEvents(t, newStorage) })
+	t.Run("PruneDeliveredEvents", func(t *testing.T) { testPruneDeliveredEvents(t, newStorage) })
+	t.Run("UserEvents", func(t *testing.T) { testUserEvents(t, newStorage) })
+	t.Run("AppendUserEventRollback", func(t *testing.T) { testAppendUserEventRollback(t, newStorage) })
+	t.Run("PruneUserEvents", func(t *testing.T) { testPruneUserEvents(t, newStorage) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newStorage) })
+	t.Run("RevokeUserSessions", func(t *testing.T) { testRevokeUserSessions(t, newStorage) })
+	t.Run("PruneSessions", func(t *testing.T) { testPruneSessions(t, newStorage) })
+	t.Run("Tenant", func(t *testing.T) { testTenant(t, newStorage) })
+	t.Run("TenantUsers", func(t *testing.T) { testTenantUsers(t, newStorage) })
+	t.Run("TenantEvents", func(t *testing.T) { testTenantEvents(t, newStorage) })
+	t.Run("Groups", func(t *testing.T) { testGroups(t, newStorage) })
+	t.Run("DeleteGroup", func(t *testing.T) { testDeleteGroup(t, newStorage) })
+	t.Run("GroupCycle", func(t *testing.T) { testGroupCycle(t, newStorage) })
+	t.Run("UserPermissions", func(t *testing.T) { testUserPermissions(t, newStorage) })
+	t.Run("GroupsRollback", func(t *testing.T) { testGroupsRollback(t, newStorage) })
+	t.Run("Consents", func(t *testing.T) { testConsents(t, newStorage) })
+	t.Run("ConsentsRollback", func(t *testing.T) { testConsentsRollback(t, newStorage) })
+	t.Run("AccessTokens", func(t *testing.T) { testAccessTokens(t, newStorage) })
+	t.Run("AccessTokensRollback", func(t *testing.T) { testAccessTokensRollback(t, newStorage) })
+	t.Run("Identities", func(t *testing.T) { testIdentities(t, newStorage) })
+	t.Run("IdentitiesRollback", func(t *testing.T) { testIdentitiesRollback(t, newStorage) })
+}
+
+func testSaveUser(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	first, err := s.SaveUser(ctx, models.DefaultTenantID, "first@example.com", []byte("hash"))
+	require.NoError(t, err)
+	assert.NotEqual(t, models.EmptyUserID, first)
+
+	second, err := s.SaveUser(ctx, models.DefaultTenantID, "second@example.com", []byte("hash"))
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	id, err := s.SaveUser(ctx, models.DefaultTenantID, "first@example.com", []byte("other"))
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+	assert.Equal(t, models.EmptyUserID, id)
+}
+
+func testUser(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	passHash := []byte{0x00, 0x24, 0x32, 0x61, 0xff}
+
+	id, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", passHash)
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, models.DefaultTenantID, "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, models.User{ID: id, TenantID: models.DefaultTenantID, Email: "user@example.com", PassHash: passHash}, user)
+
+	_, err = s.User(ctx, models.DefaultTenantID, "missing@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testUserByID(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	passHash := []byte("hash")
+
+	id, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", passHash)
+	require.NoError(t, err)
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.User{ID: id, TenantID: models.DefaultTenantID, Email: "user@example.com", PassHash: passHash}, user)
+
+	_, err = s.UserByID(ctx, id+1)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testUpdateEmail(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, models.DefaultTenantID, "old@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	otherID, err := s.SaveUser(ctx, models.DefaultTenantID, "other@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdateEmail(ctx, id, "new@example.com"))
+
+	user, err := s.User(ctx, models.DefaultTenantID, "new@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, id, user.ID)
+
+	_, err = s.User(ctx, models.DefaultTenantID, "old@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	// The email is free again
+	_, err = s.SaveUser(ctx, models.DefaultTenantID, "old@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	// Setting the same email is not a conflict
+	require.NoError(t, s.UpdateEmail(ctx, id, "new@example.com"))
+
+	err = s.UpdateEmail(ctx, otherID, "new@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	err = s.UpdateEmail(ctx, id+otherID+100, "missing@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testDisableUser(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, models.DefaultTenantID, "user@example.com")
+	require.NoError(t, err)
+	assert.False(t, user.Disabled)
+
+	require.NoError(t, s.DisableUser(ctx, id))
+	require.NoError(t, s.DisableUser(ctx, id), "disabling twice")
+
+	user, err = s.User(ctx, models.DefaultTenantID, "user@example.com")
+	require.NoError(t, err)
+	assert.True(t, user.Disabled)
+
+	user, err = s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, user.Disabled)
+
+	err = s.DisableUser(ctx, id+1)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testUpdatePassword(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	id, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", []byte("old-hash"))
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdatePassword(ctx, id, []byte("new-hash")))
+
+	user, err := s.UserByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new-hash"), user.PassHash)
+
+	err = s.UpdatePassword(ctx, id+1, []byte("hash"))
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testIsAdmin(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	userID, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	adminID, err := s.SaveUser(ctx, models.DefaultTenantID, "admin@example.com", []byte("hash"))
+	require.NoError(t, err)
+	fixtures.MakeAdmin(t, adminID)
+
+	isAdmin, err := s.IsAdmin(ctx, userID)
+	require.NoError(t, err)
+	assert.False(t, isAdmin)
+
+	isAdmin, err = s.IsAdmin(ctx, adminID)
+	require.NoError(t, err)
+	assert.True(t, isAdmin)
+
+	_, err = s.IsAdmin(ctx, adminID+userID+1)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testApp(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	want := models.App{
+		ID:            7,
+		TenantID:      models.DefaultTenantID,
+		Name:          "test",
+		Secret:        "test_secret",
+		WebhookURL:    "https://example.com/hooks/sso",
+		WebhookSecret: "hook_secret",
+		Scopes:        []string{"email", "profile"},
+
+		ExchangeAudiences: []int{8, 9},
+	}
+	fixtures.AddApp(t, want)
+
+	app, err := s.App(ctx, want.ID)
+	require.NoError(t, err)
+	assert.Equal(t, want, app)
+
+	_, err = s.App(ctx, 8)
+	assert.ErrorIs(t, err, storage.ErrAppNotFound)
+}
+
+func testPing(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+
+	assert.NoError(t, s.Ping(context.Background()))
+}
+
+func testSigningKeys(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	count, err := s.SigningKeys(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	fixtures.AddApp(t, models.App{ID: 1, Name: "first", Secret: "first_secret"})
+	fixtures.AddApp(t, models.App{ID: 2, Name: "second", Secret: ""})
+
+	count, err = s.SigningKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func testCanceledContext(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+
+	userID, err := s.SaveUser(context.Background(), models.DefaultTenantID, "user@example.com", []byte("hash"))
+	require.NoError(t, err)
+	fixtures.AddApp(t, models.App{ID: 1, Name: "test", Secret: "test_secret"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = s.SaveUser(ctx, models.DefaultTenantID, "other@example.com", []byte("hash"))
+	assert.ErrorIs(t, err, context.Canceled, "SaveUser")
+
+	_, err = s.User(ctx, models.DefaultTenantID, "user@example.com")
+	assert.ErrorIs(t, err, context.Canceled, "User")
+
+	_, err = s.IsAdmin(ctx, userID)
+	assert.ErrorIs(t, err, context.Canceled, "IsAdmin")
+
+	_, err = s.App(ctx, 1)
+	assert.ErrorIs(t, err, context.Canceled, "App")
+
+	// The canceled write must not be applied
+	_, err = s.User(context.Background(), models.DefaultTenantID, "other@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testConcurrentSaveUser(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	ids := make([]int64, concurrency)
+	errs := make([]error, concurrency)
+
+	var wg sync.WaitGroup
+	for i := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i], errs[i] = s.SaveUser(ctx, models.DefaultTenantID, fmt.Sprintf("user%d@example.com", i), []byte("hash"))
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool)
+	for i := range concurrency {
+		require.NoError(t, errs[i])
+		assert.False(t, seen[ids[i]], "duplicate user id %d", ids[i])
+		seen[ids[i]] = true
+
+		user, err := s.User(ctx, models.DefaultTenantID, fmt.Sprintf("user%d@example.com", i))
+		require.NoError(t, err)
+		assert.Equal(t, ids[i], user.ID)
+	}
+}
+
+func testConcurrentSaveSameEmail(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	errs := make([]error, concurrency)
+	ids := make([]int64, concurrency)
+
+	var wg sync.WaitGroup
+	for i := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i], errs[i] = s.SaveUser(ctx, models.DefaultTenantID, "same@example.com", []byte(fmt.Sprintf("hash%d", i)))
+		}()
+	}
+	wg.Wait()
+
+	var winner int
+	saved := 0
+	for i, err := range errs {
+		if err == nil {
+			winner = i
+			saved++
+
+			continue
+		}
+
+		assert.ErrorIs(t, err, storage.ErrUserExists)
+	}
+	require.Equal(t, 1, saved, "exactly one of the concurrent writes must succeed")
+
+	user, err := s.User(ctx, models.DefaultTenantID, "same@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, ids[winner], user.ID)
+	assert.Equal(t, []byte(fmt.Sprintf("hash%d", winner)), user.PassHash)
+}
+
+var errAbort = errors.New("abort")
+
+func testTxCommit(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	var firstID int64
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+
+		firstID, err = s.SaveUser(ctx, models.DefaultTenantID, "first@example.com", []byte("hash"))
+		if err != nil {
+			return err
+		}
+
+		// The transaction sees its own writes
+		user, err := s.User(ctx, models.DefaultTenantID, "first@example.com")
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, firstID, user.ID)
+
+		_, err = s.SaveUser(ctx, models.DefaultTenantID, "second@example.com", []byte("hash"))
+
+		return err
+	})
+	require.NoError(t, err)
+
+	user, err := s.User(ctx, models.DefaultTenantID, "first@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, firstID, user.ID)
+
+	_, err = s.User(ctx, models.DefaultTenantID, "second@example.com")
+	assert.NoError(t, err)
+}
+
+func testTxRollback(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	_, err := s.SaveUser(ctx, models.DefaultTenantID, "existing@example.com", []byte("hash"))
+	require.NoError(t, err)
+
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.SaveUser(ctx, models.DefaultTenantID, "first@example.com", []byte("hash")); err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+
+	_, err = s.User(ctx, models.DefaultTenantID, "first@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	// A failed write rolls back the writes before it
+	err = s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.SaveUser(ctx, models.DefaultTenantID, "second@example.com", []byte("hash")); err != nil {
+			return err
+		}
+
+		_, err := s.SaveUser(ctx, models.DefaultTenantID, "existing@example.com", []byte("hash"))
+
+		return err
+	})
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	_, err = s.User(ctx, models.DefaultTenantID, "second@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	// The storage is usable after the rollbacks
+	_, err = s.SaveUser(ctx, models.DefaultTenantID, "first@example.com", []byte("hash"))
+	assert.NoError(t, err)
+}
+
+func testTxRollbackOnPanic(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = s.WithTx(ctx, func(ctx context.Context) error {
+			if _, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", []byte("hash")); err != nil {
+				return err
+			}
+
+			panic("boom")
+		})
+	})
+
+	_, err := s.User(ctx, models.DefaultTenantID, "user@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testTxNested(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.SaveUser(ctx, models.DefaultTenantID, "outer@example.com", []byte("hash")); err != nil {
+			return err
+		}
+
+		// The inner call joins the outer transaction
+		err := s.WithTx(ctx, func(ctx context.Context) error {
+			_, err := s.SaveUser(ctx, models.DefaultTenantID, "inner@example.com", []byte("hash"))
+
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	assert.ErrorIs(t, err, errAbort)
+
+	_, err = s.User(ctx, models.DefaultTenantID, "outer@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	_, err = s.User(ctx, models.DefaultTenantID, "inner@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testConcurrentTx(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	errs := make([]error, concurrency)
+
+	var wg sync.WaitGroup
+	for i := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.WithTx(ctx, func(ctx context.Context) error {
+				// Read before write, the pattern that deadlocks with lock upgrades
+				if _, err := s.User(ctx, models.DefaultTenantID, fmt.Sprintf("a%d@example.com", i)); !errors.Is(err, storage.ErrUserNotFound) {
+					return fmt.Errorf("unexpected lookup result: %w", err)
+				}
+
+				if _, err := s.SaveUser(ctx, models.DefaultTenantID, fmt.Sprintf("a%d@example.com", i), []byte("hash")); err != nil {
+					return err
+				}
+
+				if i%2 == 0 {
+					return errAbort
+				}
+
+				_, err := s.SaveUser(ctx, models.DefaultTenantID, fmt.Sprintf("b%d@example.com", i), []byte("hash"))
+
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+
+	for i := range concurrency {
+		_, errA := s.User(ctx, models.DefaultTenantID, fmt.Sprintf("a%d@example.com", i))
+		_, errB := s.User(ctx, models.DefaultTenantID, fmt.Sprintf("b%d@example.com", i))
+
+		if i%2 == 0 {
+			assert.ErrorIs(t, errs[i], errAbort)
+			assert.ErrorIs(t, errA, storage.ErrUserNotFound)
+			assert.ErrorIs(t, errB, storage.ErrUserNotFound)
+
+			continue
+		}
+
+		assert.NoError(t, errs[i])
+		assert.NoError(t, errA)
+		assert.NoError(t, errB)
+	}
+}
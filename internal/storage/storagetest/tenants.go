@@ -0,0 +1,153 @@
+package storagetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/storage"
+	"testing"
+	"time"
+)
+
+// otherTenant is added by the tenant tests next to the default one
+var otherTenant = models.Tenant{ID: 2, Name: "other"}
+
+func testTenant(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	// The default tenant is always there
+	tenant, err := s.Tenant(ctx, models.DefaultTenantID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Tenant{ID: models.DefaultTenantID, Name: "default"}, tenant)
+
+	_, err = s.Tenant(ctx, otherTenant.ID)
+	assert.ErrorIs(t, err, storage.ErrTenantNotFound)
+
+	fixtures.AddTenant(t, otherTenant)
+
+	tenant, err = s.Tenant(ctx, otherTenant.ID)
+	require.NoError(t, err)
+	assert.Equal(t, otherTenant, tenant)
+}
+
+func testTenantUsers(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	fixtures.AddTenant(t, otherTenant)
+
+	firstID, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", []byte("first"))
+	require.NoError(t, err)
+
+	// The email is unique within the tenant only
+	otherID, err := s.SaveUser(ctx, otherTenant.ID, "user@example.com", []byte("other"))
+	require.NoError(t, err)
+	assert.NotEqual(t, firstID, otherID)
+
+	_, err = s.SaveUser(ctx, otherTenant.ID, "user@example.com", []byte("again"))
+	assert.ErrorIs(t, err, storage.ErrUserExists)
+
+	user, err := s.User(ctx, otherTenant.ID, "user@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, otherID, user.ID)
+	assert.Equal(t, otherTenant.ID, user.TenantID)
+	assert.Equal(t, []byte("other"), user.PassHash)
+
+	user, err = s.UserByID(ctx, firstID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DefaultTenantID, user.TenantID)
+
+	_, err = s.User(ctx, otherTenant.ID, "missing@example.com")
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+	// The email taken in another tenant is free in this one
+	_, err = s.SaveUser(ctx, models.DefaultTenantID, "taken@example.com", []byte("hash"))
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateEmail(ctx, otherID, "taken@example.com"))
+	assert.ErrorIs(t, s.UpdateEmail(ctx, firstID, "taken@example.com"), storage.ErrUserExists)
+
+	user, err = s.User(ctx, otherTenant.ID, "taken@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, otherID, user.ID)
+}
+
+func testTenantEvents(t *testing.T, newStorage Factory) {
+	s, fixtures := newStorage(t)
+	ctx := context.Background()
+
+	fixtures.AddTenant(t, otherTenant)
+	addWebhookApps(t, fixtures)
+	fixtures.AddApp(t, models.App{
+		ID: 4, TenantID: otherTenant.ID, Name: "other", Secret: "s4", WebhookURL: "http://other", WebhookSecret: "w4",
+	})
+
+	app, err := s.App(ctx, 4)
+	require.NoError(t, err)
+	assert.Equal(t, otherTenant.ID, app.TenantID)
+
+	// The events reach the apps of their tenant only
+	event := newOutboxEvent("ev-other", outboxBase)
+	event.TenantID = otherTenant.ID
+	require.NoError(t, s.EnqueueEvent(ctx, event))
+	require.NoError(t, s.EnqueueEvent(ctx, newOutboxEvent("ev-default", outboxBase)))
+
+	events, err := s.OutboxEvents(ctx, models.OutboxFilter{TenantID: otherTenant.ID})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "ev-other", events[0].EventID)
+	assert.Equal(t, 4, events[0].AppID)
+	assert.Equal(t, otherTenant.ID, events[0].TenantID)
+
+	events, err = s.OutboxEvents(ctx, models.OutboxFilter{TenantID: models.DefaultTenantID})
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	// An app of another tenant gets nothing even if named
+	event = newOutboxEvent("ev-misrouted", outboxBase)
+	event.AppID = 4
+	require.NoError(t, s.EnqueueEvent(ctx, event))
+
+	events, err = s.OutboxEvents(ctx, models.OutboxFilter{AppID: 4})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+
+	claimed, err := s.ClaimEvents(ctx, outboxBase, outboxBase.Add(time.Minute), 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 3)
+
+	for _, e := range claimed {
+		require.NoError(t, s.DeadLetterEvent(ctx, e.ID, "status 500"))
+	}
+
+	replayed, err := s.ReplayEvents(ctx, models.ReplayFilter{TenantID: otherTenant.ID}, outboxBase.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), replayed)
+
+	for _, e := range []models.AuditEvent{
+		{Type: models.AuditLogin, TenantID: models.DefaultTenantID, UserID: 1, CreatedAt: outboxBase},
+		{Type: models.AuditLogin, TenantID: otherTenant.ID, UserID: 2, CreatedAt: outboxBase},
+	} {
+		require.NoError(t, s.SaveAuditEvent(ctx, e))
+	}
+
+	audit, err := s.AuditEvents(ctx, models.AuditFilter{TenantID: otherTenant.ID})
+	require.NoError(t, err)
+	require.Len(t, audit, 1)
+	assert.Equal(t, int64(2), audit[0].UserID)
+	assert.Equal(t, otherTenant.ID, audit[0].TenantID)
+
+	for _, e := range []models.UserEvent{
+		{Type: models.EventUserRegistered, TenantID: models.DefaultTenantID, UserID: 1, CreatedAt: outboxBase},
+		{Type: models.EventUserRegistered, TenantID: otherTenant.ID, UserID: 2, CreatedAt: outboxBase},
+	} {
+		require.NoError(t, s.AppendUserEvent(ctx, e))
+	}
+
+	userEvents, err := s.UserEvents(ctx, models.UserEventFilter{TenantID: otherTenant.ID})
+	require.NoError(t, err)
+	require.Len(t, userEvents, 1)
+	assert.Equal(t, int64(2), userEvents[0].UserID)
+	assert.Equal(t, otherTenant.ID, userEvents[0].TenantID)
+}
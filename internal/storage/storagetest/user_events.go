@@ -0,0 +1,157 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"grpc-sso/internal/domain/models"
+	"testing"
+	"time"
+)
+
+// UserEvents is the user events log of a backend
+type UserEvents interface {
+	AppendUserEvent(ctx context.Context, event models.UserEvent) error
+	UserEvents(ctx context.Context, filter models.UserEventFilter) ([]models.UserEvent, error)
+	LastUserEventID(ctx context.Context) (id int64, err error)
+	PruneUserEvents(ctx context.Context, before time.Time) (deleted int64, err error)
+}
+
+func testUserEvents(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	last, err := s.LastUserEventID(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, last)
+
+	appended := []models.UserEvent{
+		{Type: models.EventUserRegistered, UserID: 1, Email: "first@example.com"},
+		{Type: models.EventTokenRevoked, UserID: 1, Email: "first@example.com", AppID: 1},
+		{Type: models.EventTokenRevoked, UserID: 2, Email: "second@example.com", AppID: 2},
+		{Type: models.EventUserDisabled, UserID: 2, Email: "second@example.com"},
+	}
+
+	for i, e := range appended {
+		e.CreatedAt = outboxBase.Add(time.Duration(i) * time.Second)
+		require.NoError(t, s.AppendUserEvent(ctx, e))
+	}
+
+	all, err := s.UserEvents(ctx, models.UserEventFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, len(appended))
+
+	for i, e := range all {
+		assert.Equal(t, appended[i].Type, e.Type)
+		assert.Equal(t, appended[i].UserID, e.UserID)
+		assert.Equal(t, appended[i].Email, e.Email)
+		assert.Equal(t, appended[i].AppID, e.AppID)
+		assert.True(t, outboxBase.Add(time.Duration(i)*time.Second).Equal(e.CreatedAt), e.CreatedAt)
+
+		if i > 0 {
+			assert.Greater(t, e.ID, all[i-1].ID, "the oldest first")
+		}
+	}
+
+	last, err = s.LastUserEventID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, all[3].ID, last)
+
+	tests := []struct {
+		name   string
+		filter models.UserEventFilter
+		want   []int
+	}{
+		{name: "after", filter: models.UserEventFilter{AfterID: all[1].ID}, want: []int{2, 3}},
+		{name: "after the last", filter: models.UserEventFilter{AfterID: last}},
+		{name: "app and every app", filter: models.UserEventFilter{AppID: 1}, want: []int{0, 1, 3}},
+		{name: "types", filter: models.UserEventFilter{
+			Types: []string{models.EventUserRegistered, models.EventUserDisabled},
+		}, want: []int{0, 3}},
+		{name: "limit", filter: models.UserEventFilter{AfterID: all[0].ID, Limit: 2}, want: []int{1, 2}},
+		{name: "combined", filter: models.UserEventFilter{
+			AfterID: all[0].ID,
+			AppID:   2,
+			Types:   []string{models.EventTokenRevoked},
+		}, want: []int{2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := s.UserEvents(ctx, test.filter)
+			require.NoError(t, err)
+
+			var ids []int64
+			for _, e := range events {
+				ids = append(ids, e.ID)
+			}
+
+			var want []int64
+			for _, i := range test.want {
+				want = append(want, all[i].ID)
+			}
+
+			assert.Equal(t, want, ids)
+		})
+	}
+}
+
+func testAppendUserEventRollback(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	errAbort := errors.New("abort")
+
+	err := s.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.SaveUser(ctx, models.DefaultTenantID, "user@example.com", []byte("hash")); err != nil {
+			return err
+		}
+
+		if err := s.AppendUserEvent(ctx, models.UserEvent{Type: models.EventUserRegistered, CreatedAt: outboxBase}); err != nil {
+			return err
+		}
+
+		return errAbort
+	})
+	require.ErrorIs(t, err, errAbort)
+
+	events, err := s.UserEvents(ctx, models.UserEventFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, events, "event of a rolled back change")
+}
+
+func testPruneUserEvents(t *testing.T, newStorage Factory) {
+	s, _ := newStorage(t)
+	ctx := context.Background()
+
+	for i := range 3 {
+		require.NoError(t, s.AppendUserEvent(ctx, models.UserEvent{
+			Type:      models.EventUserRegistered,
+			UserID:    int64(i + 1),
+			CreatedAt: outboxBase.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	deleted, err := s.PruneUserEvents(ctx, outboxBase.Add(90*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	events, err := s.UserEvents(ctx, models.UserEventFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, int64(3), events[0].UserID)
+
+	// IDs are not reused after pruning
+	last := events[0].ID
+
+	deleted, err = s.PruneUserEvents(ctx, outboxBase.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+
+	require.NoError(t, s.AppendUserEvent(ctx, models.UserEvent{Type: models.EventUserRegistered, CreatedAt: outboxBase}))
+
+	events, err = s.UserEvents(ctx, models.UserEventFilter{AfterID: last})
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
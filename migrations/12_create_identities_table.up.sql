@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS identities
+(
+    id         INTEGER PRIMARY KEY,
+    tenant_id  INTEGER   NOT NULL,
+    user_id    INTEGER   NOT NULL,
+    provider   TEXT      NOT NULL,
+    subject    TEXT      NOT NULL,
+    email      TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_identities_tenant_provider_subject ON identities (tenant_id, provider, subject);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_identities_user_id_provider ON identities (user_id, provider);
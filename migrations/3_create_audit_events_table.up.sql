@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS audit_events
+(
+    id         INTEGER PRIMARY KEY,
+    type       TEXT      NOT NULL,
+    actor_id   INTEGER   NOT NULL DEFAULT 0,
+    user_id    INTEGER   NOT NULL DEFAULT 0,
+    email      TEXT      NOT NULL DEFAULT '',
+    app_id     INTEGER   NOT NULL DEFAULT 0,
+    reason     TEXT      NOT NULL DEFAULT '',
+    ip         TEXT      NOT NULL DEFAULT '',
+    user_agent TEXT      NOT NULL DEFAULT '',
+    request_id TEXT      NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events (user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id);
@@ -0,0 +1,23 @@
+ALTER TABLE users
+    ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE apps
+    ADD COLUMN webhook_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE apps
+    ADD COLUMN webhook_secret TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS outbox_events
+(
+    id              INTEGER PRIMARY KEY,
+    event_id        TEXT      NOT NULL,
+    type            TEXT      NOT NULL,
+    app_id          INTEGER   NOT NULL,
+    user_id         INTEGER   NOT NULL DEFAULT 0,
+    payload         BLOB      NOT NULL,
+    status          TEXT      NOT NULL DEFAULT 'pending',
+    attempts        INTEGER   NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error      TEXT      NOT NULL DEFAULT '',
+    created_at      TIMESTAMP NOT NULL,
+    delivered_at    TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_due ON outbox_events (status, next_attempt_at);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS user_events
+(
+    id         INTEGER PRIMARY KEY,
+    type       TEXT      NOT NULL,
+    user_id    INTEGER   NOT NULL DEFAULT 0,
+    email      TEXT      NOT NULL DEFAULT '',
+    app_id     INTEGER   NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_user_events_created_at ON user_events (created_at);
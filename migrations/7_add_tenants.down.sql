@@ -0,0 +1,31 @@
+ALTER TABLE user_events
+    DROP COLUMN tenant_id;
+
+ALTER TABLE outbox_events
+    DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS idx_audit_events_tenant_id;
+ALTER TABLE audit_events
+    DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS idx_apps_tenant_id;
+ALTER TABLE apps
+    DROP COLUMN tenant_id;
+
+CREATE TABLE users_old
+(
+    id        INTEGER PRIMARY KEY,
+    email     TEXT    NOT NULL UNIQUE,
+    pass_hash BLOB    NOT NULL,
+    is_admin  BOOLEAN NOT NULL DEFAULT FALSE,
+    disabled  BOOLEAN NOT NULL DEFAULT FALSE
+);
+INSERT INTO users_old (id, email, pass_hash, is_admin, disabled)
+SELECT id, email, pass_hash, is_admin, disabled
+FROM users;
+DROP TABLE users;
+ALTER TABLE users_old
+    RENAME TO users;
+CREATE INDEX IF NOT EXISTS idx_email ON users (email);
+
+DROP TABLE IF EXISTS tenants;
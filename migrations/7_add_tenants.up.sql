@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS tenants
+(
+    id   INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+INSERT INTO tenants (id, name)
+VALUES (1, 'default');
+
+-- The email is unique within the tenant, SQLite can't drop the column constraint, so the table is rebuilt
+CREATE TABLE users_new
+(
+    id        INTEGER PRIMARY KEY,
+    tenant_id INTEGER NOT NULL DEFAULT 1,
+    email     TEXT    NOT NULL,
+    pass_hash BLOB    NOT NULL,
+    is_admin  BOOLEAN NOT NULL DEFAULT FALSE,
+    disabled  BOOLEAN NOT NULL DEFAULT FALSE
+);
+INSERT INTO users_new (id, email, pass_hash, is_admin, disabled)
+SELECT id, email, pass_hash, is_admin, disabled
+FROM users;
+DROP TABLE users;
+ALTER TABLE users_new
+    RENAME TO users;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email ON users (tenant_id, email);
+
+ALTER TABLE apps
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_apps_tenant_id ON apps (tenant_id);
+
+ALTER TABLE audit_events
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_audit_events_tenant_id ON audit_events (tenant_id);
+
+ALTER TABLE outbox_events
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE user_events
+    ADD COLUMN tenant_id INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS user_groups
+(
+    id        INTEGER PRIMARY KEY,
+    tenant_id INTEGER NOT NULL,
+    name      TEXT    NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_groups_tenant_name ON user_groups (tenant_id, name);
+
+CREATE TABLE IF NOT EXISTS group_members
+(
+    group_id INTEGER NOT NULL,
+    user_id  INTEGER NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_group_members_user_id ON group_members (user_id);
+
+CREATE TABLE IF NOT EXISTS group_subgroups
+(
+    group_id    INTEGER NOT NULL,
+    subgroup_id INTEGER NOT NULL,
+    PRIMARY KEY (group_id, subgroup_id)
+);
+CREATE INDEX IF NOT EXISTS idx_group_subgroups_subgroup_id ON group_subgroups (subgroup_id);
+
+CREATE TABLE IF NOT EXISTS group_roles
+(
+    group_id INTEGER NOT NULL,
+    app_id   INTEGER NOT NULL,
+    role     TEXT    NOT NULL,
+    PRIMARY KEY (group_id, app_id, role)
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS access_tokens
+(
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT      NOT NULL,
+    app_id       INTEGER     NOT NULL,
+    name         TEXT        NOT NULL,
+    hint         TEXT        NOT NULL,
+    hash         TEXT        NOT NULL UNIQUE,
+    scopes       TEXT        NOT NULL DEFAULT '',
+    expires_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_access_tokens_user_id ON access_tokens (user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE apps
+    ADD COLUMN exchange_audiences TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS outbox_events;
+
+ALTER TABLE apps
+    DROP COLUMN webhook_secret;
+ALTER TABLE apps
+    DROP COLUMN webhook_url;
+ALTER TABLE users
+    DROP COLUMN disabled;
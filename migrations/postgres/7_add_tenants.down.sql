@@ -0,0 +1,21 @@
+ALTER TABLE user_events
+    DROP COLUMN tenant_id;
+
+ALTER TABLE outbox_events
+    DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS idx_audit_events_tenant_id;
+ALTER TABLE audit_events
+    DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS idx_apps_tenant_id;
+ALTER TABLE apps
+    DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS idx_users_tenant_email;
+ALTER TABLE users
+    DROP COLUMN tenant_id;
+ALTER TABLE users
+    ADD CONSTRAINT users_email_key UNIQUE (email);
+
+DROP TABLE IF EXISTS tenants;
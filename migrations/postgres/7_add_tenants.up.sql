@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS tenants
+(
+    id   BIGINT PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+INSERT INTO tenants (id, name)
+VALUES (1, 'default');
+
+-- The email is unique within the tenant
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users
+    ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email ON users (tenant_id, email);
+
+ALTER TABLE apps
+    ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_apps_tenant_id ON apps (tenant_id);
+
+ALTER TABLE audit_events
+    ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS idx_audit_events_tenant_id ON audit_events (tenant_id);
+
+ALTER TABLE outbox_events
+    ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1;
+
+ALTER TABLE user_events
+    ADD COLUMN tenant_id BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS consents;
+
+ALTER TABLE apps
+    DROP COLUMN scopes;
@@ -0,0 +1,11 @@
+ALTER TABLE apps
+    ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS consents
+(
+    user_id    BIGINT      NOT NULL,
+    app_id     INTEGER     NOT NULL,
+    scope      TEXT        NOT NULL,
+    granted_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, app_id, scope)
+);
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+	"time"
+)
+
+func TestAccessTokens_AsBearer(t *testing.T) {
+	ctx, st := suite.New(t, withScopes)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	userCtx := suite.AuthContext(ctx, login.GetToken())
+
+	// The scopes of the token must be granted to the app first
+	request := &sso.CreateAccessTokenRequest{
+		Name:      "ci",
+		Scopes:    []string{"email"},
+		ExpiresAt: timestamppb.New(time.Now().Add(time.Hour)),
+	}
+
+	_, err = st.AuthClient.CreateAccessToken(userCtx, request)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email: email, Password: pass, AppId: appID, Scopes: []string{"email"}, GrantConsent: true,
+	})
+	require.NoError(t, err)
+
+	created, err := st.AuthClient.CreateAccessToken(userCtx, request)
+	require.NoError(t, err)
+	assert.Regexp(t, `^sso_pat_[0-9a-f]{48}$`, created.GetToken())
+	assert.Equal(t, int32(appID), created.GetAccessToken().GetAppId())
+
+	// The access token authenticates the calls like the login token
+	patCtx := suite.AuthContext(ctx, created.GetToken())
+
+	tokens, err := st.AuthClient.ListAccessTokens(patCtx, &sso.ListAccessTokensRequest{})
+	require.NoError(t, err)
+	require.Len(t, tokens.GetAccessTokens(), 1)
+	assert.Equal(t, "ci", tokens.GetAccessTokens()[0].GetName())
+	assert.Equal(t, created.GetToken()[:12], tokens.GetAccessTokens()[0].GetHint())
+	assert.NotNil(t, tokens.GetAccessTokens()[0].GetLastUsedAt())
+
+	// but doesn't create more of them
+	_, err = st.AuthClient.CreateAccessToken(patCtx, &sso.CreateAccessTokenRequest{Name: "more"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+
+	_, err = st.AuthClient.RevokeAccessToken(userCtx,
+		&sso.RevokeAccessTokenRequest{Id: created.GetAccessToken().GetId()})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ListAccessTokens(patCtx, &sso.ListAccessTokensRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+}
+
+func TestAccessTokens_FailCases(t *testing.T) {
+	ctx, st := suite.New(t, withScopes)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	userCtx := suite.AuthContext(ctx, login.GetToken())
+
+	tests := []struct {
+		name     string
+		req      *sso.CreateAccessTokenRequest
+		wantCode codes.Code
+	}{
+		{name: "no name", req: &sso.CreateAccessTokenRequest{}, wantCode: codes.InvalidArgument},
+		{
+			name:     "undeclared scope",
+			req:      &sso.CreateAccessTokenRequest{Name: "ci", Scopes: []string{"admin"}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "unknown app",
+			req:      &sso.CreateAccessTokenRequest{Name: "ci", AppId: 404},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "in the past",
+			req:      &sso.CreateAccessTokenRequest{Name: "ci", ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour))},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := st.AuthClient.CreateAccessToken(userCtx, test.req)
+			assert.Equal(t, test.wantCode, status.Code(err), err)
+		})
+	}
+
+	_, err = st.AuthClient.RevokeAccessToken(userCtx, &sso.RevokeAccessTokenRequest{Id: 404})
+	assert.Equal(t, codes.NotFound, status.Code(err), err)
+
+	_, err = st.AuthClient.ListAccessTokens(suite.AuthContext(ctx, "sso_pat_unknown"), &sso.ListAccessTokensRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+}
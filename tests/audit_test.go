@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"context"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+)
+
+func TestListAuditEvents_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	registerResponse, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+	userID := registerResponse.GetUserId()
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: "wrong-password", AppId: appID})
+	require.Error(t, err)
+
+	adminCtx, adminID := st.AdminContext(ctx)
+
+	resp, err := st.AuthClient.ListAuditEvents(adminCtx, &sso.ListAuditEventsRequest{UserId: userID})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEvents(), 3)
+	assert.Empty(t, resp.GetNextPageToken())
+
+	failed, login, register := resp.GetEvents()[0], resp.GetEvents()[1], resp.GetEvents()[2]
+	assert.Equal(t, models.AuditLoginFailed, failed.GetType())
+	assert.Equal(t, models.AuditReasonWrongPassword, failed.GetReason())
+	assert.Equal(t, models.AuditLogin, login.GetType())
+	assert.Equal(t, int32(appID), login.GetAppId())
+	assert.Equal(t, models.AuditRegister, register.GetType())
+	assert.Equal(t, email, register.GetEmail())
+
+	for _, e := range resp.GetEvents() {
+		assert.NotEmpty(t, e.GetRequestId())
+		assert.NotNil(t, e.GetCreatedAt())
+	}
+
+	// The listing itself is recorded
+	resp, err = st.AuthClient.ListAuditEvents(adminCtx, &sso.ListAuditEventsRequest{
+		ActorId: adminID,
+		Types:   []string{models.AuditAdminAction},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.GetEvents())
+	assert.Equal(t, "list_audit_events", resp.GetEvents()[0].GetReason())
+}
+
+func TestListAuditEvents_Paging(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	for range 5 {
+		_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+		require.NoError(t, err)
+	}
+
+	adminCtx, _ := st.AdminContext(ctx)
+
+	var (
+		ids       []int64
+		pageToken string
+	)
+	for {
+		resp, err := st.AuthClient.ListAuditEvents(adminCtx, &sso.ListAuditEventsRequest{
+			Types:     []string{models.AuditLogin},
+			PageSize:  2,
+			PageToken: pageToken,
+		})
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(resp.GetEvents()), 2)
+
+		for _, e := range resp.GetEvents() {
+			ids = append(ids, e.GetId())
+		}
+
+		pageToken = resp.GetNextPageToken()
+		if pageToken == "" {
+			break
+		}
+	}
+
+	// 5 user logins and the admin login
+	require.Len(t, ids, 6)
+	for i := 1; i < len(ids); i++ {
+		assert.Greater(t, ids[i-1], ids[i])
+	}
+}
+
+func TestListAuditEvents_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	adminCtx, _ := st.AdminContext(ctx)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		req      *sso.ListAuditEventsRequest
+		wantCode codes.Code
+	}{
+		{
+			name:     "Without token",
+			ctx:      ctx,
+			req:      &sso.ListAuditEventsRequest{},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "Invalid token",
+			ctx:      suite.AuthContext(ctx, "forged"),
+			req:      &sso.ListAuditEventsRequest{},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "Not an admin",
+			ctx:      suite.AuthContext(ctx, login.GetToken()),
+			req:      &sso.ListAuditEventsRequest{},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "Invalid page token",
+			ctx:      adminCtx,
+			req:      &sso.ListAuditEventsRequest{PageToken: "not a token"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := st.AuthClient.ListAuditEvents(tt.ctx, tt.req)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+)
+
+// withScopes declares the email and profile scopes for the test app
+func withScopes(cfg *config.Config) {
+	cfg.Storage.Apps[0].Scopes = []string{"email", "profile"}
+}
+
+func TestConsents_GrantAndRevoke(t *testing.T) {
+	ctx, st := suite.New(t, withScopes)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	request := &sso.LoginRequest{Email: email, Password: pass, AppId: appID, Scopes: []string{"profile", "email"}}
+
+	_, err = st.AuthClient.Login(ctx, request)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), err)
+
+	request.GrantConsent = true
+	login, err := st.AuthClient.Login(ctx, request)
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(login.GetToken(), claims)
+	require.NoError(t, err)
+	assert.Equal(t, "email profile", claims["scope"])
+
+	userCtx := suite.AuthContext(ctx, login.GetToken())
+
+	consents, err := st.AuthClient.ListConsents(userCtx, &sso.ListConsentsRequest{})
+	require.NoError(t, err)
+	require.Len(t, consents.GetConsents(), 1)
+	assert.Equal(t, int32(appID), consents.GetConsents()[0].GetAppId())
+	assert.Equal(t, []string{"email", "profile"}, consents.GetConsents()[0].GetScopes())
+
+	_, err = st.AuthClient.RevokeConsent(userCtx, &sso.RevokeConsentRequest{AppId: appID})
+	require.NoError(t, err)
+
+	// The session of the token is revoked with the consent
+	_, err = st.AuthClient.ListConsents(userCtx, &sso.ListConsentsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+
+	request.GrantConsent = false
+	_, err = st.AuthClient.Login(ctx, request)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), err)
+}
+
+func TestConsents_FailCases(t *testing.T) {
+	ctx, st := suite.New(t, withScopes)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	userCtx := suite.AuthContext(ctx, login.GetToken())
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode codes.Code
+	}{
+		{
+			name: "undeclared scope",
+			call: func() error {
+				_, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+					Email: email, Password: pass, AppId: appID, Scopes: []string{"admin"}, GrantConsent: true,
+				})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "not authenticated",
+			call: func() error {
+				_, err := st.AuthClient.ListConsents(ctx, &sso.ListConsentsRequest{})
+				return err
+			},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name: "no consent",
+			call: func() error {
+				_, err := st.AuthClient.RevokeConsent(userCtx, &sso.RevokeConsentRequest{AppId: appID})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "no app",
+			call: func() error {
+				_, err := st.AuthClient.RevokeConsent(userCtx, &sso.RevokeConsentRequest{})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			assert.Equal(t, test.wantCode, status.Code(err), err)
+		})
+	}
+}
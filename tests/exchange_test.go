@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+)
+
+const (
+	backendAppID     = 2
+	backendAppSecret = "backend-secret"
+)
+
+// withBackend adds the backend app the test app may exchange the user tokens for
+func withBackend(cfg *config.Config) {
+	cfg.Storage.Apps[0].Scopes = []string{"email", "profile"}
+	cfg.Storage.Apps[0].ExchangeAudiences = []int{backendAppID}
+	cfg.Storage.Apps = append(cfg.Storage.Apps, config.AppConfig{
+		ID:     backendAppID,
+		Name:   "backend",
+		Secret: backendAppSecret,
+		Scopes: []string{"email"},
+	})
+}
+
+func TestExchangeToken(t *testing.T) {
+	ctx, st := suite.New(t, withBackend)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	registered, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:        email,
+		Password:     pass,
+		AppId:        appID,
+		Scopes:       []string{"email", "profile"},
+		GrantConsent: true,
+	})
+	require.NoError(t, err)
+
+	resp, err := st.AuthClient.ExchangeToken(ctx, &sso.ExchangeTokenRequest{
+		SubjectToken:     login.GetToken(),
+		SubjectTokenType: "urn:ietf:params:oauth:token-type:access_token",
+		AppId:            appID,
+		AppSecret:        suite.AppSecret,
+		Audience:         backendAppID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:access_token", resp.GetIssuedTokenType())
+	assert.Equal(t, "Bearer", resp.GetTokenType())
+	assert.Equal(t, []string{"email"}, resp.GetScopes())
+	assert.InDelta(t, st.Cfg.TokenTTL.Seconds(), resp.GetExpiresIn(), 5)
+
+	// The backend verifies the token with its own secret
+	token, err := jwt.Parse(resp.GetAccessToken(), func(token *jwt.Token) (any, error) {
+		return []byte(backendAppSecret), nil
+	})
+	require.NoError(t, err)
+
+	claims := token.Claims.(jwt.MapClaims)
+	assert.Equal(t, float64(registered.GetUserId()), claims["user_id"])
+	assert.Equal(t, float64(backendAppID), claims["app_id"])
+	assert.Equal(t, "email", claims["scope"])
+	assert.Equal(t, map[string]any{"app_id": float64(appID)}, claims["act"])
+
+	// The exchanged token works on behalf of the user, except for the sensitive operations
+	exchangedCtx := suite.AuthContext(ctx, resp.GetAccessToken())
+
+	_, err = st.AuthClient.ListSessions(exchangedCtx, &sso.ListSessionsRequest{})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.ChangePassword(exchangedCtx, &sso.ChangePasswordRequest{
+		OldPassword: pass,
+		NewPassword: randomFakePassword(),
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+}
+
+func TestExchangeToken_FailCases(t *testing.T) {
+	ctx, st := suite.New(t, withBackend)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	backendLogin, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: backendAppID})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		req      *sso.ExchangeTokenRequest
+		wantCode codes.Code
+	}{
+		{
+			name:     "no subject token",
+			req:      &sso.ExchangeTokenRequest{AppId: appID, AppSecret: suite.AppSecret, Audience: backendAppID},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "unsupported subject token type",
+			req: &sso.ExchangeTokenRequest{SubjectToken: login.GetToken(),
+				SubjectTokenType: "urn:ietf:params:oauth:token-type:id_token",
+				AppId:            appID, AppSecret: suite.AppSecret, Audience: backendAppID},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "wrong secret",
+			req: &sso.ExchangeTokenRequest{SubjectToken: login.GetToken(),
+				AppId: appID, AppSecret: backendAppSecret, Audience: backendAppID},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name: "subject token of another app",
+			req: &sso.ExchangeTokenRequest{SubjectToken: backendLogin.GetToken(),
+				AppId: appID, AppSecret: suite.AppSecret, Audience: backendAppID},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "audience not allowed",
+			req: &sso.ExchangeTokenRequest{SubjectToken: backendLogin.GetToken(),
+				AppId: backendAppID, AppSecret: backendAppSecret, Audience: appID},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "scope not in the subject token",
+			req: &sso.ExchangeTokenRequest{SubjectToken: login.GetToken(),
+				AppId: appID, AppSecret: suite.AppSecret, Audience: backendAppID, Scopes: []string{"email"}},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := st.AuthClient.ExchangeToken(ctx, test.req)
+			assert.Equal(t, test.wantCode, status.Code(err), err)
+		})
+	}
+}
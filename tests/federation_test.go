@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/lib/federation/federationtest"
+	"grpc-sso/tests/suite"
+	"testing"
+)
+
+// withIdP adds the mock IdP as the google provider signing up the new users
+func withIdP(idp *federationtest.IdP) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Federation.TicketKey = "federation-ticket-key-of-the-tests"
+		cfg.Federation.Providers = []config.FederationProviderConfig{{
+			Name:         "google",
+			Issuer:       idp.URL,
+			ClientID:     federationtest.ClientID,
+			ClientSecret: federationtest.ClientSecret,
+			RedirectURL:  "https://app.example.com/callback",
+			Signup:       true,
+		}}
+	}
+}
+
+func TestFederatedLogin(t *testing.T) {
+	idp := federationtest.New(t)
+	ctx, st := suite.New(t, withIdP(idp))
+
+	start, err := st.AuthClient.StartFederatedLogin(ctx, &sso.StartFederatedLoginRequest{
+		Provider: "google",
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	email := gofakeit.Email()
+	idp.SignIn(federationtest.Account{Subject: "g-1", Email: email, EmailVerified: true})
+	callback := idp.Authorize(t, start.GetAuthUrl())
+
+	complete := &sso.CompleteFederatedLoginRequest{
+		AppId:  appID,
+		Code:   callback.Get("code"),
+		State:  callback.Get("state"),
+		Ticket: start.GetTicket(),
+	}
+
+	login, err := st.AuthClient.CompleteFederatedLogin(ctx, complete)
+	require.NoError(t, err)
+	assert.True(t, login.GetCreated())
+	assert.NotEmpty(t, login.GetToken())
+
+	// The code is single use
+	_, err = st.AuthClient.CompleteFederatedLogin(ctx, complete)
+	require.Error(t, err)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	userCtx := suite.AuthContext(ctx, login.GetToken())
+
+	identities, err := st.AuthClient.ListIdentities(userCtx, &sso.ListIdentitiesRequest{})
+	require.NoError(t, err)
+	require.Len(t, identities.GetIdentities(), 1)
+	assert.Equal(t, "google", identities.GetIdentities()[0].GetProvider())
+	assert.Equal(t, email, identities.GetIdentities()[0].GetEmail())
+
+	// The provisioned user has no password
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: randomFakePassword(), AppId: appID})
+	require.Error(t, err)
+
+	_, err = st.AuthClient.UnlinkIdentity(userCtx, &sso.UnlinkIdentityRequest{Provider: "google"})
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestFederatedLogin_FailCases(t *testing.T) {
+	idp := federationtest.New(t)
+	ctx, st := suite.New(t, withIdP(idp))
+
+	_, err := st.AuthClient.StartFederatedLogin(ctx, &sso.StartFederatedLoginRequest{Provider: "facebook", AppId: appID})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.StartFederatedLogin(ctx, &sso.StartFederatedLoginRequest{
+		Provider: "google",
+		AppId:    appID,
+		Link:     true,
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	start, err := st.AuthClient.StartFederatedLogin(ctx, &sso.StartFederatedLoginRequest{
+		Provider: "google",
+		AppId:    appID,
+	})
+	require.NoError(t, err)
+
+	idp.SignIn(federationtest.Account{Subject: "g-1", Email: "unverified@example.com"})
+	callback := idp.Authorize(t, start.GetAuthUrl())
+
+	_, err = st.AuthClient.CompleteFederatedLogin(ctx, &sso.CompleteFederatedLoginRequest{
+		AppId:  appID,
+		Code:   callback.Get("code"),
+		State:  "forged",
+		Ticket: start.GetTicket(),
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = st.AuthClient.CompleteFederatedLogin(ctx, &sso.CompleteFederatedLoginRequest{
+		AppId:  appID,
+		Code:   callback.Get("code"),
+		State:  callback.Get("state"),
+		Ticket: start.GetTicket(),
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestFederatedLogin_Disabled(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, err := st.AuthClient.StartFederatedLogin(ctx, &sso.StartFederatedLoginRequest{Provider: "google", AppId: appID})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
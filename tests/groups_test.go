@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+)
+
+// withCache turns the cache on, so the group changes have to invalidate the cached permissions
+func withCache(cfg *config.Config) {
+	cfg.Storage.Cache.Enabled = true
+}
+
+func TestGroups_RolesInToken(t *testing.T) {
+	ctx, st := suite.New(t, withCache)
+	adminCtx, _ := st.AdminContext(ctx)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	user, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	engineering, err := st.AuthClient.CreateGroup(adminCtx, &sso.CreateGroupRequest{Name: "engineering"})
+	require.NoError(t, err)
+	backend, err := st.AuthClient.CreateGroup(adminCtx, &sso.CreateGroupRequest{Name: "backend"})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.AddGroupMember(adminCtx, &sso.GroupMemberRequest{
+		GroupId: engineering.GetGroupId(),
+		Member:  &sso.GroupMemberRequest_SubgroupId{SubgroupId: backend.GetGroupId()},
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.GrantGroupRole(adminCtx, &sso.GroupRoleRequest{
+		GroupId: engineering.GetGroupId(), AppId: appID, Role: "editor",
+	})
+	require.NoError(t, err)
+
+	// Warm the cache before the user joins
+	perms, err := st.AuthClient.GetUserPermissions(adminCtx,
+		&sso.GetUserPermissionsRequest{UserId: user.GetUserId(), AppId: appID})
+	require.NoError(t, err)
+	assert.Empty(t, perms.GetRoles())
+
+	_, err = st.AuthClient.AddGroupMember(adminCtx, &sso.GroupMemberRequest{
+		GroupId: backend.GetGroupId(),
+		Member:  &sso.GroupMemberRequest_UserId{UserId: user.GetUserId()},
+	})
+	require.NoError(t, err)
+
+	perms, err = st.AuthClient.GetUserPermissions(adminCtx,
+		&sso.GetUserPermissionsRequest{UserId: user.GetUserId(), AppId: appID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"backend", "engineering"}, perms.GetGroups())
+	assert.Equal(t, []string{"editor"}, perms.GetRoles())
+
+	login, err := st.AuthClient.Login(ctx,
+		&sso.LoginRequest{Email: email, Password: pass, AppId: appID, IncludeGroups: true})
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(login.GetToken(), claims)
+	require.NoError(t, err)
+	assert.Equal(t, []any{"editor"}, claims["roles"])
+	assert.Equal(t, []any{"backend", "engineering"}, claims["groups"])
+
+	group, err := st.AuthClient.GetGroup(adminCtx, &sso.GetGroupRequest{GroupId: engineering.GetGroupId()})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{backend.GetGroupId()}, group.GetSubgroupIds())
+	require.Len(t, group.GetRoles(), 1)
+	assert.Equal(t, "editor", group.GetRoles()[0].GetRole())
+
+	// The user reads their own permissions with the app of their token
+	self, err := st.AuthClient.GetUserPermissions(suite.AuthContext(ctx, login.GetToken()),
+		&sso.GetUserPermissionsRequest{UserId: user.GetUserId()})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"editor"}, self.GetRoles())
+}
+
+func TestGroups_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+	adminCtx, _ := st.AdminContext(ctx)
+
+	group, err := st.AuthClient.CreateGroup(adminCtx, &sso.CreateGroupRequest{Name: "group"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode codes.Code
+	}{
+		{
+			name: "not authenticated",
+			call: func() error {
+				_, err := st.AuthClient.ListGroups(ctx, &sso.ListGroupsRequest{})
+				return err
+			},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name: "taken name",
+			call: func() error {
+				_, err := st.AuthClient.CreateGroup(adminCtx, &sso.CreateGroupRequest{Name: "group"})
+				return err
+			},
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name: "empty name",
+			call: func() error {
+				_, err := st.AuthClient.CreateGroup(adminCtx, &sso.CreateGroupRequest{})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "unknown group",
+			call: func() error {
+				_, err := st.AuthClient.DeleteGroup(adminCtx, &sso.DeleteGroupRequest{GroupId: 404})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "nested in itself",
+			call: func() error {
+				_, err := st.AuthClient.AddGroupMember(adminCtx, &sso.GroupMemberRequest{
+					GroupId: group.GetGroupId(),
+					Member:  &sso.GroupMemberRequest_SubgroupId{SubgroupId: group.GetGroupId()},
+				})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "no member",
+			call: func() error {
+				_, err := st.AuthClient.AddGroupMember(adminCtx, &sso.GroupMemberRequest{GroupId: group.GetGroupId()})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "unknown user",
+			call: func() error {
+				_, err := st.AuthClient.AddGroupMember(adminCtx, &sso.GroupMemberRequest{
+					GroupId: group.GetGroupId(),
+					Member:  &sso.GroupMemberRequest_UserId{UserId: 404_404},
+				})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "unknown app",
+			call: func() error {
+				_, err := st.AuthClient.GrantGroupRole(adminCtx, &sso.GroupRoleRequest{
+					GroupId: group.GetGroupId(), AppId: 404, Role: "editor",
+				})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			assert.Equal(t, test.wantCode, status.Code(err), err)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+	"time"
+)
+
+func TestImpersonate(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	registered, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+	userID := registered.GetUserId()
+
+	adminCtx, adminID := st.AdminContext(ctx)
+
+	resp, err := st.AuthClient.Impersonate(adminCtx, &sso.ImpersonateRequest{UserId: userID, Reason: "ticket 42"})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(st.Cfg.ImpersonationTTL), resp.GetExpiresAt().AsTime(), time.Minute)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(resp.GetToken(), claims)
+	require.NoError(t, err)
+	assert.Equal(t, float64(userID), claims["user_id"])
+	assert.Equal(t, map[string]any{"user_id": float64(adminID), "email": suite.AdminEmail}, claims["act"])
+
+	impersonatedCtx := suite.AuthContext(ctx, resp.GetToken())
+
+	// The token works as the user's own
+	sessions, err := st.AuthClient.ListSessions(impersonatedCtx, &sso.ListSessionsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, sessions.GetSessions())
+
+	// but not for the sensitive operations
+	_, err = st.AuthClient.ChangePassword(impersonatedCtx, &sso.ChangePasswordRequest{
+		OldPassword: pass,
+		NewPassword: randomFakePassword(),
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err, "the password is unchanged")
+
+	audit, err := st.AuthClient.ListAuditEvents(adminCtx, &sso.ListAuditEventsRequest{
+		UserId: userID,
+		Types:  []string{models.AuditImpersonate},
+	})
+	require.NoError(t, err)
+	require.Len(t, audit.GetEvents(), 1)
+	assert.Equal(t, adminID, audit.GetEvents()[0].GetActorId())
+	assert.Equal(t, "ticket 42", audit.GetEvents()[0].GetReason())
+}
+
+func TestImpersonate_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	registered, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	adminCtx, _ := st.AdminContext(ctx)
+
+	tests := []struct {
+		name     string
+		req      *sso.ImpersonateRequest
+		wantCode codes.Code
+	}{
+		{
+			name:     "no reason",
+			req:      &sso.ImpersonateRequest{UserId: registered.GetUserId()},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "unknown user",
+			req:      &sso.ImpersonateRequest{UserId: 404404, Reason: "ticket 42"},
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "unknown app",
+			req:      &sso.ImpersonateRequest{UserId: registered.GetUserId(), AppId: 404, Reason: "ticket 42"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := st.AuthClient.Impersonate(adminCtx, test.req)
+			assert.Equal(t, test.wantCode, status.Code(err), err)
+		})
+	}
+
+	_, err = st.AuthClient.Impersonate(suite.AuthContext(ctx, login.GetToken()),
+		&sso.ImpersonateRequest{UserId: registered.GetUserId(), Reason: "ticket 42"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/lib/ldap/ldaptest"
+	"grpc-sso/tests/suite"
+	"testing"
+)
+
+const staffPassword = "directory-password"
+
+// withDirectory makes the in-process LDAP server the directory of the corp.example.com emails,
+// its users are provisioned and synced into the admins group
+func withDirectory(srv *ldaptest.Server) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.LDAP = []config.LDAPConfig{{
+			Name:         "corp",
+			URL:          srv.URL,
+			BindDN:       ldaptest.BindDN,
+			BindPassword: ldaptest.BindPassword,
+			BaseDN:       ldaptest.BaseDN,
+			IDAttribute:  "entryUUID",
+			Domains:      []string{"corp.example.com"},
+			Provision:    true,
+			Groups:       map[string]string{"cn=admins,ou=groups,dc=example,dc=com": "admins"},
+		}}
+	}
+}
+
+func TestLogin_LDAP(t *testing.T) {
+	srv := ldaptest.New(t, ldaptest.Entry{
+		DN:       "uid=staff,ou=people,dc=example,dc=com",
+		Password: staffPassword,
+		Attributes: map[string][]string{
+			"mail":      {"staff@corp.example.com"},
+			"entryUUID": {"u-1"},
+			"memberOf":  {"cn=admins,ou=groups,dc=example,dc=com"},
+		},
+	})
+	ctx, st := suite.New(t, withDirectory(srv))
+
+	adminCtx, _ := st.AdminContext(ctx)
+
+	group, err := st.AuthClient.CreateGroup(adminCtx, &sso.CreateGroupRequest{Name: "admins"})
+	require.NoError(t, err)
+	_, err = st.AuthClient.GrantGroupRole(adminCtx, &sso.GroupRoleRequest{
+		GroupId: group.GetGroupId(), AppId: appID, Role: "admin",
+	})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: "staff@corp.example.com", Password: "wrong", AppId: appID})
+	require.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The user is provisioned and joins the group of the directory
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{
+		Email:         "staff@corp.example.com",
+		Password:      staffPassword,
+		AppId:         appID,
+		IncludeGroups: true,
+	})
+	require.NoError(t, err)
+
+	claims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(login.GetToken(), claims)
+	require.NoError(t, err)
+	assert.Equal(t, []any{"admin"}, claims["roles"])
+	assert.Equal(t, []any{"admins"}, claims["groups"])
+
+	identities, err := st.AuthClient.ListIdentities(suite.AuthContext(ctx, login.GetToken()), &sso.ListIdentitiesRequest{})
+	require.NoError(t, err)
+	require.Len(t, identities.GetIdentities(), 1)
+	assert.Equal(t, "corp", identities.GetIdentities()[0].GetProvider())
+
+	// The users of the directory don't register
+	_, err = st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: "new@corp.example.com", Password: staffPassword})
+	require.Error(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
@@ -1,3 +1,3 @@
 INSERT INTO apps (id, name, secret)
-VALUES (1, 'test', 'test_secret')
+VALUES (1, 'test', 'test-secret')
 ON CONFLICT DO NOTHING;
\ No newline at end of file
@@ -0,0 +1,248 @@
+package tests
+
+import (
+	"context"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+)
+
+// loginTimes registers a user and logs it in n times, returns the user ID and the tokens
+func loginTimes(ctx context.Context, st *suite.Suite, n int) (int64, []string) {
+	st.Helper()
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	registered, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(st, err)
+
+	var tokens []string
+
+	for range n {
+		login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+		require.NoError(st, err)
+
+		tokens = append(tokens, login.GetToken())
+	}
+
+	return registered.GetUserId(), tokens
+}
+
+// currentSession returns the ID of the session the token was issued for
+func currentSession(ctx context.Context, st *suite.Suite, token string) string {
+	st.Helper()
+
+	resp, err := st.AuthClient.ListSessions(suite.AuthContext(ctx, token), &sso.ListSessionsRequest{})
+	require.NoError(st, err)
+
+	for _, session := range resp.GetSessions() {
+		if session.GetCurrent() {
+			return session.GetId()
+		}
+	}
+
+	st.Fatalf("no current session")
+
+	return ""
+}
+
+func TestSessions_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	_, tokens := loginTimes(ctx, st, 3)
+
+	firstCtx := suite.AuthContext(ctx, tokens[0])
+	secondCtx := suite.AuthContext(ctx, tokens[1])
+
+	resp, err := st.AuthClient.ListSessions(firstCtx, &sso.ListSessionsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetSessions(), 3)
+
+	var current []string
+
+	for _, session := range resp.GetSessions() {
+		assert.NotEmpty(t, session.GetId())
+		assert.Equal(t, int32(appID), session.GetAppId())
+		assert.NotEmpty(t, session.GetIp())
+		assert.NotEmpty(t, session.GetUserAgent())
+		assert.NotNil(t, session.GetCreatedAt())
+		assert.NotNil(t, session.GetLastUsedAt())
+		assert.True(t, session.GetExpiresAt().AsTime().After(session.GetCreatedAt().AsTime()))
+
+		if session.GetCurrent() {
+			current = append(current, session.GetId())
+		}
+	}
+
+	first := currentSession(ctx, st, tokens[0])
+	assert.Equal(t, []string{first}, current)
+
+	_, err = st.AuthClient.RevokeSession(firstCtx,
+		&sso.RevokeSessionRequest{SessionId: currentSession(ctx, st, tokens[1])})
+	require.NoError(t, err)
+
+	// The token of the revoked session is rejected
+	_, err = st.AuthClient.ListSessions(secondCtx, &sso.ListSessionsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+
+	revoked, err := st.AuthClient.RevokeAllSessions(firstCtx, &sso.RevokeAllSessionsRequest{KeepCurrent: true})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), revoked.GetRevoked())
+
+	resp, err = st.AuthClient.ListSessions(firstCtx, &sso.ListSessionsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetSessions(), 1)
+	assert.Equal(t, first, resp.GetSessions()[0].GetId())
+
+	revoked, err = st.AuthClient.RevokeAllSessions(firstCtx, &sso.RevokeAllSessionsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), revoked.GetRevoked())
+
+	_, err = st.AuthClient.ListSessions(firstCtx, &sso.ListSessionsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+}
+
+func TestUserSessions_Admin(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userID, tokens := loginTimes(ctx, st, 2)
+	adminCtx, _ := st.AdminContext(ctx)
+
+	resp, err := st.AuthClient.ListUserSessions(adminCtx, &sso.ListUserSessionsRequest{UserId: userID})
+	require.NoError(t, err)
+	require.Len(t, resp.GetSessions(), 2)
+
+	for _, session := range resp.GetSessions() {
+		assert.False(t, session.GetCurrent(), "the admin's session is not listed")
+	}
+
+	_, err = st.AuthClient.RevokeUserSession(adminCtx, &sso.RevokeUserSessionRequest{
+		UserId:    userID,
+		SessionId: resp.GetSessions()[0].GetId(),
+	})
+	require.NoError(t, err)
+
+	revoked, err := st.AuthClient.RevokeAllUserSessions(adminCtx, &sso.RevokeAllUserSessionsRequest{UserId: userID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), revoked.GetRevoked())
+
+	for _, token := range tokens {
+		_, err = st.AuthClient.ListSessions(suite.AuthContext(ctx, token), &sso.ListSessionsRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+	}
+}
+
+func TestSessions_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	userID, tokens := loginTimes(ctx, st, 1)
+	otherID, otherTokens := loginTimes(ctx, st, 1)
+
+	userCtx := suite.AuthContext(ctx, tokens[0])
+	otherCtx := suite.AuthContext(ctx, otherTokens[0])
+	adminCtx, _ := st.AdminContext(ctx)
+
+	otherSessionID := currentSession(ctx, st, otherTokens[0])
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode codes.Code
+	}{
+		{
+			name: "list anonymous",
+			call: func() error {
+				_, err := st.AuthClient.ListSessions(ctx, &sso.ListSessionsRequest{})
+				return err
+			},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name: "revoke without session ID",
+			call: func() error {
+				_, err := st.AuthClient.RevokeSession(userCtx, &sso.RevokeSessionRequest{})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "revoke session of another user",
+			call: func() error {
+				_, err := st.AuthClient.RevokeSession(userCtx, &sso.RevokeSessionRequest{SessionId: otherSessionID})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "revoke all anonymous",
+			call: func() error {
+				_, err := st.AuthClient.RevokeAllSessions(ctx, &sso.RevokeAllSessionsRequest{})
+				return err
+			},
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name: "list user sessions not admin",
+			call: func() error {
+				_, err := st.AuthClient.ListUserSessions(userCtx, &sso.ListUserSessionsRequest{UserId: otherID})
+				return err
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "revoke user session not admin",
+			call: func() error {
+				_, err := st.AuthClient.RevokeUserSession(userCtx, &sso.RevokeUserSessionRequest{
+					UserId:    otherID,
+					SessionId: otherSessionID,
+				})
+				return err
+			},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name: "revoke user session of another user",
+			call: func() error {
+				_, err := st.AuthClient.RevokeUserSession(adminCtx, &sso.RevokeUserSessionRequest{
+					UserId:    userID,
+					SessionId: otherSessionID,
+				})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "revoke all user sessions without user ID",
+			call: func() error {
+				_, err := st.AuthClient.RevokeAllUserSessions(adminCtx, &sso.RevokeAllUserSessionsRequest{})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "revoke all sessions of unknown user",
+			call: func() error {
+				_, err := st.AuthClient.RevokeAllUserSessions(adminCtx, &sso.RevokeAllUserSessionsRequest{UserId: 1 << 40})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			assert.Equal(t, test.wantCode, status.Code(err), err)
+		})
+	}
+
+	// Nothing was revoked
+	_, err := st.AuthClient.ListSessions(otherCtx, &sso.ListSessionsRequest{})
+	assert.NoError(t, err)
+}
@@ -2,54 +2,104 @@ package suite
 
 import (
 	"context"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"grpc-sso/internal/app"
 	"grpc-sso/internal/config"
 	"grpc-sso/internal/grpc/proto/sso"
-	"net"
-	"strconv"
+	"io"
+	"log/slog"
+	"sync"
 	"testing"
 )
 
-const grpcHost = "localhost"
+// The app every test server is seeded with
+const (
+	AppID     = 1
+	AppName   = "test"
+	AppSecret = "test-secret"
+
+	// AdminEmail is granted admin rights on registration, see Suite.AdminContext
+	AdminEmail    = "admin@example.com"
+	AdminPassword = "admin-password"
+)
 
 type Suite struct {
 	*testing.T
 	Cfg        *config.Config
 	AuthClient sso.AuthClient
+	// Stop stops the server before the test ends, it is stopped at the end anyway
+	Stop func()
 }
 
-func New(t *testing.T) (context.Context, *Suite) {
+// New starts a server with an empty in-memory storage for the test
+// and connects to it in process, so no database or free port is needed.
+// The configure funcs change the config before the server starts
+func New(t *testing.T, configure ...func(cfg *config.Config)) (context.Context, *Suite) {
 	t.Helper()
 	t.Parallel()
 
 	cfg := config.MustLoadByPath("../config/config_local.yaml")
 
-	ctx, cancelCtx := context.WithTimeout(context.Background(), cfg.GRPC.Timeout)
+	cfg.Storage = config.StorageConfig{
+		Driver: config.StorageDriverMemory,
+		Apps:   []config.AppConfig{{ID: AppID, Name: AppName, Secret: AppSecret}},
+		Admins: []string{AdminEmail},
+	}
+	cfg.MigrationsPath = ""
+	cfg.GRPC.Port = 0
+	cfg.GRPC.TLS.Enabled = false
+	cfg.Gateway.Enabled = false
+	cfg.Metrics.Enabled = false
 
-	t.Cleanup(func() {
-		t.Helper()
-		cancelCtx()
-	})
+	for _, fn := range configure {
+		fn(cfg)
+	}
 
-	//cc, err := grpc.DialContext(context.Background(),
-	//	grpcAddress(cfg),
-	//	grpc.WithTransportCredentials(insecure.NewCredentials()))
+	application := app.New(slog.New(slog.NewTextHandler(io.Discard, nil)), cfg)
+	application.MustRun()
 
-	cc, err := grpc.NewClient(
-		grpcAddress(cfg),
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	cc, err := application.GrpcApp.DialInProcess()
 	if err != nil {
 		t.Fatalf("grpc server connection failed: %v", err)
 	}
 
+	ctx, cancelCtx := context.WithTimeout(context.Background(), cfg.GRPC.Timeout)
+	stop := sync.OnceFunc(application.Stop)
+
+	t.Cleanup(func() {
+		t.Helper()
+		cancelCtx()
+		_ = cc.Close()
+		stop()
+	})
+
 	return ctx, &Suite{
 		T:          t,
 		Cfg:        cfg,
 		AuthClient: sso.NewAuthClient(cc),
+		Stop:       stop,
 	}
 }
 
-func grpcAddress(cfg *config.Config) string {
-	return net.JoinHostPort(grpcHost, strconv.Itoa(cfg.GRPC.Port))
+// AuthContext returns ctx with the token as the bearer of the calls
+func AuthContext(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// AdminContext registers the admin and returns ctx with the admin token
+// as the bearer of the calls, and the admin user ID
+func (s *Suite) AdminContext(ctx context.Context) (context.Context, int64) {
+	s.Helper()
+
+	registered, err := s.AuthClient.Register(ctx, &sso.RegisterRequest{Email: AdminEmail, Password: AdminPassword})
+	if err != nil {
+		s.Fatalf("failed to register admin: %v", err)
+	}
+
+	login, err := s.AuthClient.Login(ctx, &sso.LoginRequest{Email: AdminEmail, Password: AdminPassword, AppId: AppID})
+	if err != nil {
+		s.Fatalf("failed to login admin: %v", err)
+	}
+
+	return AuthContext(ctx, login.GetToken()), registered.GetUserId()
 }
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"context"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+)
+
+const (
+	tenantID    = 2
+	tenantAppID = 2
+)
+
+// withTenant adds the tenant 2 owning the app 2, the suite admin email is its admin too
+func withTenant(cfg *config.Config) {
+	cfg.Storage.Tenants = []config.TenantConfig{{ID: tenantID, Name: "acme", Admins: []string{suite.AdminEmail}}}
+	cfg.Storage.Apps = append(cfg.Storage.Apps,
+		config.AppConfig{ID: tenantAppID, TenantID: tenantID, Name: "acme", Secret: "acme-secret"})
+}
+
+// tenantContext names the tenant in the request metadata
+func tenantContext(ctx context.Context, tenantID string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "x-tenant-id", tenantID)
+}
+
+func TestTenants_SameEmail(t *testing.T) {
+	ctx, st := suite.New(t, withTenant)
+
+	email := gofakeit.Email()
+	defaultPass := randomFakePassword()
+	tenantPass := randomFakePassword()
+
+	defaultUser, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: defaultPass})
+	require.NoError(t, err)
+
+	tenantUser, err := st.AuthClient.Register(ctx,
+		&sso.RegisterRequest{Email: email, Password: tenantPass, AppId: tenantAppID})
+	require.NoError(t, err)
+	assert.NotEqual(t, defaultUser.GetUserId(), tenantUser.GetUserId())
+
+	_, err = st.AuthClient.Register(tenantContext(ctx, "2"), &sso.RegisterRequest{Email: email, Password: tenantPass})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), err)
+
+	// Each user logs in to the apps of its tenant
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: defaultPass, AppId: appID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: tenantPass, AppId: tenantAppID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: tenantPass, AppId: appID})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+}
+
+func TestTenants_FailCases(t *testing.T) {
+	ctx, st := suite.New(t, withTenant)
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode codes.Code
+	}{
+		{
+			name: "register to unknown tenant",
+			call: func() error {
+				_, err := st.AuthClient.Register(tenantContext(ctx, "3"),
+					&sso.RegisterRequest{Email: gofakeit.Email(), Password: randomFakePassword()})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "register to app of another tenant",
+			call: func() error {
+				_, err := st.AuthClient.Register(tenantContext(ctx, "1"),
+					&sso.RegisterRequest{Email: gofakeit.Email(), Password: randomFakePassword(), AppId: tenantAppID})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "login to app of another tenant",
+			call: func() error {
+				_, err := st.AuthClient.Login(tenantContext(ctx, "2"),
+					&sso.LoginRequest{Email: gofakeit.Email(), Password: randomFakePassword(), AppId: appID})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			assert.Equal(t, test.wantCode, status.Code(err), err)
+		})
+	}
+}
+
+func TestTenants_Admin(t *testing.T) {
+	ctx, st := suite.New(t, withTenant)
+
+	defaultUser, err := st.AuthClient.Register(ctx,
+		&sso.RegisterRequest{Email: gofakeit.Email(), Password: randomFakePassword()})
+	require.NoError(t, err)
+
+	tenantUser, err := st.AuthClient.Register(ctx,
+		&sso.RegisterRequest{Email: gofakeit.Email(), Password: randomFakePassword(), AppId: tenantAppID})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Register(ctx,
+		&sso.RegisterRequest{Email: suite.AdminEmail, Password: suite.AdminPassword, AppId: tenantAppID})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx,
+		&sso.LoginRequest{Email: suite.AdminEmail, Password: suite.AdminPassword, AppId: tenantAppID})
+	require.NoError(t, err)
+
+	adminCtx := suite.AuthContext(ctx, login.GetToken())
+
+	_, err = st.AuthClient.DisableUser(adminCtx, &sso.DisableUserRequest{UserId: defaultUser.GetUserId()})
+	assert.Equal(t, codes.NotFound, status.Code(err), err)
+
+	_, err = st.AuthClient.ListUserSessions(adminCtx, &sso.ListUserSessionsRequest{UserId: defaultUser.GetUserId()})
+	assert.Equal(t, codes.NotFound, status.Code(err), err)
+
+	_, err = st.AuthClient.DisableUser(adminCtx, &sso.DisableUserRequest{UserId: tenantUser.GetUserId()})
+	require.NoError(t, err)
+
+	// The audit log of the tenant doesn't show the other tenants
+	resp, err := st.AuthClient.ListAuditEvents(adminCtx, &sso.ListAuditEventsRequest{PageSize: 100})
+	require.NoError(t, err)
+	require.NotEmpty(t, resp.GetEvents())
+
+	for _, event := range resp.GetEvents() {
+		assert.NotEqual(t, defaultUser.GetUserId(), event.GetUserId(), event.GetType())
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"context"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/tests/suite"
+	"testing"
+	"time"
+)
+
+// watchUserEvents opens the stream and waits until the server has started watching,
+// so the changes made after it returns are streamed
+func watchUserEvents(
+	ctx context.Context,
+	st *suite.Suite,
+	req *sso.WatchUserEventsRequest,
+) sso.Auth_WatchUserEventsClient {
+	st.Helper()
+
+	before, err := st.AuthClient.ListAuditEvents(ctx, &sso.ListAuditEventsRequest{
+		Types: []string{models.AuditAdminAction},
+	})
+	require.NoError(st, err)
+
+	stream, err := st.AuthClient.WatchUserEvents(ctx, req)
+	require.NoError(st, err)
+
+	require.Eventually(st, func() bool {
+		after, err := st.AuthClient.ListAuditEvents(ctx, &sso.ListAuditEventsRequest{
+			Types: []string{models.AuditAdminAction},
+		})
+		require.NoError(st, err)
+
+		return len(after.GetEvents()) > len(before.GetEvents()) &&
+			after.GetEvents()[0].GetReason() == "watch_user_events"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return stream
+}
+
+func TestWatchUserEvents_HappyPath(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx, _ := st.AdminContext(ctx)
+	stream := watchUserEvents(adminCtx, st, &sso.WatchUserEventsRequest{})
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	registered, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	newPass := randomFakePassword()
+	_, err = st.AuthClient.ChangePassword(suite.AuthContext(ctx, login.GetToken()),
+		&sso.ChangePasswordRequest{OldPassword: pass, NewPassword: newPass})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.DisableUser(adminCtx, &sso.DisableUserRequest{UserId: registered.GetUserId()})
+	require.NoError(t, err)
+
+	var got []*sso.UserEvent
+
+	for _, wantType := range []string{
+		models.EventUserRegistered,
+		models.EventUserPasswordChanged,
+		models.EventUserDisabled,
+	} {
+		event, err := stream.Recv()
+		require.NoError(t, err)
+
+		assert.Equal(t, wantType, event.GetType())
+		assert.Equal(t, registered.GetUserId(), event.GetUserId())
+		assert.Equal(t, email, event.GetEmail())
+		assert.NotEmpty(t, event.GetCursor())
+
+		got = append(got, event)
+	}
+
+	// Resumes after the registration, filtered by type
+	resumed, err := st.AuthClient.WatchUserEvents(adminCtx, &sso.WatchUserEventsRequest{
+		Cursor: got[0].GetCursor(),
+		Types:  []string{models.EventUserDisabled},
+	})
+	require.NoError(t, err)
+
+	event, err := resumed.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, got[2].GetCursor(), event.GetCursor())
+	assert.Equal(t, models.EventUserDisabled, event.GetType())
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: newPass, AppId: appID})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+}
+
+func TestWatchUserEvents_EndsOnShutdown(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	adminCtx, _ := st.AdminContext(ctx)
+	stream := watchUserEvents(adminCtx, st, &sso.WatchUserEventsRequest{})
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		st.Stop()
+	}()
+
+	_, err := stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err), err)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
+
+func TestWatchUserEvents_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	_, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	userCtx := suite.AuthContext(ctx, login.GetToken())
+	adminCtx, _ := st.AdminContext(ctx)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		req      *sso.WatchUserEventsRequest
+		wantCode codes.Code
+	}{
+		{name: "anonymous", ctx: ctx, req: &sso.WatchUserEventsRequest{}, wantCode: codes.Unauthenticated},
+		{name: "not admin", ctx: userCtx, req: &sso.WatchUserEventsRequest{}, wantCode: codes.PermissionDenied},
+		{
+			name:     "invalid cursor",
+			ctx:      adminCtx,
+			req:      &sso.WatchUserEventsRequest{Cursor: "garbage"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream, err := st.AuthClient.WatchUserEvents(test.ctx, test.req)
+			require.NoError(t, err)
+
+			_, err = stream.Recv()
+			assert.Equal(t, test.wantCode, status.Code(err), err)
+		})
+	}
+
+	_, err = st.AuthClient.ChangePassword(ctx, &sso.ChangePasswordRequest{OldPassword: pass, NewPassword: "new-password"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+
+	_, err = st.AuthClient.ChangePassword(userCtx,
+		&sso.ChangePasswordRequest{OldPassword: "wrong-password", NewPassword: "new-password"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+
+	_, err = st.AuthClient.ChangePassword(userCtx, &sso.ChangePasswordRequest{OldPassword: pass})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+}
@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"grpc-sso/internal/config"
+	"grpc-sso/internal/domain/models"
+	"grpc-sso/internal/grpc/proto/sso"
+	"grpc-sso/internal/lib/webhook"
+	"grpc-sso/tests/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const webhookSecret = "webhook-secret"
+
+// hookReceiver is the webhook endpoint of the test app, it answers with status
+type hookReceiver struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	status   int
+	payloads []webhook.Payload
+}
+
+func (r *hookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	require.NoError(r.t, err)
+
+	err = webhook.Verify(webhookSecret, req.Header.Get(webhook.HeaderTimestamp),
+		req.Header.Get(webhook.HeaderSignature), body, time.Now(), time.Minute)
+	assert.NoError(r.t, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.status != http.StatusOK {
+		w.WriteHeader(r.status)
+
+		return
+	}
+
+	var p webhook.Payload
+	assert.NoError(r.t, json.Unmarshal(body, &p))
+	assert.Equal(r.t, p.Type, req.Header.Get(webhook.HeaderEvent))
+	assert.Equal(r.t, p.ID, req.Header.Get(webhook.HeaderEventID))
+
+	r.payloads = append(r.payloads, p)
+}
+
+func (r *hookReceiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = status
+}
+
+// received returns the types of the delivered events
+func (r *hookReceiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	types := make([]string, 0, len(r.payloads))
+	for _, p := range r.payloads {
+		types = append(types, p.Type)
+	}
+
+	return types
+}
+
+// newHookReceiver starts the webhook endpoint and returns it with its URL
+func newHookReceiver(t *testing.T) (*hookReceiver, string) {
+	receiver := &hookReceiver{t: t, status: http.StatusOK}
+
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	return receiver, server.URL
+}
+
+func webhookConfig(url string, maxAttempts int) func(cfg *config.Config) {
+	return func(cfg *config.Config) {
+		cfg.Storage.Apps[0].WebhookURL = url
+		cfg.Storage.Apps[0].WebhookSecret = webhookSecret
+		cfg.Webhooks = config.WebhooksConfig{
+			Enabled:      true,
+			PollInterval: 10 * time.Millisecond,
+			BatchSize:    10,
+			MaxAttempts:  maxAttempts,
+			MinBackoff:   10 * time.Millisecond,
+			MaxBackoff:   10 * time.Millisecond,
+			Timeout:      time.Second,
+		}
+	}
+}
+
+func TestWebhooks_HappyPath(t *testing.T) {
+	receiver, url := newHookReceiver(t)
+	ctx, st := suite.New(t, webhookConfig(url, 3))
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	registered, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	newEmail := gofakeit.Email()
+	_, err = st.AuthClient.ChangeEmail(suite.AuthContext(ctx, login.GetToken()),
+		&sso.ChangeEmailRequest{Email: newEmail, Password: pass})
+	require.NoError(t, err)
+
+	adminCtx, _ := st.AdminContext(ctx)
+
+	_, err = st.AuthClient.DisableUser(adminCtx, &sso.DisableUserRequest{UserId: registered.GetUserId()})
+	require.NoError(t, err)
+
+	_, err = st.AuthClient.Login(ctx, &sso.LoginRequest{Email: newEmail, Password: pass, AppId: appID})
+	require.Equal(t, codes.PermissionDenied, status.Code(err), err)
+
+	want := []string{
+		models.EventUserRegistered,
+		models.EventUserEmailChanged,
+		models.EventUserRegistered, // the admin
+		models.EventUserDisabled,
+		models.EventTokenRevoked, // the session of the disabled user
+	}
+	require.Eventually(t, func() bool { return len(receiver.received()) == len(want) }, 5*time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, want, receiver.received())
+
+	resp, err := st.AuthClient.ListWebhookEvents(adminCtx, &sso.ListWebhookEventsRequest{Status: models.OutboxDelivered})
+	require.NoError(t, err)
+	require.Len(t, resp.GetEvents(), len(want))
+
+	for _, e := range resp.GetEvents() {
+		assert.Equal(t, int32(appID), e.GetAppId())
+		assert.Equal(t, int32(1), e.GetAttempts())
+		assert.NotNil(t, e.GetDeliveredAt())
+	}
+}
+
+func TestWebhooks_DeadLetterAndReplay(t *testing.T) {
+	receiver, url := newHookReceiver(t)
+	receiver.setStatus(http.StatusServiceUnavailable)
+
+	ctx, st := suite.New(t, webhookConfig(url, 2))
+	adminCtx, _ := st.AdminContext(ctx)
+
+	var dead []*sso.WebhookEvent
+	require.Eventually(t, func() bool {
+		resp, err := st.AuthClient.ListWebhookEvents(adminCtx, &sso.ListWebhookEventsRequest{Status: models.OutboxDead})
+		require.NoError(t, err)
+		dead = resp.GetEvents()
+
+		return len(dead) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, int32(2), dead[0].GetAttempts())
+	assert.Contains(t, dead[0].GetLastError(), "503")
+	assert.Nil(t, dead[0].GetDeliveredAt())
+	assert.Empty(t, receiver.received())
+
+	receiver.setStatus(http.StatusOK)
+
+	replayed, err := st.AuthClient.ReplayWebhookEvents(adminCtx, &sso.ReplayWebhookEventsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), replayed.GetReplayed())
+
+	require.Eventually(t, func() bool { return len(receiver.received()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{models.EventUserRegistered}, receiver.received())
+}
+
+func TestWebhooks_FailCases(t *testing.T) {
+	ctx, st := suite.New(t)
+
+	email := gofakeit.Email()
+	pass := randomFakePassword()
+
+	registered, err := st.AuthClient.Register(ctx, &sso.RegisterRequest{Email: email, Password: pass})
+	require.NoError(t, err)
+
+	login, err := st.AuthClient.Login(ctx, &sso.LoginRequest{Email: email, Password: pass, AppId: appID})
+	require.NoError(t, err)
+
+	userCtx := suite.AuthContext(ctx, login.GetToken())
+
+	_, err = st.AuthClient.ChangeEmail(userCtx, &sso.ChangeEmailRequest{Email: gofakeit.Email(), Password: "wrong-password"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), err)
+
+	_, err = st.AuthClient.ChangeEmail(ctx, &sso.ChangeEmailRequest{Email: gofakeit.Email(), Password: pass})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), err)
+
+	_, err = st.AuthClient.DisableUser(userCtx, &sso.DisableUserRequest{UserId: registered.GetUserId()})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+
+	_, err = st.AuthClient.ReplayWebhookEvents(userCtx, &sso.ReplayWebhookEventsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+
+	adminCtx, _ := st.AdminContext(ctx)
+
+	_, err = st.AuthClient.DisableUser(adminCtx, &sso.DisableUserRequest{UserId: registered.GetUserId() + 1000})
+	assert.Equal(t, codes.NotFound, status.Code(err), err)
+
+	_, err = st.AuthClient.ChangeEmail(userCtx, &sso.ChangeEmailRequest{Email: suite.AdminEmail, Password: pass})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err), err)
+}